@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// watchBundles keeps CERT_DIR/bundles/<trust domain>.{pem,json} in step
+// with the RA's /v1/bundles: the local trust domain's bundle and those of
+// every federated one, so services can verify peers from each domain
+// against that domain's authorities only. The agent's own trust domain td
+// also becomes truststore.p12 when formats selects PKCS#12.
+func watchBundles(interval time.Duration, td spiffeid.TrustDomain, formats credentialFormats) {
+	dir := filepath.Join(certDir, "bundles")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("bundles: %v", err)
+		return
+	}
+	for {
+		if err := syncBundles(dir, td, formats); err != nil {
+			log.Printf("bundles: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func syncBundles(dir string, local spiffeid.TrustDomain, formats credentialFormats) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(raURL + "/v1/bundles")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	var result struct {
+		TrustDomains map[string]json.RawMessage `json:"trust_domains"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for name, data := range result.TrustDomains {
+		td, err := spiffeid.TrustDomainFromString(name)
+		if err != nil {
+			return fmt.Errorf("trust domain %q: %w", name, err)
+		}
+		b, err := ca.ParseTrustBundle(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", td, err)
+		}
+		writeFile(filepath.Join(dir, td.Name()+".json"), string(data), 0644)
+		writeFile(filepath.Join(dir, td.Name()+".pem"), string(b.MarshalPEM()), 0644)
+		keep[td.Name()+".json"], keep[td.Name()+".pem"] = true, true
+		if td == local {
+			if err := formats.writeTrustStore(b); err != nil {
+				return fmt.Errorf("truststore: %w", err)
+			}
+		}
+	}
+	// Drop bundles of domains the RA no longer federates with.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !keep[e.Name()] {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+)
+
+// crlWatcher keeps the base CRLs and their deltas from the CRL publisher in
+// CERT_DIR. The base is only downloaded again when it is due, changed
+// (If-Modified-Since) or too old for the current delta; every poll fetches
+// the delta, which only lists revocations since the base.
+type crlWatcher struct {
+	baseURL, deltaURL string
+	serial            string              // this agent's certificate
+	issuers           []*x509.Certificate // chain.pem: leaf, intermediate, cross-certificates
+
+	bases        []*x509.RevocationList
+	baseModified string
+	needBase     bool
+}
+
+func watchCRLs(serial, chainPEM string) {
+	w := &crlWatcher{
+		baseURL:  getEnv("CRL_URL", "http://crl-publisher:8444/crl"),
+		serial:   ca.NormalizeSerial(serial),
+		needBase: true,
+	}
+	w.deltaURL = getEnv("CRL_DELTA_URL", w.baseURL+"/delta")
+	interval, err := time.ParseDuration(getEnv("CRL_POLL_INTERVAL", "60s"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "CRL_POLL_INTERVAL: %v\n", err)
+		os.Exit(1)
+	}
+	if w.issuers, err = ca.ParseCertificatesPEM([]byte(chainPEM)); err != nil {
+		fmt.Fprintf(os.Stderr, "parse chain: %v\n", err)
+		os.Exit(1)
+	}
+	for {
+		if err := w.poll(time.Now()); err != nil {
+			log.Printf("CRL poll: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (w *crlWatcher) poll(now time.Time) error {
+	for _, b := range w.bases {
+		if now.After(b.NextUpdate) {
+			w.needBase = true
+		}
+	}
+	if w.needBase {
+		body, modified, err := fetchCRL(w.baseURL, w.baseModified)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			bases, err := w.parse(body)
+			if err != nil {
+				return fmt.Errorf("base CRL: %w", err)
+			}
+			writeFile(filepath.Join(certDir, "crl.pem"), string(body), 0644)
+			w.bases, w.baseModified = bases, modified
+		}
+		w.needBase = false
+	}
+	body, _, err := fetchCRL(w.deltaURL, "")
+	if err != nil {
+		return err
+	}
+	deltas, err := w.parse(body)
+	if err != nil {
+		return fmt.Errorf("delta CRL: %w", err)
+	}
+	revoked := 0
+	for _, delta := range deltas {
+		base := w.baseFor(delta)
+		if base == nil {
+			w.needBase = true
+			continue
+		}
+		entries, err := ca.MergeDeltaCRL(base, delta)
+		if err != nil {
+			// Usually a delta against a base newer than ours.
+			w.needBase = true
+			log.Printf("merge delta CRL %s: %v", delta.Number, err)
+			continue
+		}
+		revoked += len(entries)
+		for _, e := range entries {
+			if fmt.Sprintf("%X", e.SerialNumber) == w.serial && w.issuedBy(base) {
+				log.Printf("this agent's certificate %s is REVOKED (reason %d at %s)", w.serial, e.ReasonCode, e.RevocationTime.Format(time.RFC3339))
+			}
+		}
+	}
+	writeFile(filepath.Join(certDir, "crl-delta.pem"), string(body), 0644)
+	log.Printf("CRLs current: %d revoked certificates across %d issuers", revoked, len(deltas))
+	return nil
+}
+
+// parse decodes a CRL bundle and checks the signature of every CRL whose
+// issuer is in our chain; other issuers' CRLs are passed on to the service,
+// which verifies them against the trust bundle.
+func (w *crlWatcher) parse(body []byte) ([]*x509.RevocationList, error) {
+	crls, err := ca.ParseCRLsPEM(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, crl := range crls {
+		for _, issuer := range w.issuers {
+			if bytes.Equal(issuer.RawSubject, crl.RawIssuer) && bytes.Equal(issuer.SubjectKeyId, crl.AuthorityKeyId) &&
+				crl.CheckSignatureFrom(issuer) != nil {
+				return nil, fmt.Errorf("CRL %s: bad signature from %s", crl.Number, issuer.Subject)
+			}
+		}
+	}
+	return crls, nil
+}
+
+func (w *crlWatcher) baseFor(delta *x509.RevocationList) *x509.RevocationList {
+	for _, b := range w.bases {
+		if bytes.Equal(b.RawIssuer, delta.RawIssuer) && bytes.Equal(b.AuthorityKeyId, delta.AuthorityKeyId) {
+			return b
+		}
+	}
+	return nil
+}
+
+// issuedBy reports whether crl comes from the intermediate in our chain.
+func (w *crlWatcher) issuedBy(crl *x509.RevocationList) bool {
+	return len(w.issuers) > 1 && crl.CheckSignatureFrom(w.issuers[1]) == nil
+}
+
+// fetchCRL GETs url. With ifModified set it returns a nil body on 304.
+func fetchCRL(url, ifModified string) (body []byte, modified string, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if ifModified != "" {
+		req.Header.Set("If-Modified-Since", ifModified)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, ifModified, nil
+	default:
+		return nil, "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	return body, resp.Header.Get("Last-Modified"), err
+}
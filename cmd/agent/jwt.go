@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/jwtsvid"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// watchJWTSVID keeps CERT_DIR/jwt-svid.token current for JWT_AUDIENCE,
+// fetching a new token from the RA at half the previous one's lifetime.
+// The agent authenticates with a proof signed by its X.509-SVID key, so
+// Ed25519 leaf keys cannot be used.
+func watchJWTSVID(key crypto.Signer, chainPEM string, td spiffeid.TrustDomain, audience []string) {
+	chain, err := ca.ParseCertificatesPEM([]byte(chainPEM))
+	if err != nil {
+		log.Printf("JWT-SVID: parse chain: %v", err)
+		return
+	}
+	for {
+		wait := time.Minute
+		token, expiresAt, err := fetchJWTSVID(key, chain, td, audience)
+		if err != nil {
+			log.Printf("JWT-SVID: %v", err)
+		} else {
+			writeFile(filepath.Join(certDir, "jwt-svid.token"), token, 0600)
+			if half := time.Until(expiresAt) / 2; half > wait {
+				wait = half
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+func fetchJWTSVID(key crypto.Signer, chain []*x509.Certificate, td spiffeid.TrustDomain, audience []string) (string, time.Time, error) {
+	proof, err := jwtsvid.SignProof(key, chain, td.IDString(), time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body, err := json.Marshal(map[string]any{"audience": audience, "ttl": getEnv("JWT_TTL", "")})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, _ := http.NewRequest("POST", raURL+"/v1/jwt", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+proof)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", time.Time{}, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", time.Time{}, err
+	}
+	return result.Token, result.ExpiresAt, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/pkcs12"
+)
+
+// credentialFormats are the CERT_FORMATS the agent writes the X.509-SVID
+// in: PEM (cert.pem, key.pem, chain.pem) and PKCS#12 (keystore.p12, and
+// truststore.p12 from the trust domain's bundle) for JVM and .NET services.
+type credentialFormats struct {
+	pem      bool
+	pkcs12   bool
+	password string // PKCS#12 store password
+}
+
+// loadCredentialFormats reads CERT_FORMATS (default "pem") and, for
+// pkcs12, the store password from KEYSTORE_PASSWORD or the file named by
+// KEYSTORE_PASSWORD_FILE.
+func loadCredentialFormats() (credentialFormats, error) {
+	var f credentialFormats
+	for _, v := range cliutil.SplitList(getEnv("CERT_FORMATS", "pem")) {
+		switch v {
+		case "pem":
+			f.pem = true
+		case "pkcs12":
+			f.pkcs12 = true
+		default:
+			return f, fmt.Errorf("CERT_FORMATS: unknown format %q (want pem, pkcs12)", v)
+		}
+	}
+	if !f.pem && !f.pkcs12 {
+		return f, errors.New("CERT_FORMATS: no format selected")
+	}
+	if !f.pkcs12 {
+		return f, nil
+	}
+	f.password = os.Getenv("KEYSTORE_PASSWORD")
+	if path := os.Getenv("KEYSTORE_PASSWORD_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return f, fmt.Errorf("KEYSTORE_PASSWORD_FILE: %w", err)
+		}
+		f.password = strings.TrimRight(string(data), "\r\n")
+	}
+	if f.password == "" {
+		return f, errors.New("CERT_FORMATS=pkcs12 requires KEYSTORE_PASSWORD or KEYSTORE_PASSWORD_FILE")
+	}
+	return f, nil
+}
+
+// writeSVID writes the SVID in every selected format. Each file is
+// replaced atomically, so a service reloading on change never reads a
+// half-written store.
+func (f credentialFormats) writeSVID(key crypto.Signer, keyPEM []byte, certPEM, chainPEM string) error {
+	if f.pkcs12 {
+		chain, err := ca.ParseCertificatesPEM([]byte(chainPEM))
+		if err != nil {
+			return fmt.Errorf("chain: %w", err)
+		}
+		if len(chain) == 0 {
+			return errors.New("chain: no certificates")
+		}
+		store, err := pkcs12.Encode(key, chain[0], chain[1:], cliutil.KeyStoreAlias, f.password)
+		if err != nil {
+			return err
+		}
+		writeFile(filepath.Join(certDir, "keystore.p12"), string(store), 0600)
+	}
+	if f.pem {
+		writeFile(filepath.Join(certDir, "cert.pem"), certPEM, 0644)
+		writeFile(filepath.Join(certDir, "key.pem"), string(keyPEM), 0600)
+		writeFile(filepath.Join(certDir, "chain.pem"), chainPEM, 0644)
+	}
+	return nil
+}
+
+// writeTrustStore writes the X.509 authorities of the agent's own trust
+// domain to truststore.p12 when PKCS#12 output is selected.
+func (f credentialFormats) writeTrustStore(b *ca.TrustBundle) error {
+	if !f.pkcs12 {
+		return nil
+	}
+	store, err := pkcs12.EncodeTrustStore(b.X509Authorities, f.password)
+	if err != nil {
+		return err
+	}
+	writeFile(filepath.Join(certDir, "truststore.p12"), string(store), 0644)
+	return nil
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"time"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
 )
 
 var (
@@ -31,8 +35,54 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Register first if needed (agent uses token to issue)
-	resp, err := fetchCert(token)
+	formats, err := loadCredentialFormats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// The private key is generated here and never leaves this host; the RA
+	// only sees a CSR.
+	keyAlg, err := ca.ParseKeyAlgorithm(os.Getenv("KEY_ALG"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "KEY_ALG: %v\n", err)
+		os.Exit(1)
+	}
+	key, err := ca.GenerateKey(keyAlg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate key failed: %v\n", err)
+		os.Exit(1)
+	}
+	id, err := agentSPIFFEID(serviceID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	leafReq := ca.LeafRequest{
+		SPIFFEID: id.String(),
+		Profile:  os.Getenv("CERT_PROFILE"),
+		DNSNames: cliutil.SplitList(os.Getenv("DNS_SANS")),
+	}
+	for _, v := range cliutil.SplitList(os.Getenv("IP_SANS")) {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			fmt.Fprintf(os.Stderr, "IP_SANS: invalid IP %q\n", v)
+			os.Exit(1)
+		}
+		leafReq.IPAddresses = append(leafReq.IPAddresses, ip)
+	}
+	csrPEM, err := ca.CreateLeafCSR(key, leafReq)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create CSR failed: %v\n", err)
+		os.Exit(1)
+	}
+	keyPEM, err := ca.MarshalPrivateKeyPEM(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal key failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := fetchCert(token, csrPEM, leafReq.Profile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fetch cert failed: %v\n", err)
 		os.Exit(1)
@@ -47,7 +97,6 @@ func main() {
 
 	var result struct {
 		CertPEM  string `json:"cert_pem"`
-		KeyPEM   string `json:"key_pem"`
 		ChainPEM string `json:"chain_pem"`
 		Serial   string `json:"serial"`
 	}
@@ -60,19 +109,56 @@ func main() {
 		fmt.Fprintf(os.Stderr, "mkdir failed: %v\n", err)
 		os.Exit(1)
 	}
-	writeFile(filepath.Join(certDir, "cert.pem"), result.CertPEM, 0644)
-	writeFile(filepath.Join(certDir, "key.pem"), result.KeyPEM, 0600)
-	writeFile(filepath.Join(certDir, "chain.pem"), result.ChainPEM, 0644)
+	if err := formats.writeSVID(key, keyPEM, result.CertPEM, result.ChainPEM); err != nil {
+		fmt.Fprintf(os.Stderr, "write credentials failed: %v\n", err)
+		os.Exit(1)
+	}
 
 	fmt.Printf("Cert issued for %s, serial %s\n", serviceID, result.Serial)
 
+	bundleInterval, err := time.ParseDuration(getEnv("BUNDLE_POLL_INTERVAL", ca.BundleRefreshHint.String()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "BUNDLE_POLL_INTERVAL: %v\n", err)
+		os.Exit(1)
+	}
+	go watchBundles(bundleInterval, id.TrustDomain(), formats)
+	if aud := cliutil.SplitList(os.Getenv("JWT_AUDIENCE")); len(aud) > 0 {
+		go watchJWTSVID(key, result.ChainPEM, id.TrustDomain(), aud)
+	}
+
 	// TODO: rotation loop (renew at 2/3 lifetime)
-	select {}
+	watchCRLs(result.Serial, result.ChainPEM)
 }
 
-func fetchCert(token string) (*http.Response, error) {
-	req, _ := http.NewRequest("POST", raURL+"/v1/issue", bytes.NewReader(nil))
+// agentSPIFFEID returns SPIFFE_ID if set, else the identity the RA assigns
+// serviceID in TRUST_DOMAIN (default spiffeid.DefaultTrustDomain).
+func agentSPIFFEID(serviceID string) (spiffeid.ID, error) {
+	if v := os.Getenv("SPIFFE_ID"); v != "" {
+		id, err := spiffeid.FromString(v)
+		if err != nil {
+			return spiffeid.ID{}, fmt.Errorf("SPIFFE_ID: %w", err)
+		}
+		return id, nil
+	}
+	td, err := spiffeid.TrustDomainFromString(getEnv("TRUST_DOMAIN", spiffeid.DefaultTrustDomain))
+	if err != nil {
+		return spiffeid.ID{}, fmt.Errorf("TRUST_DOMAIN: %w", err)
+	}
+	id, err := spiffeid.ForService(td, serviceID)
+	if err != nil {
+		return spiffeid.ID{}, fmt.Errorf("SERVICE_ID: %w", err)
+	}
+	return id, nil
+}
+
+func fetchCert(token string, csrPEM []byte, profile string) (*http.Response, error) {
+	body, err := json.Marshal(map[string]string{"csr_pem": string(csrPEM), "profile": profile})
+	if err != nil {
+		return nil, err
+	}
+	req, _ := http.NewRequest("POST", raURL+"/v1/issue", bytes.NewReader(body))
 	req.Header.Set("X-Bootstrap-Token", token)
+	req.Header.Set("Content-Type", "application/json")
 	client := &http.Client{Timeout: 10 * time.Second}
 	return client.Do(req)
 }
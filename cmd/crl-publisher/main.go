@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/pem"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
 const defaultPort = "8444"
 
+// issuerName matches the CA file stems the publisher may serve
+// ("intermediate", "intermediate-2", "intermediate-2-delta", "root").
+var issuerName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
 func main() {
 	port := os.Getenv("CRL_PORT")
 	if port == "" {
@@ -17,17 +27,78 @@ func main() {
 	if crlPath == "" {
 		crlPath = "ca/crl.pem"
 	}
+	deltaPath := os.Getenv("CRL_DELTA_PATH")
+	if deltaPath == "" {
+		deltaPath = "ca/crl-delta.pem"
+	}
+	caDir := os.Getenv("CA_DIR")
+	if caDir == "" {
+		caDir = filepath.Dir(crlPath)
+	}
 
-	http.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
-		data, err := os.ReadFile(crlPath)
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+	// Base CRLs change once per refresh; agents poll the small delta and
+	// re-fetch the base with If-Modified-Since.
+	http.HandleFunc("/crl", serveFile(crlPath, "application/pkix-crl"))
+	http.HandleFunc("/crl/delta", serveFile(deltaPath, "application/pkix-crl"))
+	// Per-issuer DER files named by the distribution URLs in certificates:
+	// /crl/<name> serves <name>.crl and /ca/<name>.crt the DER certificate.
+	http.HandleFunc("/crl/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/crl/")
+		if !issuerName.MatchString(name) {
+			http.NotFound(w, r)
+			return
+		}
+		serveFile(filepath.Join(caDir, name+".crl"), "application/pkix-crl")(w, r)
+	})
+	http.HandleFunc("/ca/", func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/ca/"), ".crt")
+		if !ok || !issuerName.MatchString(name) {
+			http.NotFound(w, r)
 			return
 		}
-		w.Header().Set("Content-Type", "application/pkix-crl")
-		w.Write(data)
+		serveCertDER(w, r, filepath.Join(caDir, name+".crt"))
 	})
 
+	// JWT validators that do not read SPIFFE bundles fetch the JWT
+	// authorities as a plain JWK Set.
+	http.HandleFunc("/jwks.json", serveFile(filepath.Join(caDir, "jwks.json"), "application/jwk-set+json"))
+
 	log.Printf("CRL publisher listening on :%s", port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
+
+func serveFile(path, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := os.Open(path)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", fi.ModTime(), f)
+	}
+}
+
+// serveCertDER serves the first certificate of a PEM file as DER, the form
+// AIA caIssuers clients expect.
+func serveCertDER(w http.ResponseWriter, r *http.Request, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		http.Error(w, "not a certificate", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-cert")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(block.Bytes))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/health"
+	"github.com/zero-trust/zt-identity/pkg/ocsp"
+)
+
+const (
+	defaultPort  = "8445"
+	defaultCADir = "ca"
+)
+
+func main() {
+	port := os.Getenv("OCSP_PORT")
+	if port == "" {
+		port = defaultPort
+	}
+	cadir := os.Getenv("CA_DIR")
+	if cadir == "" {
+		cadir = defaultCADir
+	}
+	// Delegated signers are issued by the RA or `ztca ocsp`; the responder
+	// only reads them, so it needs neither the CA keys nor write access.
+	r := &ocsp.Responder{
+		CA:       &ca.Config{BaseDir: cadir},
+		Validity: cliutil.DurationEnv("OCSP_VALIDITY"),
+	}
+	// Sign responses for every live certificate up front and keep them
+	// fresh, so requests are answered from the cache.
+	refresh := func() {
+		n, err := r.Refresh(time.Now())
+		if err != nil {
+			log.Printf("refresh OCSP responses: %v", err)
+			return
+		}
+		log.Printf("OCSP responses ready for %d certificates", n)
+	}
+	refresh()
+	go func() {
+		validity := r.Validity
+		if validity <= 0 {
+			validity = ocsp.DefaultValidity
+		}
+		for range time.Tick(validity / 4) {
+			refresh()
+		}
+	}()
+
+	// OCSP GET requests put base64 in the path, which may contain "/" and
+	// "//"; route by hand so ServeMux does not clean and redirect them.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/healthz" {
+			health.HealthHandler(w, req)
+			return
+		}
+		r.ServeHTTP(w, req)
+	})
+
+	log.Printf("OCSP responder listening on :%s", port)
+	log.Fatal(http.ListenAndServe(":"+port, handler))
+}
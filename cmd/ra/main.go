@@ -1,35 +1,56 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/zero-trust/zt-identity/internal/cliutil"
 	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/federation"
+	"github.com/zero-trust/zt-identity/pkg/jwtsvid"
 	"github.com/zero-trust/zt-identity/pkg/models"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+	"github.com/zero-trust/zt-identity/pkg/translog"
 )
 
 const (
-	defaultPort   = "8443"
-	defaultCADir  = "ca"
-	spiffePrefix  = "spiffe://demo/ns/default/sa/"
+	defaultPort  = "8443"
+	defaultCADir = "ca"
+	maxIssueBody = 64 << 10
+
+	// ocspSignerCheckInterval is how often the RA looks for intermediates
+	// whose delegated OCSP signer is missing or due for renewal.
+	ocspSignerCheckInterval = 10 * time.Minute
 )
 
 type store struct {
 	mu         sync.RWMutex
 	identities map[string]*models.ServiceIdentity
 	tokens     map[string]*models.BootstrapToken
-	certs      map[string]*models.IssuedCert
-	revoked    map[string]*models.RevocationEntry
 }
 
 type server struct {
-	store *store
-	ca    *ca.Config
+	store             *store
+	ca                *ca.Config
+	issuer            *ca.Issuer           // issues through ca with cached signing material
+	trustDomain       spiffeid.TrustDomain // from ca.json
+	allowServerKeygen bool
+	crlMu             sync.Mutex // serializes base and delta CRL updates
 }
 
 func main() {
@@ -41,22 +62,83 @@ func main() {
 	if cadir == "" {
 		cadir = defaultCADir
 	}
+	leafAlg, err := ca.ParseKeyAlgorithm(os.Getenv("LEAF_KEY_ALG"))
+	if err != nil {
+		log.Fatalf("LEAF_KEY_ALG: %v", err)
+	}
+	passphrase, err := ca.PassphraseFromSpec(os.Getenv("CA_PASSPHRASE"), false)
+	if err != nil {
+		log.Fatalf("CA_PASSPHRASE: %v", err)
+	}
+	keyStore, err := ca.OpenKeyStore(os.Getenv("CA_KEYSTORE"), cadir, passphrase)
+	if err != nil {
+		log.Fatalf("CA_KEYSTORE: %v", err)
+	}
+	allowServerKeygen := false
+	if v := os.Getenv("RA_ALLOW_SERVER_KEYGEN"); v != "" {
+		if allowServerKeygen, err = strconv.ParseBool(v); err != nil {
+			log.Fatalf("RA_ALLOW_SERVER_KEYGEN: %v", err)
+		}
+	}
+
+	keyPool := 0
+	if v := os.Getenv("LEAF_KEY_POOL"); v != "" {
+		if keyPool, err = strconv.Atoi(v); err != nil || keyPool < 0 {
+			log.Fatalf("LEAF_KEY_POOL: want a non-negative number of keys, got %q", v)
+		}
+		if keyPool > 0 && !allowServerKeygen {
+			log.Printf("LEAF_KEY_POOL ignored: server-side key generation is disabled (RA_ALLOW_SERVER_KEYGEN)")
+			keyPool = 0
+		}
+	}
+
+	crlValidity := cliutil.DurationEnv("CRL_VALIDITY")
+	deltaCRLValidity := cliutil.DurationEnv("DELTA_CRL_VALIDITY")
 
 	s := &server{
 		store: &store{
 			identities: make(map[string]*models.ServiceIdentity),
 			tokens:     make(map[string]*models.BootstrapToken),
-			certs:      make(map[string]*models.IssuedCert),
-			revoked:    make(map[string]*models.RevocationEntry),
 		},
-		ca: &ca.Config{BaseDir: cadir},
+		ca: &ca.Config{
+			BaseDir:            cadir,
+			LeafKeyAlgorithm:   leafAlg,
+			KeyStore:           keyStore,
+			CRLValidity:        crlValidity,
+			DeltaCRLValidity:   deltaCRLValidity,
+			DeltaCRLURL:        os.Getenv("DELTA_CRL_URL"),
+			OCSPSignerValidity: cliutil.DurationEnv("OCSP_SIGNER_VALIDITY"),
+			Logf:               log.Printf,
+		},
+		allowServerKeygen: allowServerKeygen,
+	}
+	s.issuer = &ca.Issuer{CA: s.ca, KeyPoolSize: keyPool}
+	go s.issuer.Run(context.Background())
+
+	if s.trustDomain, err = s.ca.LoadTrustDomain(); err != nil {
+		log.Fatalf("trust domain: %v", err)
+	}
+	if err := s.ca.UpdateCRL(time.Now()); err != nil {
+		log.Fatalf("update CRL: %v", err)
+	}
+	go s.refreshCRL(s.ca.CRLValidity, ca.DefaultCRLValidity, s.ca.UpdateCRL)
+	go s.refreshCRL(s.ca.DeltaCRLValidity, ca.DefaultDeltaCRLValidity, s.ca.UpdateDeltaCRL)
+	go s.renewOCSPSigners()
+
+	refresher := &federation.Refresher{CA: s.ca, WebRoots: certPoolEnv("FEDERATION_CA_FILE"), Logf: log.Printf}
+	go refresher.Run(context.Background())
+	if addr := os.Getenv("BUNDLE_ENDPOINT_ADDR"); addr != "" {
+		go s.serveBundleEndpoint(addr)
 	}
 
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/register", s.handleRegister).Methods("POST")
 	r.HandleFunc("/v1/issue", s.handleIssue).Methods("POST")
+	r.HandleFunc("/v1/jwt", s.handleJWT).Methods("POST")
 	r.HandleFunc("/v1/revoke", s.handleRevoke).Methods("POST")
 	r.HandleFunc("/v1/status", s.handleStatus).Methods("GET")
+	r.HandleFunc("/v1/bundles", s.handleBundles).Methods("GET")
+	r.PathPrefix("/v1/log/").Handler(http.StripPrefix("/v1/log", translog.Handler(s.ca.TransparencyLog())))
 
 	log.Printf("RA listening on :%s", port)
 	log.Fatal(http.ListenAndServe(":"+port, r))
@@ -69,11 +151,26 @@ func (s *server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing service", http.StatusBadRequest)
 		return
 	}
+	// profile may be repeated; the first is the service's default and the
+	// agent may select any of them per issue call.
+	profiles := r.URL.Query()["profile"]
+	for _, p := range profiles {
+		if _, err := s.ca.Profile(p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	id, err := spiffeid.ForService(s.trustDomain, serviceID)
+	if err != nil {
+		http.Error(w, "invalid service: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	token := "zt-bootstrap-" + randomHex(16)
-	spiffeID := spiffePrefix + serviceID
+	spiffeID := id.String()
 	ident := &models.ServiceIdentity{
 		ID:       serviceID,
 		SpiffeID: spiffeID,
+		Profiles: profiles,
 		Active:   true,
 	}
 	s.store.mu.Lock()
@@ -88,6 +185,21 @@ func (s *server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"bootstrap_token":"` + token + `","spiffe_id":"` + spiffeID + `"}`))
 }
 
+// issueRequest is the /v1/issue body. Agents send a PKCS#10 CSR so their
+// private key never leaves the workload; an empty body asks the RA to
+// generate the key, which is refused unless RA_ALLOW_SERVER_KEYGEN is set.
+type issueRequest struct {
+	CSRPEM  string `json:"csr_pem"`
+	Profile string `json:"profile,omitempty"`
+}
+
+type issueResponse struct {
+	CertPEM  string `json:"cert_pem"`
+	KeyPEM   string `json:"key_pem,omitempty"`
+	ChainPEM string `json:"chain_pem"`
+	Serial   string `json:"serial"`
+}
+
 func (s *server) handleIssue(w http.ResponseWriter, r *http.Request) {
 	token := r.Header.Get("X-Bootstrap-Token")
 	if token == "" {
@@ -97,6 +209,15 @@ func (s *server) handleIssue(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing bootstrap token", http.StatusUnauthorized)
 		return
 	}
+	var req issueRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxIssueBody)).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.CSRPEM == "" && !s.allowServerKeygen {
+		http.Error(w, "csr_pem required (server-side key generation is disabled)", http.StatusBadRequest)
+		return
+	}
 	s.store.mu.Lock()
 	bt, ok := s.store.tokens[token]
 	if !ok || bt.Used {
@@ -105,29 +226,133 @@ func (s *server) handleIssue(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	serviceID := bt.ServiceID
+	profile, ok := allowedProfile(s.store.identities[serviceID], req.Profile)
+	if !ok {
+		s.store.mu.Unlock()
+		http.Error(w, "profile "+req.Profile+" not allowed for "+serviceID, http.StatusForbidden)
+		return
+	}
 	bt.Used = true
-	spiffeID := spiffePrefix + serviceID
+	spiffeID := s.store.identities[serviceID].SpiffeID
 	s.store.mu.Unlock()
 
-	certPEM, keyPEM, chainPEM, serial, err := s.ca.IssueLeaf(spiffeID, 0)
+	var resp issueResponse
+	var err error
+	leafReq := ca.LeafRequest{SPIFFEID: spiffeID, Profile: profile}
+	if req.CSRPEM != "" {
+		resp.CertPEM, resp.ChainPEM, resp.Serial, err = s.issuer.SignCSRRequest([]byte(req.CSRPEM), leafReq)
+	} else {
+		resp.CertPEM, resp.KeyPEM, resp.ChainPEM, resp.Serial, err = s.issuer.IssueLeafRequest(leafReq)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// Let the agent retry with a corrected request.
+		s.store.mu.Lock()
+		bt.Used = false
+		s.store.mu.Unlock()
+		status := http.StatusInternalServerError
+		if errors.Is(err, ca.ErrInvalidCSR) || errors.Is(err, ca.ErrLint) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
-	// TODO: parse expires from cert
-	ic := &models.IssuedCert{
-		Serial:    serial,
-		ServiceID: serviceID,
-		CertPEM:   certPEM,
-		KeyPEM:    keyPEM,
-		ChainPEM:  chainPEM,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// allowedProfile resolves the profile for an issue call: the requested one
+// if the service was registered with it, else the service's first profile.
+// Services registered without profiles use the CA default.
+func allowedProfile(ident *models.ServiceIdentity, requested string) (string, bool) {
+	var profiles []string
+	if ident != nil {
+		profiles = ident.Profiles
 	}
-	s.store.mu.Lock()
-	s.store.certs[serial] = ic
-	s.store.mu.Unlock()
+	if requested == "" {
+		if len(profiles) > 0 {
+			return profiles[0], true
+		}
+		return "", true
+	}
+	if len(profiles) == 0 {
+		return requested, requested == ca.DefaultProfile
+	}
+	for _, p := range profiles {
+		if p == requested {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// jwtRequest is the /v1/jwt body. TTL is a Go duration and defaults to
+// ca.DefaultJWTSVIDTTL.
+type jwtRequest struct {
+	Audience []string `json:"audience"`
+	TTL      string   `json:"ttl,omitempty"`
+}
 
+type jwtResponse struct {
+	Token     string    `json:"token"`
+	SPIFFEID  string    `json:"spiffe_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleJWT issues a JWT-SVID to a workload that proves it holds a valid,
+// unrevoked X.509-SVID: the Authorization header carries a bearer proof
+// from jwtsvid.SignProof whose audience is the trust domain's ID
+// ("spiffe://<trust domain>"). The token's subject is the certificate's
+// SPIFFE ID.
+func (s *server) handleJWT(w http.ResponseWriter, r *http.Request) {
+	proof, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || proof == "" {
+		http.Error(w, "missing bearer proof", http.StatusUnauthorized)
+		return
+	}
+	var req jwtRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxIssueBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	bundle, err := s.ca.TrustBundle()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	svid, leaf, err := jwtsvid.ValidateProof(proof, s.trustDomain, bundle.X509Authorities, s.trustDomain.IDString(), now)
+	if err != nil {
+		http.Error(w, "invalid proof: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	rec, ok, err := s.ca.IssuanceDB().Get(fmt.Sprintf("%X", leaf.SerialNumber))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok || rec.StatusAt(now) != ca.StatusValid {
+		http.Error(w, "X.509-SVID is revoked or unknown to this CA", http.StatusUnauthorized)
+		return
+	}
+	token, expiresAt, err := s.ca.SignJWTSVID(svid.ID, req.Audience, ttl, now)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ca.ErrInvalidJWTSVIDRequest) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"cert_pem":"` + certPEM + `","key_pem":"` + keyPEM + `","chain_pem":"` + chainPEM + `","serial":"` + serial + `"}`))
+	json.NewEncoder(w).Encode(jwtResponse{Token: token, SPIFFEID: svid.ID.String(), ExpiresAt: expiresAt})
 }
 
 func (s *server) handleRevoke(w http.ResponseWriter, r *http.Request) {
@@ -138,26 +363,211 @@ func (s *server) handleRevoke(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "serial or service required", http.StatusBadRequest)
 		return
 	}
-	s.store.mu.Lock()
+	_, reason, err := ca.ParseRevocationReason(r.URL.Query().Get("reason"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	db := s.ca.IssuanceDB()
+	var revoked []ca.IssuedRecord
 	if service != "" {
-		for ser, ic := range s.store.certs {
-			if ic.ServiceID == service {
-				s.store.revoked[ser] = &models.RevocationEntry{Serial: ser, Reason: "revoked"}
-			}
+		id, err := spiffeid.ForService(s.trustDomain, service)
+		if err != nil {
+			http.Error(w, "invalid service: "+err.Error(), http.StatusBadRequest)
+			return
 		}
+		recs, err := db.Revoke(ca.IssuedFilter{SPIFFEID: id.String()}, reason, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		revoked = recs
 	} else {
-		s.store.revoked[serial] = &models.RevocationEntry{Serial: serial, Reason: "revoked"}
+		rec, err := db.RevokeSerial(serial, reason, time.Now())
+		if errors.Is(err, ca.ErrUnknownSerial) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		revoked = []ca.IssuedRecord{rec}
 	}
-	s.store.mu.Unlock()
-	w.WriteHeader(http.StatusOK)
+	s.crlMu.Lock()
+	err = s.ca.UpdateDeltaCRL(time.Now())
+	s.crlMu.Unlock()
+	if err != nil {
+		// The revocation is recorded; the periodic refresh retries the CRL.
+		log.Printf("update CRL after revocation: %v", err)
+		http.Error(w, "revoked, but CRL update failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]ca.IssuedRecord{"revoked": nonNil(revoked)})
+}
+
+// statusResponse lists unexpired certificates from the CA's issuance
+// database, split by status.
+type statusResponse struct {
+	Certs   []ca.IssuedRecord `json:"certs"`
+	Revoked []ca.IssuedRecord `json:"revoked"`
 }
 
 func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	// TODO: auth admin
-	s.store.mu.RLock()
-	defer s.store.mu.RUnlock()
+	filter := ca.IssuedFilter{}
+	if service := r.URL.Query().Get("service"); service != "" {
+		id, err := spiffeid.ForService(s.trustDomain, service)
+		if err != nil {
+			http.Error(w, "invalid service: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.SPIFFEID = id.String()
+	}
+	recs, err := s.ca.IssuanceDB().List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	var resp statusResponse
+	for _, rec := range recs {
+		switch rec.StatusAt(now) {
+		case ca.StatusValid:
+			resp.Certs = append(resp.Certs, rec)
+		case ca.StatusRevoked:
+			if now.Before(rec.NotAfter) {
+				resp.Revoked = append(resp.Revoked, rec)
+			}
+		}
+	}
+	resp.Certs, resp.Revoked = nonNil(resp.Certs), nonNil(resp.Revoked)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(recs []ca.IssuedRecord) []ca.IssuedRecord {
+	if recs == nil {
+		return []ca.IssuedRecord{}
+	}
+	return recs
+}
+
+// handleBundles returns the SPIFFE bundle of every trust domain agents
+// should trust, keyed by trust domain name: this CA's own and each
+// federated domain's last fetched bundle.
+func (s *server) handleBundles(w http.ResponseWriter, r *http.Request) {
+	own, err := s.ca.TrustBundle()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bundles, err := s.ca.FederatedBundles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bundles[s.trustDomain.Name()] = own
+	resp := map[string]json.RawMessage{}
+	for name, b := range bundles {
+		data, err := b.MarshalSPIFFE()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp[name] = data
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"certs":[],"revoked":[]}`))
+	json.NewEncoder(w).Encode(map[string]map[string]json.RawMessage{"trust_domains": resp})
+}
+
+// serveBundleEndpoint serves this trust domain's SPIFFE bundle to foreign
+// ones over TLS. With BUNDLE_ENDPOINT_PROFILE=https_spiffe (the default)
+// the endpoint presents an X.509-SVID for BUNDLE_ENDPOINT_SPIFFE_ID
+// (default spiffe://<trust domain>/bundle-endpoint) that it issues itself;
+// with https_web it presents BUNDLE_ENDPOINT_CERT and BUNDLE_ENDPOINT_KEY.
+func (s *server) serveBundleEndpoint(addr string) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch profile := getEnv("BUNDLE_ENDPOINT_PROFILE", ca.ProfileHTTPSSPIFFE); profile {
+	case ca.ProfileHTTPSSPIFFE:
+		id, err := spiffeid.FromPath(s.trustDomain, "/bundle-endpoint")
+		if v := os.Getenv("BUNDLE_ENDPOINT_SPIFFE_ID"); v != "" {
+			id, err = spiffeid.FromString(v)
+		}
+		if err != nil {
+			log.Fatalf("BUNDLE_ENDPOINT_SPIFFE_ID: %v", err)
+		}
+		tlsConfig.GetCertificate = (&federation.EndpointSVID{CA: s.ca, ID: id}).GetCertificate
+	case ca.ProfileHTTPSWeb:
+		cert, err := tls.LoadX509KeyPair(os.Getenv("BUNDLE_ENDPOINT_CERT"), os.Getenv("BUNDLE_ENDPOINT_KEY"))
+		if err != nil {
+			log.Fatalf("BUNDLE_ENDPOINT_CERT/KEY: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	default:
+		log.Fatalf("BUNDLE_ENDPOINT_PROFILE: want %s or %s, got %q", ca.ProfileHTTPSSPIFFE, ca.ProfileHTTPSWeb, profile)
+	}
+	srv := &http.Server{Addr: addr, Handler: federation.Handler(s.ca), TLSConfig: tlsConfig}
+	log.Printf("SPIFFE bundle endpoint listening on %s", addr)
+	log.Fatal(srv.ListenAndServeTLS("", ""))
+}
+
+// refreshCRL runs update at half of validity (def when unset), so relying
+// parties always hold a CRL whose NextUpdate has not passed.
+func (s *server) refreshCRL(validity, def time.Duration, update func(time.Time) error) {
+	if validity <= 0 {
+		validity = def
+	}
+	for range time.Tick(validity / 2) {
+		s.crlMu.Lock()
+		err := update(time.Now())
+		s.crlMu.Unlock()
+		if err != nil {
+			log.Printf("refresh CRL: %v", err)
+		}
+	}
+}
+
+// renewOCSPSigners keeps the delegated OCSP signers of the unretired
+// intermediates current for ocsp-responder, which cannot issue them itself.
+func (s *server) renewOCSPSigners() {
+	for {
+		renewed, err := s.ca.RenewOCSPSigners(time.Now())
+		if err != nil {
+			log.Printf("renew OCSP signers: %v", err)
+		}
+		for _, name := range renewed {
+			log.Printf("issued delegated OCSP signer for %s", name)
+		}
+		time.Sleep(ocspSignerCheckInterval)
+	}
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
+
+// certPoolEnv returns the certificates in the PEM file named by the
+// variable, or nil (the system roots) when it is unset.
+func certPoolEnv(name string) *x509.CertPool {
+	path := os.Getenv(name)
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		log.Fatalf("%s: no certificates in %s", name, path)
+	}
+	return pool
 }
 
 func randomHex(n int) string {
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/models"
+)
+
+func runRoot(args []string) {
+	if len(args) < 1 {
+		fail("usage: ztca root {init|sign-intermediate|rollover|crl|split|combine} [flags]")
+	}
+	switch args[0] {
+	case "init":
+		runRootInit(args[1:])
+	case "sign-intermediate":
+		runRootSignIntermediate(args[1:])
+	case "rollover":
+		runRootRollover(args[1:])
+	case "crl":
+		runRootCRL(args[1:])
+	case "split":
+		runRootSplit(args[1:])
+	case "combine":
+		runRootCombine(args[1:])
+	default:
+		fail("unknown root command %q", args[0])
+	}
+}
+
+func runIntermediate(args []string) {
+	if len(args) < 1 {
+		fail("usage: ztca intermediate {csr|install|rotate|retire|list} [flags]")
+	}
+	switch args[0] {
+	case "csr":
+		runIntermediateCSR(args[1:])
+	case "install":
+		runIntermediateInstall(args[1:])
+	case "rotate":
+		runIntermediateRotate(args[1:])
+	case "retire":
+		runIntermediateRetire(args[1:])
+	case "list":
+		runIntermediateList(args[1:])
+	default:
+		fail("unknown intermediate command %q", args[0])
+	}
+}
+
+func runRootInit(args []string) {
+	fs := flag.NewFlagSet("root init", flag.ExitOnError)
+	dir := fs.String("dir", defaultRootDir, "root CA directory (keep on the offline host)")
+	alg := keyAlgFlag(fs, "key-alg", "root CA key algorithm")
+	passSpec := fs.String("passphrase", passphraseSource(), "passphrase source for encrypting root.key")
+	td := trustDomainFlag(fs)
+	constraints := nameConstraintFlags(fs, td)
+	distribution := distributionFlags(fs, false, true)
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:          *dir,
+		TrustDomain:      parseTrustDomain(*td),
+		RootKeyAlgorithm: parseKeyAlg("key-alg", *alg),
+		KeyStore:         openKeyStore(*dir, *passSpec, true),
+		NameConstraints:  constraints(),
+		Distribution:     distribution(),
+	}
+	if err := cfg.InitRoot(); err != nil {
+		fail("root init failed: %v", err)
+	}
+	fmt.Printf("Root CA created in %s. Copy only %s to the RA host.\n", *dir, filepath.Join(*dir, "root.crt"))
+}
+
+func runRootSignIntermediate(args []string) {
+	fs := flag.NewFlagSet("root sign-intermediate", flag.ExitOnError)
+	dir := fs.String("dir", defaultRootDir, "root CA directory")
+	csrPath := fs.String("csr", "", "intermediate CSR produced by `ztca intermediate csr`")
+	out := fs.String("out", "intermediate.crt", "where to write the signed intermediate certificate")
+	fs.Parse(args)
+	if *csrPath == "" {
+		fail("usage: ztca root sign-intermediate --csr <file> [--dir dir] [--out file]")
+	}
+	csrPEM, err := os.ReadFile(*csrPath)
+	if err != nil {
+		fail("read CSR: %v", err)
+	}
+	cfg := ca.Config{BaseDir: *dir, KeyStore: openKeyStore(*dir, passphraseSource(), false)}
+	certPEM, err := cfg.SignIntermediate(csrPEM)
+	if err != nil {
+		fail("sign intermediate failed: %v", err)
+	}
+	if err := os.WriteFile(*out, certPEM, 0644); err != nil {
+		fail("write %s: %v", *out, err)
+	}
+	fmt.Printf("Signed intermediate written to %s. Carry it and %s to the RA host.\n", *out, filepath.Join(*dir, "root.crt"))
+}
+
+func runIntermediateCSR(args []string) {
+	fs := flag.NewFlagSet("intermediate csr", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	alg := keyAlgFlag(fs, "key-alg", "intermediate CA key algorithm")
+	passSpec := fs.String("passphrase", passphraseSource(), "passphrase source for encrypting intermediate.key")
+	td := trustDomainFlag(fs)
+	distribution := distributionFlags(fs, true, false)
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:                  *dir,
+		TrustDomain:              parseTrustDomain(*td),
+		IntermediateKeyAlgorithm: parseKeyAlg("key-alg", *alg),
+		KeyStore:                 openKeyStore(*dir, *passSpec, true),
+		Distribution:             distribution(),
+	}
+	if _, err := cfg.CreateIntermediateCSR(); err != nil {
+		fail("intermediate csr failed: %v", err)
+	}
+	fmt.Printf("Intermediate key and CSR created. Sign %s on the root host.\n", filepath.Join(*dir, "intermediate.csr"))
+}
+
+func runIntermediateInstall(args []string) {
+	fs := flag.NewFlagSet("intermediate install", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	certPath := fs.String("cert", "", "signed intermediate certificate")
+	rootPath := fs.String("root", "", "root CA certificate")
+	fs.Parse(args)
+	if *certPath == "" || *rootPath == "" {
+		fail("usage: ztca intermediate install --cert <file> --root <file> [--dir dir]")
+	}
+	certPEM, err := os.ReadFile(*certPath)
+	if err != nil {
+		fail("read certificate: %v", err)
+	}
+	rootPEM, err := os.ReadFile(*rootPath)
+	if err != nil {
+		fail("read root: %v", err)
+	}
+	cfg := ca.Config{BaseDir: *dir, KeyStore: openKeyStore(*dir, passphraseSource(), false)}
+	if err := cfg.InstallIntermediate(certPEM, rootPEM); err != nil {
+		fail("intermediate install failed: %v", err)
+	}
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		fail("create CRL failed: %v", err)
+	}
+	fmt.Println("Intermediate installed: intermediate.crt, root.crt, trust-bundle, crl in", *dir)
+}
+
+func runIntermediateRotate(args []string) {
+	fs := flag.NewFlagSet("intermediate rotate", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	rootDir := fs.String("root-dir", defaultRootDir, "root CA directory (one-step rotation)")
+	alg := keyAlgFlag(fs, "key-alg", "new intermediate key algorithm")
+	delay := fs.Duration("delay", 24*time.Hour, "propagation delay before the new intermediate signs leaves")
+	csrOnly := fs.Bool("csr-only", false, "offline root: only create the new key and CSR")
+	certPath := fs.String("cert", "", "offline root: stage the signed certificate for the pending key")
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:                  *dir,
+		IntermediateKeyAlgorithm: parseKeyAlg("key-alg", *alg),
+		KeyStore:                 openKeyStore(*dir, passphraseSource(), false),
+	}
+	var rec ca.IntermediateRecord
+	var err error
+	switch {
+	case *csrOnly:
+		name, _, err := cfg.PrepareIntermediateRotation()
+		if err != nil {
+			fail("rotate failed: %v", err)
+		}
+		fmt.Printf("Created %s key. Sign %s on the root host, then run: ztca intermediate rotate --cert <file>\n",
+			name, filepath.Join(*dir, name+".csr"))
+		return
+	case *certPath != "":
+		certPEM, rerr := os.ReadFile(*certPath)
+		if rerr != nil {
+			fail("read certificate: %v", rerr)
+		}
+		rec, err = cfg.StageIntermediate(certPEM, *delay)
+	default:
+		root := ca.Config{BaseDir: *rootDir, KeyStore: openKeyStore(*rootDir, passphraseSource(), false)}
+		rec, err = cfg.RotateIntermediate(&root, *delay)
+	}
+	if err != nil {
+		fail("rotate failed: %v", err)
+	}
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		fail("update CRL failed: %v", err)
+	}
+	fmt.Printf("Staged %s (serial %s). Trust bundle now carries old and new intermediates.\n", rec.Name, rec.Serial)
+	fmt.Printf("Issuance switches at %s.\n", rec.ActivateAt.Format(time.RFC3339))
+}
+
+func runIntermediateRetire(args []string) {
+	fs := flag.NewFlagSet("intermediate retire", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: *dir}
+	retired, err := cfg.RetireIntermediates(time.Now())
+	if err != nil {
+		fail("retire failed: %v", err)
+	}
+	if len(retired) == 0 {
+		fmt.Println("No intermediates due for retirement.")
+		return
+	}
+	for _, r := range retired {
+		fmt.Printf("Retired %s (serial %s); removed from trust bundle.\n", r.Name, r.Serial)
+	}
+}
+
+func runIntermediateList(args []string) {
+	fs := flag.NewFlagSet("intermediate list", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: *dir}
+	recs, err := cfg.Intermediates()
+	if err != nil {
+		fail("list failed: %v", err)
+	}
+	now := time.Now()
+	for _, r := range recs {
+		state := "active"
+		switch {
+		case r.Retired:
+			state = "retired"
+		case now.Before(r.ActivateAt):
+			state = "pending"
+		case !r.RetireAt.IsZero():
+			state = "retiring"
+		}
+		retire := "-"
+		if !r.RetireAt.IsZero() {
+			retire = r.RetireAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%-16s %-8s serial=%s activate=%s retire=%s expires=%s\n", r.Name, state, r.Serial,
+			r.ActivateAt.Format(time.RFC3339), retire, r.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func runRootCRL(args []string) {
+	fs := flag.NewFlagSet("root crl", flag.ExitOnError)
+	dir := fs.String("dir", defaultRootDir, "root CA directory")
+	revoke := fs.String("revoke", "", "comma-separated intermediate serials to add to the revoked list, each optionally serial:reason")
+	validity := fs.Duration("validity", ca.DefaultRootCRLValidity, "time from ThisUpdate to NextUpdate")
+	fs.Parse(args)
+	now := time.Now()
+	var entries []models.RevocationEntry
+	for _, v := range cliutil.SplitList(*revoke) {
+		serial, reason, _ := strings.Cut(v, ":")
+		if _, _, err := ca.ParseRevocationReason(reason); err != nil {
+			fail("--revoke: %v", err)
+		}
+		entries = append(entries, models.RevocationEntry{Serial: serial, RevokedAt: now, Reason: reason})
+	}
+	cfg := ca.Config{BaseDir: *dir, KeyStore: openKeyStore(*dir, passphraseSource(), false), CRLValidity: *validity}
+	if _, err := cfg.CreateRootCRL(entries, now); err != nil {
+		fail("root crl failed: %v", err)
+	}
+	revoked, err := cfg.RootRevocations()
+	if err != nil {
+		fail("root crl failed: %v", err)
+	}
+	fmt.Printf("Wrote %s (%d revoked intermediates, next update in %s). Publish it at the intermediates' --root-crl-url.\n",
+		filepath.Join(*dir, "root.crl"), len(revoked), *validity)
+}
+
+func runRootRollover(args []string) {
+	if len(args) < 1 {
+		fail("usage: ztca root rollover {begin|stage|finish} [flags]")
+	}
+	switch args[0] {
+	case "begin":
+		runRootRolloverBegin(args[1:])
+	case "stage":
+		runRootRolloverStage(args[1:])
+	case "finish":
+		runRootRolloverFinish(args[1:])
+	default:
+		fail("unknown root rollover command %q", args[0])
+	}
+}
+
+func runRootRolloverBegin(args []string) {
+	fs := flag.NewFlagSet("root rollover begin", flag.ExitOnError)
+	dir := fs.String("dir", defaultRootDir, "root CA directory")
+	alg := keyAlgFlag(fs, "key-alg", "new root CA key algorithm")
+	passSpec := fs.String("passphrase", passphraseSource(), "passphrase source for the root keys")
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:          *dir,
+		RootKeyAlgorithm: parseKeyAlg("key-alg", *alg),
+		KeyStore:         openKeyStore(*dir, *passSpec, false),
+	}
+	rr, err := cfg.RolloverRoot()
+	if err != nil {
+		fail("root rollover failed: %v", err)
+	}
+	fmt.Printf("Created %s (serial %s), cross-signed with %s.\n", rr.New.Name, rr.New.Serial, rr.Old.Name)
+	fmt.Printf("Carry %s.crt, %s-by-%s.crt and %s-by-%s.crt to the RA host and run: ztca root rollover stage\n",
+		rr.New.Name, rr.New.Name, rr.Old.Name, rr.Old.Name, rr.New.Name)
+	fmt.Printf("New intermediates are now signed by %s. Relying-party bundles: %s (transition), %s (final).\n",
+		rr.New.Name, rr.Transitional, rr.Final)
+}
+
+func runRootRolloverStage(args []string) {
+	fs := flag.NewFlagSet("root rollover stage", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	newRoot := fs.String("new-root", "", "new self-signed root certificate")
+	newByOld := fs.String("new-by-old", "", "new root cross-signed by the current root")
+	oldByNew := fs.String("old-by-new", "", "current root cross-signed by the new root")
+	fs.Parse(args)
+	if *newRoot == "" || *newByOld == "" || *oldByNew == "" {
+		fail("usage: ztca root rollover stage --new-root <file> --new-by-old <file> --old-by-new <file> [--dir dir]")
+	}
+	var pems [3][]byte
+	for i, path := range []string{*newRoot, *newByOld, *oldByNew} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fail("read %s: %v", path, err)
+		}
+		pems[i] = data
+	}
+	cfg := ca.Config{BaseDir: *dir}
+	if err := cfg.StageRootRollover(pems[0], pems[1], pems[2]); err != nil {
+		fail("root rollover stage failed: %v", err)
+	}
+	fmt.Println("New root staged; trust bundle now carries both roots.")
+	fmt.Println("Rotate the intermediate (ztca intermediate rotate), then run `ztca root rollover finish` once relying parties have the new bundle.")
+}
+
+func runRootRolloverFinish(args []string) {
+	fs := flag.NewFlagSet("root rollover finish", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: *dir}
+	if err := cfg.FinishRootRollover(); err != nil {
+		fail("root rollover finish failed: %v", err)
+	}
+	fmt.Println("New root promoted to root.crt; old root dropped from the trust bundle.")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/federation"
+)
+
+func runFederation(args []string) {
+	if len(args) < 1 {
+		fail("usage: ztca federation {add|remove|list|refresh} [flags]")
+	}
+	switch args[0] {
+	case "add":
+		runFederationAdd(args[1:])
+	case "remove":
+		runFederationRemove(args[1:])
+	case "list":
+		runFederationList(args[1:])
+	case "refresh":
+		runFederationRefresh(args[1:])
+	default:
+		fail("unknown federation command %q", args[0])
+	}
+}
+
+func runFederationAdd(args []string) {
+	fs := flag.NewFlagSet("federation add", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	td := fs.String("trust-domain", "", "foreign trust domain (required)")
+	endpoint := fs.String("url", "", "foreign bundle endpoint URL (required)")
+	profile := fs.String("profile", ca.ProfileHTTPSWeb, "bundle endpoint profile: https_web or https_spiffe")
+	endpointID := fs.String("endpoint-id", "", "https_spiffe: SPIFFE ID the endpoint presents")
+	bundlePath := fs.String("bundle", "", "bootstrap bundle of the foreign domain (required for https_spiffe)")
+	fs.Parse(args)
+	if *td == "" || *endpoint == "" {
+		fail("usage: ztca federation add --trust-domain <td> --url <https url> [--profile https_web|https_spiffe --endpoint-id <id> --bundle <file>]")
+	}
+	rel := ca.FederationRelationship{
+		TrustDomain:       parseTrustDomain(*td),
+		BundleEndpointURL: *endpoint,
+		Profile:           *profile,
+		EndpointSPIFFEID:  *endpointID,
+	}
+	var bootstrap *ca.TrustBundle
+	if *bundlePath != "" {
+		data, err := os.ReadFile(*bundlePath)
+		if err != nil {
+			fail("read bundle: %v", err)
+		}
+		if bootstrap, err = ca.ParseTrustBundle(data); err != nil {
+			fail("bundle: %v", err)
+		}
+	}
+	cfg := ca.Config{BaseDir: *dir}
+	if err := cfg.SetFederationRelationship(rel, bootstrap); err != nil {
+		fail("federation add failed: %v", err)
+	}
+	fmt.Printf("Federating with %s via %s (%s). The RA fetches its bundle on the next refresh.\n", rel.TrustDomain, rel.BundleEndpointURL, rel.Profile)
+}
+
+func runFederationRemove(args []string) {
+	fs := flag.NewFlagSet("federation remove", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fail("usage: ztca federation remove <trust domain>")
+	}
+	cfg := ca.Config{BaseDir: *dir}
+	td := parseTrustDomain(fs.Arg(0))
+	if err := cfg.RemoveFederationRelationship(td); err != nil {
+		fail("federation remove failed: %v", err)
+	}
+	fmt.Printf("Stopped federating with %s; its bundle was deleted.\n", td)
+}
+
+func runFederationList(args []string) {
+	fs := flag.NewFlagSet("federation list", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: *dir}
+	rels, err := cfg.FederationRelationships()
+	if err != nil {
+		fail("list failed: %v", err)
+	}
+	bundles, err := cfg.FederatedBundles()
+	if err != nil {
+		fail("list failed: %v", err)
+	}
+	for _, r := range rels {
+		state := "not fetched"
+		if b, ok := bundles[r.TrustDomain.Name()]; ok {
+			state = fmt.Sprintf("sequence=%d x509=%d jwt=%d", b.Sequence, len(b.X509Authorities), len(b.JWTAuthorities))
+		}
+		fmt.Printf("%-24s %-12s %s %s\n", r.TrustDomain, r.Profile, r.BundleEndpointURL, state)
+	}
+}
+
+func runFederationRefresh(args []string) {
+	fs := flag.NewFlagSet("federation refresh", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	caFile := fs.String("ca-file", "", "https_web: PEM roots to trust instead of the system roots")
+	fs.Parse(args)
+	r := &federation.Refresher{CA: &ca.Config{BaseDir: *dir}, Logf: func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}}
+	if *caFile != "" {
+		data, err := os.ReadFile(*caFile)
+		if err != nil {
+			fail("read --ca-file: %v", err)
+		}
+		r.WebRoots = x509.NewCertPool()
+		if !r.WebRoots.AppendCertsFromPEM(data) {
+			fail("--ca-file: no certificates")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := r.Refresh(ctx, time.Now()); err != nil {
+		fail("refresh failed: %v", err)
+	}
+	fmt.Println("Federated bundles refreshed.")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/pkcs12"
+)
+
+// runImport adopts an intermediate CA of an existing PKI as this CA's
+// signing intermediate.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "CA directory to create")
+	certPath := fs.String("cert", "", "intermediate certificate (PEM)")
+	keyPath := fs.String("key", "", "intermediate private key: PEM (PKCS#1, SEC 1, PKCS#8) or DER PKCS#8, optionally encrypted")
+	p12Path := fs.String("pkcs12", "", "intermediate key and certificate as a PKCS#12 file, instead of --cert and --key")
+	rootPath := fs.String("root", "", "root CA certificate the intermediate must chain to (required); put issuing CAs after the intermediate in --cert")
+	keyPass := fs.String("key-password", "prompt", "password source for an encrypted --key or --pkcs12 (env:NAME, file:PATH, fd:N, prompt)")
+	passSpec := fs.String("passphrase", passphraseSource(), "passphrase source for encrypting the imported key")
+	noPass := fs.Bool("no-passphrase", false, "write the imported key unencrypted (demo only)")
+	td := trustDomainFlag(fs)
+	distribution := distributionFlags(fs, true, false)
+	fs.Parse(args)
+	if *rootPath == "" || (*p12Path == "") == (*certPath == "") || (*p12Path != "" && *keyPath != "") {
+		fail("usage: ztca import --root <file> (--cert <file> [--key <file>] | --pkcs12 <file>) [--dir ca] [--trust-domain td]")
+	}
+	if *noPass {
+		*passSpec = ""
+	}
+	rootPEM, err := os.ReadFile(*rootPath)
+	if err != nil {
+		fail("read root: %v", err)
+	}
+
+	var key crypto.Signer
+	var certPEM []byte
+	if *p12Path != "" {
+		data, err := os.ReadFile(*p12Path)
+		if err != nil {
+			fail("read PKCS#12: %v", err)
+		}
+		k, certs, err := pkcs12.Decode(data, string(importPassword(*keyPass)))
+		if err != nil {
+			fail("%s: %v", *p12Path, err)
+		}
+		if k == nil {
+			fail("%s holds no private key", *p12Path)
+		}
+		key = k
+		// Decode puts the key's certificate first; the rest may be issuing CAs.
+		for _, c := range certs {
+			certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+		}
+	} else {
+		if certPEM, err = os.ReadFile(*certPath); err != nil {
+			fail("read certificate: %v", err)
+		}
+		// Without --key the intermediate key must already be in CA_KEYSTORE.
+		if *keyPath != "" {
+			key = readImportKey(*keyPath, *keyPass)
+		}
+	}
+
+	cfg := ca.Config{
+		BaseDir:      *dir,
+		TrustDomain:  parseTrustDomain(*td),
+		KeyStore:     openKeyStore(*dir, *passSpec, true),
+		Distribution: distribution(),
+		Logf:         warnf,
+	}
+	if err := cfg.ImportIntermediate(key, certPEM, rootPEM); err != nil {
+		fail("import failed: %v", err)
+	}
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		fail("create CRL failed: %v", err)
+	}
+	inter, err := ca.ParseCertificatePEM(certPEM)
+	if err != nil {
+		fail("%v", err)
+	}
+	fmt.Printf("Imported intermediate %q (issued by %q, expires %s): intermediate.crt, root.crt, trust-bundle, crl in %s\n",
+		inter.Subject.CommonName, inter.Issuer.CommonName, inter.NotAfter.Format(time.RFC3339), *dir)
+}
+
+// readImportKey reads a PEM or DER private key, decrypting it with the
+// password from passSpec if it is encrypted.
+func readImportKey(path, passSpec string) crypto.Signer {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fail("read key: %v", err)
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		if k, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+			if signer, ok := k.(crypto.Signer); ok {
+				return signer
+			}
+			fail("%s: unsupported private key type %T", path, k)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: data})
+	}
+	var pass []byte
+	if ca.IsEncryptedKeyPEM(data) {
+		pass = importPassword(passSpec)
+	}
+	key, err := ca.DecryptPrivateKeyPEM(data, pass)
+	if err != nil {
+		fail("%s: %v", path, err)
+	}
+	return key
+}
+
+func importPassword(spec string) []byte {
+	read, err := ca.PassphraseFromSpec(spec, false)
+	if err != nil {
+		fail("--key-password: %v", err)
+	}
+	if read == nil {
+		fail("--key-password: a password source is required")
+	}
+	pass, err := read()
+	if err != nil {
+		fail("key password: %v", err)
+	}
+	return pass
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/jwtsvid"
+)
+
+func runJWT(args []string) {
+	if len(args) < 1 {
+		fail("usage: ztca jwt {rotate|retire|list|issue|validate} [flags]")
+	}
+	switch args[0] {
+	case "rotate":
+		runJWTRotate(args[1:])
+	case "retire":
+		runJWTRetire(args[1:])
+	case "list":
+		runJWTList(args[1:])
+	case "issue":
+		runJWTIssue(args[1:])
+	case "validate":
+		runJWTValidate(args[1:])
+	default:
+		fail("unknown jwt command %q", args[0])
+	}
+}
+
+func runJWTRotate(args []string) {
+	fs := flag.NewFlagSet("jwt rotate", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	alg := fs.String("key-alg", string(ca.DefaultJWTKeyAlgorithm), "JWT signing key algorithm (RSA or ECDSA)")
+	delay := fs.Duration("delay", 24*time.Hour, "propagation delay before the new key signs JWT-SVIDs")
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:         *dir,
+		JWTKeyAlgorithm: parseKeyAlg("key-alg", *alg),
+		KeyStore:        openKeyStore(*dir, passphraseSource(), false),
+	}
+	rec, err := cfg.RotateJWTKey(*delay)
+	if err != nil {
+		fail("rotate failed: %v", err)
+	}
+	fmt.Printf("Staged %s (kid %s). Trust bundle and jwks.json now carry it.\n", rec.Name, rec.KeyID)
+	fmt.Printf("Signing switches at %s.\n", rec.ActivateAt.Format(time.RFC3339))
+}
+
+func runJWTRetire(args []string) {
+	fs := flag.NewFlagSet("jwt retire", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: *dir}
+	retired, err := cfg.RetireJWTKeys(time.Now())
+	if err != nil {
+		fail("retire failed: %v", err)
+	}
+	if len(retired) == 0 {
+		fmt.Println("No JWT keys due for retirement.")
+		return
+	}
+	for _, r := range retired {
+		fmt.Printf("Retired %s (kid %s); removed from trust bundle.\n", r.Name, r.KeyID)
+	}
+}
+
+func runJWTList(args []string) {
+	fs := flag.NewFlagSet("jwt list", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "online CA directory")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: *dir}
+	recs, err := cfg.JWTKeys()
+	if err != nil {
+		fail("list failed: %v", err)
+	}
+	if len(recs) == 0 {
+		fmt.Println("No JWT signing keys (run: ztca jwt rotate --delay 0).")
+		return
+	}
+	now := time.Now()
+	for _, r := range recs {
+		state := "active"
+		switch {
+		case r.Retired:
+			state = "retired"
+		case now.Before(r.ActivateAt):
+			state = "pending"
+		case !r.RetireAt.IsZero():
+			state = "retiring"
+		}
+		retire := "-"
+		if !r.RetireAt.IsZero() {
+			retire = r.RetireAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%-8s %-8s kid=%s activate=%s retire=%s\n", r.Name, state, r.KeyID,
+			r.ActivateAt.Format(time.RFC3339), retire)
+	}
+}
+
+func runJWTIssue(args []string) {
+	fs := flag.NewFlagSet("jwt issue", flag.ExitOnError)
+	audience := fs.String("audience", "", "comma-separated audiences (required)")
+	ttl := fs.Duration("ttl", ca.DefaultJWTSVIDTTL, "token lifetime")
+	fs.Parse(args)
+	if fs.NArg() < 1 || *audience == "" {
+		fail("usage: ztca jwt issue --audience aud[,aud...] [--ttl 5m] <service>")
+	}
+	cfg := ca.Config{BaseDir: defaultCADir, KeyStore: openKeyStore(defaultCADir, passphraseSource(), false)}
+	token, expiresAt, err := cfg.SignJWTSVID(serviceID(&cfg, fs.Arg(0)), cliutil.SplitList(*audience), *ttl, time.Now())
+	if err != nil {
+		fail("issue failed: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "JWT-SVID for %s, expires %s\n", fs.Arg(0), expiresAt.Format(time.RFC3339))
+	fmt.Println(token)
+}
+
+// runJWTValidate checks a JWT-SVID (argument or stdin) against a trust
+// bundle, by default the CA's own.
+func runJWTValidate(args []string) {
+	fs := flag.NewFlagSet("jwt validate", flag.ExitOnError)
+	audience := fs.String("audience", "", "audience the token must carry (required)")
+	bundlePath := fs.String("bundle", "", "trust bundle (SPIFFE format) holding the JWT authorities (default: ca/)")
+	fs.Parse(args)
+	if *audience == "" {
+		fail("usage: ztca jwt validate --audience aud [--bundle file] [token]")
+	}
+	token := fs.Arg(0)
+	if token == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fail("read token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	cfg := ca.Config{BaseDir: defaultCADir}
+	td, err := cfg.LoadTrustDomain()
+	if err != nil {
+		fail("trust domain: %v", err)
+	}
+	var b *ca.TrustBundle
+	if *bundlePath != "" {
+		var data []byte
+		if data, err = os.ReadFile(*bundlePath); err == nil {
+			b, err = ca.ParseTrustBundle(data)
+		}
+	} else {
+		b, err = cfg.TrustBundle()
+	}
+	if err != nil {
+		fail("read trust bundle: %v", err)
+	}
+	svid, err := jwtsvid.Validate(token, td, b.JWTAuthorities, *audience, time.Now())
+	if err != nil {
+		fail("invalid: %v", err)
+	}
+	fmt.Printf("valid: %s, audience %s, expires %s\n", svid.ID, strings.Join(svid.Audience, ","), svid.Expiry.Format(time.RFC3339))
+}
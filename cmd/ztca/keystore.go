@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/pkcs12"
+)
+
+// parseFormats parses the --format list of `ztca issue`.
+func parseFormats(value string) (pem, p12 bool) {
+	for _, v := range cliutil.SplitList(value) {
+		switch v {
+		case "pem":
+			pem = true
+		case "pkcs12":
+			p12 = true
+		default:
+			fail("--format: unknown format %q (want pem, pkcs12)", v)
+		}
+	}
+	if !pem && !p12 {
+		fail("--format: no format selected")
+	}
+	return pem, p12
+}
+
+// writeKeyStores writes keystore.p12 (the key with chainPEM) and
+// truststore.p12 (the CA's trust bundle) to dir, protected by the
+// password from passSpec.
+func writeKeyStores(cfg *ca.Config, dir, keyPEM, chainPEM, passSpec string) {
+	read, err := ca.PassphraseFromSpec(passSpec, true)
+	if err != nil {
+		fail("--keystore-password: %v", err)
+	}
+	if read == nil {
+		fail("--keystore-password: a password source is required")
+	}
+	pass, err := read()
+	if err != nil {
+		fail("keystore password: %v", err)
+	}
+	if len(pass) == 0 {
+		fail("keystore password is empty")
+	}
+	key, err := ca.ParsePrivateKeyPEM([]byte(keyPEM))
+	if err != nil {
+		fail("parse key: %v", err)
+	}
+	chain, err := ca.ParseCertificatesPEM([]byte(chainPEM))
+	if err != nil || len(chain) == 0 {
+		fail("parse chain: %v", err)
+	}
+	bundle, err := cfg.TrustBundle()
+	if err != nil {
+		fail("read trust bundle: %v", err)
+	}
+	keystore, err := pkcs12.Encode(key, chain[0], chain[1:], cliutil.KeyStoreAlias, string(pass))
+	if err != nil {
+		fail("keystore: %v", err)
+	}
+	truststore, err := pkcs12.EncodeTrustStore(bundle.X509Authorities, string(pass))
+	if err != nil {
+		fail("truststore: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "keystore.p12"), keystore, 0600); err != nil {
+		fail("write keystore: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "truststore.p12"), truststore, 0644); err != nil {
+		fail("write truststore: %v", err)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a service reloading path never reads a partial file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
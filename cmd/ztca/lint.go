@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+)
+
+// runLint audits issued leaf certificates with the pre-issuance lint rules,
+// at the levels configured for the CA in --dir.
+func runLint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	dir := fs.String("dir", defaultCADir, "CA directory (lint policy and intermediates)")
+	issuerPath := fs.String("issuer", "", "issuing certificate (default: next in the file, else the matching intermediate in --dir)")
+	rules := fs.Bool("rules", false, "list the lint rules and their levels, then exit")
+	fs.Parse(args)
+	cfg := &ca.Config{BaseDir: *dir}
+	policy, err := cfg.LintPolicy()
+	if err != nil {
+		fail("lint policy: %v", err)
+	}
+	if *rules {
+		for _, r := range ca.LintRules() {
+			level := r.DefaultLevel
+			if l, ok := policy[r.Name]; ok {
+				level = l
+			}
+			fmt.Printf("%-24s %-6s %s\n", r.Name, level, r.Description)
+		}
+		return
+	}
+	if fs.NArg() == 0 {
+		fail("usage: ztca lint [--dir ca] [--issuer file] <cert-file>...")
+	}
+	var issuer *x509.Certificate
+	if *issuerPath != "" {
+		data, err := os.ReadFile(*issuerPath)
+		if err != nil {
+			fail("read issuer: %v", err)
+		}
+		if issuer, err = ca.ParseCertificatePEM(data); err != nil {
+			fail("%s: %v", *issuerPath, err)
+		}
+	}
+
+	failed := false
+	for _, p := range fs.Args() {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			fail("read certificate: %v", err)
+		}
+		certs, err := ca.ParseCertificatesPEM(data)
+		if err != nil {
+			fail("%s: %v", p, err)
+		}
+		cert, parent := certs[0], issuer
+		if parent == nil && len(certs) > 1 {
+			parent = certs[1]
+		}
+		if parent == nil {
+			parent = findIssuer(*dir, cert)
+		}
+		if parent == nil {
+			fmt.Printf("%s: issuer not found, skipping checks against it\n", p)
+		} else if err := cert.CheckSignatureFrom(parent); err != nil {
+			fail("%s: not signed by %q: %v", p, parent.Subject.CommonName, err)
+		}
+		findings := ca.LintCertificate(cert, parent, policy)
+		if len(findings) == 0 {
+			fmt.Printf("%s: serial %X ok\n", p, cert.SerialNumber)
+			continue
+		}
+		for _, f := range findings {
+			fmt.Printf("%s: serial %X %s\n", p, cert.SerialNumber, f)
+			if f.Level == ca.LintError {
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// findIssuer returns the intermediate in dir that signed cert, or nil.
+func findIssuer(dir string, cert *x509.Certificate) *x509.Certificate {
+	recs, err := (&ca.Config{BaseDir: dir}).Intermediates()
+	if err != nil {
+		return nil
+	}
+	for _, rec := range recs {
+		data, err := os.ReadFile(filepath.Join(dir, rec.Name+".crt"))
+		if err != nil {
+			continue
+		}
+		inter, err := ca.ParseCertificatePEM(data)
+		if err == nil && cert.CheckSignatureFrom(inter) == nil {
+			return inter
+		}
+	}
+	return nil
+}
@@ -3,16 +3,25 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"text/tabwriter"
+	"time"
 
+	"github.com/zero-trust/zt-identity/internal/cliutil"
 	"github.com/zero-trust/zt-identity/pkg/ca"
 	"github.com/zero-trust/zt-identity/pkg/health"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
 )
 
-const defaultCADir = "ca"
+const (
+	defaultCADir   = "ca"
+	defaultRootDir = "ca-root"
+)
 
 func main() {
 	if len(os.Args) < 2 {
@@ -24,7 +33,11 @@ func main() {
 
 	switch cmd {
 	case "init":
-		runInit()
+		runInit(args)
+	case "root":
+		runRoot(args)
+	case "intermediate":
+		runIntermediate(args)
 	case "serve":
 		runServe(args)
 	case "register":
@@ -34,15 +47,31 @@ func main() {
 		}
 		runRegister(args[0])
 	case "issue":
-		if len(args) < 1 {
-			fmt.Fprintln(os.Stderr, "usage: ztca issue <service>")
-			os.Exit(1)
-		}
-		runIssue(args[0])
+		runIssue(args)
 	case "revoke":
 		runRevoke(args)
 	case "status":
-		runStatus()
+		runStatus(args)
+	case "crl":
+		runCRL(args)
+	case "ocsp":
+		runOCSP(args)
+	case "bundle":
+		runBundle(args)
+	case "jwt":
+		runJWT(args)
+	case "federation":
+		runFederation(args)
+	case "backup":
+		runBackup(args)
+	case "restore":
+		runRestore(args)
+	case "log":
+		runLog(args)
+	case "lint":
+		runLint(args)
+	case "import":
+		runImport(args)
 	default:
 		printUsage()
 		os.Exit(1)
@@ -53,39 +82,285 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, `ztca - Zero-Trust Certificate Authority CLI
 
 Usage:
-  ztca init                         Create Root + Intermediate CA, trust bundle
+  ztca init [flags]                 Create Root + Intermediate CA, trust bundle (demo: one directory)
+  ztca root init [flags]            Offline: create Root CA in --dir (default ca-root)
+  ztca root sign-intermediate --csr <file> [flags]
+                                    Offline: sign an intermediate CSR with the root
+  ztca root crl [--revoke serial[:reason],...]
+                                    Offline: sign root.crl for the intermediates' CRL URL
+  ztca root split --shares N --threshold M [flags]
+                                    Offline: split root.key into printable shares, then delete it
+  ztca root combine [--restore] <share-file|-> ...
+                                    Offline: check (or restore) a split key from its shares
+  ztca root rollover begin [flags]  Offline: create the next root and cross-certificates
+  ztca root rollover stage --new-root <file> --new-by-old <file> --old-by-new <file>
+                                    RA host: trust both roots during a rollover
+  ztca root rollover finish         RA host: promote the new root, drop the old one
+  ztca import --root <file> (--cert <file> --key <file> | --pkcs12 <file>) [flags]
+                                    Run under an existing (enterprise) root: adopt its intermediate
+  ztca intermediate csr [flags]     RA host: create intermediate key + intermediate.csr
+  ztca intermediate install --cert <file> --root <file> [flags]
+                                    RA host: verify and install the signed intermediate
+  ztca intermediate rotate [--delay 24h] [--root-dir dir | --csr-only | --cert <file>]
+                                    Stage a new intermediate alongside the current one
+  ztca intermediate retire          Drop intermediates whose leaves have all expired
+  ztca intermediate list            Show intermediate generations and their state
   ztca register <service>           Register service, output bootstrap token
-  ztca issue <service>              Issue leaf cert (admin; agents use API)
-  ztca revoke <serial>              Revoke cert by serial
+  ztca issue [--csr file] <service> Issue leaf cert (admin; agents use API)
+  ztca revoke [--reason r] <serial> Revoke cert by serial
   ztca revoke --service <name>      Revoke all certs for service
-  ztca status                       List active certs, expirations, revoked
+  ztca status [--service name]      List issued certs, expirations, revocations
+  ztca crl [--validity 24h]         Regenerate base and delta CRLs from the issuance database
+  ztca crl --delta                  Regenerate only the delta CRLs
+  ztca ocsp [--validity 168h]       Issue or renew the delegated OCSP responder certificates
+  ztca bundle [--format spiffe|pem] [file]
+                                    Print the trust bundle (default ca/) in either format
+  ztca jwt rotate [--delay 24h]     Stage a new JWT-SVID signing key alongside the current one
+  ztca jwt retire                   Drop JWT keys whose tokens have all expired
+  ztca jwt list                     Show JWT key generations and their state
+  ztca jwt issue --audience a[,b] <service>
+                                    Print a JWT-SVID for service (admin; agents use API)
+  ztca jwt validate --audience a [--bundle file] [token]
+                                    Validate a JWT-SVID against the trust bundle
+  ztca federation add --trust-domain <td> --url <url> [--profile https_web|https_spiffe]
+                                    Fetch and distribute a foreign trust domain's bundle
+  ztca federation remove <td>       Stop federating with a trust domain
+  ztca federation list              Show federation relationships and fetched bundles
+  ztca federation refresh           Fetch every foreign bundle now
+  ztca backup [--dir ca] <archive>  Write an encrypted, integrity-protected archive of the CA directory
+  ztca restore [--dir ca] [--check] <archive>
+                                    Verify a backup and restore it into an empty CA directory
+  ztca log verify [--url <log url> --log-key <file>] [cert-file...]
+                                    Audit the transparency log; prove the certificates are in it
+  ztca log monitor --expect <pattern>[,...] [--state file] [--interval 1m]
+                                    Follow the log and alert on unexpected identities
+  ztca lint [--issuer file] <cert-file>...
+                                    Check issued certificates against the pre-issuance lint rules
+  ztca lint --rules                 List the lint rules and their configured levels
+
+Environment:
+  CA_KEYSTORE    CA key backend: file (default), file:<dir>, pkcs11:<uri>, exec:<cmd>
+  CA_PASSPHRASE  CA key passphrase source: env:<VAR>, fd:<N>, file:<path>, prompt (default)
+  ROOT_SHARES    Comma-separated key share files (- for stdin) unlocking a split root key
+
+Revocation reasons (RFC 5280): unspecified, keyCompromise, cACompromise, affiliationChanged,
+  superseded, cessationOfOperation, certificateHold, privilegeWithdrawn, aACompromise
+
+Key algorithms: rsa-2048 (default), rsa-3072, rsa-4096, ecdsa-p256, ecdsa-p384, ed25519
 `)
 }
 
-func runInit() {
-	cfg := ca.Config{BaseDir: defaultCADir}
+// keyAlgFlag registers a key algorithm flag on fs.
+func keyAlgFlag(fs *flag.FlagSet, name, usage string) *string {
+	return fs.String(name, string(ca.DefaultKeyAlgorithm), usage)
+}
+
+func parseKeyAlg(name, value string) ca.KeyAlgorithm {
+	alg, err := ca.ParseKeyAlgorithm(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	return alg
+}
+
+// trustDomainFlag registers --trust-domain on fs.
+func trustDomainFlag(fs *flag.FlagSet) *string {
+	return fs.String("trust-domain", spiffeid.DefaultTrustDomain, "SPIFFE trust domain, recorded in ca.json; intermediates may only issue for it")
+}
+
+func parseTrustDomain(value string) spiffeid.TrustDomain {
+	td, err := spiffeid.TrustDomainFromString(value)
+	if err != nil {
+		fail("--trust-domain: %v", err)
+	}
+	return td
+}
+
+// serviceID returns the SPIFFE ID of service in the trust domain recorded
+// in cfg's ca.json.
+func serviceID(cfg *ca.Config, service string) spiffeid.ID {
+	td, err := cfg.LoadTrustDomain()
+	if err != nil {
+		fail("trust domain: %v", err)
+	}
+	id, err := spiffeid.ForService(td, service)
+	if err != nil {
+		fail("service %q: %v", service, err)
+	}
+	return id
+}
+
+// nameConstraintFlags registers the intermediate name constraint flags on fs
+// and returns a function that builds the constraints after fs.Parse. td is
+// the --trust-domain flag, which also becomes the permitted URI domain.
+func nameConstraintFlags(fs *flag.FlagSet, td *string) func() *ca.NameConstraints {
+	dns := fs.String("permit-dns", "", "comma-separated DNS domains intermediates may issue for")
+	ips := fs.String("permit-ip", "", "comma-separated CIDR ranges intermediates may issue for")
+	none := fs.Bool("no-name-constraints", false, "sign intermediates without name constraints")
+	return func() *ca.NameConstraints {
+		if *none {
+			return &ca.NameConstraints{}
+		}
+		nc := &ca.NameConstraints{TrustDomain: parseTrustDomain(*td).Name(), DNSDomains: cliutil.SplitList(*dns), IPRanges: cliutil.SplitList(*ips)}
+		if err := nc.Validate(); err != nil {
+			fail("name constraints: %v", err)
+		}
+		return nc
+	}
+}
+
+// distributionFlags registers the URL flags for leaves (leaf) and for
+// intermediates signed by the root (root) on fs. The returned function
+// yields nil when none was given, leaving ca.json as it is.
+func distributionFlags(fs *flag.FlagSet, leaf, root bool) func() *ca.DistributionURLs {
+	var crl, issuer, ocsp, deltaCRL, rootCRL, rootIssuer *string
+	if leaf {
+		crl = fs.String("crl-url", "", "comma-separated CRL distribution point URLs for leaves ({issuer} = intermediate name)")
+		issuer = fs.String("issuer-url", "", "comma-separated AIA caIssuers URLs for leaves ({issuer} = intermediate name)")
+		ocsp = fs.String("ocsp-url", "", "comma-separated OCSP responder URLs for leaves")
+		deltaCRL = fs.String("delta-crl-url", "", "comma-separated delta CRL URLs advertised in base CRLs ({issuer} = intermediate name)")
+	}
+	if root {
+		rootCRL = fs.String("root-crl-url", "", "comma-separated CRL distribution point URLs for intermediates (root CRL)")
+		rootIssuer = fs.String("root-issuer-url", "", "comma-separated AIA caIssuers URLs for intermediates (root certificate)")
+	}
+	list := func(v *string) []string {
+		if v == nil {
+			return nil
+		}
+		return cliutil.SplitList(*v)
+	}
+	return func() *ca.DistributionURLs {
+		d := &ca.DistributionURLs{CRL: list(crl), Issuer: list(issuer), OCSP: list(ocsp), DeltaCRL: list(deltaCRL), RootCRL: list(rootCRL), RootIssuer: list(rootIssuer)}
+		if d.IsZero() {
+			return nil
+		}
+		if err := d.Validate(); err != nil {
+			fail("%v", err)
+		}
+		return d
+	}
+}
+
+// passphraseSource returns the CA_PASSPHRASE spec, defaulting to an interactive prompt.
+func passphraseSource() string {
+	if spec := os.Getenv("CA_PASSPHRASE"); spec != "" {
+		return spec
+	}
+	return "prompt"
+}
+
+// openKeyStore returns the CA key backend selected by CA_KEYSTORE for dir. File keys
+// are protected by the passphrase from spec; an empty spec leaves new keys unencrypted.
+// Keys split by `ztca root split` are combined from the shares in ROOT_SHARES.
+func openKeyStore(dir, spec string, confirm bool) ca.KeyStore {
+	pass, err := ca.PassphraseFromSpec(spec, confirm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "CA_PASSPHRASE: %v\n", err)
+		os.Exit(1)
+	}
+	ks, err := ca.OpenKeyStore(os.Getenv("CA_KEYSTORE"), dir, pass)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "CA_KEYSTORE: %v\n", err)
+		os.Exit(1)
+	}
+	return &ca.ShareKeyStore{KeyStore: ks, Dir: dir, Shares: readKeyShares(cliutil.SplitList(os.Getenv("ROOT_SHARES"))), Passphrase: pass}
+}
+
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	rootAlg := keyAlgFlag(fs, "root-key-alg", "root CA key algorithm")
+	interAlg := keyAlgFlag(fs, "intermediate-key-alg", "intermediate CA key algorithm")
+	passSpec := fs.String("passphrase", passphraseSource(), "passphrase source for encrypting CA keys")
+	noPass := fs.Bool("no-passphrase", false, "write CA keys unencrypted (demo only)")
+	td := trustDomainFlag(fs)
+	constraints := nameConstraintFlags(fs, td)
+	distribution := distributionFlags(fs, true, true)
+	fs.Parse(args)
+	if *noPass {
+		*passSpec = ""
+	}
+	cfg := ca.Config{
+		BaseDir:                  defaultCADir,
+		TrustDomain:              parseTrustDomain(*td),
+		RootKeyAlgorithm:         parseKeyAlg("root-key-alg", *rootAlg),
+		IntermediateKeyAlgorithm: parseKeyAlg("intermediate-key-alg", *interAlg),
+		KeyStore:                 openKeyStore(defaultCADir, *passSpec, true),
+		NameConstraints:          constraints(),
+		Distribution:             distribution(),
+	}
 	if err := cfg.Init(); err != nil {
 		fmt.Fprintf(os.Stderr, "init failed: %v\n", err)
 		os.Exit(1)
 	}
-	if err := cfg.CreateEmptyCRL(); err != nil {
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
 		fmt.Fprintf(os.Stderr, "create CRL failed: %v\n", err)
 		os.Exit(1)
 	}
+	if _, err := cfg.CreateRootCRL(nil, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "create root CRL failed: %v\n", err)
+		os.Exit(1)
+	}
 	fmt.Println("CA initialized: root, intermediate, trust-bundle, crl in", defaultCADir)
 }
 
 func runRegister(service string) {
 	// For MVP: generate token; in full flow, RA API does this
+	id := serviceID(&ca.Config{BaseDir: defaultCADir}, service)
 	token := "zt-bootstrap-" + randomHex(16)
 	fmt.Printf("Service %q registered. Bootstrap token (store securely):\n%s\n", service, token)
-	fmt.Printf("SPIFFE ID: spiffe://demo/ns/default/sa/%s\n", service)
+	fmt.Printf("SPIFFE ID: %s\n", id)
 }
 
-func runIssue(service string) {
-	cfg := ca.Config{BaseDir: defaultCADir}
-	spiffeID := fmt.Sprintf("spiffe://demo/ns/default/sa/%s", service)
-	certPEM, keyPEM, chainPEM, serial, err := cfg.IssueLeaf(spiffeID, 0)
+func runIssue(args []string) {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	leafAlg := keyAlgFlag(fs, "key-alg", "leaf key algorithm")
+	csrPath := fs.String("csr", "", "sign this PKCS#10 CSR instead of generating the leaf key")
+	profile := fs.String("profile", "", "certificate profile (default: "+ca.DefaultProfile+")")
+	dns := fs.String("dns", "", "comma-separated DNS SANs (generated keys only; CSRs carry their own)")
+	ips := fs.String("ip", "", "comma-separated IP SANs (generated keys only; CSRs carry their own)")
+	formats := fs.String("format", "pem", "comma-separated output formats: pem, pkcs12 (keystore.p12 and truststore.p12)")
+	storePass := fs.String("keystore-password", "prompt", "PKCS#12 password source (env:NAME, file:PATH, fd:N, prompt)")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: ztca issue [--profile name] [--key-alg alg | --csr file] [--format pem,pkcs12] <service>")
+		os.Exit(1)
+	}
+	writePEM, writePKCS12 := parseFormats(*formats)
+	if writePKCS12 && *csrPath != "" {
+		fail("--format pkcs12 needs the private key; it cannot be used with --csr")
+	}
+	service := fs.Arg(0)
+	cfg := ca.Config{
+		BaseDir:          defaultCADir,
+		LeafKeyAlgorithm: parseKeyAlg("key-alg", *leafAlg),
+		KeyStore:         openKeyStore(defaultCADir, passphraseSource(), false),
+		Logf:             warnf,
+	}
+	req := ca.LeafRequest{
+		SPIFFEID: serviceID(&cfg, service).String(),
+		Profile:  *profile,
+		DNSNames: cliutil.SplitList(*dns),
+	}
+	for _, v := range cliutil.SplitList(*ips) {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			fail("--ip: invalid IP %q", v)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	var certPEM, keyPEM, chainPEM, serial string
+	var err error
+	if *csrPath != "" {
+		csrPEM, rerr := os.ReadFile(*csrPath)
+		if rerr != nil {
+			fail("read CSR: %v", rerr)
+		}
+		certPEM, chainPEM, serial, err = cfg.SignCSRRequest(csrPEM, req)
+	} else {
+		certPEM, keyPEM, chainPEM, serial, err = cfg.IssueLeafRequest(req)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "issue failed: %v\n", err)
 		os.Exit(1)
@@ -94,31 +369,145 @@ func runIssue(service string) {
 	// Write to ca/issued/<service>/ for demo (optional local output)
 	dir := defaultCADir + "/issued/" + service
 	os.MkdirAll(dir, 0700)
-	os.WriteFile(dir+"/cert.pem", []byte(certPEM), 0644)
-	os.WriteFile(dir+"/key.pem", []byte(keyPEM), 0600)
-	os.WriteFile(dir+"/chain.pem", []byte(chainPEM), 0644)
-	_ = chainPEM
-	_ = keyPEM
+	if writePEM {
+		os.WriteFile(dir+"/cert.pem", []byte(certPEM), 0644)
+		if keyPEM != "" {
+			os.WriteFile(dir+"/key.pem", []byte(keyPEM), 0600)
+		}
+		os.WriteFile(dir+"/chain.pem", []byte(chainPEM), 0644)
+	}
+	if writePKCS12 {
+		writeKeyStores(&cfg, dir, keyPEM, chainPEM, *storePass)
+	}
 	fmt.Printf("Wrote certs to %s/\n", dir)
 }
 
 func runRevoke(args []string) {
-	// TODO: call RA API to revoke
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "usage: ztca revoke <serial> | ztca revoke --service <name>")
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	service := fs.String("service", "", "revoke every valid certificate issued to this service")
+	reason := fs.String("reason", "unspecified", "RFC 5280 revocation reason")
+	fs.Parse(args)
+	if (*service == "") == (fs.NArg() == 0) {
+		fmt.Fprintln(os.Stderr, "usage: ztca revoke [--reason r] <serial> | ztca revoke [--reason r] --service <name>")
 		os.Exit(1)
 	}
-	if args[0] == "--service" && len(args) >= 2 {
-		fmt.Printf("Revoked all certs for service %s (RA integration pending)\n", args[1])
+	if _, _, err := ca.ParseRevocationReason(*reason); err != nil {
+		fail("--reason: %v", err)
+	}
+	cfg := ca.Config{BaseDir: defaultCADir, KeyStore: openKeyStore(defaultCADir, passphraseSource(), false)}
+	db := cfg.IssuanceDB()
+	now := time.Now()
+	if *service != "" {
+		recs, err := db.Revoke(ca.IssuedFilter{SPIFFEID: serviceID(&cfg, *service).String()}, *reason, now)
+		if err != nil {
+			fail("revoke failed: %v", err)
+		}
+		for _, rec := range recs {
+			fmt.Printf("Revoked cert serial %s\n", rec.Serial)
+		}
+		fmt.Printf("Revoked %d certs for service %s\n", len(recs), *service)
+	} else {
+		rec, err := db.RevokeSerial(fs.Arg(0), *reason, now)
+		if err != nil {
+			fail("revoke failed: %v", err)
+		}
+		fmt.Printf("Revoked cert serial %s (%s)\n", rec.Serial, rec.SPIFFEID)
+	}
+	if err := cfg.UpdateDeltaCRL(now); err != nil {
+		fail("update CRL failed (revocation recorded; rerun ztca crl): %v", err)
+	}
+}
+
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	service := fs.String("service", "", "only show certificates issued to this service")
+	fs.Parse(args)
+	cfg := ca.Config{BaseDir: defaultCADir}
+	filter := ca.IssuedFilter{}
+	if *service != "" {
+		filter.SPIFFEID = serviceID(&cfg, *service).String()
+	}
+	recs, err := cfg.IssuanceDB().List(filter)
+	if err != nil {
+		fail("status failed: %v", err)
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tSPIFFE ID\tPROFILE\tSTATUS\tNOT AFTER")
+	for _, rec := range recs {
+		status := string(rec.StatusAt(now))
+		if rec.Status == ca.StatusRevoked {
+			status += " (" + rec.RevocationReason + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", rec.Serial, rec.SPIFFEID, rec.Profile, status, rec.NotAfter.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
+
+func runCRL(args []string) {
+	fs := flag.NewFlagSet("crl", flag.ExitOnError)
+	validity := fs.Duration("validity", ca.DefaultCRLValidity, "base CRL: time from ThisUpdate to NextUpdate")
+	backdate := fs.Duration("backdate", ca.DefaultCRLBackdate, "how far ThisUpdate is set in the past")
+	deltaOnly := fs.Bool("delta", false, "only regenerate the delta CRLs against the current base")
+	deltaValidity := fs.Duration("delta-validity", ca.DefaultDeltaCRLValidity, "delta CRL: time from ThisUpdate to NextUpdate")
+	deltaURL := fs.String("delta-url", "", "URL of the delta CRLs, advertised in base CRLs (Freshest CRL); overrides ca.json")
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:          defaultCADir,
+		KeyStore:         openKeyStore(defaultCADir, passphraseSource(), false),
+		CRLValidity:      *validity,
+		CRLBackdate:      *backdate,
+		DeltaCRLValidity: *deltaValidity,
+		DeltaCRLURL:      *deltaURL,
+	}
+	if *deltaOnly {
+		if err := cfg.UpdateDeltaCRL(time.Now()); err != nil {
+			fail("update delta CRL failed: %v", err)
+		}
+		fmt.Printf("Wrote %s/crl-delta.pem (next update in %s)\n", defaultCADir, *deltaValidity)
 		return
 	}
-	fmt.Printf("Revoked cert serial %s (RA integration pending)\n", args[0])
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		fail("update CRL failed: %v", err)
+	}
+	fmt.Printf("Wrote %s/crl.pem and crl-delta.pem (next update in %s)\n", defaultCADir, *validity)
 }
 
-func runStatus() {
-	// TODO: call RA API for status
-	fmt.Println("Active certs: (RA integration pending)")
-	fmt.Println("Revoked: (RA integration pending)")
+// runBundle converts a trust bundle in either format, by default the CA's
+// own, to the requested one on stdout.
+func runBundle(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	format := fs.String("format", "spiffe", "output format: spiffe (JWKS) or pem")
+	fs.Parse(args)
+	var b *ca.TrustBundle
+	var err error
+	if fs.NArg() > 0 {
+		var data []byte
+		if data, err = os.ReadFile(fs.Arg(0)); err == nil {
+			b, err = ca.ParseTrustBundle(data)
+		}
+	} else {
+		cfg := ca.Config{BaseDir: defaultCADir}
+		b, err = cfg.TrustBundle()
+	}
+	if err != nil {
+		fail("read trust bundle: %v", err)
+	}
+	switch *format {
+	case "spiffe":
+		out, err := b.MarshalSPIFFE()
+		if err != nil {
+			fail("encode SPIFFE bundle: %v", err)
+		}
+		fmt.Println(string(out))
+	case "pem":
+		if len(b.JWTAuthorities) > 0 {
+			fmt.Fprintf(os.Stderr, "note: %d JWT authorities have no PEM form and are omitted\n", len(b.JWTAuthorities))
+		}
+		os.Stdout.Write(b.MarshalPEM())
+	default:
+		fail("--format: want spiffe or pem, got %q", *format)
+	}
 }
 
 func runServe(args []string) {
@@ -134,6 +523,17 @@ func runServe(args []string) {
 	}
 }
 
+// fail prints an error and exits.
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// warnf prints a non-fatal problem on stderr; it serves as ca.Config.Logf.
+func warnf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
 func randomHex(n int) string {
 	b := make([]byte, n/2+1)
 	rand.Read(b)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+)
+
+// runOCSP issues the delegated OCSP signers that ocsp-responder serves
+// with, for deployments where the RA does not run to keep them current.
+func runOCSP(args []string) {
+	fs := flag.NewFlagSet("ocsp", flag.ExitOnError)
+	validity := fs.Duration("validity", ca.DefaultOCSPSignerValidity, "lifetime of new delegated OCSP signers")
+	keyAlg := keyAlgFlag(fs, "key-alg", "delegated signer key algorithm (ed25519 uses ecdsa-p256)")
+	force := fs.Bool("force", false, "issue new signers even if the current ones are not due")
+	fs.Parse(args)
+	cfg := ca.Config{
+		BaseDir:            defaultCADir,
+		KeyStore:           openKeyStore(defaultCADir, passphraseSource(), false),
+		LeafKeyAlgorithm:   parseKeyAlg("key-alg", *keyAlg),
+		OCSPSignerValidity: *validity,
+	}
+	now := time.Now()
+	var renewed []string
+	if *force {
+		recs, err := cfg.Intermediates()
+		if err != nil {
+			fail("%v", err)
+		}
+		for _, rec := range recs {
+			if rec.Retired {
+				continue
+			}
+			if _, _, err := cfg.IssueOCSPSigner(rec.Name, now); err != nil {
+				fail("OCSP signer for %s: %v", rec.Name, err)
+			}
+			renewed = append(renewed, rec.Name)
+		}
+	} else {
+		var err error
+		if renewed, err = cfg.RenewOCSPSigners(now); err != nil {
+			fail("renew OCSP signers failed: %v", err)
+		}
+	}
+	if len(renewed) == 0 {
+		fmt.Println("Delegated OCSP signers are current")
+		return
+	}
+	for _, name := range renewed {
+		fmt.Printf("Wrote %s/%s.{crt,key}\n", defaultCADir, ca.OCSPSignerName(name))
+	}
+}
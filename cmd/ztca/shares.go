@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+)
+
+// runRootSplit splits a root key into Shamir shares written as printable
+// text files, one per holder, and deletes the key file.
+func runRootSplit(args []string) {
+	fs := flag.NewFlagSet("root split", flag.ExitOnError)
+	dir := fs.String("dir", defaultRootDir, "root CA directory")
+	key := fs.String("key", "root", "key to split")
+	total := fs.Int("shares", 0, "number of shares (N)")
+	threshold := fs.Int("threshold", 0, "shares needed to use the key (M)")
+	out := fs.String("out", "root-shares", "directory for the share files")
+	fs.Parse(args)
+	if *total == 0 || *threshold == 0 {
+		fail("usage: ztca root split --shares N --threshold M [--dir dir] [--key root] [--out dir]")
+	}
+	fks := fileKeyStore(*dir)
+	keyPath := filepath.Join(fks.Dir, *key+".key")
+	keyFile, err := os.ReadFile(keyPath)
+	if err != nil {
+		fail("read key: %v", err)
+	}
+	if !ca.IsEncryptedKeyPEM(keyFile) {
+		fmt.Fprintf(os.Stderr, "warning: %s is not encrypted; shares are the only protection of the key\n", keyPath)
+	}
+	cfg := ca.Config{BaseDir: *dir, KeyStore: fks}
+	var written []string
+	_, err = cfg.SplitKey(*key, *total, *threshold, func(shares []ca.KeyShare) ([]ca.KeyShare, error) {
+		if err := os.MkdirAll(*out, 0700); err != nil {
+			return nil, err
+		}
+		for _, s := range shares {
+			text := fmt.Sprintf("ZTCA key share %d of %d for %s (set %s).\nAny %d shares of this set unlock the key. Keep it offline, apart from the others.\n\n",
+				s.Index, s.Total, s.Key, s.SetID, s.Threshold)
+			path := filepath.Join(*out, fmt.Sprintf("%s-share-%d-of-%d.txt", s.Key, s.Index, s.Total))
+			// Never overwrite the shares of an earlier split: they stay
+			// valid until this one is recorded.
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return nil, err
+			}
+			written = append(written, path)
+			_, err = f.Write(append([]byte(text), s.MarshalPEM()...))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		// Read the files back so the split is only recorded for shares as
+		// their holders will get them.
+		var saved []ca.KeyShare
+		for _, path := range written {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			s, err := ca.ParseKeySharesPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			saved = append(saved, s...)
+		}
+		return saved, nil
+	})
+	if err != nil {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		fail("split failed: %v (key file and previous split kept)", err)
+	}
+	if err := os.Remove(keyPath); err != nil {
+		fail("remove %s: %v", keyPath, err)
+	}
+	fmt.Printf("Split %s into %d shares (threshold %d) in %s/ and removed %s.\n", *key, *total, *threshold, *out, keyPath)
+	fmt.Println("Hand each share to a different holder, then delete the directory.")
+	fmt.Println("Ceremonies take the shares from ROOT_SHARES=<file>,<file>,... (or - for stdin).")
+}
+
+// runRootCombine checks that shares reconstruct a split key, for backup
+// drills, and with --restore writes the key file back and retires the
+// split.
+func runRootCombine(args []string) {
+	fs := flag.NewFlagSet("root combine", flag.ExitOnError)
+	dir := fs.String("dir", defaultRootDir, "root CA directory")
+	key := fs.String("key", "root", "split key")
+	restore := fs.Bool("restore", false, "write the reconstructed key file back to --dir")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fail("usage: ztca root combine [--dir dir] [--key root] [--restore] <share-file|-> ...")
+	}
+	shares := readKeyShares(fs.Args())
+	if !*restore {
+		cfg := ca.Config{BaseDir: *dir}
+		if _, err := cfg.CombineKey(*key, shares); err != nil {
+			fail("%v", err)
+		}
+		fmt.Printf("Shares reconstruct %s.\n", *key)
+		return
+	}
+	fks := fileKeyStore(*dir)
+	cfg := ca.Config{BaseDir: *dir, KeyStore: fks}
+	if err := cfg.RestoreKey(*key, shares); err != nil {
+		fail("%v", err)
+	}
+	fmt.Printf("Restored %s; its shares are retired. Split it again or remove it when done.\n", filepath.Join(fks.Dir, *key+".key"))
+}
+
+// fileKeyStore opens CA_KEYSTORE for dir, which must be a file key store:
+// only key files are split into shares.
+func fileKeyStore(dir string) *ca.FileKeyStore {
+	ks, err := ca.OpenKeyStore(os.Getenv("CA_KEYSTORE"), dir, nil)
+	if err != nil {
+		fail("CA_KEYSTORE: %v", err)
+	}
+	fks, ok := ks.(*ca.FileKeyStore)
+	if !ok {
+		fail("CA_KEYSTORE: only file keys can be split")
+	}
+	return fks
+}
+
+// readKeyShares reads the share files in paths; "-" reads standard input,
+// so shares can be pasted or typed in from paper.
+func readKeyShares(paths []string) []ca.KeyShare {
+	var shares []ca.KeyShare
+	for _, p := range paths {
+		var data []byte
+		var err error
+		if p == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(p)
+		}
+		if err != nil {
+			fail("read share: %v", err)
+		}
+		s, err := ca.ParseKeySharesPEM(data)
+		if err != nil {
+			fail("%s: %v", p, err)
+		}
+		shares = append(shares, s...)
+	}
+	return shares
+}
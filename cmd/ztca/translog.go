@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/zero-trust/zt-identity/internal/cliutil"
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/translog"
+)
+
+func runLog(args []string) {
+	if len(args) < 1 {
+		fail("usage: ztca log {verify|monitor} [flags]")
+	}
+	switch args[0] {
+	case "verify":
+		runLogVerify(args[1:])
+	case "monitor":
+		runLogMonitor(args[1:])
+	default:
+		fail("unknown log command %q", args[0])
+	}
+}
+
+// logFlags registers the flags selecting a transparency log: the RA's at
+// --url, or the one in --dir.
+func logFlags(fs *flag.FlagSet) (dir, url, keyPath *string) {
+	dir = fs.String("dir", defaultCADir, "CA directory holding the log")
+	url = fs.String("url", "", "read the log from the RA instead, e.g. http://ra:8443/v1/log")
+	keyPath = fs.String("log-key", "", "log public key (default <dir>/translog.pub; required with --url)")
+	return
+}
+
+func openLog(dir, url, keyPath string) (translog.Log, crypto.PublicKey) {
+	var l translog.Log
+	if url != "" {
+		if keyPath == "" {
+			fail("--log-key is required with --url: get translog.pub from the CA out of band")
+		}
+		l = &translog.Client{URL: url}
+	} else {
+		l = (&ca.Config{BaseDir: dir}).TransparencyLog()
+		if keyPath == "" {
+			keyPath = filepath.Join(dir, "translog.pub")
+		}
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		fail("log key: %v", err)
+	}
+	pub, err := ca.ParsePublicKeyPEM(data)
+	if err != nil {
+		fail("log key %s: %v", keyPath, err)
+	}
+	return l, pub
+}
+
+// fetchEntries calls fn for each entry in [start, end), paging through l.
+func fetchEntries(l translog.Log, start, end int64, fn func(translog.Entry)) error {
+	for start < end {
+		entries, err := l.Entries(start, end)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("log returned no entries at index %d", start)
+		}
+		for _, e := range entries {
+			if start == end {
+				break
+			}
+			if e.Index != start {
+				return fmt.Errorf("log returned entry %d, want %d", e.Index, start)
+			}
+			fn(e)
+			start++
+		}
+	}
+	return nil
+}
+
+// runLogVerify audits the whole log against its signed tree head and checks
+// inclusion proofs for the given certificates.
+func runLogVerify(args []string) {
+	fs := flag.NewFlagSet("log verify", flag.ExitOnError)
+	dir, url, keyPath := logFlags(fs)
+	fs.Parse(args)
+	l, pub := openLog(*dir, *url, *keyPath)
+
+	head, err := l.TreeHead()
+	if err != nil {
+		fail("tree head: %v", err)
+	}
+	if err := head.Verify(pub); err != nil {
+		fail("tree head: %v", err)
+	}
+	var tree translog.Tree
+	if err := fetchEntries(l, 0, head.TreeSize, func(e translog.Entry) { tree.Append(e.LeafHash()) }); err != nil {
+		fail("entries: %v", err)
+	}
+	if !bytes.Equal(tree.Root(), head.RootHash) {
+		fail("the log's %d entries do not hash to the signed root %x", head.TreeSize, head.RootHash)
+	}
+	fmt.Printf("Tree head verified: %d entries, root %x, signed %s.\n", head.TreeSize, head.RootHash, head.Timestamp.Format(time.RFC3339))
+
+	failed := false
+	for _, p := range fs.Args() {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			fail("read certificate: %v", err)
+		}
+		cert, err := ca.ParseCertificatePEM(data)
+		if err != nil {
+			fail("%s: %v", p, err)
+		}
+		leaf := translog.LeafHash(cert.Raw)
+		index, proof, err := l.InclusionProof(leaf, head.TreeSize)
+		if err == nil {
+			err = translog.VerifyInclusion(leaf, index, head.TreeSize, proof, head.RootHash)
+		}
+		if err != nil {
+			fmt.Printf("%s: serial %X NOT proven in the log: %v\n", p, cert.SerialNumber, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: serial %X included at index %d\n", p, cert.SerialNumber, index)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// monitorState is what the monitor remembers between runs: the last tree
+// head it verified and the compact tree of the entries it has seen.
+type monitorState struct {
+	TreeHead translog.TreeHead `json:"tree_head"`
+	Tree     translog.Tree     `json:"tree"`
+}
+
+// runLogMonitor follows the log from the last verified tree head, checks
+// that the new head extends it, and alerts on certificates for identities
+// that match none of the --expect patterns.
+func runLogMonitor(args []string) {
+	fs := flag.NewFlagSet("log monitor", flag.ExitOnError)
+	dir, url, keyPath := logFlags(fs)
+	statePath := fs.String("state", "translog-monitor.json", "file recording the last verified tree head")
+	expect := fs.String("expect", "", "comma-separated SPIFFE ID patterns the CA may issue, e.g. spiffe://demo/ns/*/sa/* (required)")
+	interval := fs.Duration("interval", 0, "poll every interval instead of checking once")
+	fs.Parse(args)
+	patterns := cliutil.SplitList(*expect)
+	if len(patterns) == 0 {
+		fail("usage: ztca log monitor --expect <pattern>[,...] [--dir ca | --url <log url> --log-key <file>] [--state file] [--interval 1m]")
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			fail("--expect %q: %v", p, err)
+		}
+	}
+	l, pub := openLog(*dir, *url, *keyPath)
+	for {
+		alerts, err := monitorLog(l, pub, *statePath, patterns)
+		if *interval == 0 {
+			if err != nil {
+				fail("monitor: %v", err)
+			}
+			if alerts > 0 {
+				os.Exit(2)
+			}
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monitor: %v\n", err)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// monitorLog runs one monitoring pass and returns the number of alerts it
+// printed. The state only advances when the new tree head is consistent
+// with the old one and the fetched entries hash to it.
+func monitorLog(l translog.Log, pub crypto.PublicKey, statePath string, patterns []string) (int, error) {
+	var state monitorState
+	if data, err := os.ReadFile(statePath); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0, fmt.Errorf("%s: %w", statePath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	alerts := 0
+	alert := func(format string, a ...any) {
+		fmt.Printf("ALERT: "+format+"\n", a...)
+		alerts++
+	}
+
+	head, err := l.TreeHead()
+	if err != nil {
+		return 0, fmt.Errorf("tree head: %w", err)
+	}
+	if err := head.Verify(pub); err != nil {
+		alert("tree head of size %d: %v", head.TreeSize, err)
+		return alerts, nil
+	}
+	old := state.TreeHead
+	if head.TreeSize < old.TreeSize {
+		alert("log shrank from %d to %d entries", old.TreeSize, head.TreeSize)
+		return alerts, nil
+	}
+	if old.TreeSize > 0 {
+		proof, err := l.ConsistencyProof(old.TreeSize, head.TreeSize)
+		if err != nil {
+			return 0, fmt.Errorf("consistency proof: %w", err)
+		}
+		if err := translog.VerifyConsistency(old.TreeSize, head.TreeSize, old.RootHash, head.RootHash, proof); err != nil {
+			alert("tree head of size %d does not extend the verified head of size %d: %v", head.TreeSize, old.TreeSize, err)
+			return alerts, nil
+		}
+	}
+
+	tree := state.Tree
+	err = fetchEntries(l, old.TreeSize, head.TreeSize, func(e translog.Entry) {
+		tree.Append(e.LeafHash())
+		cert, err := x509.ParseCertificate(e.Certificate)
+		if err != nil {
+			alert("entry %d: unparsable certificate: %v", e.Index, err)
+			return
+		}
+		if len(cert.URIs) == 0 {
+			alert("entry %d: serial %X has no SPIFFE ID (subject %q)", e.Index, cert.SerialNumber, cert.Subject.String())
+		}
+		for _, u := range cert.URIs {
+			if !matchesAny(patterns, u.String()) {
+				alert("entry %d: unexpected identity %s (serial %X, issued %s by %q)",
+					e.Index, u, cert.SerialNumber, cert.NotBefore.Format(time.RFC3339), cert.Issuer.CommonName)
+			}
+		}
+	})
+	if err != nil {
+		return alerts, fmt.Errorf("entries: %w", err)
+	}
+	if tree.Size != head.TreeSize || !bytes.Equal(tree.Root(), head.RootHash) {
+		alert("entries %d-%d do not hash to the signed root of size %d", old.TreeSize, head.TreeSize, head.TreeSize)
+		return alerts, nil
+	}
+
+	state = monitorState{TreeHead: head, Tree: tree}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return alerts, err
+	}
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return alerts, err
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		return alerts, err
+	}
+	fmt.Printf("%s: checked %d new entries, log at %d entries (root %x).\n",
+		time.Now().Format(time.RFC3339), head.TreeSize-old.TreeSize, head.TreeSize, head.RootHash)
+	return alerts, nil
+}
+
+func matchesAny(patterns []string, id string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, id); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,11 @@ SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 cd "$SCRIPT_DIR"
 CADIR="${CADIR:-ca}"
 ZTCA="${ZTCA:-./bin/ztca}"
+# CA keys are encrypted at rest; export ZTCA_PASSPHRASE to skip the prompt
+# and to let the RA container unlock the intermediate key.
+if [ -n "$ZTCA_PASSPHRASE" ]; then
+    export CA_PASSPHRASE="${CA_PASSPHRASE:-env:ZTCA_PASSPHRASE}"
+fi
 
 ensure_ztca() {
     if [ ! -x "$ZTCA" ]; then
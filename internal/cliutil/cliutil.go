@@ -0,0 +1,39 @@
+// Package cliutil holds the small helpers shared by the commands in cmd/.
+package cliutil
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// KeyStoreAlias is the alias of the key entry in the keystore.p12 files
+// written by the agent and ztca issue.
+const KeyStoreAlias = "svid"
+
+// SplitList splits a comma-separated flag or environment value, trimming
+// spaces and dropping empty items.
+func SplitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// DurationEnv parses the duration in the environment variable name, or
+// returns 0 when it is unset. A malformed value is fatal.
+func DurationEnv(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return d
+}
@@ -0,0 +1,20 @@
+package cliutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	for in, want := range map[string][]string{
+		"":            nil,
+		" , ,":        nil,
+		"a":           {"a"},
+		" a, b ,,c ":  {"a", "b", "c"},
+		"pem,pkcs12,": {"pem", "pkcs12"},
+	} {
+		if got := SplitList(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("SplitList(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
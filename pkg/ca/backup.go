@@ -0,0 +1,532 @@
+package ca
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A backup is one file holding every file of BaseDir:
+//
+//	ZTCA-BACKUP v1\n
+//	{"created":...,"kdf":{scrypt parameters},"nonce":...}\n
+//	AES-256-GCM(tar archive)
+//
+// The key comes from the backup passphrase via scrypt, as for encrypted
+// keys, and the header line is authenticated as additional data, so any
+// change to the file, truncation included, fails decryption. The archive
+// starts with MANIFEST.json listing each file's size and SHA-256, and
+// restore requires it to match the archive exactly.
+
+const (
+	backupMagic    = "ZTCA-BACKUP v1\n"
+	backupManifest = "MANIFEST.json"
+	// maxBackupSize bounds the archive RestoreBackup reads into memory.
+	maxBackupSize = 1 << 30
+)
+
+// ErrBackupCorrupt is returned for archives that fail decryption or whose
+// contents do not match their manifest: a wrong passphrase, tampering or
+// truncation.
+var ErrBackupCorrupt = errors.New("backup is corrupt, truncated or the passphrase is wrong")
+
+// BackupManifest lists the files in a backup.
+type BackupManifest struct {
+	Created time.Time    `json:"created"`
+	Files   []BackupFile `json:"files"`
+}
+
+// BackupFile is one file of a backup, by path relative to BaseDir.
+type BackupFile struct {
+	Path   string      `json:"path"`
+	Mode   fs.FileMode `json:"mode"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256"`
+}
+
+type backupHeader struct {
+	Created time.Time    `json:"created"`
+	KDF     scryptParams `json:"kdf"`
+	Nonce   []byte       `json:"nonce"`
+}
+
+// Backup writes an encrypted archive of every regular file in BaseDir
+// (keys, certificates, bundles, CRLs and counters, issuance database,
+// transparency log) to w, protected by passphrase. It refuses a file key
+// store outside BaseDir, whose keys the archive would miss; keys on a
+// token or behind a key program are not files and are not backed up. The
+// CRL number, issuance and log locks are held while the files are read,
+// so they are captured at one consistent point.
+func (c *Config) Backup(w io.Writer, passphrase []byte, now time.Time) (*BackupManifest, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("backup: empty passphrase")
+	}
+	if fks, ok := c.KeyStore.(*FileKeyStore); ok && !withinDir(c.BaseDir, fks.Dir) {
+		return nil, fmt.Errorf("backup: keys are in %s, outside %s", fks.Dir, c.BaseDir)
+	}
+	unlock, err := c.lockForBackup()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	manifest := &BackupManifest{Created: now.UTC()}
+	contents := map[string][]byte{}
+	err = filepath.WalkDir(c.BaseDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(c.BaseDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasSuffix(rel, ".tmp") {
+			return nil // an interrupted atomic write
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("backup: %s is not a regular file", rel)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, BackupFile{
+			Path:   rel,
+			Mode:   info.Mode().Perm(),
+			Size:   int64(len(data)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		contents[rel] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("backup: %s has no files", c.BaseDir)
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, backupManifest, 0644, manifestJSON, now); err != nil {
+		return nil, err
+	}
+	for _, f := range manifest.Files {
+		if err := writeTarFile(tw, f.Path, f.Mode, contents[f.Path], now); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	hdr := backupHeader{
+		Created: manifest.Created,
+		KDF:     scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: aesKeyLen},
+	}
+	aead, err := keyEncryptionAEAD(passphrase, hdr.KDF)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(hdr.Nonce); err != nil {
+		return nil, err
+	}
+	hdrJSON, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	ad := append([]byte(backupMagic), append(hdrJSON, '\n')...)
+	if _, err := w.Write(ad); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(aead.Seal(nil, hdr.Nonce, archive.Bytes(), ad)); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// lockForBackup takes the locks of every file that is rewritten in place
+// or appended to, and returns the function that releases them.
+func (c *Config) lockForBackup() (func(), error) {
+	var unlocks []func()
+	release := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, lock := range []func() (func(), error){c.lockCRLNumber, c.IssuanceDB().lock, c.TransparencyLog().lock} {
+		unlock, err := lock()
+		if err != nil {
+			release()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return release, nil
+}
+
+// withinDir reports whether dir is base or below it.
+func withinDir(base, dir string) bool {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return false
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(base, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func writeTarFile(tw *tar.Writer, name string, mode fs.FileMode, data []byte, now time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode),
+		Size:     int64(len(data)),
+		ModTime:  now,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ReadBackup decrypts the archive in r and checks it against its manifest,
+// returning the manifest and the file contents by path.
+func ReadBackup(r io.Reader, passphrase []byte) (*BackupManifest, map[string][]byte, error) {
+	br := bufio.NewReader(io.LimitReader(r, maxBackupSize+1))
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != backupMagic {
+		return nil, nil, errors.New("not a ztca backup")
+	}
+	hdrJSON, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, nil, ErrBackupCorrupt
+	}
+	var hdr backupHeader
+	if err := json.Unmarshal(hdrJSON, &hdr); err != nil {
+		return nil, nil, fmt.Errorf("%w: header: %v", ErrBackupCorrupt, err)
+	}
+	if err := hdr.KDF.check(); err != nil {
+		return nil, nil, fmt.Errorf("backup: %w", err)
+	}
+	sealed, err := io.ReadAll(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(magic)+len(hdrJSON)+len(sealed) > maxBackupSize {
+		return nil, nil, fmt.Errorf("backup exceeds %d bytes", maxBackupSize)
+	}
+	aead, err := keyEncryptionAEAD(passphrase, hdr.KDF)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(hdr.Nonce) != aead.NonceSize() {
+		return nil, nil, ErrBackupCorrupt
+	}
+	archive, err := aead.Open(nil, hdr.Nonce, sealed, append(magic, hdrJSON...))
+	if err != nil {
+		return nil, nil, ErrBackupCorrupt
+	}
+
+	tr := tar.NewReader(bytes.NewReader(archive))
+	var manifest *BackupManifest
+	files := map[string][]byte{}
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrBackupCorrupt, err)
+		}
+		if manifest == nil {
+			if th.Name != backupManifest {
+				return nil, nil, fmt.Errorf("%w: archive does not start with %s", ErrBackupCorrupt, backupManifest)
+			}
+			manifest = &BackupManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("%w: %s: %v", ErrBackupCorrupt, backupManifest, err)
+			}
+			continue
+		}
+		if th.Typeflag != tar.TypeReg || !validBackupPath(th.Name) {
+			return nil, nil, fmt.Errorf("%w: bad archive entry %q", ErrBackupCorrupt, th.Name)
+		}
+		if _, dup := files[th.Name]; dup {
+			return nil, nil, fmt.Errorf("%w: %s appears twice", ErrBackupCorrupt, th.Name)
+		}
+		files[th.Name] = data
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%w: empty archive", ErrBackupCorrupt)
+	}
+	if len(files) != len(manifest.Files) {
+		return nil, nil, fmt.Errorf("%w: archive has %d files, manifest lists %d", ErrBackupCorrupt, len(files), len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		data, ok := files[f.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s is missing", ErrBackupCorrupt, f.Path)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, nil, fmt.Errorf("%w: %s does not match the manifest", ErrBackupCorrupt, f.Path)
+		}
+	}
+	return manifest, files, nil
+}
+
+// validBackupPath accepts clean relative slash paths that stay inside the
+// directory being restored.
+func validBackupPath(p string) bool {
+	return p != "" && p != backupManifest && path.Clean(p) == p && !path.IsAbs(p) &&
+		p != ".." && !strings.HasPrefix(p, "../") && !strings.Contains(p, "\\")
+}
+
+// RestoreBackup unpacks the archive in r into BaseDir, which must not
+// exist or be empty. Nothing is written unless the whole archive decrypts
+// and matches its manifest; the files are unpacked next to BaseDir and
+// moved into place together. VerifyState then checks the restored CA with
+// c's key store, and on failure BaseDir is removed again.
+func (c *Config) RestoreBackup(r io.Reader, passphrase []byte, now time.Time) (*BackupManifest, error) {
+	if entries, err := os.ReadDir(c.BaseDir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("restore: %s is not empty", c.BaseDir)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	manifest, files, err := ReadBackup(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Clean(c.BaseDir)
+	tmp, err := os.MkdirTemp(filepath.Dir(base), "."+filepath.Base(base)+".restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0700); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(manifest.Files))
+	modes := map[string]fs.FileMode{}
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+		modes[f.Path] = f.Mode.Perm()
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		dst := filepath.Join(tmp, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dst, files[p], modes[p]); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(base); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.Rename(tmp, base); err != nil {
+		return nil, err
+	}
+	if err := c.VerifyState(now); err != nil {
+		os.RemoveAll(base)
+		return nil, fmt.Errorf("restored CA failed verification (removed %s): %w", base, err)
+	}
+	return manifest, nil
+}
+
+// VerifyState checks that BaseDir holds a consistent CA: every root is
+// self-signed, every unretired intermediate chains to the trust anchors at
+// now and matches its key, delegated OCSP signers, unretired JWT-SVID keys
+// and the transparency log key match their key store keys, and the trust
+// bundle parses. Root keys
+// are checked when the key store has them; an offline or split root is
+// skipped. A root-only directory (the offline root host) has no
+// intermediates.
+func (c *Config) VerifyState(now time.Time) error {
+	roots, err := c.Roots()
+	if err != nil {
+		return fmt.Errorf("roots: %w", err)
+	}
+	anchors := x509.NewCertPool()
+	for _, r := range roots {
+		cert, err := c.readCert(r.Name)
+		if err != nil {
+			return err
+		}
+		if err := cert.CheckSignatureFrom(cert); err != nil {
+			return fmt.Errorf("%s.crt is not self-signed: %w", r.Name, err)
+		}
+		anchors.AddCert(cert)
+		if err := c.verifyOptionalKey(r.Name, cert); err != nil {
+			return err
+		}
+	}
+
+	recs, err := c.Intermediates()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var names []string
+	var inters []*x509.Certificate
+	for _, r := range recs {
+		if r.Retired {
+			continue
+		}
+		cert, err := c.readCert(r.Name)
+		if err != nil {
+			return err
+		}
+		names = append(names, r.Name)
+		inters = append(inters, cert)
+	}
+	extra, err := c.trustAnchors(inters)
+	if err != nil {
+		return err
+	}
+	for _, a := range extra {
+		anchors.AddCert(a)
+	}
+	cross := x509.NewCertPool()
+	for _, name := range []string{"cross-next", "cross-prev"} {
+		if cert, err := c.readCert(name); err == nil {
+			cross.AddCert(cert)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	chain, err := c.issuerChain()
+	if err != nil {
+		return err
+	}
+	for _, cert := range chain {
+		cross.AddCert(cert)
+	}
+	for i, cert := range inters {
+		name := names[i]
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:         anchors,
+			Intermediates: cross,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return fmt.Errorf("%s.crt: %w", name, err)
+		}
+		key, err := c.keyStore().Signer(name)
+		if err != nil {
+			return fmt.Errorf("%s key: %w", name, err)
+		}
+		if !publicKeysEqual(key.Public(), cert.PublicKey) {
+			return fmt.Errorf("%s key does not match %s.crt", name, name)
+		}
+		signer, err := c.readCert(OCSPSignerName(name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := signer.CheckSignatureFrom(cert); err != nil {
+			return fmt.Errorf("%s.crt: %w", OCSPSignerName(name), err)
+		}
+		if _, _, err := c.OCSPSigner(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	jwtKeys, err := c.JWTKeys()
+	if err != nil {
+		return err
+	}
+	for _, r := range jwtKeys {
+		if r.Retired {
+			continue
+		}
+		key, err := c.keyStore().Signer(r.Name)
+		if err != nil {
+			return fmt.Errorf("%s key: %w", r.Name, err)
+		}
+		kid, err := jwkThumbprint(key.Public())
+		if err != nil {
+			return err
+		}
+		if kid != r.KeyID {
+			return fmt.Errorf("%s key does not match its key ID %s", r.Name, r.KeyID)
+		}
+	}
+	if pub, err := c.TransparencyLog().PublicKey(); err == nil {
+		key, err := c.keyStore().Signer(TransparencyLogKey)
+		if err != nil {
+			return fmt.Errorf("%s key: %w", TransparencyLogKey, err)
+		}
+		if !publicKeysEqual(key.Public(), pub) {
+			return fmt.Errorf("%s key does not match %s", TransparencyLogKey, translogPubFile)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := c.TrustBundle(); err != nil {
+		return fmt.Errorf("trust bundle: %w", err)
+	}
+	return nil
+}
+
+// verifyOptionalKey checks name's key against cert if the key store has
+// it.
+func (c *Config) verifyOptionalKey(name string, cert *x509.Certificate) error {
+	if _, split, err := c.keySplit(name); err != nil {
+		return err
+	} else if split {
+		return nil
+	}
+	key, err := c.keyStore().Signer(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s key: %w", name, err)
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return fmt.Errorf("%s key does not match %s.crt", name, name)
+	}
+	return nil
+}
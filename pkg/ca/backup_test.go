@@ -0,0 +1,108 @@
+package ca
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := cfg.VerifyState(now); err != nil {
+		t.Fatalf("fresh CA: %v", err)
+	}
+	pass := []byte("backup passphrase")
+	var archive bytes.Buffer
+	manifest, err := cfg.Backup(&archive, pass, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := Config{BaseDir: filepath.Join(t.TempDir(), "ca")}
+	got, err := restored.RestoreBackup(bytes.NewReader(archive.Bytes()), pass, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files) != len(manifest.Files) {
+		t.Errorf("restored %d files, backed up %d", len(got.Files), len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		if !bytes.Equal(readFile(t, filepath.Join(restored.BaseDir, f.Path)), readFile(t, filepath.Join(dir, f.Path))) {
+			t.Errorf("%s differs after restore", f.Path)
+		}
+	}
+	if _, err := restored.RestoreBackup(bytes.NewReader(archive.Bytes()), pass, now); err == nil {
+		t.Error("restored over an existing CA")
+	}
+
+	data := archive.Bytes()
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-40] ^= 1
+	for name, c := range map[string]struct {
+		data []byte
+		pass string
+	}{
+		"tampered":         {tampered, string(pass)},
+		"truncated":        {data[:len(data)-100], string(pass)},
+		"wrong passphrase": {data, "guess"},
+	} {
+		target := Config{BaseDir: filepath.Join(t.TempDir(), "ca")}
+		if _, err := target.RestoreBackup(bytes.NewReader(c.data), []byte(c.pass), now); !errors.Is(err, ErrBackupCorrupt) {
+			t.Errorf("%s: err = %v, want ErrBackupCorrupt", name, err)
+		}
+		if _, err := os.Stat(target.BaseDir); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: restore left %s behind", name, target.BaseDir)
+		}
+	}
+
+	// A backup whose intermediate key does not match its certificate is
+	// refused after unpacking and removed again.
+	other := Config{BaseDir: t.TempDir(), RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := other.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "intermediate.key"), readFile(t, filepath.Join(other.BaseDir, "intermediate.key")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.VerifyState(now); err == nil {
+		t.Fatal("VerifyState accepted a mismatched intermediate key")
+	}
+	archive.Reset()
+	if _, err := cfg.Backup(&archive, pass, now); err != nil {
+		t.Fatal(err)
+	}
+	target := Config{BaseDir: filepath.Join(t.TempDir(), "ca")}
+	if _, err := target.RestoreBackup(&archive, pass, now); err == nil {
+		t.Error("restored a CA whose key does not match its certificate")
+	}
+	if _, err := os.Stat(target.BaseDir); !errors.Is(err, os.ErrNotExist) {
+		t.Error("failed restore left the directory behind")
+	}
+}
+
+func TestBackupRefusesOutsideKeyStore(t *testing.T) {
+	dir, keys := t.TempDir(), t.TempDir()
+	cfg := Config{BaseDir: dir, KeyStore: &FileKeyStore{Dir: keys}, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.Backup(io.Discard, []byte("backup passphrase"), time.Now()); err == nil {
+		t.Error("backed up a CA whose keys are outside its directory")
+	}
+	cfg.KeyStore = &FileKeyStore{Dir: filepath.Join(dir, ".")}
+	if _, err := cfg.Backup(io.Discard, []byte("backup passphrase"), time.Now()); err != nil {
+		t.Errorf("key store in the CA directory: %v", err)
+	}
+}
@@ -0,0 +1,393 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/jwtsvid"
+)
+
+const (
+	trustBundleFile  = "trust-bundle.pem"
+	spiffeBundleFile = "trust-bundle.json"
+)
+
+// BundleRefreshHint is the spiffe_refresh_hint of the CA's SPIFFE bundle:
+// how often relying parties should poll it for new authorities.
+const BundleRefreshHint = 5 * time.Minute
+
+// Key uses in a SPIFFE bundle (SPIFFE Trust Domain and Bundle, section 4).
+const (
+	UseX509SVID = "x509-svid"
+	UseJWTSVID  = "jwt-svid"
+)
+
+// TrustBundle is the set of authorities relying parties accept for a trust
+// domain. The CA writes it to BaseDir both as trust-bundle.pem, the X.509
+// authorities as concatenated PEM, and as trust-bundle.json, a SPIFFE bundle
+// whose Sequence increases whenever the authorities change.
+type TrustBundle struct {
+	X509Authorities []*x509.Certificate
+	JWTAuthorities  map[string]crypto.PublicKey // by key ID
+	Sequence        uint64
+	RefreshHint     time.Duration
+}
+
+// jwk is an RFC 7517 JSON Web Key with the members SPIFFE bundles use.
+type jwk struct {
+	Kty string   `json:"kty"`
+	Use string   `json:"use"`
+	Kid string   `json:"kid,omitempty"`
+	Alg string   `json:"alg,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+type spiffeBundle struct {
+	Keys        []jwk  `json:"keys"`
+	Sequence    uint64 `json:"spiffe_sequence,omitempty"`
+	RefreshHint int64  `json:"spiffe_refresh_hint,omitempty"` // seconds
+}
+
+// MarshalPEM encodes the X.509 authorities as concatenated PEM; JWT
+// authorities have no PEM form and are left out.
+func (b *TrustBundle) MarshalPEM() []byte {
+	var out []byte
+	for _, cert := range b.X509Authorities {
+		out = append(out, encodeCertPEM(cert)...)
+	}
+	return out
+}
+
+// MarshalSPIFFE encodes b as a SPIFFE bundle: a JWK Set with one
+// x509-svid key per certificate (carrying it in x5c) and one jwt-svid key
+// per JWT authority, ordered by key ID.
+func (b *TrustBundle) MarshalSPIFFE() ([]byte, error) {
+	sb := spiffeBundle{Keys: []jwk{}, Sequence: b.Sequence, RefreshHint: int64(b.RefreshHint / time.Second)}
+	for _, cert := range b.X509Authorities {
+		k, err := publicKeyJWK(cert.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cert.Subject, err)
+		}
+		k.Use = UseX509SVID
+		k.X5c = []string{base64.StdEncoding.EncodeToString(cert.Raw)}
+		sb.Keys = append(sb.Keys, k)
+	}
+	for _, kid := range b.jwtKeyIDs() {
+		k, err := publicKeyJWK(b.JWTAuthorities[kid])
+		if err != nil {
+			return nil, fmt.Errorf("JWT authority %s: %w", kid, err)
+		}
+		k.Use = UseJWTSVID
+		k.Kid = kid
+		sb.Keys = append(sb.Keys, k)
+	}
+	return json.MarshalIndent(sb, "", "  ")
+}
+
+// MarshalJWKS encodes the JWT authorities as a plain JWK Set ("use": "sig",
+// with "alg"), for JWT validators that do not understand SPIFFE bundles.
+func (b *TrustBundle) MarshalJWKS() ([]byte, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+	for _, kid := range b.jwtKeyIDs() {
+		pub := b.JWTAuthorities[kid]
+		k, err := publicKeyJWK(pub)
+		if err != nil {
+			return nil, fmt.Errorf("JWT authority %s: %w", kid, err)
+		}
+		if k.Alg, err = jwtsvid.Algorithm(pub); err != nil {
+			return nil, fmt.Errorf("JWT authority %s: %w", kid, err)
+		}
+		k.Use = "sig"
+		k.Kid = kid
+		set.Keys = append(set.Keys, k)
+	}
+	return json.MarshalIndent(set, "", "  ")
+}
+
+func (b *TrustBundle) jwtKeyIDs() []string {
+	kids := make([]string, 0, len(b.JWTAuthorities))
+	for kid := range b.JWTAuthorities {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+// ParseTrustBundle decodes a trust bundle in either format the CA writes:
+// a SPIFFE bundle (JSON) or concatenated PEM certificates. Keys with an
+// unknown use are skipped, as the SPIFFE specification requires.
+func ParseTrustBundle(data []byte) (*TrustBundle, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		certs, err := ParseCertificatesPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, errors.New("trust bundle holds no certificates")
+		}
+		return &TrustBundle{X509Authorities: certs}, nil
+	}
+	var sb spiffeBundle
+	if err := json.Unmarshal(trimmed, &sb); err != nil {
+		return nil, fmt.Errorf("SPIFFE bundle: %w", err)
+	}
+	b := &TrustBundle{
+		JWTAuthorities: map[string]crypto.PublicKey{},
+		Sequence:       sb.Sequence,
+		RefreshHint:    time.Duration(sb.RefreshHint) * time.Second,
+	}
+	for i, k := range sb.Keys {
+		switch k.Use {
+		case UseX509SVID:
+			cert, err := k.certificate()
+			if err != nil {
+				return nil, fmt.Errorf("SPIFFE bundle key %d: %w", i, err)
+			}
+			b.X509Authorities = append(b.X509Authorities, cert)
+		case UseJWTSVID:
+			if k.Kid == "" {
+				return nil, fmt.Errorf("SPIFFE bundle key %d: jwt-svid key without kid", i)
+			}
+			if _, dup := b.JWTAuthorities[k.Kid]; dup {
+				return nil, fmt.Errorf("SPIFFE bundle key %d: duplicate kid %q", i, k.Kid)
+			}
+			pub, err := k.publicKey()
+			if err != nil {
+				return nil, fmt.Errorf("SPIFFE bundle key %s: %w", k.Kid, err)
+			}
+			b.JWTAuthorities[k.Kid] = pub
+		}
+	}
+	return b, nil
+}
+
+// TrustBundle reads the CA's trust bundle from BaseDir, preferring the
+// SPIFFE form.
+func (c *Config) TrustBundle() (*TrustBundle, error) {
+	for _, name := range []string{spiffeBundleFile, trustBundleFile} {
+		data, err := os.ReadFile(filepath.Join(c.BaseDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := ParseTrustBundle(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("%s: %w", trustBundleFile, os.ErrNotExist)
+}
+
+// writeTrustBundle publishes certs as trust-bundle.pem and, with the
+// unretired JWT keys, as trust-bundle.json and jwks.json.
+func (c *Config) writeTrustBundle(certs ...*x509.Certificate) error {
+	jwtKeys, err := c.jwtAuthorities()
+	if err != nil {
+		return err
+	}
+	b := &TrustBundle{X509Authorities: certs, JWTAuthorities: jwtKeys, RefreshHint: BundleRefreshHint}
+	if err := writeFileAtomic(filepath.Join(c.BaseDir, trustBundleFile), b.MarshalPEM(), 0644); err != nil {
+		return err
+	}
+	jwks, err := b.MarshalJWKS()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(c.BaseDir, jwksFile), jwks, 0644); err != nil {
+		return err
+	}
+	return c.writeSPIFFEBundle(b)
+}
+
+// writeSPIFFEBundle writes b to trust-bundle.json with the sequence number
+// after the current file's, or leaves the file alone if its authorities are
+// the same as b's.
+func (c *Config) writeSPIFFEBundle(b *TrustBundle) error {
+	path := filepath.Join(c.BaseDir, spiffeBundleFile)
+	b.Sequence = 1
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		prev, err := ParseTrustBundle(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", spiffeBundleFile, err)
+		}
+		if prev.sameAuthorities(b) {
+			return nil
+		}
+		b.Sequence = prev.Sequence + 1
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	out, err := b.MarshalSPIFFE()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, out, 0644)
+}
+
+// sameAuthorities reports whether b and o hold the same certificates, in
+// order, and the same JWT keys.
+func (b *TrustBundle) sameAuthorities(o *TrustBundle) bool {
+	if len(b.X509Authorities) != len(o.X509Authorities) || len(b.JWTAuthorities) != len(o.JWTAuthorities) {
+		return false
+	}
+	for i, cert := range b.X509Authorities {
+		if !cert.Equal(o.X509Authorities[i]) {
+			return false
+		}
+	}
+	for kid, pub := range b.JWTAuthorities {
+		if other, ok := o.JWTAuthorities[kid]; !ok || !publicKeysEqual(pub, other) {
+			return false
+		}
+	}
+	return true
+}
+
+var b64url = base64.RawURLEncoding
+
+// publicKeyJWK returns the RFC 7518 key parameters for pub.
+func publicKeyJWK(pub crypto.PublicKey) (jwk, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwk{Kty: "RSA", N: b64url.EncodeToString(k.N.Bytes()), E: b64url.EncodeToString(big.NewInt(int64(k.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		crv, size := curveName(k.Curve)
+		if crv == "" {
+			return jwk{}, errors.New("unsupported elliptic curve")
+		}
+		return jwk{
+			Kty: "EC",
+			Crv: crv,
+			X:   b64url.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   b64url.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return jwk{Kty: "OKP", Crv: "Ed25519", X: b64url.EncodeToString(k)}, nil
+	}
+	return jwk{}, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+func curveName(c elliptic.Curve) (string, int) {
+	switch c {
+	case elliptic.P256():
+		return "P-256", 32
+	case elliptic.P384():
+		return "P-384", 48
+	case elliptic.P521():
+		return "P-521", 66
+	}
+	return "", 0
+}
+
+// publicKey decodes the key parameters of k.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(name, v string) ([]byte, error) {
+		b, err := b64url.DecodeString(v)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("malformed %q", name)
+		}
+		return b, nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 || exp.Int64() < 3 {
+			return nil, errors.New("unsupported RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+			if name, _ := curveName(c); name == k.Crv {
+				curve = c
+			}
+		}
+		if curve == nil {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported OKP key %q", k.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// certificate decodes an x509-svid key's single x5c certificate, checking
+// it against the key parameters when those are present.
+func (k jwk) certificate() (*x509.Certificate, error) {
+	if len(k.X5c) != 1 {
+		return nil, fmt.Errorf("x509-svid key has %d x5c certificates, want 1", len(k.X5c))
+	}
+	der, err := base64.StdEncoding.DecodeString(k.X5c[0])
+	if err != nil {
+		return nil, fmt.Errorf("x5c: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if k.Kty != "" {
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		if !publicKeysEqual(pub, cert.PublicKey) {
+			return nil, errors.New("key parameters do not match the x5c certificate")
+		}
+	}
+	return cert, nil
+}
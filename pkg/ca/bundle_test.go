@@ -0,0 +1,103 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustBundle(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP384, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := cfg.TrustBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.X509Authorities) != 2 || len(b.JWTAuthorities) != 1 || b.Sequence != 1 || b.RefreshHint != BundleRefreshHint {
+		t.Fatalf("bundle: %d authorities, %d JWT keys, sequence %d, hint %s", len(b.X509Authorities), len(b.JWTAuthorities), b.Sequence, b.RefreshHint)
+	}
+	// The PEM form carries only the X.509 authorities.
+	pemBundle, err := ParseTrustBundle(readFile(t, filepath.Join(dir, "trust-bundle.pem")))
+	if err != nil || !pemBundle.sameAuthorities(&TrustBundle{X509Authorities: b.X509Authorities}) {
+		t.Fatalf("PEM and SPIFFE bundles differ: %v", err)
+	}
+
+	var raw struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	json.Unmarshal(readFile(t, filepath.Join(dir, "trust-bundle.json")), &raw)
+	for _, k := range raw.Keys {
+		switch k["use"] {
+		case UseX509SVID:
+			if k["x5c"] == nil || k["kty"] != "EC" {
+				t.Errorf("key %v", k)
+			}
+		case UseJWTSVID:
+			if k["kid"] == nil || k["x5c"] != nil {
+				t.Errorf("key %v", k)
+			}
+		default:
+			t.Errorf("key %v", k)
+		}
+	}
+
+	// Republishing unchanged authorities keeps the sequence; a rotation
+	// bumps it.
+	if err := cfg.publishTrustBundle(mustIntermediates(t, &cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := cfg.TrustBundle(); b.Sequence != 1 {
+		t.Errorf("unchanged bundle got sequence %d", b.Sequence)
+	}
+	if _, err := cfg.RotateIntermediate(&cfg, 0); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := cfg.TrustBundle(); b.Sequence != 2 || len(b.X509Authorities) != 3 {
+		t.Errorf("after rotation: sequence %d, %d authorities", b.Sequence, len(b.X509Authorities))
+	}
+}
+
+func TestSPIFFEBundleJWTAuthorities(t *testing.T) {
+	b := &TrustBundle{JWTAuthorities: map[string]crypto.PublicKey{}, Sequence: 7}
+	for _, alg := range KeyAlgorithms {
+		key, err := GenerateKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.JWTAuthorities[string(alg)] = key.Public()
+	}
+	data, err := b.MarshalSPIFFE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseTrustBundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.sameAuthorities(b) || got.Sequence != 7 {
+		t.Errorf("round trip changed the bundle: %+v", got)
+	}
+
+	// Unknown uses are ignored; a jwt-svid key needs a kid.
+	if _, err := ParseTrustBundle([]byte(`{"keys":[{"kty":"oct","use":"enc"}]}`)); err != nil {
+		t.Errorf("unknown use: %v", err)
+	}
+	noKid := bytes.Replace(data, []byte(`"kid": "ecdsa-p256"`), []byte(`"kid": ""`), 1)
+	if _, err := ParseTrustBundle(noKid); err == nil {
+		t.Error("accepted a jwt-svid key without kid")
+	}
+}
+
+func mustIntermediates(t *testing.T, c *Config) []IntermediateRecord {
+	t.Helper()
+	recs, err := c.Intermediates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
@@ -1,68 +1,127 @@
 package ca
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"math/big"
-	"net/url"
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
 )
 
 const (
-	DefaultValidityRoot    = 10 * 365 * 24 * time.Hour
-	DefaultValidityInter   = 365 * 24 * time.Hour
-	DefaultValidityLeaf    = 24 * time.Hour
-	KeySize                = 2048
-	SerialCounterStart     = 1
+	DefaultValidityRoot  = 10 * 365 * 24 * time.Hour
+	DefaultValidityInter = 365 * 24 * time.Hour
+	DefaultValidityLeaf  = 24 * time.Hour
+	SerialCounterStart   = 1
 )
 
-// Config holds paths for CA artifacts.
+// Config holds paths for CA artifacts and the key algorithms used for each tier.
+// Unset algorithms fall back to DefaultKeyAlgorithm.
 type Config struct {
-	BaseDir string
+	BaseDir                  string
+	RootKeyAlgorithm         KeyAlgorithm
+	IntermediateKeyAlgorithm KeyAlgorithm
+	LeafKeyAlgorithm         KeyAlgorithm
+
+	// KeyStore holds the CA private keys; nil keeps them as files in
+	// BaseDir, encrypted with Passphrase when one is set.
+	KeyStore   KeyStore
+	Passphrase PassphraseFunc
+
+	// MaxLeafValidity caps leaf lifetimes (DefaultMaxValidityLeaf).
+	MaxLeafValidity time.Duration
+	// NameConstraints, when set, is recorded by InitRoot and overrides
+	// ca.json when signing intermediates.
+	NameConstraints *NameConstraints
+	// ProfilesFile holds the leaf profiles (default BaseDir/profiles.json).
+	ProfilesFile string
+
+	// CRLValidity and CRLBackdate set the CRL update window
+	// (DefaultCRLValidity, DefaultCRLBackdate).
+	CRLValidity time.Duration
+	CRLBackdate time.Duration
+	// DeltaCRLValidity sets the delta CRL update window
+	// (DefaultDeltaCRLValidity).
+	DeltaCRLValidity time.Duration
+	// DeltaCRLURL, when set, overrides the delta CRL URLs in ca.json that
+	// base CRLs advertise.
+	DeltaCRLURL string
+	// Distribution, when set, is recorded in ca.json and overrides it for
+	// URLs embedded in issued certificates.
+	Distribution *DistributionURLs
+	// OCSPSignerValidity is the lifetime of delegated OCSP responder
+	// certificates (DefaultOCSPSignerValidity).
+	OCSPSignerValidity time.Duration
+
+	// TrustDomain, when set, is recorded in ca.json at init; leaves are
+	// only issued for IDs in the recorded trust domain (see
+	// LoadTrustDomain).
+	TrustDomain spiffeid.TrustDomain
+	// JWTKeyAlgorithm is the algorithm of new JWT-SVID signing keys
+	// (DefaultJWTKeyAlgorithm).
+	JWTKeyAlgorithm KeyAlgorithm
+	// MaxJWTSVIDValidity caps JWT-SVID lifetimes
+	// (DefaultMaxJWTSVIDValidity).
+	MaxJWTSVIDValidity time.Duration
+
+	// Lint, when set, overrides the pre-issuance lint levels of ca.json
+	// (see LintPolicy).
+	Lint LintPolicy
+	// Logf receives lint warnings and other non-fatal problems; they are
+	// dropped when it is nil.
+	Logf func(format string, args ...any)
+
+	// tlog is the transparency log signLeaf appends to, kept so each
+	// issuance extends its compact tree instead of rehashing the log.
+	tlog *TransparencyLog
+}
+
+func (c *Config) keyStore() KeyStore {
+	if c.KeyStore != nil {
+		return c.KeyStore
+	}
+	return &FileKeyStore{Dir: c.BaseDir, Passphrase: c.Passphrase}
 }
 
 // Init creates Root CA and Intermediate CA, writes trust bundle.
+// It runs every step of the offline root ceremony in BaseDir, which is
+// convenient for demos but leaves root.key next to the online intermediate.
 func (c *Config) Init() error {
-	if err := os.MkdirAll(c.BaseDir, 0700); err != nil {
+	if err := c.InitRoot(); err != nil {
 		return err
 	}
-	rootKey, rootCert, err := createRootCA()
+	csrPEM, err := c.CreateIntermediateCSR()
 	if err != nil {
 		return err
 	}
-	if err := c.writeKeyCert("root", rootKey, rootCert); err != nil {
-		return err
-	}
-	interKey, interCert, err := createIntermediateCA(rootKey, rootCert)
+	certPEM, err := c.SignIntermediate(csrPEM)
 	if err != nil {
 		return err
 	}
-	if err := c.writeKeyCert("intermediate", interKey, interCert); err != nil {
+	rootPEM, err := os.ReadFile(filepath.Join(c.BaseDir, "root.crt"))
+	if err != nil {
 		return err
 	}
-	return c.writeTrustBundle(rootCert, interCert)
+	return c.InstallIntermediate(certPEM, rootPEM)
 }
 
-func createRootCA() (*rsa.PrivateKey, *x509.Certificate, error) {
-	key, err := rsa.GenerateKey(rand.Reader, KeySize)
-	if err != nil {
-		return nil, nil, err
-	}
+func createRootCA(key crypto.Signer, commonName string) (*x509.Certificate, error) {
 	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	template := &x509.Certificate{
 		SerialNumber: serial,
 		Subject: pkix.Name{
 			Organization: []string{"Zero-Trust Demo"},
-			CommonName:   "Root CA",
+			CommonName:   commonName,
 		},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(DefaultValidityRoot),
@@ -70,25 +129,17 @@ func createRootCA() (*rsa.PrivateKey, *x509.Certificate, error) {
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	cert, err := x509.ParseCertificate(certDER)
-	if err != nil {
-		return nil, nil, err
-	}
-	return key, cert, nil
+	return x509.ParseCertificate(certDER)
 }
 
-func createIntermediateCA(parentKey *rsa.PrivateKey, parentCert *x509.Certificate) (*rsa.PrivateKey, *x509.Certificate, error) {
-	key, err := rsa.GenerateKey(rand.Reader, KeySize)
-	if err != nil {
-		return nil, nil, err
-	}
+func createIntermediateCA(pub crypto.PublicKey, parentKey crypto.Signer, parentCert *x509.Certificate, nc NameConstraints, dist DistributionURLs) (*x509.Certificate, error) {
 	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	template := &x509.Certificate{
 		SerialNumber: serial,
@@ -102,101 +153,139 @@ func createIntermediateCA(parentKey *rsa.PrivateKey, parentCert *x509.Certificat
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
-	if err != nil {
-		return nil, nil, err
+	if err := nc.apply(template); err != nil {
+		return nil, err
 	}
-	cert, err := x509.ParseCertificate(certDER)
+	dist.applyIntermediate(template)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parentCert, pub, parentKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return key, cert, nil
+	return x509.ParseCertificate(certDER)
 }
 
-func (c *Config) writeKeyCert(name string, key *rsa.PrivateKey, cert *x509.Certificate) error {
-	keyPath := filepath.Join(c.BaseDir, name+".key")
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
-	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
-		return err
-	}
+func (c *Config) writeCert(name string, cert *x509.Certificate) error {
 	certPath := filepath.Join(c.BaseDir, name+".crt")
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
 	return os.WriteFile(certPath, certPEM, 0644)
 }
 
-func (c *Config) writeTrustBundle(root, inter *x509.Certificate) error {
-	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
-	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: inter.Raw})...)
-	path := filepath.Join(c.BaseDir, "trust-bundle.pem")
-	return os.WriteFile(path, bundle, 0644)
+// IssueLeaf creates a leaf cert for the given SPIFFE ID with the default
+// profile, signed by the currently active intermediate. The leaf key is
+// generated with c.LeafKeyAlgorithm and returned as PKCS#8 PEM. Prefer
+// SignCSR, which keeps the private key with the workload.
+func (c *Config) IssueLeaf(spiffeID string, validity time.Duration) (certPEM, keyPEM, chainPEM string, serial string, err error) {
+	return c.IssueLeafRequest(LeafRequest{SPIFFEID: spiffeID, Validity: validity})
 }
 
-// IssueLeaf creates a leaf cert for the given SPIFFE ID.
-func (c *Config) IssueLeaf(spiffeID string, validity time.Duration) (certPEM, keyPEM, chainPEM string, serial string, err error) {
-	interKeyPEM, err := os.ReadFile(filepath.Join(c.BaseDir, "intermediate.key"))
+// IssueLeafRequest is IssueLeaf with a profile and extra SANs.
+func (c *Config) IssueLeafRequest(req LeafRequest) (certPEM, keyPEM, chainPEM string, serial string, err error) {
+	key, err := GenerateKey(c.LeafKeyAlgorithm)
 	if err != nil {
 		return "", "", "", "", err
 	}
-	interCertPEM, err := os.ReadFile(filepath.Join(c.BaseDir, "intermediate.crt"))
+	certPEM, chainPEM, serial, err = c.signLeaf(key.Public(), req)
 	if err != nil {
 		return "", "", "", "", err
 	}
-	key, err := rsa.GenerateKey(rand.Reader, KeySize)
+	keyBytes, err := MarshalPrivateKeyPEM(key)
 	if err != nil {
 		return "", "", "", "", err
 	}
-	keyBlock, _ := pem.Decode(interKeyPEM)
-	interKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	return certPEM, string(keyBytes), chainPEM, serial, nil
+}
+
+// signLeaf certifies pub as described by req with the active intermediate
+// and returns the leaf and chain PEM.
+func (c *Config) signLeaf(pub crypto.PublicKey, req LeafRequest) (certPEM, chainPEM, serial string, err error) {
+	return c.signLeafWith(pub, req, c.signingIntermediate, c.transparencyLog())
+}
+
+// intermediateSource returns the intermediate active at now, as
+// signingIntermediate does.
+type intermediateSource func(now time.Time) (IntermediateRecord, crypto.Signer, *x509.Certificate, []byte, error)
+
+// signLeafWith is signLeaf with the signing intermediate taken from inter
+// and the certificate logged to tlog; an Issuer passes cached ones.
+func (c *Config) signLeafWith(pub crypto.PublicKey, req LeafRequest, inter intermediateSource, tlog *TransparencyLog) (certPEM, chainPEM, serial string, err error) {
+	profile, err := c.Profile(req.Profile)
+	if err != nil {
+		return "", "", "", err
+	}
+	validity, err := profile.validity(req.Validity, c.maxLeafValidity())
 	if err != nil {
-		return "", "", "", "", err
+		return "", "", "", err
 	}
-	interCertBlock, _ := pem.Decode(interCertPEM)
-	interCert, err := x509.ParseCertificate(interCertBlock.Bytes)
+	id, err := spiffeid.FromString(req.SPIFFEID)
 	if err != nil {
-		return "", "", "", "", err
+		return "", "", "", err
+	}
+	td, err := c.LoadTrustDomain()
+	if err != nil {
+		return "", "", "", err
+	}
+	if err := id.RequireMemberOf(td); err != nil {
+		return "", "", "", fmt.Errorf("refusing to issue: %w", err)
+	}
+	uri := id.URL()
+	template, err := profile.template(req, uri, pub)
+	if err != nil {
+		return "", "", "", err
+	}
+	rec, interKey, interCert, interCertPEM, err := inter(time.Now())
+	if err != nil {
+		return "", "", "", err
 	}
-	if validity == 0 {
-		validity = DefaultValidityLeaf
+	dist, err := c.distribution()
+	if err != nil {
+		return "", "", "", err
 	}
+	dist.applyLeaf(template, rec.Name)
 	serialInt, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return "", "", "", "", err
+		return "", "", "", err
 	}
 	serial = fmt.Sprintf("%X", serialInt)
-	template := &x509.Certificate{
-		SerialNumber: serialInt,
-		Subject: pkix.Name{
-			Organization: []string{"Zero-Trust Demo"},
-			CommonName:   spiffeID,
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(validity),
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		URIs:        []*url.URL{parseSpiffeURI(spiffeID)},
+	template.SerialNumber = serialInt
+	template.NotBefore = time.Now()
+	template.NotAfter = template.NotBefore.Add(validity)
+	if err := checkNameConstraints(interCert, template); err != nil {
+		return "", "", "", fmt.Errorf("refusing to issue: %w", err)
+	}
+	template.PublicKey = pub
+	if err := c.lintLeaf(template, interCert); err != nil {
+		return "", "", "", fmt.Errorf("refusing to issue: %w", err)
 	}
-	certDER, err := x509.CreateCertificate(rand.Reader, template, interCert, &key.PublicKey, interKey)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, interCert, pub, interKey)
 	if err != nil {
-		return "", "", "", "", err
+		return "", "", "", err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return "", "", "", err
+	}
+	// A certificate the database does not know about could never be revoked
+	// or audited, and one missing from the transparency log is invisible to
+	// monitors, so failing to record it fails the issuance.
+	profileName := req.Profile
+	if profileName == "" {
+		profileName = DefaultProfile
+	}
+	if err := c.IssuanceDB().Record(newIssuedRecord(cert, uri.String(), profileName, interCert)); err != nil {
+		return "", "", "", fmt.Errorf("recording issued certificate: %w", err)
+	}
+	if err := tlog.append(cert, time.Now()); err != nil {
+		return "", "", "", fmt.Errorf("logging issued certificate: %w", err)
 	}
 	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
-	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
-	chainPEM = certPEM + string(interCertPEM)
-	return certPEM, keyPEM, chainPEM, serial, nil
-}
-
-func parseSpiffeURI(id string) *url.URL {
-	var uri string
-	switch {
-	case len(id) == 0:
-		uri = "spiffe://demo/ns/default/sa/unknown"
-	case len(id) >= 7 && id[:7] == "spiffe:":
-		uri = id
-	case id[0] == '/':
-		uri = "spiffe://demo" + id
-	default:
-		uri = "spiffe://" + id
-	}
-	u, _ := url.Parse(uri)
-	return u
+	issuerPEM, err := c.issuerChainPEM(interCert)
+	if err != nil {
+		return "", "", "", err
+	}
+	crossPEM, err := c.crossChainPEM(interCert)
+	if err != nil {
+		return "", "", "", err
+	}
+	chainPEM = certPEM + string(interCertPEM) + string(issuerPEM) + string(crossPEM)
+	return certPEM, chainPEM, serial, nil
 }
@@ -0,0 +1,233 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The offline root ceremony splits Init across two machines:
+//
+//	root host (air-gapped)           RA host
+//	----------------------           -------
+//	InitRoot                         CreateIntermediateCSR
+//	SignIntermediate(csr)   <-csr--
+//	                        --crt->  InstallIntermediate(crt, root.crt)
+//
+// Only intermediate.csr travels to the root host, and only intermediate.crt
+// and root.crt travel back; root.key never leaves the root host.
+
+// InitRoot creates the Root CA key and self-signed certificate in BaseDir.
+// It refuses to replace an existing root.
+func (c *Config) InitRoot() error {
+	if err := os.MkdirAll(c.BaseDir, 0700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(c.BaseDir, "root.crt")); err == nil {
+		return fmt.Errorf("%s already contains root.crt; refusing to overwrite the root CA", c.BaseDir)
+	}
+	if err := c.recordSettings(); err != nil {
+		return err
+	}
+	rootKey, err := c.keyStore().GenerateKey("root", c.RootKeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	rootCert, err := createRootCA(rootKey, "Root CA")
+	if err != nil {
+		return err
+	}
+	return c.writeCert("root", rootCert)
+}
+
+// CreateIntermediateCSR generates the Intermediate CA key and writes a
+// PKCS#10 request for it to BaseDir/intermediate.csr. It refuses to run
+// where an intermediate key or certificate already exists.
+func (c *Config) CreateIntermediateCSR() ([]byte, error) {
+	if err := os.MkdirAll(c.BaseDir, 0700); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"intermediate.key", "intermediate.crt"} {
+		if _, err := os.Stat(filepath.Join(c.BaseDir, name)); err == nil {
+			return nil, fmt.Errorf("%s already contains %s; refusing to replace the intermediate CA", c.BaseDir, name)
+		}
+	}
+	if err := c.recordSettings(); err != nil {
+		return nil, err
+	}
+	key, err := c.keyStore().GenerateKey("intermediate", c.IntermediateKeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	csrPEM, err := createIntermediateCSR(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(c.BaseDir, "intermediate.csr"), csrPEM, 0644); err != nil {
+		return nil, err
+	}
+	return csrPEM, nil
+}
+
+// SignIntermediate signs an intermediate CSR with the root in BaseDir and
+// returns the PEM certificate. Only the CSR's public key is used; the
+// subject and extensions come from the CA's intermediate template, with the
+// name constraints and root distribution URLs recorded at InitRoot.
+func (c *Config) SignIntermediate(csrPEM []byte) ([]byte, error) {
+	csr, err := parseCSRPEM(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := c.nameConstraints()
+	if err != nil {
+		return nil, err
+	}
+	dist, err := c.distribution()
+	if err != nil {
+		return nil, err
+	}
+	rootKey, rootCert, err := c.loadRoot()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := createIntermediateCA(csr.PublicKey, rootKey, rootCert, nc, dist)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), nil
+}
+
+// InstallIntermediate verifies a signed intermediate against rootPEM and the
+// intermediate key held by the key store, then writes intermediate.crt,
+// root.crt and trust-bundle.pem to BaseDir. It starts a fresh rotation
+// history with this intermediate active, and creates the first JWT-SVID
+// signing key and the transparency log key unless the directory already
+// has them.
+func (c *Config) InstallIntermediate(certPEM, rootPEM []byte) error {
+	inter, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("intermediate certificate: %w", err)
+	}
+	root, err := ParseCertificatePEM(rootPEM)
+	if err != nil {
+		return fmt.Errorf("root certificate: %w", err)
+	}
+	return c.installIntermediate(inter, root, nil, nil)
+}
+
+// installIntermediate is InstallIntermediate for an intermediate issued by
+// root through chain, the CA certificates between them (issuer first),
+// which is written to issuer-chain.pem. The intermediate must match key,
+// or the key store's "intermediate" key when key is nil.
+func (c *Config) installIntermediate(inter, root *x509.Certificate, chain []*x509.Certificate, key crypto.Signer) error {
+	if err := root.CheckSignatureFrom(root); err != nil {
+		return fmt.Errorf("root certificate is not self-signed: %w", err)
+	}
+	if !inter.IsCA {
+		return errors.New("intermediate certificate is not a CA")
+	}
+	issuer := root
+	if len(chain) > 0 {
+		issuer = chain[0]
+	}
+	if err := inter.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("intermediate is not signed by %q: %w", issuer.Subject.CommonName, err)
+	}
+	if key == nil {
+		var err error
+		if key, err = c.keyStore().Signer("intermediate"); err != nil {
+			return err
+		}
+	}
+	if !publicKeysEqual(key.Public(), inter.PublicKey) {
+		return errors.New("intermediate certificate does not match the intermediate key")
+	}
+	if err := c.writeCert("root", root); err != nil {
+		return err
+	}
+	if len(chain) > 0 {
+		var data []byte
+		for _, cert := range chain {
+			data = append(data, encodeCertPEM(cert)...)
+		}
+		if err := writeFileAtomic(filepath.Join(c.BaseDir, issuerChainFile), data, 0644); err != nil {
+			return err
+		}
+	}
+	if err := c.writeCert("intermediate", inter); err != nil {
+		return err
+	}
+	if recs, err := c.JWTKeys(); err != nil {
+		return err
+	} else if len(recs) == 0 {
+		if _, err := c.addJWTKey(0, time.Now()); err != nil {
+			return fmt.Errorf("JWT signing key: %w", err)
+		}
+	}
+	if err := c.TransparencyLog().init(time.Now()); err != nil {
+		return fmt.Errorf("transparency log: %w", err)
+	}
+	if err := c.writeTrustBundle(append(append([]*x509.Certificate{root}, chain...), inter)...); err != nil {
+		return err
+	}
+	if err := c.saveIntermediates([]IntermediateRecord{newIntermediateRecord("intermediate", inter, inter.NotBefore)}); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(c.BaseDir, "intermediate.csr"))
+	return nil
+}
+
+func createIntermediateCSR(key crypto.Signer) ([]byte, error) {
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{"Zero-Trust Demo"},
+			CommonName:   "Intermediate CA",
+		},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), nil
+}
+
+func (c *Config) loadRoot() (crypto.Signer, *x509.Certificate, error) {
+	roots, err := c.Roots()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := roots[len(roots)-1].Name
+	rootCert, err := c.readCert(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootKey, err := c.keyStore().Signer(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !publicKeysEqual(rootKey.Public(), rootCert.PublicKey) {
+		return nil, nil, fmt.Errorf("%s key does not match %s.crt", name, name)
+	}
+	return rootKey, rootCert, nil
+}
+
+func parseCSRPEM(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("no CERTIFICATE REQUEST PEM block found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("CSR signature: %w", err)
+	}
+	return csr, nil
+}
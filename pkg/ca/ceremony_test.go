@@ -0,0 +1,110 @@
+package ca
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOfflineRootCeremony(t *testing.T) {
+	rootDir, raDir := t.TempDir(), t.TempDir()
+	root := Config{BaseDir: rootDir, RootKeyAlgorithm: ECDSAP384}
+	ra := Config{BaseDir: raDir, IntermediateKeyAlgorithm: ECDSAP256}
+
+	if err := root.InitRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.InitRoot(); err == nil {
+		t.Error("InitRoot overwrote an existing root")
+	}
+	csrPEM, err := ra.CreateIntermediateCSR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := root.SignIntermediate(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPEM, err := os.ReadFile(filepath.Join(rootDir, "root.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.InstallIntermediate(certPEM, rootPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"root.key", "intermediate.csr"} {
+		if _, err := os.Stat(filepath.Join(raDir, f)); !os.IsNotExist(err) {
+			t.Errorf("%s present on RA host", f)
+		}
+	}
+	leafPEM, _, chainPEM, _, err := ra.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyLeafChain(t, raDir, leafPEM, chainPEM)
+}
+
+func TestInstallIntermediateRejectsMismatch(t *testing.T) {
+	rootDir, otherRootDir, raDir := t.TempDir(), t.TempDir(), t.TempDir()
+	root := Config{BaseDir: rootDir, RootKeyAlgorithm: ECDSAP256}
+	other := Config{BaseDir: otherRootDir, RootKeyAlgorithm: ECDSAP256}
+	ra := Config{BaseDir: raDir, IntermediateKeyAlgorithm: ECDSAP256}
+	for _, c := range []*Config{&root, &other} {
+		if err := c.InitRoot(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csrPEM, err := ra.CreateIntermediateCSR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := root.SignIntermediate(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRootPEM, _ := os.ReadFile(filepath.Join(otherRootDir, "root.crt"))
+	if err := ra.InstallIntermediate(certPEM, otherRootPEM); err == nil {
+		t.Error("installed intermediate against the wrong root")
+	}
+
+	// A certificate for a different key must not be installed.
+	ra2 := Config{BaseDir: t.TempDir(), IntermediateKeyAlgorithm: ECDSAP256}
+	if _, err := ra2.CreateIntermediateCSR(); err != nil {
+		t.Fatal(err)
+	}
+	rootPEM, _ := os.ReadFile(filepath.Join(rootDir, "root.crt"))
+	if err := ra2.InstallIntermediate(certPEM, rootPEM); err == nil {
+		t.Error("installed certificate that does not match the intermediate key")
+	}
+	if _, err := root.SignIntermediate([]byte("not a csr")); err == nil {
+		t.Error("signed garbage CSR")
+	}
+}
+
+func TestCreateIntermediateCSRRefusesExistingKey(t *testing.T) {
+	ra := Config{BaseDir: t.TempDir(), IntermediateKeyAlgorithm: ECDSAP256}
+	if _, err := ra.CreateIntermediateCSR(); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(ra.BaseDir, "intermediate.key")
+	before, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ra.CreateIntermediateCSR(); err == nil {
+		t.Error("second CreateIntermediateCSR succeeded")
+	}
+	after, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("intermediate.key was replaced")
+	}
+	store := &FileKeyStore{Dir: ra.BaseDir}
+	if _, err := store.GenerateKey("intermediate", ECDSAP256); err == nil {
+		t.Error("GenerateKey replaced an existing key")
+	}
+}
@@ -0,0 +1,195 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// NameConstraints limits the names an intermediate may certify. They are
+// written into every intermediate the root signs (RFC 5280 section 4.2.1.10)
+// and IssueLeaf refuses leaves the issuing intermediate's constraints would
+// reject, so a coerced RA cannot mint certificates outside these subtrees.
+//
+// TrustDomain becomes the only permitted URI host, so leaves may only carry
+// spiffe://<TrustDomain>/... IDs. DNSDomains and IPRanges (CIDR notation)
+// are optional; when empty, that name type is unconstrained.
+type NameConstraints struct {
+	TrustDomain string   `json:"trust_domain,omitempty"`
+	DNSDomains  []string `json:"permitted_dns_domains,omitempty"`
+	IPRanges    []string `json:"permitted_ip_ranges,omitempty"`
+}
+
+// IsZero reports whether n constrains nothing.
+func (n NameConstraints) IsZero() bool {
+	return n.TrustDomain == "" && len(n.DNSDomains) == 0 && len(n.IPRanges) == 0
+}
+
+// Validate checks that every subtree is well formed.
+func (n NameConstraints) Validate() error {
+	if n.TrustDomain != "" {
+		if _, err := spiffeid.TrustDomainFromString(n.TrustDomain); err != nil {
+			return err
+		}
+	}
+	for _, d := range n.DNSDomains {
+		if !validConstraintDomain(strings.TrimPrefix(d, ".")) {
+			return fmt.Errorf("invalid permitted DNS domain %q", d)
+		}
+	}
+	_, err := n.ipNets()
+	return err
+}
+
+func (n NameConstraints) ipNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, r := range n.IPRanges {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permitted IP range %q: %w", r, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// apply sets the permitted subtrees on an intermediate template.
+func (n NameConstraints) apply(template *x509.Certificate) error {
+	if n.IsZero() {
+		return nil
+	}
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	nets, _ := n.ipNets()
+	template.PermittedDNSDomainsCritical = true // marks the whole extension critical
+	if n.TrustDomain != "" {
+		template.PermittedURIDomains = []string{strings.ToLower(n.TrustDomain)}
+	}
+	for _, d := range n.DNSDomains {
+		template.PermittedDNSDomains = append(template.PermittedDNSDomains, strings.ToLower(d))
+	}
+	template.PermittedIPRanges = nets
+	return nil
+}
+
+func validConstraintDomain(d string) bool {
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkNameConstraints returns an error if any name in leaf falls outside
+// issuer's permitted subtrees or inside its excluded ones. URI constraints
+// without a leading dot match the host exactly, as OpenSSL does; this is
+// stricter than Go's verifier, so anything accepted here passes both.
+func checkNameConstraints(issuer, leaf *x509.Certificate) error {
+	for _, u := range leaf.URIs {
+		host, err := uriHost(u)
+		if err != nil {
+			if len(issuer.PermittedURIDomains) > 0 || len(issuer.ExcludedURIDomains) > 0 {
+				return err
+			}
+			continue
+		}
+		if err := checkDomain("URI", u.String(), host, issuer.PermittedURIDomains, issuer.ExcludedURIDomains, matchURIDomain); err != nil {
+			return err
+		}
+	}
+	for _, name := range leaf.DNSNames {
+		if err := checkDomain("DNS name", name, strings.ToLower(name), issuer.PermittedDNSDomains, issuer.ExcludedDNSDomains, matchDNSDomain); err != nil {
+			return err
+		}
+	}
+	for _, ip := range leaf.IPAddresses {
+		if len(issuer.PermittedIPRanges) > 0 && !ipInRanges(ip, issuer.PermittedIPRanges) {
+			return fmt.Errorf("IP address %s is not permitted by the issuer's name constraints", ip)
+		}
+		if ipInRanges(ip, issuer.ExcludedIPRanges) {
+			return fmt.Errorf("IP address %s is excluded by the issuer's name constraints", ip)
+		}
+	}
+	if len(leaf.EmailAddresses) > 0 && (len(issuer.PermittedEmailAddresses) > 0 || len(issuer.ExcludedEmailAddresses) > 0) {
+		return fmt.Errorf("email SANs are not supported under name constraints")
+	}
+	return nil
+}
+
+func checkDomain(kind, name, host string, permitted, excluded []string, match func(host, constraint string) bool) error {
+	if len(permitted) > 0 {
+		ok := false
+		for _, c := range permitted {
+			if match(host, c) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s %q is not permitted by the issuer's name constraints", kind, name)
+		}
+	}
+	for _, c := range excluded {
+		if match(host, c) {
+			return fmt.Errorf("%s %q is excluded by the issuer's name constraints", kind, name)
+		}
+	}
+	return nil
+}
+
+func uriHost(u *url.URL) (string, error) {
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("URI %q has no host to check against name constraints", u.String())
+	}
+	if net.ParseIP(host) != nil {
+		return "", fmt.Errorf("URI %q has an IP address host", u.String())
+	}
+	return strings.ToLower(host), nil
+}
+
+// matchDNSDomain: "example.com" matches itself and its subdomains,
+// ".example.com" only subdomains.
+func matchDNSDomain(host, constraint string) bool {
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint || strings.HasSuffix(host, "."+constraint)
+}
+
+// matchURIDomain: "example.com" matches only that host, ".example.com" only
+// subdomains (RFC 5280).
+func matchURIDomain(host, constraint string) bool {
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+func ipInRanges(ip net.IP, ranges []*net.IPNet) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+func TestIntermediateNameConstraints(t *testing.T) {
+	dir := t.TempDir()
+	nc := &NameConstraints{TrustDomain: "demo", DNSDomains: []string{"svc.internal"}, IPRanges: []string{"10.0.0.0/8"}}
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256, NameConstraints: nc}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	inter, err := cfg.readCert("intermediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inter.PermittedURIDomains) != 1 || inter.PermittedURIDomains[0] != "demo" || !inter.PermittedDNSDomainsCritical {
+		t.Fatalf("intermediate constraints: uri=%v critical=%v", inter.PermittedURIDomains, inter.PermittedDNSDomainsCritical)
+	}
+
+	certPEM, _, chainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyLeafChain(t, dir, certPEM, chainPEM)
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://evil/ns/default/sa/test", 0); !errors.Is(err, spiffeid.ErrNotMemberOfDomain) {
+		t.Errorf("issued outside the trust domain: %v", err)
+	}
+	// A CA misconfigured for another trust domain is still held to the
+	// intermediate's constraints.
+	misconfigured := cfg
+	misconfigured.TrustDomain, _ = spiffeid.TrustDomainFromString("evil")
+	if _, _, _, _, err := misconfigured.IssueLeaf("spiffe://evil/ns/default/sa/test", 0); err == nil || !strings.Contains(err.Error(), "name constraints") {
+		t.Errorf("issued outside the name constraints: %v", err)
+	}
+
+	// Rotated intermediates pick the constraints up from ca.json.
+	online := Config{BaseDir: dir, IntermediateKeyAlgorithm: ECDSAP256}
+	if _, err := online.RotateIntermediate(&online, 0); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := cfg.readCert("intermediate-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated.PermittedURIDomains) != 1 || len(rotated.PermittedIPRanges) != 1 {
+		t.Errorf("rotated intermediate lost its constraints: %v %v", rotated.PermittedURIDomains, rotated.PermittedIPRanges)
+	}
+
+	// A relying party rejects a leaf the CA would have refused.
+	key, err := cfg.keyStore().Signer("intermediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, _ := GenerateKey(ECDSAP256)
+	evil, _ := url.Parse("spiffe://evil/ns/default/sa/test")
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), URIs: []*url.URL{evil},
+	}, inter, leafKey.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	root, _ := cfg.readCert("root")
+	roots, inters := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	inters.AddCert(inter)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inters, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err == nil {
+		t.Error("relying party accepted a leaf outside the name constraints")
+	}
+}
+
+func TestCheckNameConstraints(t *testing.T) {
+	var issuer x509.Certificate
+	if err := (NameConstraints{TrustDomain: "demo", DNSDomains: []string{"svc.internal"}, IPRanges: []string{"10.0.0.0/8"}}).apply(&issuer); err != nil {
+		t.Fatal(err)
+	}
+	uri := func(s string) []*url.URL { u, _ := url.Parse(s); return []*url.URL{u} }
+	tests := []struct {
+		name string
+		leaf x509.Certificate
+		ok   bool
+	}{
+		{"trust domain", x509.Certificate{URIs: uri("spiffe://demo/ns/a")}, true},
+		{"trust domain case", x509.Certificate{URIs: uri("spiffe://DEMO/ns/a")}, true},
+		{"other trust domain", x509.Certificate{URIs: uri("spiffe://other/ns/a")}, false},
+		{"subdomain of trust domain", x509.Certificate{URIs: uri("spiffe://x.demo/ns/a")}, false},
+		{"no host", x509.Certificate{URIs: uri("spiffe:///ns/a")}, false},
+		{"dns exact", x509.Certificate{DNSNames: []string{"svc.internal"}}, true},
+		{"dns subdomain", x509.Certificate{DNSNames: []string{"api.svc.internal"}}, true},
+		{"dns suffix only", x509.Certificate{DNSNames: []string{"evilsvc.internal"}}, false},
+		{"ip in range", x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, true},
+		{"ip out of range", x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}}, false},
+	}
+	for _, tt := range tests {
+		err := checkNameConstraints(&issuer, &tt.leaf)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+	if err := (NameConstraints{IPRanges: []string{"10.0.0.0"}}).Validate(); err == nil {
+		t.Error("accepted an IP range without a prefix length")
+	}
+	if err := (NameConstraints{TrustDomain: "bad domain"}).Validate(); err == nil {
+		t.Error("accepted an invalid trust domain")
+	}
+}
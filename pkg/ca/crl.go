@@ -1,46 +1,356 @@
 package ca
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/models"
+)
+
+// DefaultCRLValidity is the gap between a CRL's ThisUpdate and NextUpdate.
+// Whoever publishes the CRL (the RA, or ztca crl from cron) must regenerate
+// it well within this window.
+const DefaultCRLValidity = 24 * time.Hour
+
+// DefaultCRLBackdate sets ThisUpdate slightly in the past so relying parties
+// with a skewed clock do not reject a freshly published CRL.
+const DefaultCRLBackdate = 5 * time.Minute
+
+const (
+	crlFile           = "crl.pem"
+	crlNumberFile     = "crlnumber"
+	crlNumberLockFile = "crlnumber.lock"
+
+	rootRevocationsFile = "root-revocations.json"
 )
 
+// RFC 5280 section 5.3.1 CRLReason codes, keyed by their ASN.1 names.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"removeFromCRL":        8,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// ParseRevocationReason returns the RFC 5280 reason code and canonical name
+// for reason. Names match case-insensitively and ignore '_' and '-', so
+// "key_compromise" is keyCompromise; "" is unspecified. removeFromCRL is
+// rejected because it only has meaning in a delta CRL.
+func ParseRevocationReason(reason string) (int, string, error) {
+	if reason == "" {
+		return 0, "unspecified", nil
+	}
+	fold := strings.NewReplacer("_", "", "-", "").Replace(reason)
+	for name, code := range revocationReasons {
+		if strings.EqualFold(fold, name) && code != 8 {
+			return code, name, nil
+		}
+	}
+	return 0, "", fmt.Errorf("unknown revocation reason %q", reason)
+}
+
+// RevocationEntry converts a revoked issuance record to the form CRLs are
+// built from.
+func (r IssuedRecord) RevocationEntry() models.RevocationEntry {
+	return models.RevocationEntry{Serial: r.Serial, RevokedAt: r.RevokedAt, Reason: r.RevocationReason}
+}
+
+func (c *Config) crlValidity() time.Duration {
+	if c.CRLValidity > 0 {
+		return c.CRLValidity
+	}
+	return DefaultCRLValidity
+}
+
+func (c *Config) crlBackdate() time.Duration {
+	if c.CRLBackdate > 0 {
+		return c.CRLBackdate
+	}
+	return DefaultCRLBackdate
+}
+
+// crlNumberMu serializes CRL number allocation within a process; a flock
+// on BaseDir/crlnumber.lock serializes the processes sharing BaseDir. The
+// lock is on a file of its own because crlnumber is replaced on every
+// write.
+var crlNumberMu sync.Mutex
 
-// CreateEmptyCRL creates an empty CRL for the Intermediate CA.
-func (c *Config) CreateEmptyCRL() error {
-	interCertPEM, err := os.ReadFile(filepath.Join(c.BaseDir, "intermediate.crt"))
+// lockCRLNumber takes crlNumberMu and crlnumber.lock, and returns the
+// function that releases both.
+func (c *Config) lockCRLNumber() (func(), error) {
+	crlNumberMu.Lock()
+	unlock, err := lockFile(filepath.Join(c.BaseDir, crlNumberLockFile))
 	if err != nil {
-		return err
+		crlNumberMu.Unlock()
+		return nil, fmt.Errorf("lock %s: %w", crlNumberFile, err)
 	}
-	interKeyPEM, err := os.ReadFile(filepath.Join(c.BaseDir, "intermediate.key"))
+	return func() {
+		unlock()
+		crlNumberMu.Unlock()
+	}, nil
+}
+
+// nextCRLNumber increments and returns the CRL number persisted in
+// BaseDir/crlnumber (hex, as openssl ca keeps it). Numbers are shared by
+// every intermediate, which keeps each issuer's sequence increasing.
+func (c *Config) nextCRLNumber() (*big.Int, error) {
+	unlock, err := c.lockCRLNumber()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer unlock()
+	path := filepath.Join(c.BaseDir, crlNumberFile)
+	n := new(big.Int)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if _, ok := n.SetString(strings.TrimSpace(string(data)), 16); !ok {
+			return nil, fmt.Errorf("%s: invalid CRL number", crlNumberFile)
+		}
 	}
-	interCertBlock, _ := pem.Decode(interCertPEM)
-	interCert, err := x509.ParseCertificate(interCertBlock.Bytes)
+	n.Add(n, big.NewInt(1))
+	if err := writeFileAtomic(path, []byte(fmt.Sprintf("%X\n", n)), 0644); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// CreateCRL returns a DER CRL listing entries, signed by the intermediate
+// named issuer. ThisUpdate is now minus CRLBackdate and NextUpdate is
+// ThisUpdate plus CRLValidity; the CRL number is taken from
+// BaseDir/crlnumber. With a delta CRL URL (DeltaCRLURL, else the one in
+// ca.json) the CRL carries a Freshest CRL extension pointing relying
+// parties at its deltas ({issuer} is replaced with the issuer name).
+func (c *Config) CreateCRL(issuer string, entries []models.RevocationEntry, now time.Time) ([]byte, error) {
+	urls, err := c.deltaCRLURLs()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var exts []pkix.Extension
+	if len(urls) > 0 {
+		ext, err := freshestCRLExtension(expandIssuer(urls, issuer))
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
 	}
-	interKeyBlock, _ := pem.Decode(interKeyPEM)
-	interKey, err := x509.ParsePKCS1PrivateKey(interKeyBlock.Bytes)
+	return c.signCRL(issuer, entries, now, c.crlValidity(), exts)
+}
+
+func (c *Config) signCRL(issuer string, entries []models.RevocationEntry, now time.Time, validity time.Duration, exts []pkix.Extension) ([]byte, error) {
+	cert, err := c.readCert(issuer)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	key, err := c.keyStore().Signer(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return nil, fmt.Errorf("%s key does not match %s.crt", issuer, issuer)
+	}
+	return c.signCRLWith(key, cert, entries, now, validity, exts)
+}
+
+func (c *Config) signCRLWith(key crypto.Signer, cert *x509.Certificate, entries []models.RevocationEntry, now time.Time, validity time.Duration, exts []pkix.Extension) ([]byte, error) {
+	revoked := make([]x509.RevocationListEntry, 0, len(entries))
+	for _, e := range entries {
+		serial, ok := new(big.Int).SetString(NormalizeSerial(e.Serial), 16)
+		if !ok {
+			return nil, fmt.Errorf("revocation entry: invalid serial %q", e.Serial)
+		}
+		code, _, err := ParseRevocationReason(e.Reason)
+		if err != nil {
+			return nil, fmt.Errorf("revocation entry %s: %w", e.Serial, err)
+		}
+		if e.RevokedAt.IsZero() {
+			return nil, fmt.Errorf("revocation entry %s: missing revocation date", e.Serial)
+		}
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: e.RevokedAt.UTC(),
+			ReasonCode:     code,
+		})
 	}
+	number, err := c.nextCRLNumber()
+	if err != nil {
+		return nil, err
+	}
+	thisUpdate := now.Add(-c.crlBackdate()).UTC()
 	template := &x509.RevocationList{
-		Number:     big.NewInt(1),
-		ThisUpdate: time.Now(),
-		NextUpdate: time.Now().Add(24 * time.Hour),
-		RevokedCertificateEntries: []x509.RevocationListEntry{},
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                thisUpdate.Add(validity),
+		RevokedCertificateEntries: revoked,
+		ExtraExtensions:           exts,
 	}
-	crlDER, err := x509.CreateRevocationList(rand.Reader, template, interCert, interKey)
+	return x509.CreateRevocationList(rand.Reader, template, cert, key)
+}
+
+// UpdateCRL regenerates BaseDir/crl.pem from the issuance database: one CRL
+// per unretired intermediate, each listing the unexpired certificates it
+// issued that have been revoked. Relying parties load every CRL in the file
+// and match them to issuers; each CRL is also written as DER to
+// BaseDir/<intermediate>.crl for distribution points. It also writes an empty delta CRL bundle
+// against the new base CRLs (see UpdateDeltaCRL).
+func (c *Config) UpdateCRL(now time.Time) error {
+	inters, revoked, err := c.revokedByIntermediate(now)
 	if err != nil {
 		return err
 	}
-	path := filepath.Join(c.BaseDir, "crl.pem")
-	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}), 0644)
+	var bases []*x509.RevocationList
+	var out bytes.Buffer
+	for _, inter := range inters {
+		der, err := c.CreateCRL(inter.Name, revoked[inter.Serial], now)
+		if err != nil {
+			return fmt.Errorf("CRL for %s: %w", inter.Name, err)
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return err
+		}
+		bases = append(bases, crl)
+		pem.Encode(&out, &pem.Block{Type: "X509 CRL", Bytes: der})
+		// Per-issuer DER copy for CRL Distribution Point URLs.
+		if err := writeFileAtomic(filepath.Join(c.BaseDir, inter.Name+".crl"), der, 0644); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(c.BaseDir, crlFile), out.Bytes(), 0644); err != nil {
+		return err
+	}
+	return c.writeDeltaCRLs(inters, bases, revoked, now)
+}
+
+// DefaultRootCRLValidity is the root CRL's update window. The root is
+// offline, so its CRL is re-signed rarely, by hand.
+const DefaultRootCRLValidity = 30 * 24 * time.Hour
+
+// CreateRootCRL adds the revoked intermediates in entries to those recorded
+// in BaseDir/root-revocations.json, signs a CRL listing all of them with
+// the current root key, and writes it as DER to BaseDir/root.crl for
+// publication at the intermediates' CRL Distribution Point. An intermediate
+// already recorded keeps its original revocation time and reason. Its window
+// is CRLValidity, default DefaultRootCRLValidity.
+func (c *Config) CreateRootCRL(entries []models.RevocationEntry, now time.Time) ([]byte, error) {
+	key, cert, err := c.loadRoot()
+	if err != nil {
+		return nil, err
+	}
+	all, err := c.RootRevocations()
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, e := range all {
+		known[NormalizeSerial(e.Serial)] = true
+	}
+	for _, e := range entries {
+		if serial := NormalizeSerial(e.Serial); !known[serial] {
+			known[serial] = true
+			e.Serial = serial
+			all = append(all, e)
+		}
+	}
+	validity := c.CRLValidity
+	if validity <= 0 {
+		validity = DefaultRootCRLValidity
+	}
+	der, err := c.signCRLWith(key, cert, all, now, validity, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(c.BaseDir, rootRevocationsFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return der, writeFileAtomic(filepath.Join(c.BaseDir, "root.crl"), der, 0644)
+}
+
+// RootRevocations returns the intermediates recorded as revoked in
+// BaseDir/root-revocations.json by CreateRootCRL.
+func (c *Config) RootRevocations() ([]models.RevocationEntry, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, rootRevocationsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.RevocationEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", rootRevocationsFile, err)
+	}
+	return entries, nil
+}
+
+// revokedByIntermediate returns the unretired intermediates and, keyed by
+// intermediate serial, the unexpired revoked certificates each issued.
+func (c *Config) revokedByIntermediate(now time.Time) ([]IntermediateRecord, map[string][]models.RevocationEntry, error) {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return nil, nil, err
+	}
+	revoked, err := c.IssuanceDB().List(IssuedFilter{Status: StatusRevoked, At: now})
+	if err != nil {
+		return nil, nil, err
+	}
+	var inters []IntermediateRecord
+	for _, r := range recs {
+		if !r.Retired {
+			inters = append(inters, r)
+		}
+	}
+	byIssuer := map[string][]models.RevocationEntry{}
+	for _, r := range revoked {
+		if now.Before(r.NotAfter) {
+			byIssuer[r.IssuerSerial] = append(byIssuer[r.IssuerSerial], r.RevocationEntry())
+		}
+	}
+	return inters, byIssuer, nil
+}
+
+// ParseCRLsPEM parses every X509 CRL block in data, such as crl.pem.
+func ParseCRLsPEM(data []byte) ([]*x509.RevocationList, error) {
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return crls, nil
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
 }
@@ -1,9 +1,13 @@
 package ca
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestInit(t *testing.T) {
@@ -33,3 +37,117 @@ func TestIssueLeaf(t *testing.T) {
 		t.Error("empty output")
 	}
 }
+
+func TestUpdateCRL(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256, CRLValidity: 6 * time.Hour}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, oldSerial, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second generation signs the next leaf; each keeps its own CRL.
+	if _, err := cfg.RotateIntermediate(&cfg, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, newSerial, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/b", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, keptSerial, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/c", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if _, err := cfg.IssuanceDB().RevokeSerial(oldSerial, "key_compromise", revokedAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.IssuanceDB().RevokeSerial(newSerial, "superseded", revokedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if err := cfg.UpdateCRL(now); err != nil {
+		t.Fatal(err)
+	}
+	crls := readCRLs(t, filepath.Join(dir, "crl.pem"))
+	if len(crls) != 2 {
+		t.Fatalf("crl.pem holds %d CRLs, want one per intermediate", len(crls))
+	}
+	inters, _ := cfg.Intermediates()
+	want := map[string][2]any{oldSerial: {inters[0].Name, 1}, newSerial: {inters[1].Name, 4}}
+	for i, crl := range crls {
+		issuer, _ := cfg.readCert(inters[i].Name)
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			t.Errorf("CRL %d not signed by %s: %v", i, inters[i].Name, err)
+		}
+		if crl.NextUpdate.Sub(crl.ThisUpdate) != 6*time.Hour || !crl.ThisUpdate.Before(now) {
+			t.Errorf("CRL %d window %s - %s", i, crl.ThisUpdate, crl.NextUpdate)
+		}
+		if len(crl.RevokedCertificateEntries) != 1 {
+			t.Fatalf("CRL %d has %d entries, want 1", i, len(crl.RevokedCertificateEntries))
+		}
+		e := crl.RevokedCertificateEntries[0]
+		w, ok := want[fmt.Sprintf("%X", e.SerialNumber)]
+		if !ok || w[0] != inters[i].Name || e.ReasonCode != w[1] || !e.RevocationTime.Equal(revokedAt) {
+			t.Errorf("CRL %d entry: serial=%X reason=%d time=%s", i, e.SerialNumber, e.ReasonCode, e.RevocationTime)
+		}
+		if fmt.Sprintf("%X", e.SerialNumber) == keptSerial {
+			t.Error("unrevoked certificate listed")
+		}
+	}
+
+	// CRL numbers persist and keep increasing.
+	first := crls[1].Number
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if again := readCRLs(t, filepath.Join(dir, "crl.pem")); again[0].Number.Cmp(first) <= 0 {
+		t.Errorf("CRL number %s after %s", again[0].Number, first)
+	}
+
+	// Once revoked leaves expire they drop off.
+	if err := cfg.UpdateCRL(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, crl := range readCRLs(t, filepath.Join(dir, "crl.pem")) {
+		if len(crl.RevokedCertificateEntries) != 0 {
+			t.Errorf("expired entries still listed: %d", len(crl.RevokedCertificateEntries))
+		}
+	}
+}
+
+func TestParseRevocationReason(t *testing.T) {
+	for in, code := range map[string]int{"": 0, "keyCompromise": 1, "key_compromise": 1, "CA-compromise": 2, "cessation-of-operation": 5, "removeFromCRL": -1, "revoked": -1} {
+		got, _, err := ParseRevocationReason(in)
+		if code < 0 {
+			if err == nil {
+				t.Errorf("%q accepted", in)
+			}
+			continue
+		}
+		if err != nil || got != code {
+			t.Errorf("ParseRevocationReason(%q) = %d, %v; want %d", in, got, err, code)
+		}
+	}
+}
+
+func readCRLs(t *testing.T, path string) []*x509.RevocationList {
+	t.Helper()
+	data := readFile(t, path)
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return crls
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crls = append(crls, crl)
+	}
+}
@@ -0,0 +1,143 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+var oidBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+
+// ErrInvalidCSR wraps every reason SignCSR refuses a request, as opposed to
+// CA-side failures.
+var ErrInvalidCSR = errors.New("invalid CSR")
+
+// minLeafRSABits is the smallest RSA modulus SignCSR accepts.
+const minLeafRSABits = 2048
+
+// SignCSR certifies the key in a PKCS#10 request for spiffeID with the
+// default profile, so the private key never leaves the workload. The request
+// must be self-signed, name spiffeID as its only URI SAN and use a key type
+// the CA accepts. Subject, key usages and validity come from the CA; other
+// requested extensions are ignored, except that asking to be a CA is
+// rejected.
+func (c *Config) SignCSR(csrPEM []byte, spiffeID string, validity time.Duration) (certPEM, chainPEM, serial string, err error) {
+	return c.SignCSRRequest(csrPEM, LeafRequest{SPIFFEID: spiffeID, Validity: validity})
+}
+
+// SignCSRRequest is SignCSR with a profile. DNS and IP SANs are taken from
+// the CSR and must be allowed by the profile.
+func (c *Config) SignCSRRequest(csrPEM []byte, req LeafRequest) (certPEM, chainPEM, serial string, err error) {
+	pub, req, err := c.checkCSRRequest(csrPEM, req)
+	if err != nil {
+		return "", "", "", err
+	}
+	return c.signLeaf(pub, req)
+}
+
+// checkCSRRequest checks csrPEM for req and returns its public key and req
+// with the CSR's SANs.
+func (c *Config) checkCSRRequest(csrPEM []byte, req LeafRequest) (crypto.PublicKey, LeafRequest, error) {
+	csr, err := checkLeafCSR(csrPEM, req.SPIFFEID)
+	if err != nil {
+		return nil, req, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	profile, err := c.Profile(req.Profile)
+	if err != nil {
+		return nil, req, err
+	}
+	req.DNSNames, req.IPAddresses = csr.DNSNames, csr.IPAddresses
+	if err := profile.checkSANs(req); err != nil {
+		return nil, req, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	return csr.PublicKey, req, nil
+}
+
+func checkLeafCSR(csrPEM []byte, spiffeID string) (*x509.CertificateRequest, error) {
+	csr, err := parseCSRPEM(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLeafPublicKey(csr.PublicKey); err != nil {
+		return nil, err
+	}
+	if len(csr.EmailAddresses) > 0 {
+		return nil, errors.New("email SANs may not be requested")
+	}
+	want, err := spiffeid.FromString(spiffeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(csr.URIs) != 1 {
+		return nil, fmt.Errorf("must request exactly the URI SAN %s", want)
+	}
+	got, err := spiffeid.FromURI(csr.URIs[0])
+	if err != nil {
+		return nil, err
+	}
+	if got != want {
+		return nil, fmt.Errorf("must request exactly the URI SAN %s, not %s", want, got)
+	}
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidBasicConstraints) {
+			continue
+		}
+		var bc struct {
+			IsCA bool `asn1:"optional"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &bc); err != nil || bc.IsCA {
+			return nil, errors.New("requests a CA certificate")
+		}
+	}
+	return csr, nil
+}
+
+// CreateLeafCSR returns a PEM PKCS#10 request for key naming req.SPIFFEID
+// and req's DNS and IP SANs, in the form SignCSRRequest expects.
+func CreateLeafCSR(key crypto.Signer, req LeafRequest) ([]byte, error) {
+	id, err := spiffeid.FromString(req.SPIFFEID)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: id.String()},
+		URIs:        []*url.URL{id.URL()},
+		DNSNames:    req.DNSNames,
+		IPAddresses: req.IPAddresses,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// checkLeafPublicKey rejects key types and sizes the CA will not certify.
+func checkLeafPublicKey(pub crypto.PublicKey) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minLeafRSABits {
+			return fmt.Errorf("RSA key too small: %d bits, need at least %d", k.N.BitLen(), minLeafRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() && k.Curve != elliptic.P384() {
+			return fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
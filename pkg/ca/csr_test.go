@@ -0,0 +1,90 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestSignCSR(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	const id = "spiffe://demo/ns/default/sa/test"
+	for _, alg := range []KeyAlgorithm{ECDSAP256, Ed25519} {
+		key, err := GenerateKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csrPEM, err := CreateLeafCSR(key, LeafRequest{SPIFFEID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM, chainPEM, serial, err := cfg.SignCSR(csrPEM, id, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		verifyLeafChain(t, dir, certPEM, chainPEM)
+		leaf, _ := ParseCertificatePEM([]byte(certPEM))
+		if !publicKeysEqual(leaf.PublicKey, key.Public()) {
+			t.Errorf("%s: leaf does not certify the CSR key", alg)
+		}
+		if leaf.IsCA || len(leaf.URIs) != 1 || leaf.URIs[0].String() != id || serial == "" {
+			t.Errorf("%s: unexpected leaf: ca=%v uris=%v serial=%q", alg, leaf.IsCA, leaf.URIs, serial)
+		}
+	}
+}
+
+func TestSignCSRRejects(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	const id = "spiffe://demo/ns/default/sa/test"
+	key, _ := GenerateKey(ECDSAP256)
+	csr := func(tmpl *x509.CertificateRequest) []byte {
+		der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	uri := func(s string) []*url.URL { u, _ := url.Parse(s); return []*url.URL{u} }
+	caExt, _ := asn1.Marshal(struct{ IsCA bool }{true})
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{URIs: uri(id)}, weak)
+	good := csr(&x509.CertificateRequest{URIs: uri(id)})
+	tampered := append([]byte{}, good...)
+	block, _ := pem.Decode(tampered)
+	block.Bytes[len(block.Bytes)-1] ^= 0xff
+	tampered = pem.EncodeToMemory(block)
+
+	tests := map[string][]byte{
+		"other ID":      csr(&x509.CertificateRequest{URIs: uri("spiffe://demo/ns/default/sa/other")}),
+		"no SAN":        csr(&x509.CertificateRequest{Subject: pkix.Name{CommonName: id}}),
+		"extra DNS SAN": csr(&x509.CertificateRequest{URIs: uri(id), DNSNames: []string{"example.com"}}),
+		"CA request": csr(&x509.CertificateRequest{URIs: uri(id), ExtraExtensions: []pkix.Extension{
+			{Id: oidBasicConstraints, Critical: true, Value: caExt},
+		}}),
+		"weak RSA key":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: weakDER}),
+		"bad signature": tampered,
+		"not a CSR":     []byte("garbage"),
+	}
+	for name, csrPEM := range tests {
+		if _, _, _, err := cfg.SignCSR(csrPEM, id, 0); !errors.Is(err, ErrInvalidCSR) {
+			t.Errorf("%s: err = %v, want ErrInvalidCSR", name, err)
+		}
+	}
+}
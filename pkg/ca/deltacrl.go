@@ -0,0 +1,197 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/models"
+)
+
+// DefaultDeltaCRLValidity is the gap between a delta CRL's ThisUpdate and
+// NextUpdate. Deltas are small, so they are republished far more often than
+// base CRLs.
+const DefaultDeltaCRLValidity = time.Hour
+
+const deltaCRLFile = "crl-delta.pem"
+
+var (
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// distributionPoint is the RFC 5280 DistributionPoint, reduced to the
+// fullName form used for Freshest CRL.
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+func (c *Config) deltaCRLValidity() time.Duration {
+	if c.DeltaCRLValidity > 0 {
+		return c.DeltaCRLValidity
+	}
+	return DefaultDeltaCRLValidity
+}
+
+func freshestCRLExtension(urls []string) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	for _, u := range urls {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(u)})
+	}
+	value, err := asn1.Marshal([]distributionPoint{{DistributionPoint: distributionPointName{FullName: names}}})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidFreshestCRL, Value: value}, nil
+}
+
+// CreateDeltaCRL returns a DER delta CRL against the base CRL numbered
+// baseNumber, listing entries revoked since that base. Its window is
+// DeltaCRLValidity and it shares the CRL number sequence with base CRLs.
+func (c *Config) CreateDeltaCRL(issuer string, baseNumber *big.Int, entries []models.RevocationEntry, now time.Time) ([]byte, error) {
+	value, err := asn1.Marshal(baseNumber)
+	if err != nil {
+		return nil, err
+	}
+	ext := pkix.Extension{Id: oidDeltaCRLIndicator, Critical: true, Value: value}
+	return c.signCRL(issuer, entries, now, c.deltaCRLValidity(), []pkix.Extension{ext})
+}
+
+// UpdateDeltaCRL regenerates BaseDir/crl-delta.pem: for each unretired
+// intermediate, a delta CRL against its CRL in crl.pem listing revocations
+// that base does not carry yet. It is cheap enough to run on every
+// revocation. If crl.pem lacks a base for some intermediate (for example
+// after a rotation) it regenerates the base CRLs instead.
+func (c *Config) UpdateDeltaCRL(now time.Time) error {
+	inters, revoked, err := c.revokedByIntermediate(now)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, crlFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return c.UpdateCRL(now)
+	}
+	if err != nil {
+		return err
+	}
+	crls, err := ParseCRLsPEM(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", crlFile, err)
+	}
+	bases := make([]*x509.RevocationList, len(inters))
+	for i, inter := range inters {
+		cert, err := c.readCert(inter.Name)
+		if err != nil {
+			return err
+		}
+		for _, crl := range crls {
+			if crl.CheckSignatureFrom(cert) == nil {
+				bases[i] = crl
+			}
+		}
+		if bases[i] == nil {
+			return c.UpdateCRL(now)
+		}
+	}
+	return c.writeDeltaCRLs(inters, bases, revoked, now)
+}
+
+// writeDeltaCRLs writes one delta per intermediate, bases[i] being the base
+// CRL of inters[i], to crl-delta.pem and as DER to <intermediate>-delta.crl.
+func (c *Config) writeDeltaCRLs(inters []IntermediateRecord, bases []*x509.RevocationList, revoked map[string][]models.RevocationEntry, now time.Time) error {
+	var out bytes.Buffer
+	for i, inter := range inters {
+		listed := map[string]bool{}
+		for _, e := range bases[i].RevokedCertificateEntries {
+			listed[fmt.Sprintf("%X", e.SerialNumber)] = true
+		}
+		var entries []models.RevocationEntry
+		for _, e := range revoked[inter.Serial] {
+			if !listed[NormalizeSerial(e.Serial)] {
+				entries = append(entries, e)
+			}
+		}
+		der, err := c.CreateDeltaCRL(inter.Name, bases[i].Number, entries, now)
+		if err != nil {
+			return fmt.Errorf("delta CRL for %s: %w", inter.Name, err)
+		}
+		pem.Encode(&out, &pem.Block{Type: "X509 CRL", Bytes: der})
+		if err := writeFileAtomic(filepath.Join(c.BaseDir, inter.Name+"-delta.crl"), der, 0644); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(c.BaseDir, deltaCRLFile), out.Bytes(), 0644)
+}
+
+// DeltaCRLBase returns the base CRL number a delta CRL applies to, and
+// false if crl is a base CRL.
+func DeltaCRLBase(crl *x509.RevocationList) (*big.Int, bool, error) {
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidDeltaCRLIndicator) {
+			n := new(big.Int)
+			if rest, err := asn1.Unmarshal(ext.Value, &n); err != nil || len(rest) != 0 {
+				return nil, false, errors.New("malformed delta CRL indicator")
+			}
+			return n, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// MergeDeltaCRL applies delta to base and returns the complete set of
+// revoked certificates. The delta must come from the same issuer, be newer
+// than base and reference a base no newer than it; entries with reason
+// removeFromCRL are taken off the list. Signatures are not checked here.
+func MergeDeltaCRL(base, delta *x509.RevocationList) ([]x509.RevocationListEntry, error) {
+	if _, isDelta, err := DeltaCRLBase(base); err != nil || isDelta {
+		return nil, errors.New("base is not a complete CRL")
+	}
+	baseNumber, isDelta, err := DeltaCRLBase(delta)
+	if err != nil {
+		return nil, err
+	}
+	if !isDelta {
+		return nil, errors.New("not a delta CRL")
+	}
+	if !bytes.Equal(base.RawIssuer, delta.RawIssuer) || !bytes.Equal(base.AuthorityKeyId, delta.AuthorityKeyId) {
+		return nil, errors.New("delta CRL is from a different issuer")
+	}
+	if base.Number.Cmp(baseNumber) < 0 {
+		return nil, fmt.Errorf("delta CRL needs base CRL %s or later, have %s", baseNumber, base.Number)
+	}
+	if delta.Number.Cmp(base.Number) <= 0 {
+		return nil, fmt.Errorf("delta CRL %s is older than base CRL %s", delta.Number, base.Number)
+	}
+	bySerial := map[string]int{}
+	var merged []x509.RevocationListEntry
+	for _, list := range [][]x509.RevocationListEntry{base.RevokedCertificateEntries, delta.RevokedCertificateEntries} {
+		for _, e := range list {
+			key := e.SerialNumber.String()
+			if i, ok := bySerial[key]; ok {
+				merged[i] = e
+				continue
+			}
+			bySerial[key] = len(merged)
+			merged = append(merged, e)
+		}
+	}
+	out := merged[:0]
+	for _, e := range merged {
+		if e.ReasonCode != 8 { // removeFromCRL
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package ca
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeltaCRL(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256,
+		DeltaCRLURL: "http://crl.example/crl/delta",
+	}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var serials []string
+	for _, svc := range []string{"a", "b", "c"} {
+		_, _, _, serial, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/"+svc, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, serial)
+	}
+	db := cfg.IssuanceDB()
+	if _, err := db.RevokeSerial(serials[0], "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RevokeSerial(serials[1], "superseded", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.UpdateDeltaCRL(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	base := readCRLs(t, filepath.Join(dir, "crl.pem"))[0]
+	delta := readCRLs(t, filepath.Join(dir, "crl-delta.pem"))[0]
+	freshest := false
+	for _, ext := range base.Extensions {
+		freshest = freshest || ext.Id.Equal(oidFreshestCRL)
+	}
+	if !freshest {
+		t.Error("base CRL lacks Freshest CRL extension")
+	}
+	if n, isDelta, err := DeltaCRLBase(delta); err != nil || !isDelta || n.Cmp(base.Number) != 0 {
+		t.Fatalf("delta CRL indicator = %v, %v, %v; base is %s", n, isDelta, err, base.Number)
+	}
+	if len(base.RevokedCertificateEntries) != 1 || len(delta.RevokedCertificateEntries) != 1 {
+		t.Fatalf("base lists %d, delta lists %d; want 1 each", len(base.RevokedCertificateEntries), len(delta.RevokedCertificateEntries))
+	}
+	if got := fmt.Sprintf("%X", delta.RevokedCertificateEntries[0].SerialNumber); got != serials[1] {
+		t.Errorf("delta lists %s, want %s", got, serials[1])
+	}
+	merged, err := MergeDeltaCRL(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 {
+		t.Errorf("merged %d entries, want 2", len(merged))
+	}
+
+	// A new base absorbs the delta's entries, and its fresh delta does not
+	// apply to the old base.
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	newBase := readCRLs(t, filepath.Join(dir, "crl.pem"))[0]
+	newDelta := readCRLs(t, filepath.Join(dir, "crl-delta.pem"))[0]
+	if len(newBase.RevokedCertificateEntries) != 2 || len(newDelta.RevokedCertificateEntries) != 0 {
+		t.Errorf("after rebase: base lists %d, delta lists %d", len(newBase.RevokedCertificateEntries), len(newDelta.RevokedCertificateEntries))
+	}
+	if _, err := MergeDeltaCRL(base, newDelta); err == nil {
+		t.Error("merged a delta against a newer base")
+	}
+	if _, err := MergeDeltaCRL(delta, newDelta); err == nil {
+		t.Error("used a delta as base")
+	}
+}
@@ -0,0 +1,96 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// IssuerPlaceholder in a leaf or delta CRL URL is replaced with the name of
+// the signing intermediate ("intermediate", "intermediate-2", ...), so each
+// generation's CRL and certificate can be published at their own URL.
+const IssuerPlaceholder = "{issuer}"
+
+// DistributionURLs are the revocation and issuer locations embedded in
+// issued certificates, so relying parties find CRLs, OCSP and missing chain
+// certificates without out-of-band configuration. The leaf URLs are
+// recorded in the online CA's ca.json; the Root* URLs in the root's, where
+// they are written into every intermediate it signs.
+type DistributionURLs struct {
+	CRL        []string `json:"crl,omitempty"`         // leaves: CRL Distribution Points
+	Issuer     []string `json:"issuer,omitempty"`      // leaves: AIA caIssuers (intermediate certificate, DER)
+	OCSP       []string `json:"ocsp,omitempty"`        // leaves: AIA OCSP responder
+	DeltaCRL   []string `json:"delta_crl,omitempty"`   // base CRLs: Freshest CRL (delta CRLs)
+	RootCRL    []string `json:"root_crl,omitempty"`    // intermediates: CRL Distribution Points
+	RootIssuer []string `json:"root_issuer,omitempty"` // intermediates: AIA caIssuers (root certificate, DER)
+}
+
+// IsZero reports whether no URL is configured.
+func (d DistributionURLs) IsZero() bool {
+	return len(d.CRL)+len(d.Issuer)+len(d.OCSP)+len(d.DeltaCRL)+len(d.RootCRL)+len(d.RootIssuer) == 0
+}
+
+// Validate checks that every URL is absolute http or https.
+func (d DistributionURLs) Validate() error {
+	for _, list := range [][]string{d.CRL, d.Issuer, d.OCSP, d.DeltaCRL, d.RootCRL, d.RootIssuer} {
+		for _, raw := range list {
+			if err := checkDistributionURL(raw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkDistributionURL(raw string) error {
+	u, err := url.Parse(strings.ReplaceAll(raw, IssuerPlaceholder, "issuer"))
+	if err != nil {
+		return fmt.Errorf("distribution URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("distribution URL %q: must be an absolute http(s) URL", raw)
+	}
+	return nil
+}
+
+// applyLeaf adds the leaf URLs for a leaf signed by issuer.
+func (d DistributionURLs) applyLeaf(template *x509.Certificate, issuer string) {
+	template.CRLDistributionPoints = expandIssuer(d.CRL, issuer)
+	template.IssuingCertificateURL = expandIssuer(d.Issuer, issuer)
+	template.OCSPServer = expandIssuer(d.OCSP, issuer)
+}
+
+// applyIntermediate adds the root URLs to an intermediate template.
+func (d DistributionURLs) applyIntermediate(template *x509.Certificate) {
+	template.CRLDistributionPoints = d.RootCRL
+	template.IssuingCertificateURL = d.RootIssuer
+}
+
+func expandIssuer(urls []string, issuer string) []string {
+	var out []string
+	for _, u := range urls {
+		out = append(out, strings.ReplaceAll(u, IssuerPlaceholder, issuer))
+	}
+	return out
+}
+
+// distribution returns c.Distribution when set, else the URLs recorded in
+// ca.json.
+func (c *Config) distribution() (DistributionURLs, error) {
+	if c.Distribution != nil {
+		return *c.Distribution, nil
+	}
+	s, err := c.LoadSettings()
+	return s.Distribution, err
+}
+
+// deltaCRLURLs returns c.DeltaCRLURL when set, else the delta CRL URLs in
+// c.Distribution or ca.json.
+func (c *Config) deltaCRLURLs() ([]string, error) {
+	if c.DeltaCRLURL != "" {
+		return []string{c.DeltaCRLURL}, nil
+	}
+	d, err := c.distribution()
+	return d.DeltaCRL, err
+}
@@ -0,0 +1,119 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/models"
+)
+
+func TestDistributionURLs(t *testing.T) {
+	dir := t.TempDir()
+	dist := &DistributionURLs{
+		CRL:        []string{"http://crl.example/crl/{issuer}"},
+		Issuer:     []string{"http://crl.example/ca/{issuer}.crt"},
+		OCSP:       []string{"http://ocsp.example"},
+		DeltaCRL:   []string{"http://crl.example/crl/{issuer}/delta"},
+		RootCRL:    []string{"http://crl.example/crl/root"},
+		RootIssuer: []string{"http://crl.example/ca/root.crt"},
+	}
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256, Distribution: dist}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// Later steps read the URLs back from ca.json.
+	cfg.Distribution = nil
+	if s, err := cfg.LoadSettings(); err != nil || !reflect.DeepEqual(s.Distribution, *dist) {
+		t.Fatalf("ca.json distribution = %+v, %v", s.Distribution, err)
+	}
+
+	inter, _ := cfg.readCert("intermediate")
+	if !reflect.DeepEqual(inter.CRLDistributionPoints, dist.RootCRL) || !reflect.DeepEqual(inter.IssuingCertificateURL, dist.RootIssuer) {
+		t.Errorf("intermediate: CDP=%v AIA=%v", inter.CRLDistributionPoints, inter.IssuingCertificateURL)
+	}
+	checkLeaf := func(issuer string) {
+		t.Helper()
+		certPEM, _, chainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verifyLeafChain(t, dir, certPEM, chainPEM)
+		leaf, _ := ParseCertificatePEM([]byte(certPEM))
+		if want := []string{"http://crl.example/crl/" + issuer}; !reflect.DeepEqual(leaf.CRLDistributionPoints, want) {
+			t.Errorf("leaf CDP = %v, want %v", leaf.CRLDistributionPoints, want)
+		}
+		if want := []string{"http://crl.example/ca/" + issuer + ".crt"}; !reflect.DeepEqual(leaf.IssuingCertificateURL, want) {
+			t.Errorf("leaf caIssuers = %v, want %v", leaf.IssuingCertificateURL, want)
+		}
+		if !reflect.DeepEqual(leaf.OCSPServer, dist.OCSP) {
+			t.Errorf("leaf OCSP = %v", leaf.OCSPServer)
+		}
+	}
+	checkLeaf("intermediate")
+	rec, err := cfg.RotateIntermediate(&cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLeaf(rec.Name)
+
+	// The per-issuer CRL and the root CRL exist at the names the URLs use,
+	// and base CRLs point at their deltas.
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	base, err := x509.ParseRevocationList(readFile(t, filepath.Join(dir, rec.Name+".crl")))
+	if err != nil {
+		t.Fatalf("%s.crl: %v", rec.Name, err)
+	}
+	deltaURL := "http://crl.example/crl/" + rec.Name + "/delta"
+	want, _ := freshestCRLExtension([]string{deltaURL})
+	found := false
+	for _, ext := range base.Extensions {
+		found = found || (ext.Id.Equal(oidFreshestCRL) && bytes.Equal(ext.Value, want.Value))
+	}
+	if !found {
+		t.Errorf("%s.crl lacks Freshest CRL %s", rec.Name, deltaURL)
+	}
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if _, err := cfg.CreateRootCRL([]models.RevocationEntry{{Serial: "0A", RevokedAt: revokedAt, Reason: "cACompromise"}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// A later run adds to the recorded revocations; repeating a serial does
+	// not change its time or reason.
+	rootCRL, err := cfg.CreateRootCRL([]models.RevocationEntry{
+		{Serial: "0a", RevokedAt: time.Now(), Reason: "superseded"},
+		{Serial: "0B", RevokedAt: time.Now(), Reason: "keyCompromise"},
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(rootCRL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := cfg.readCert("root")
+	if err := crl.CheckSignatureFrom(root); err != nil || len(crl.RevokedCertificateEntries) != 2 {
+		t.Fatalf("root CRL: %v, entries %v", err, crl.RevokedCertificateEntries)
+	}
+	if e := crl.RevokedCertificateEntries[0]; e.SerialNumber.Int64() != 10 || e.ReasonCode != 2 || !e.RevocationTime.Equal(revokedAt) {
+		t.Errorf("root CRL entry for 0A = %+v", e)
+	}
+	if crl.NextUpdate.Sub(crl.ThisUpdate) != DefaultRootCRLValidity {
+		t.Errorf("root CRL window %s", crl.NextUpdate.Sub(crl.ThisUpdate))
+	}
+}
+
+func TestDistributionURLsValidate(t *testing.T) {
+	for _, u := range []string{"ftp://crl.example/crl", "/crl", "http://", "ldap://dir.example/cn=CRL"} {
+		if err := (DistributionURLs{CRL: []string{u}}).Validate(); err == nil {
+			t.Errorf("%q accepted", u)
+		}
+	}
+	if err := (DistributionURLs{Issuer: []string{"https://pki.example/ca/{issuer}.crt"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
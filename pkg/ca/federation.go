@@ -0,0 +1,232 @@
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+const (
+	federationFile = "federation.json"
+	federatedDir   = "federated"
+)
+
+// Bundle endpoint profiles (SPIFFE Federation, section 5).
+const (
+	// ProfileHTTPSWeb endpoints are authenticated with Web PKI.
+	ProfileHTTPSWeb = "https_web"
+	// ProfileHTTPSSPIFFE endpoints present an X.509-SVID that chains to the
+	// foreign trust domain's previous bundle.
+	ProfileHTTPSSPIFFE = "https_spiffe"
+)
+
+// FederationRelationship names a foreign trust domain whose bundle the CA
+// fetches from BundleEndpointURL. EndpointSPIFFEID is the ID the
+// https_spiffe endpoint must present; it must be in TrustDomain, whose
+// bootstrap bundle is stored with StoreFederatedBundle before the first
+// fetch. Relationships are recorded in BaseDir/federation.json.
+type FederationRelationship struct {
+	TrustDomain       spiffeid.TrustDomain `json:"trust_domain"`
+	BundleEndpointURL string               `json:"bundle_endpoint_url"`
+	Profile           string               `json:"bundle_endpoint_profile"`
+	EndpointSPIFFEID  string               `json:"endpoint_spiffe_id,omitempty"`
+}
+
+type federationState struct {
+	Relationships []FederationRelationship `json:"relationships"`
+}
+
+// Validate checks the endpoint URL and profile.
+func (r FederationRelationship) Validate() error {
+	if r.TrustDomain.IsZero() {
+		return errors.New("federation: trust domain is required")
+	}
+	u, err := url.Parse(r.BundleEndpointURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("federation %s: bundle endpoint %q must be an absolute https URL", r.TrustDomain, r.BundleEndpointURL)
+	}
+	switch r.Profile {
+	case ProfileHTTPSWeb:
+		if r.EndpointSPIFFEID != "" {
+			return fmt.Errorf("federation %s: endpoint SPIFFE ID only applies to %s", r.TrustDomain, ProfileHTTPSSPIFFE)
+		}
+	case ProfileHTTPSSPIFFE:
+		id, err := spiffeid.FromString(r.EndpointSPIFFEID)
+		if err != nil {
+			return fmt.Errorf("federation %s: endpoint SPIFFE ID: %w", r.TrustDomain, err)
+		}
+		if err := id.RequireMemberOf(r.TrustDomain); err != nil {
+			return fmt.Errorf("federation %s: endpoint SPIFFE ID: %w", r.TrustDomain, err)
+		}
+	default:
+		return fmt.Errorf("federation %s: unknown bundle endpoint profile %q (want %s or %s)", r.TrustDomain, r.Profile, ProfileHTTPSWeb, ProfileHTTPSSPIFFE)
+	}
+	return nil
+}
+
+// FederationRelationships returns the relationships in federation.json,
+// ordered by trust domain. A directory without the file federates with
+// nobody.
+func (c *Config) FederationRelationships() ([]FederationRelationship, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, federationFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st federationState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", federationFile, err)
+	}
+	for _, r := range st.Relationships {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", federationFile, err)
+		}
+	}
+	return st.Relationships, nil
+}
+
+// SetFederationRelationship adds rel to federation.json, replacing any
+// relationship with the same trust domain. A bootstrap bundle, required
+// for https_spiffe, is stored as the domain's current bundle.
+func (c *Config) SetFederationRelationship(rel FederationRelationship, bootstrap *TrustBundle) error {
+	if err := rel.Validate(); err != nil {
+		return err
+	}
+	td, err := c.LoadTrustDomain()
+	if err != nil {
+		return err
+	}
+	if rel.TrustDomain == td {
+		return fmt.Errorf("federation: %s is this CA's own trust domain", td)
+	}
+	if bootstrap != nil {
+		if err := c.StoreFederatedBundle(rel.TrustDomain, bootstrap); err != nil {
+			return err
+		}
+	} else if rel.Profile == ProfileHTTPSSPIFFE {
+		if _, err := c.FederatedBundle(rel.TrustDomain); err != nil {
+			return fmt.Errorf("federation %s: %s needs a bootstrap bundle: %w", rel.TrustDomain, ProfileHTTPSSPIFFE, err)
+		}
+	}
+	recs, err := c.FederationRelationships()
+	if err != nil {
+		return err
+	}
+	out := []FederationRelationship{rel}
+	for _, r := range recs {
+		if r.TrustDomain != rel.TrustDomain {
+			out = append(out, r)
+		}
+	}
+	return c.saveFederationRelationships(out)
+}
+
+// RemoveFederationRelationship drops td from federation.json and deletes
+// its stored bundle.
+func (c *Config) RemoveFederationRelationship(td spiffeid.TrustDomain) error {
+	recs, err := c.FederationRelationships()
+	if err != nil {
+		return err
+	}
+	var out []FederationRelationship
+	for _, r := range recs {
+		if r.TrustDomain != td {
+			out = append(out, r)
+		}
+	}
+	if len(out) == len(recs) {
+		return fmt.Errorf("federation: no relationship with %s", td)
+	}
+	if err := c.saveFederationRelationships(out); err != nil {
+		return err
+	}
+	for _, ext := range []string{".json", ".pem"} {
+		if err := os.Remove(c.federatedBundlePath(td, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) saveFederationRelationships(recs []FederationRelationship) error {
+	sort.Slice(recs, func(i, j int) bool { return recs[i].TrustDomain.Name() < recs[j].TrustDomain.Name() })
+	data, err := json.MarshalIndent(federationState{Relationships: recs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.BaseDir, federationFile), data, 0644)
+}
+
+func (c *Config) federatedBundlePath(td spiffeid.TrustDomain, ext string) string {
+	return filepath.Join(c.BaseDir, federatedDir, td.Name()+ext)
+}
+
+// StoreFederatedBundle writes td's bundle to BaseDir/federated/<td>.json
+// (SPIFFE form, keeping the foreign sequence number) and <td>.pem.
+func (c *Config) StoreFederatedBundle(td spiffeid.TrustDomain, b *TrustBundle) error {
+	if len(b.X509Authorities) == 0 && len(b.JWTAuthorities) == 0 {
+		return fmt.Errorf("federation %s: bundle has no authorities", td)
+	}
+	if err := os.MkdirAll(filepath.Join(c.BaseDir, federatedDir), 0755); err != nil {
+		return err
+	}
+	data, err := b.MarshalSPIFFE()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.federatedBundlePath(td, ".pem"), b.MarshalPEM(), 0644); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.federatedBundlePath(td, ".json"), data, 0644)
+}
+
+// FederatedBundle returns the last stored bundle of td.
+func (c *Config) FederatedBundle(td spiffeid.TrustDomain) (*TrustBundle, error) {
+	data, err := os.ReadFile(c.federatedBundlePath(td, ".json"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := ParseTrustBundle(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(federatedDir, td.Name()+".json"), err)
+	}
+	return b, nil
+}
+
+// FederatedBundles returns every stored foreign bundle by trust domain
+// name. Domains whose bundle has not been fetched yet are absent.
+func (c *Config) FederatedBundles() (map[string]*TrustBundle, error) {
+	entries, err := os.ReadDir(filepath.Join(c.BaseDir, federatedDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]*TrustBundle{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bundles := map[string]*TrustBundle{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		td, err := spiffeid.TrustDomainFromString(name)
+		if err != nil {
+			continue
+		}
+		b, err := c.FederatedBundle(td)
+		if err != nil {
+			return nil, err
+		}
+		bundles[td.Name()] = b
+	}
+	return bundles, nil
+}
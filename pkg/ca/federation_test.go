@@ -0,0 +1,71 @@
+package ca
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+func TestFederationRelationships(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	own, err := cfg.TrustBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := spiffeid.TrustDomainFromString("other.org")
+	demo, _ := spiffeid.TrustDomainFromString("demo")
+
+	web := FederationRelationship{TrustDomain: other, BundleEndpointURL: "https://bundle.other.org/", Profile: ProfileHTTPSWeb}
+	spiffe := FederationRelationship{TrustDomain: other, BundleEndpointURL: "https://bundle.other.org/", Profile: ProfileHTTPSSPIFFE, EndpointSPIFFEID: "spiffe://other.org/bundle-endpoint"}
+	for name, rel := range map[string]FederationRelationship{
+		"http url":         {TrustDomain: other, BundleEndpointURL: "http://bundle.other.org/", Profile: ProfileHTTPSWeb},
+		"unknown profile":  {TrustDomain: other, BundleEndpointURL: web.BundleEndpointURL, Profile: "https"},
+		"endpoint id":      {TrustDomain: other, BundleEndpointURL: web.BundleEndpointURL, Profile: ProfileHTTPSSPIFFE},
+		"foreign endpoint": {TrustDomain: other, BundleEndpointURL: web.BundleEndpointURL, Profile: ProfileHTTPSSPIFFE, EndpointSPIFFEID: "spiffe://third.org/be"},
+		"own domain":       {TrustDomain: demo, BundleEndpointURL: web.BundleEndpointURL, Profile: ProfileHTTPSWeb},
+	} {
+		if err := cfg.SetFederationRelationship(rel, nil); err == nil {
+			t.Errorf("%s: accepted %+v", name, rel)
+		}
+	}
+	if err := cfg.SetFederationRelationship(spiffe, nil); err == nil {
+		t.Error("https_spiffe without a bootstrap bundle accepted")
+	}
+
+	if err := cfg.SetFederationRelationship(web, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.SetFederationRelationship(spiffe, own); err != nil {
+		t.Fatal(err)
+	}
+	rels, err := cfg.FederationRelationships()
+	if err != nil || len(rels) != 1 || rels[0] != spiffe {
+		t.Fatalf("relationships = %+v, %v", rels, err)
+	}
+	bundles, err := cfg.FederatedBundles()
+	if err != nil || len(bundles) != 1 || !bundles["other.org"].sameAuthorities(own) {
+		t.Fatalf("bundles = %v, %v", bundles, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "federated", "other.org.pem")); err != nil {
+		t.Error(err)
+	}
+
+	if err := cfg.RemoveFederationRelationship(other); err != nil {
+		t.Fatal(err)
+	}
+	if rels, _ := cfg.FederationRelationships(); len(rels) != 0 {
+		t.Errorf("after remove: %+v", rels)
+	}
+	if bundles, _ := cfg.FederatedBundles(); len(bundles) != 0 {
+		t.Errorf("bundle kept after remove: %v", bundles)
+	}
+	if err := cfg.RemoveFederationRelationship(other); err == nil {
+		t.Error("removed a relationship twice")
+	}
+}
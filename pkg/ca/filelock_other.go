@@ -0,0 +1,9 @@
+//go:build !unix
+
+package ca
+
+// lockFile is a no-op where flock is unavailable; only the in-process
+// mutexes serialize access there.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,115 @@
+//go:build unix
+
+package ca
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The lock is taken on a separate open file, as another process would, so
+// it conflicts with nextCRLNumber even within this process.
+func TestCRLNumberLock(t *testing.T) {
+	cfg := Config{BaseDir: t.TempDir()}
+	unlock, err := lockFile(filepath.Join(cfg.BaseDir, crlNumberLockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		n, err := cfg.nextCRLNumber()
+		if err == nil && n.Int64() != 1 {
+			t.Errorf("CRL number %s, want 1", n)
+		}
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("nextCRLNumber did not wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nextCRLNumber still blocked after the lock was released")
+	}
+}
+
+// A second process holding translog.lock keeps append from writing either
+// the entry or the tree head until it lets go.
+func TestTransparencyLogLock(t *testing.T) {
+	cfg := Config{BaseDir: t.TempDir()}
+	log := cfg.TransparencyLog()
+	if err := log.init(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockFile(filepath.Join(cfg.BaseDir, translogLockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- log.append(&x509.Certificate{Raw: []byte("leaf")}, time.Now())
+	}()
+	select {
+	case <-done:
+		t.Fatal("append did not wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if head, err := log.TreeHead(); err != nil || head.TreeSize != 0 {
+		t.Errorf("tree head while locked: size %d, %v", head.TreeSize, err)
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("append still blocked after the lock was released")
+	}
+	if head, err := log.TreeHead(); err != nil || head.TreeSize != 1 {
+		t.Errorf("tree head after append: size %d, %v", head.TreeSize, err)
+	}
+}
+
+// Another process holding issued.lock keeps Record from appending, so a
+// concurrent revocation's read-modify-append sees a stable file.
+func TestIssuanceDBLock(t *testing.T) {
+	cfg := Config{BaseDir: t.TempDir()}
+	db := cfg.IssuanceDB()
+	unlock, err := lockFile(filepath.Join(cfg.BaseDir, issuanceLockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Record(IssuedRecord{Serial: "1", SPIFFEID: "spiffe://example.org/a", Status: StatusValid})
+	}()
+	select {
+	case <-done:
+		t.Fatal("Record did not wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, ok, err := db.Get("1"); err != nil || ok {
+		t.Errorf("record visible while locked: %v, %v", ok, err)
+	}
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record still blocked after the lock was released")
+	}
+	if _, ok, err := db.Get("1"); err != nil || !ok {
+		t.Errorf("record after append: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,27 @@
+package ca
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// FileStamp identifies a version of a file, so that pollers such as the
+// Issuer and the OCSP responder notice when it changes. The zero value is
+// a missing file.
+type FileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// StatFile returns the FileStamp of path.
+func StatFile(path string) (FileStamp, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileStamp{}, nil
+	}
+	if err != nil {
+		return FileStamp{}, err
+	}
+	return FileStamp{fi.ModTime(), fi.Size()}, nil
+}
@@ -0,0 +1,252 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// issuerChainFile holds the CA certificates between the intermediates and
+// root.crt, issuer first, when an imported intermediate was not issued
+// directly by the root.
+const issuerChainFile = "issuer-chain.pem"
+
+// ImportIntermediate sets up BaseDir to issue under an intermediate CA of an
+// existing PKI, such as an enterprise root, instead of the root Init
+// creates. certPEM holds the intermediate certificate, followed by the
+// issuing CAs between it and the root if there are any, and rootPEM the
+// self-signed root the chain reaches; the root's key stays with its owner.
+// key is the intermediate's private key, which is handed to the key store
+// (see KeyImporter); when key is nil the key store must already hold it as
+// "intermediate", as on a PKCS#11 token.
+//
+// Nothing is written unless the intermediate is a CA that chains to the
+// root, the chain is valid now, the key matches and the intermediate's
+// name constraints admit the trust domain (c.TrustDomain or ca.json). The
+// result is the layout InstallIntermediate leaves behind, with the external
+// root and issuing CAs in the trust bundle; the issuing CAs are kept in
+// issuer-chain.pem and follow the intermediate in leaf chains.
+func (c *Config) ImportIntermediate(key crypto.Signer, certPEM, rootPEM []byte) error {
+	certs, err := ParseCertificatesPEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("intermediate certificate: %w", err)
+	}
+	if len(certs) == 0 {
+		return errors.New("intermediate certificate: no CERTIFICATE PEM block found")
+	}
+	inter := certs[0]
+	root, err := ParseCertificatePEM(rootPEM)
+	if err != nil {
+		return fmt.Errorf("root certificate: %w", err)
+	}
+	chain, err := checkImportedChain(inter, certs[1:], root, time.Now())
+	if err != nil {
+		return err
+	}
+	if key != nil && !publicKeysEqual(key.Public(), inter.PublicKey) {
+		return errors.New("intermediate certificate does not match the private key")
+	}
+	td, err := c.LoadTrustDomain()
+	if err != nil {
+		return err
+	}
+	probe, err := spiffeid.FromPath(td, "/import-check")
+	if err != nil {
+		return err
+	}
+	if err := checkNameConstraints(inter, &x509.Certificate{URIs: []*url.URL{probe.URL()}}); err != nil {
+		return fmt.Errorf("intermediate cannot issue for trust domain %s: %w", td, err)
+	}
+	if c.Logf != nil && !allowsMTLS(inter) {
+		c.Logf("intermediate %q does not allow both serverAuth and clientAuth; mTLS leaves issued under it will fail verification", inter.Subject.CommonName)
+	}
+
+	for _, name := range []string{"root.crt", "intermediate.crt"} {
+		if _, err := os.Stat(filepath.Join(c.BaseDir, name)); err == nil {
+			return fmt.Errorf("%s already contains %s; refusing to overwrite the CA", c.BaseDir, name)
+		}
+	}
+	restore, err := snapshotDir(c.BaseDir)
+	if err != nil {
+		return err
+	}
+	if err := c.installImported(key, inter, root, chain); err != nil {
+		if rerr := restore(); rerr != nil {
+			return fmt.Errorf("%w (restoring %s: %v)", err, c.BaseDir, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// installImported writes the checked import to BaseDir. The key is handed
+// to the key store last, so a failure before leaves no key behind, and
+// ImportIntermediate undoes the files written.
+func (c *Config) installImported(key crypto.Signer, inter, root *x509.Certificate, chain []*x509.Certificate) error {
+	if err := os.MkdirAll(c.BaseDir, 0700); err != nil {
+		return err
+	}
+	if err := c.recordSettings(); err != nil {
+		return err
+	}
+	if err := c.installIntermediate(inter, root, chain, key); err != nil {
+		return err
+	}
+	if key != nil {
+		if err := importKey(c.keyStore(), "intermediate", key); err != nil {
+			return fmt.Errorf("intermediate key: %w", err)
+		}
+	}
+	return nil
+}
+
+// snapshotDir records the top-level files of dir and returns a function
+// that puts dir back: entries created since are removed and recorded files
+// rewritten. If dir does not exist, restoring removes it.
+func snapshotDir(dir string) (func() error, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return func() error { return os.RemoveAll(dir) }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	type saved struct {
+		data []byte
+		mode fs.FileMode
+	}
+	files := map[string]*saved{}
+	for _, e := range entries {
+		files[e.Name()] = nil
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[e.Name()] = &saved{data, info.Mode().Perm()}
+	}
+	return func() error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if _, ok := files[e.Name()]; !ok {
+				if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		for name, f := range files {
+			if f == nil {
+				continue
+			}
+			if err := writeFileAtomic(filepath.Join(dir, name), f.data, f.mode); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// checkImportedChain verifies that inter is a CA certificate that chains to
+// the self-signed root at now, through issuing CAs taken from pool if it
+// was not issued by the root itself. It returns those issuing CAs, issuer
+// first.
+func checkImportedChain(inter *x509.Certificate, pool []*x509.Certificate, root *x509.Certificate, now time.Time) ([]*x509.Certificate, error) {
+	if !root.IsCA || root.CheckSignatureFrom(root) != nil {
+		return nil, fmt.Errorf("root %q is not a self-signed CA certificate", root.Subject.CommonName)
+	}
+	if !inter.IsCA {
+		return nil, fmt.Errorf("%q is not a CA certificate", inter.Subject.CommonName)
+	}
+	if inter.KeyUsage != 0 && inter.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("intermediate %q may not sign certificates (no keyCertSign key usage)", inter.Subject.CommonName)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	for _, cert := range pool {
+		intermediates.AddCert(cert)
+	}
+	chains, err := inter.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return nil, fmt.Errorf("intermediate %q, issued by %q, does not chain to root %q: %w (include the issuing CAs after the intermediate)",
+			inter.Subject.CommonName, inter.Issuer.CommonName, root.Subject.CommonName, err)
+	}
+	// chains[0] runs from inter to root; keep what lies between.
+	return chains[0][1 : len(chains[0])-1], nil
+}
+
+// issuerChain returns the issuing CAs of issuer-chain.pem, or nil when the
+// intermediates are issued by the root.
+func (c *Config) issuerChain() ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, issuerChainFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ParseCertificatesPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", issuerChainFile, err)
+	}
+	return chain, nil
+}
+
+// issuerChainPEM returns the issuing CAs that must follow inter in leaf
+// chains, if inter was issued under one.
+func (c *Config) issuerChainPEM(inter *x509.Certificate) ([]byte, error) {
+	chain, err := c.issuerChain()
+	if err != nil || len(chain) == 0 || inter.CheckSignatureFrom(chain[0]) != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, cert := range chain {
+		data = append(data, encodeCertPEM(cert)...)
+	}
+	return data, nil
+}
+
+// allowsMTLS reports whether leaves of inter may be used for both ends of
+// an mTLS connection.
+func allowsMTLS(inter *x509.Certificate) bool {
+	if len(inter.ExtKeyUsage) == 0 {
+		return true
+	}
+	var server, client bool
+	for _, eku := range inter.ExtKeyUsage {
+		switch eku {
+		case x509.ExtKeyUsageAny:
+			return true
+		case x509.ExtKeyUsageServerAuth:
+			server = true
+		case x509.ExtKeyUsageClientAuth:
+			client = true
+		}
+	}
+	return server && client
+}
+
+func importKey(ks KeyStore, name string, key crypto.Signer) error {
+	ki, ok := ks.(KeyImporter)
+	if !ok {
+		return ErrKeyImportUnsupported
+	}
+	return ki.ImportKey(name, key)
+}
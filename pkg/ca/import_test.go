@@ -0,0 +1,190 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+func TestImportIntermediate(t *testing.T) {
+	// An enterprise PKI this CA has not created.
+	newCA := func(cn string, parent *x509.Certificate, parentKey crypto.Signer, permitURI ...string) (*x509.Certificate, crypto.Signer) {
+		t.Helper()
+		key, err := GenerateKey(ECDSAP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{Organization: []string{"Acme"}, CommonName: cn},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(2 * 365 * 24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			PermittedURIDomains:   permitURI,
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	root, rootKey := newCA("Acme Root", nil, nil)
+	inter, interKey := newCA("Acme SPIFFE Issuing CA", root, rootKey)
+	otherRoot, _ := newCA("Other Root", nil, nil)
+	policyCA, policyKey := newCA("Acme Policy CA", root, rootKey)
+	deep, deepKey := newCA("Acme Deep Issuing CA", policyCA, policyKey)
+	constrained, constrainedKey := newCA("Acme Web CA", root, rootKey, "other.example")
+
+	refused := []struct {
+		name string
+		key  crypto.Signer
+		cert *x509.Certificate
+		root *x509.Certificate
+	}{
+		{"wrong root", interKey, inter, otherRoot},
+		{"key mismatch", rootKey, inter, root},
+		{"issuing CA missing from the chain", deepKey, deep, root},
+		{"root is not self-signed", interKey, inter, inter},
+		{"name constraints exclude the trust domain", constrainedKey, constrained, root},
+	}
+	for _, tc := range refused {
+		dir := filepath.Join(t.TempDir(), "ca")
+		cfg := Config{BaseDir: dir}
+		if err := cfg.ImportIntermediate(tc.key, encodeCertPEM(tc.cert), encodeCertPEM(tc.root)); err == nil {
+			t.Errorf("%s: imported", tc.name)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s: wrote %s", tc.name, dir)
+		}
+	}
+
+	// A failed import leaves the directory as it was and can be retried.
+	td, err := spiffeid.TrustDomainFromString("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, existing := range []bool{false, true} {
+		dir := filepath.Join(t.TempDir(), "ca")
+		if existing {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cfg := Config{BaseDir: dir, TrustDomain: td, KeyStore: noImportKeyStore{&FileKeyStore{Dir: dir}}}
+		if err := cfg.ImportIntermediate(interKey, encodeCertPEM(inter), encodeCertPEM(root)); !errors.Is(err, ErrKeyImportUnsupported) {
+			t.Fatalf("import into a key store without import: %v", err)
+		}
+		entries, _ := os.ReadDir(dir)
+		if existing && (len(entries) != 1 || entries[0].Name() != "notes.txt") {
+			t.Errorf("failed import left %d entries in an existing directory", len(entries))
+		} else if _, err := os.Stat(dir); !existing && !os.IsNotExist(err) {
+			t.Errorf("failed import left %s behind", dir)
+		}
+		cfg.KeyStore = nil
+		if err := cfg.ImportIntermediate(interKey, encodeCertPEM(inter), encodeCertPEM(root)); err != nil {
+			t.Errorf("retry after a failed import: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	pass := func() ([]byte, error) { return []byte("import test passphrase"), nil }
+	cfg := Config{BaseDir: dir, Passphrase: pass, LeafKeyAlgorithm: ECDSAP256}
+	chainPEM := append(encodeCertPEM(inter), encodeCertPEM(root)...)
+	if err := cfg.ImportIntermediate(interKey, chainPEM, encodeCertPEM(root)); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "intermediate.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedKeyPEM(keyPEM) {
+		t.Error("imported key written unencrypted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "root.key")); !os.IsNotExist(err) {
+		t.Error("root.key present after import")
+	}
+	bundle, err := cfg.TrustBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.X509Authorities) == 0 || !bundle.X509Authorities[0].Equal(root) {
+		t.Error("trust bundle does not start with the enterprise root")
+	}
+	if err := cfg.VerifyState(time.Now()); err != nil {
+		t.Error(err)
+	}
+	certPEM, _, leafChainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/imported", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyLeafChain(t, dir, certPEM, leafChainPEM)
+	leaf, err := ParseCertificatePEM([]byte(certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(inter); err != nil {
+		t.Errorf("leaf not signed by the imported intermediate: %v", err)
+	}
+
+	if err := cfg.ImportIntermediate(interKey, encodeCertPEM(inter), encodeCertPEM(root)); err == nil {
+		t.Error("imported over an existing CA")
+	}
+
+	// root -> policy CA -> deep: the policy CA is kept and served.
+	deepDir := t.TempDir()
+	deepCfg := Config{BaseDir: deepDir, LeafKeyAlgorithm: ECDSAP256}
+	deepChainPEM := append(encodeCertPEM(deep), encodeCertPEM(policyCA)...)
+	if err := deepCfg.ImportIntermediate(deepKey, deepChainPEM, encodeCertPEM(root)); err != nil {
+		t.Fatal(err)
+	}
+	if err := deepCfg.VerifyState(time.Now()); err != nil {
+		t.Error(err)
+	}
+	certPEM, _, leafChainPEM, _, err = deepCfg.IssueLeaf("spiffe://demo/ns/default/sa/deep", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyLeafChain(t, deepDir, certPEM, leafChainPEM)
+	served, err := ParseCertificatesPEM([]byte(leafChainPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 3 || !served[1].Equal(deep) || !served[2].Equal(policyCA) {
+		t.Errorf("leaf chain has %d certificates, want leaf, intermediate and policy CA", len(served))
+	}
+	bundle, err = deepCfg.TrustBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inBundle bool
+	for _, cert := range bundle.X509Authorities {
+		inBundle = inBundle || cert.Equal(policyCA)
+	}
+	if !inBundle {
+		t.Error("trust bundle lacks the issuing CA")
+	}
+}
+
+// noImportKeyStore hides the KeyImporter of the key store it wraps.
+type noImportKeyStore struct{ KeyStore }
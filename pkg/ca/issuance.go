@@ -0,0 +1,345 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	issuanceFile     = "issued.jsonl"
+	issuanceLockFile = "issued.lock"
+)
+
+// ErrUnknownSerial is returned when a serial is not in the issuance database.
+var ErrUnknownSerial = errors.New("serial not found in issuance database")
+
+// CertStatus is the state of an issued certificate. Only StatusValid and
+// StatusRevoked are stored; StatusExpired is derived from NotAfter.
+type CertStatus string
+
+const (
+	StatusValid   CertStatus = "valid"
+	StatusRevoked CertStatus = "revoked"
+	StatusExpired CertStatus = "expired"
+)
+
+// IssuedRecord is the issuance database entry for one leaf certificate.
+type IssuedRecord struct {
+	Serial           string     `json:"serial"`
+	SPIFFEID         string     `json:"spiffe_id"`
+	Profile          string     `json:"profile,omitempty"`
+	NotBefore        time.Time  `json:"not_before"`
+	NotAfter         time.Time  `json:"not_after"`
+	KeySHA256        string     `json:"key_sha256"` // hex SHA-256 of the SubjectPublicKeyInfo
+	IssuerSerial     string     `json:"issuer_serial"`
+	Status           CertStatus `json:"status"`
+	RevokedAt        time.Time  `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+}
+
+// StatusAt returns the record's status at t, reporting unrevoked
+// certificates past NotAfter as expired.
+func (r IssuedRecord) StatusAt(t time.Time) CertStatus {
+	if r.Status == StatusValid && t.After(r.NotAfter) {
+		return StatusExpired
+	}
+	return r.Status
+}
+
+// IssuedFilter selects records in IssuanceDB.List. Zero fields match
+// everything; Status is evaluated at At (default now).
+type IssuedFilter struct {
+	SPIFFEID     string
+	IssuerSerial string
+	Status       CertStatus
+	At           time.Time
+}
+
+func (f IssuedFilter) match(r IssuedRecord) bool {
+	at := f.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return (f.SPIFFEID == "" || r.SPIFFEID == f.SPIFFEID) &&
+		(f.IssuerSerial == "" || r.IssuerSerial == f.IssuerSerial) &&
+		(f.Status == "" || r.StatusAt(at) == f.Status)
+}
+
+// IssuanceDB is the durable record of every leaf the CA has signed. It is an
+// append-only JSON Lines file: each line is a full record and the last line
+// for a serial wins, so a revocation is a new line rather than a rewrite and
+// a crash can at worst truncate the final line.
+type IssuanceDB struct {
+	Path string
+}
+
+// issuanceMu serializes writers within a process and issued.lock across
+// processes, so a revocation's read-modify-append cannot race an issuance
+// and dropTornLine never truncates a line another writer is appending.
+var issuanceMu sync.Mutex
+
+// lock takes issuanceMu and issued.lock, and returns the function that
+// releases both.
+func (db *IssuanceDB) lock() (func(), error) {
+	issuanceMu.Lock()
+	unlock, err := lockFile(filepath.Join(filepath.Dir(db.Path), issuanceLockFile))
+	if err != nil {
+		issuanceMu.Unlock()
+		return nil, fmt.Errorf("lock %s: %w", filepath.Base(db.Path), err)
+	}
+	return func() {
+		unlock()
+		issuanceMu.Unlock()
+	}, nil
+}
+
+// IssuanceDB returns the issuance database in BaseDir.
+func (c *Config) IssuanceDB() *IssuanceDB {
+	return &IssuanceDB{Path: filepath.Join(c.BaseDir, issuanceFile)}
+}
+
+// NormalizeSerial converts a hex serial such as "01:ab:cd" to the upper-case,
+// colon-free form the CA records ("1ABCD").
+func NormalizeSerial(serial string) string {
+	s := strings.TrimLeft(strings.ToUpper(strings.ReplaceAll(serial, ":", "")), "0")
+	if s == "" && serial != "" {
+		return "0"
+	}
+	return s
+}
+
+func newIssuedRecord(cert *x509.Certificate, spiffeID, profile string, issuer *x509.Certificate) IssuedRecord {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return IssuedRecord{
+		Serial:       fmt.Sprintf("%X", cert.SerialNumber),
+		SPIFFEID:     spiffeID,
+		Profile:      profile,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		KeySHA256:    hex.EncodeToString(sum[:]),
+		IssuerSerial: fmt.Sprintf("%X", issuer.SerialNumber),
+		Status:       StatusValid,
+	}
+}
+
+// Record appends rec.
+func (db *IssuanceDB) Record(rec IssuedRecord) error {
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return db.append(rec)
+}
+
+func (db *IssuanceDB) append(recs ...IssuedRecord) error {
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return appendLines(db.Path, buf.Bytes())
+}
+
+// appendLines appends complete lines to the JSON Lines file at path in one
+// O_APPEND write and syncs it, first dropping a torn final line.
+func appendLines(path string, lines []byte) error {
+	if err := dropTornLine(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(lines); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dropTornLine truncates a partial final line left by an interrupted append,
+// so the next record starts on a line of its own. Only the last byte is
+// read unless the line is torn.
+func dropTornLine(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, int64(bytes.LastIndexByte(data, '\n')+1))
+}
+
+// fileSize returns the size of the file at path, 0 if it does not exist.
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// load returns the latest record for every serial, in first-issued order.
+func (db *IssuanceDB) load() ([]IssuedRecord, error) {
+	data, err := os.ReadFile(db.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	// The segment after the final newline is empty, or a torn line from an
+	// interrupted append; either way it is not a record.
+	lines = lines[:len(lines)-1]
+	index := map[string]int{}
+	var recs []IssuedRecord
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec IssuedRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filepath.Base(db.Path), i+1, err)
+		}
+		if j, ok := index[rec.Serial]; ok {
+			recs[j] = rec
+			continue
+		}
+		index[rec.Serial] = len(recs)
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// Get returns the record for serial (hex, any case, colons allowed).
+func (db *IssuanceDB) Get(serial string) (IssuedRecord, bool, error) {
+	serial = NormalizeSerial(serial)
+	recs, err := db.load()
+	if err != nil {
+		return IssuedRecord{}, false, err
+	}
+	for _, r := range recs {
+		if r.Serial == serial {
+			return r, true, nil
+		}
+	}
+	return IssuedRecord{}, false, nil
+}
+
+// List returns the records matching f, ordered by NotBefore.
+func (db *IssuanceDB) List(f IssuedFilter) ([]IssuedRecord, error) {
+	recs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	var out []IssuedRecord
+	for _, r := range recs {
+		if f.match(r) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].NotBefore.Before(out[j].NotBefore) })
+	return out, nil
+}
+
+// Revoke marks every matching valid, unexpired certificate as revoked at
+// revokedAt and returns the records it changed. f must name a SPIFFE ID or
+// issuer; reason is an RFC 5280 reason name (see ParseRevocationReason).
+func (db *IssuanceDB) Revoke(f IssuedFilter, reason string, revokedAt time.Time) ([]IssuedRecord, error) {
+	if f.SPIFFEID == "" && f.IssuerSerial == "" {
+		return nil, errors.New("refusing to revoke every certificate: filter by SPIFFE ID or issuer")
+	}
+	_, reason, err := ParseRevocationReason(reason)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := db.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	f.Status, f.At = StatusValid, revokedAt
+	recs, err := db.List(f)
+	if err != nil {
+		return nil, err
+	}
+	for i := range recs {
+		recs[i].Status = StatusRevoked
+		recs[i].RevokedAt = revokedAt
+		recs[i].RevocationReason = reason
+	}
+	if len(recs) == 0 {
+		return nil, nil
+	}
+	return recs, db.append(recs...)
+}
+
+// RevokeSerial revokes one certificate. It fails if serial was never issued
+// and is a no-op if it is already revoked.
+func (db *IssuanceDB) RevokeSerial(serial, reason string, revokedAt time.Time) (IssuedRecord, error) {
+	_, reason, err := ParseRevocationReason(reason)
+	if err != nil {
+		return IssuedRecord{}, err
+	}
+	unlock, err := db.lock()
+	if err != nil {
+		return IssuedRecord{}, err
+	}
+	defer unlock()
+	rec, ok, err := db.Get(serial)
+	if err != nil {
+		return IssuedRecord{}, err
+	}
+	if !ok {
+		return IssuedRecord{}, fmt.Errorf("%w: %s", ErrUnknownSerial, serial)
+	}
+	if rec.Status == StatusRevoked {
+		return rec, nil
+	}
+	rec.Status = StatusRevoked
+	rec.RevokedAt = revokedAt
+	rec.RevocationReason = reason
+	return rec, db.append(rec)
+}
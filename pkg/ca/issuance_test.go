@@ -0,0 +1,95 @@
+package ca
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIssuanceDB(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	const a, b = "spiffe://demo/ns/default/sa/a", "spiffe://demo/ns/default/sa/b"
+	var serials []string
+	for _, id := range []string{a, a, b} {
+		certPEM, _, _, serial, err := cfg.IssueLeaf(id, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, serial)
+		leaf, _ := ParseCertificatePEM([]byte(certPEM))
+		rec, ok, err := cfg.IssuanceDB().Get(serial)
+		if err != nil || !ok {
+			t.Fatalf("Get(%s) = %v, %v", serial, ok, err)
+		}
+		if rec.SPIFFEID != id || rec.Profile != DefaultProfile || !rec.NotAfter.Equal(leaf.NotAfter) || rec.Status != StatusValid || len(rec.KeySHA256) != 64 {
+			t.Errorf("record for %s: %+v", serial, rec)
+		}
+	}
+
+	// Reopening the database (a restarted process) sees the same records.
+	db := &IssuanceDB{Path: filepath.Join(dir, "issued.jsonl")}
+	recs, err := db.List(IssuedFilter{SPIFFEID: a})
+	if err != nil || len(recs) != 2 {
+		t.Fatalf("List(a) = %d records, %v", len(recs), err)
+	}
+
+	revoked, err := db.Revoke(IssuedFilter{SPIFFEID: a}, "keyCompromise", time.Now())
+	if err != nil || len(revoked) != 2 {
+		t.Fatalf("Revoke(a) = %d records, %v", len(revoked), err)
+	}
+	if again, _ := db.Revoke(IssuedFilter{SPIFFEID: a}, "keyCompromise", time.Now()); len(again) != 0 {
+		t.Errorf("second revoke changed %d records", len(again))
+	}
+	if _, err := db.Revoke(IssuedFilter{}, "unspecified", time.Now()); err == nil {
+		t.Error("revoked with an empty filter")
+	}
+	if _, err := db.RevokeSerial("ABCDEF", "unspecified", time.Now()); !errors.Is(err, ErrUnknownSerial) {
+		t.Errorf("unknown serial: %v", err)
+	}
+	valid, _ := db.List(IssuedFilter{Status: StatusValid})
+	if len(valid) != 1 || valid[0].Serial != serials[2] {
+		t.Errorf("valid after revoking a: %+v", valid)
+	}
+	rec, err := db.RevokeSerial(serials[2], "superseded", time.Now())
+	if err != nil || rec.Status != StatusRevoked || rec.RevocationReason != "superseded" {
+		t.Errorf("RevokeSerial = %+v, %v", rec, err)
+	}
+	if recs, _ := db.List(IssuedFilter{}); len(recs) != 3 {
+		t.Errorf("List() = %d records after revocations, want 3", len(recs))
+	}
+	if rec.StatusAt(rec.NotAfter.Add(time.Second)) != StatusRevoked {
+		t.Error("revoked certificate reported expired")
+	}
+
+	// A torn final line from an interrupted append is ignored, and the next
+	// append replaces it.
+	f, err := os.OpenFile(db.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"serial":"DEAD","spiffe_id":`)
+	f.Close()
+	if recs, err := db.List(IssuedFilter{}); err != nil || len(recs) != 3 {
+		t.Fatalf("List() with torn line = %d records, %v", len(recs), err)
+	}
+	if _, _, _, _, err := cfg.IssueLeaf(b, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if recs, err := db.List(IssuedFilter{SPIFFEID: b}); err != nil || len(recs) != 2 {
+		t.Errorf("List(b) after repairing torn line = %d records, %v", len(recs), err)
+	}
+}
+
+func TestNormalizeSerial(t *testing.T) {
+	for in, want := range map[string]string{"0a:bc:de": "ABCDE", "ABCDE": "ABCDE", "00": "0", "": ""} {
+		if got := NormalizeSerial(in); got != want {
+			t.Errorf("NormalizeSerial(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ca
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Issuer issues leaves for CA as its IssueLeafRequest and SignCSRRequest
+// do, but keeps the signing intermediate, its key and the transparency log
+// in memory between calls instead of reading and decrypting them for every
+// certificate. The intermediate is loaded again when intermediates.json or
+// its certificate changes on disk, so rotations and `ztca intermediate
+// install` take effect without a restart.
+//
+// With KeyPoolSize > 0, Run keeps that many leaf keys generated ahead of
+// time for IssueLeafRequest; an empty pool falls back to generating the key
+// in the call. An Issuer is safe for concurrent use.
+type Issuer struct {
+	CA          *Config
+	KeyPoolSize int
+
+	once sync.Once
+	keys chan crypto.Signer
+	tlog *TransparencyLog
+
+	mu     sync.Mutex
+	recs   []IntermediateRecord
+	stamp  FileStamp // of intermediates.json when recs was read
+	loaded *loadedIntermediate
+}
+
+// loadedIntermediate is an intermediate as signingIntermediate returns it.
+type loadedIntermediate struct {
+	name    string
+	stamp   FileStamp // of <name>.crt when loaded
+	key     crypto.Signer
+	cert    *x509.Certificate
+	certPEM []byte
+}
+
+func (is *Issuer) init() {
+	is.once.Do(func() {
+		is.keys = make(chan crypto.Signer, max(is.KeyPoolSize, 0))
+		is.tlog = is.CA.TransparencyLog()
+	})
+}
+
+// IssueLeafRequest is Config.IssueLeafRequest, taking the leaf key from the
+// pool when one is ready.
+func (is *Issuer) IssueLeafRequest(req LeafRequest) (certPEM, keyPEM, chainPEM string, serial string, err error) {
+	is.init()
+	var key crypto.Signer
+	select {
+	case key = <-is.keys:
+	default:
+		if key, err = GenerateKey(is.CA.LeafKeyAlgorithm); err != nil {
+			return "", "", "", "", err
+		}
+	}
+	certPEM, chainPEM, serial, err = is.CA.signLeafWith(key.Public(), req, is.signingIntermediate, is.tlog)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	keyBytes, err := MarshalPrivateKeyPEM(key)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	return certPEM, string(keyBytes), chainPEM, serial, nil
+}
+
+// SignCSRRequest is Config.SignCSRRequest.
+func (is *Issuer) SignCSRRequest(csrPEM []byte, req LeafRequest) (certPEM, chainPEM, serial string, err error) {
+	is.init()
+	pub, req, err := is.CA.checkCSRRequest(csrPEM, req)
+	if err != nil {
+		return "", "", "", err
+	}
+	return is.CA.signLeafWith(pub, req, is.signingIntermediate, is.tlog)
+}
+
+// Run fills the key pool until ctx is done. It returns at once when
+// KeyPoolSize is not positive.
+func (is *Issuer) Run(ctx context.Context) {
+	is.init()
+	if is.KeyPoolSize <= 0 {
+		return
+	}
+	for {
+		key, err := GenerateKey(is.CA.LeafKeyAlgorithm)
+		if err != nil {
+			if is.CA.Logf != nil {
+				is.CA.Logf("leaf key pool: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case is.keys <- key:
+		}
+	}
+}
+
+// signingIntermediate is Config.signingIntermediate from the cache, which
+// is refreshed when intermediates.json or the active certificate changed.
+func (is *Issuer) signingIntermediate(now time.Time) (IntermediateRecord, crypto.Signer, *x509.Certificate, []byte, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	stamp, err := StatFile(filepath.Join(is.CA.BaseDir, intermediatesFile))
+	if err != nil {
+		return IntermediateRecord{}, nil, nil, nil, err
+	}
+	if is.recs == nil || stamp != is.stamp {
+		recs, err := is.CA.Intermediates()
+		if err != nil {
+			return IntermediateRecord{}, nil, nil, nil, err
+		}
+		is.recs, is.stamp = recs, stamp
+	}
+	rec, err := activeIntermediate(is.recs, now)
+	if err != nil {
+		return IntermediateRecord{}, nil, nil, nil, err
+	}
+	certStamp, err := StatFile(filepath.Join(is.CA.BaseDir, rec.Name+".crt"))
+	if err != nil {
+		return IntermediateRecord{}, nil, nil, nil, err
+	}
+	if l := is.loaded; l == nil || l.name != rec.Name || l.stamp != certStamp {
+		key, cert, certPEM, err := is.CA.loadIntermediate(rec.Name)
+		if err != nil {
+			return IntermediateRecord{}, nil, nil, nil, err
+		}
+		is.loaded = &loadedIntermediate{name: rec.Name, stamp: certStamp, key: key, cert: cert, certPEM: certPEM}
+	}
+	l := is.loaded
+	return rec, l.key, l.cert, l.certPEM, nil
+}
@@ -0,0 +1,190 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIssuer(t *testing.T) {
+	dir := t.TempDir()
+	pass := func() ([]byte, error) { return []byte("issuer test passphrase"), nil }
+	cfg := Config{BaseDir: dir, Passphrase: pass, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	is := &Issuer{CA: &cfg, KeyPoolSize: 4}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go is.Run(ctx)
+
+	type result struct {
+		certPEM, chainPEM string
+		err               error
+	}
+	var wg sync.WaitGroup
+	results := make(chan result, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("spiffe://demo/ns/default/sa/w%d", i)
+			var r result
+			if i%2 == 0 {
+				r.certPEM, _, r.chainPEM, _, r.err = is.IssueLeafRequest(LeafRequest{SPIFFEID: id})
+			} else if key, err := GenerateKey(ECDSAP256); err != nil {
+				r.err = err
+			} else if csrPEM, err := CreateLeafCSR(key, LeafRequest{SPIFFEID: id}); err != nil {
+				r.err = err
+			} else {
+				r.certPEM, r.chainPEM, _, r.err = is.SignCSRRequest(csrPEM, LeafRequest{SPIFFEID: id})
+			}
+			results <- r
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	for r := range results {
+		if r.err != nil {
+			t.Error(r.err)
+			continue
+		}
+		verifyLeafChain(t, dir, r.certPEM, r.chainPEM)
+	}
+	if _, _, _, err := is.SignCSRRequest([]byte("not a CSR"), LeafRequest{SPIFFEID: "spiffe://demo/ns/default/sa/x"}); err == nil {
+		t.Error("signed an invalid CSR")
+	}
+
+	// Issuance through the Config in between, then a rotation, must both be
+	// picked up by the Issuer.
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/direct", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.RotateIntermediate(&cfg, 0); err != nil {
+		t.Fatal(err)
+	}
+	newInter, err := cfg.readCert("intermediate-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, chainPEM, _, err := is.IssueLeafRequest(LeafRequest{SPIFFEID: "spiffe://demo/ns/default/sa/rotated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyLeafChain(t, dir, certPEM, chainPEM)
+	leaf, _ := ParseCertificatePEM([]byte(certPEM))
+	if !bytes.Equal(leaf.AuthorityKeyId, newInter.SubjectKeyId) {
+		t.Error("Issuer did not switch to the rotated intermediate")
+	}
+	if err := cfg.VerifyState(time.Now()); err != nil {
+		t.Error(err)
+	}
+	recs, err := cfg.IssuanceDB().List(IssuedFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 10 {
+		t.Errorf("issuance database has %d records, want 10", len(recs))
+	}
+}
+
+// The benchmarks compare issuance through Config, which reads and decrypts
+// the intermediate and log keys for every certificate, with an Issuer, and
+// for IssueLeafRequest with an Issuer whose key pool is warm. Run with
+// -benchtime=300x or so: every iteration appends to the issuance database
+// and transparency log.
+func BenchmarkIssueLeaf(b *testing.B) {
+	for _, alg := range []KeyAlgorithm{ECDSAP256, RSA2048} {
+		b.Run(string(alg)+"/Config", func(b *testing.B) {
+			cfg := benchCA(b, alg, false)
+			benchIssue(b, func(id string) error {
+				_, _, _, _, err := cfg.IssueLeafRequest(LeafRequest{SPIFFEID: id})
+				return err
+			})
+		})
+		b.Run(string(alg)+"/Issuer", func(b *testing.B) {
+			is := &Issuer{CA: benchCA(b, alg, false)}
+			benchIssue(b, func(id string) error {
+				_, _, _, _, err := is.IssueLeafRequest(LeafRequest{SPIFFEID: id})
+				return err
+			})
+		})
+		b.Run(string(alg)+"/IssuerKeyPool", func(b *testing.B) {
+			is := &Issuer{CA: benchCA(b, alg, false), KeyPoolSize: 32}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go is.Run(ctx)
+			benchIssue(b, func(id string) error {
+				// Measure issuance from a full pool, as at the start of a burst.
+				if len(is.keys) == 0 {
+					b.StopTimer()
+					for len(is.keys) < cap(is.keys) {
+						time.Sleep(time.Millisecond)
+					}
+					b.StartTimer()
+				}
+				_, _, _, _, err := is.IssueLeafRequest(LeafRequest{SPIFFEID: id})
+				return err
+			})
+		})
+	}
+}
+
+func BenchmarkSignCSR(b *testing.B) {
+	key, err := GenerateKey(ECDSAP256)
+	if err != nil {
+		b.Fatal(err)
+	}
+	const id = "spiffe://demo/ns/default/sa/bench"
+	csrPEM, err := CreateLeafCSR(key, LeafRequest{SPIFFEID: id})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, encrypted := range []bool{false, true} {
+		name := "plain"
+		if encrypted {
+			name = "encrypted"
+		}
+		b.Run(name+"/Config", func(b *testing.B) {
+			cfg := benchCA(b, ECDSAP256, encrypted)
+			benchIssue(b, func(string) error {
+				_, _, _, err := cfg.SignCSRRequest(csrPEM, LeafRequest{SPIFFEID: id})
+				return err
+			})
+		})
+		b.Run(name+"/Issuer", func(b *testing.B) {
+			is := &Issuer{CA: benchCA(b, ECDSAP256, encrypted)}
+			benchIssue(b, func(string) error {
+				_, _, _, err := is.SignCSRRequest(csrPEM, LeafRequest{SPIFFEID: id})
+				return err
+			})
+		})
+	}
+}
+
+// benchCA creates a CA issuing leaf keys of leaf, with its keys encrypted
+// under a passphrase if encrypted.
+func benchCA(b *testing.B, leaf KeyAlgorithm, encrypted bool) *Config {
+	b.Helper()
+	cfg := &Config{BaseDir: b.TempDir(), RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: leaf}
+	if encrypted {
+		cfg.Passphrase = func() ([]byte, error) { return []byte("bench passphrase"), nil }
+	}
+	if err := cfg.Init(); err != nil {
+		b.Fatal(err)
+	}
+	return cfg
+}
+
+func benchIssue(b *testing.B, issue func(id string) error) {
+	b.Helper()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := issue(fmt.Sprintf("spiffe://demo/ns/default/sa/w%d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
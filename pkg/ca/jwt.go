@@ -0,0 +1,316 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/jwtsvid"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+const (
+	jwtKeysFile = "jwt-keys.json"
+	jwksFile    = "jwks.json"
+)
+
+// DefaultJWTSVIDTTL is the lifetime of a JWT-SVID when the caller does not
+// ask for one; DefaultMaxJWTSVIDValidity caps requested lifetimes so a
+// rotated-out JWT key can be retired a known time after its successor
+// takes over.
+const (
+	DefaultJWTSVIDTTL         = 5 * time.Minute
+	DefaultMaxJWTSVIDValidity = time.Hour
+)
+
+// DefaultJWTKeyAlgorithm signs JWT-SVIDs with ES256.
+const DefaultJWTKeyAlgorithm = ECDSAP256
+
+// ErrInvalidJWTSVIDRequest wraps every reason SignJWTSVID refuses a
+// request, as opposed to CA failures.
+var ErrInvalidJWTSVIDRequest = errors.New("invalid JWT-SVID request")
+
+// JWTKeyRecord tracks one JWT-SVID signing key generation. Name is the key
+// store name and KeyID the kid published in the trust bundle. Keys follow
+// the intermediate rotation rules (see IntermediateRecord): a key is
+// published as soon as it is created, signs from ActivateAt until a newer
+// key activates, and is dropped from the bundle once RetireAt passes and
+// every token it signed has expired.
+type JWTKeyRecord struct {
+	Name       string    `json:"name"`
+	KeyID      string    `json:"kid"`
+	PublicKey  string    `json:"public_key"` // PEM
+	ActivateAt time.Time `json:"activate_at"`
+	RetireAt   time.Time `json:"retire_at,omitempty"`
+	Retired    bool      `json:"retired,omitempty"`
+}
+
+type jwtKeyState struct {
+	Keys []JWTKeyRecord `json:"keys"`
+}
+
+func (c *Config) jwtKeyAlgorithm() KeyAlgorithm {
+	if c.JWTKeyAlgorithm != "" {
+		return c.JWTKeyAlgorithm
+	}
+	return DefaultJWTKeyAlgorithm
+}
+
+func (c *Config) maxJWTSVIDValidity() time.Duration {
+	if c.MaxJWTSVIDValidity > 0 {
+		return c.MaxJWTSVIDValidity
+	}
+	return DefaultMaxJWTSVIDValidity
+}
+
+// JWTKeys returns every JWT key generation, oldest first. A CA directory
+// created before JWT-SVID support has none.
+func (c *Config) JWTKeys() ([]JWTKeyRecord, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, jwtKeysFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st jwtKeyState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", jwtKeysFile, err)
+	}
+	return st.Keys, nil
+}
+
+func (c *Config) saveJWTKeys(recs []JWTKeyRecord) error {
+	data, err := json.MarshalIndent(jwtKeyState{Keys: recs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.BaseDir, jwtKeysFile), data, 0644)
+}
+
+func (r JWTKeyRecord) publicKey() (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(r.PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: no public key PEM", r.Name)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.Name, err)
+	}
+	return pub, nil
+}
+
+// activeJWTKey returns the most recently activated, unretired record.
+func activeJWTKey(recs []JWTKeyRecord, now time.Time) (JWTKeyRecord, error) {
+	for i := len(recs) - 1; i >= 0; i-- {
+		if !recs[i].Retired && !now.Before(recs[i].ActivateAt) {
+			return recs[i], nil
+		}
+	}
+	return JWTKeyRecord{}, errors.New("no active JWT signing key (run: ztca jwt rotate)")
+}
+
+// addJWTKey generates the next JWT key, activating after delay, and
+// schedules every earlier key for retirement once the longest-lived token
+// it can still sign has expired. It does not republish the trust bundle.
+func (c *Config) addJWTKey(delay time.Duration, now time.Time) (JWTKeyRecord, error) {
+	recs, err := c.JWTKeys()
+	if err != nil {
+		return JWTKeyRecord{}, err
+	}
+	if len(recs) > 0 {
+		if last := recs[len(recs)-1]; now.Before(last.ActivateAt) {
+			return JWTKeyRecord{}, fmt.Errorf("%s is still pending until %s", last.Name, last.ActivateAt.Format(time.RFC3339))
+		}
+	}
+	alg := c.jwtKeyAlgorithm()
+	if alg == Ed25519 {
+		return JWTKeyRecord{}, fmt.Errorf("%s keys cannot sign JWT-SVIDs; use RSA or ECDSA", alg)
+	}
+	name := "jwt-" + strconv.Itoa(len(recs)+1)
+	key, err := c.keyStore().GenerateKey(name, alg)
+	if err != nil {
+		return JWTKeyRecord{}, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return JWTKeyRecord{}, err
+	}
+	kid, err := jwkThumbprint(key.Public())
+	if err != nil {
+		return JWTKeyRecord{}, err
+	}
+	rec := JWTKeyRecord{
+		Name:       name,
+		KeyID:      kid,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		ActivateAt: now.Add(delay),
+	}
+	retireAt := rec.ActivateAt.Add(c.maxJWTSVIDValidity())
+	for i := range recs {
+		if recs[i].RetireAt.IsZero() || recs[i].RetireAt.After(retireAt) {
+			recs[i].RetireAt = retireAt
+		}
+	}
+	recs = append(recs, rec)
+	return rec, c.saveJWTKeys(recs)
+}
+
+// RotateJWTKey creates a new JWT-SVID signing key and publishes it in the
+// trust bundle at once; it takes over signing after delay, giving relying
+// parties time to fetch the new bundle. The first key of a CA directory
+// (which Init and InstallIntermediate create) may use a zero delay.
+func (c *Config) RotateJWTKey(delay time.Duration) (JWTKeyRecord, error) {
+	rec, err := c.addJWTKey(delay, time.Now())
+	if err != nil {
+		return JWTKeyRecord{}, err
+	}
+	return rec, c.republishTrustBundle()
+}
+
+// RetireJWTKeys marks every JWT key whose RetireAt has passed as retired
+// and republishes the trust bundle without it. It returns the records
+// retired by this call.
+func (c *Config) RetireJWTKeys(now time.Time) ([]JWTKeyRecord, error) {
+	recs, err := c.JWTKeys()
+	if err != nil {
+		return nil, err
+	}
+	active, err := activeJWTKey(recs, now)
+	if err != nil {
+		return nil, err
+	}
+	var retired []JWTKeyRecord
+	for i := range recs {
+		r := &recs[i]
+		if r.Retired || r.Name == active.Name || r.RetireAt.IsZero() || now.Before(r.RetireAt) {
+			continue
+		}
+		r.Retired = true
+		retired = append(retired, *r)
+	}
+	if len(retired) == 0 {
+		return nil, nil
+	}
+	if err := c.saveJWTKeys(recs); err != nil {
+		return nil, err
+	}
+	return retired, c.republishTrustBundle()
+}
+
+// republishTrustBundle rewrites the trust bundle from intermediates.json
+// and jwt-keys.json.
+func (c *Config) republishTrustBundle() error {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return err
+	}
+	return c.publishTrustBundle(recs)
+}
+
+// jwtAuthorities returns the public keys of every unretired JWT key by
+// key ID.
+func (c *Config) jwtAuthorities() (map[string]crypto.PublicKey, error) {
+	recs, err := c.JWTKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, r := range recs {
+		if r.Retired {
+			continue
+		}
+		pub, err := r.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[r.KeyID] = pub
+	}
+	return keys, nil
+}
+
+// SignJWTSVID signs a JWT-SVID for id with the active JWT key, valid for
+// ttl (DefaultJWTSVIDTTL when zero) and the given audiences. id must be in
+// the CA's trust domain.
+func (c *Config) SignJWTSVID(id spiffeid.ID, audience []string, ttl time.Duration, now time.Time) (token string, expiresAt time.Time, err error) {
+	if len(audience) == 0 {
+		return "", time.Time{}, fmt.Errorf("%w: at least one audience is required", ErrInvalidJWTSVIDRequest)
+	}
+	for _, a := range audience {
+		if a == "" {
+			return "", time.Time{}, fmt.Errorf("%w: empty audience", ErrInvalidJWTSVIDRequest)
+		}
+	}
+	if ttl <= 0 {
+		ttl = DefaultJWTSVIDTTL
+	}
+	if max := c.maxJWTSVIDValidity(); ttl > max {
+		return "", time.Time{}, fmt.Errorf("%w: lifetime %v exceeds maximum %v", ErrInvalidJWTSVIDRequest, ttl, max)
+	}
+	td, err := c.LoadTrustDomain()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := id.RequireMemberOf(td); err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: refusing to issue: %v", ErrInvalidJWTSVIDRequest, err)
+	}
+	recs, err := c.JWTKeys()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	rec, err := activeJWTKey(recs, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	key, err := c.keyStore().Signer(rec.Name)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	pub, err := rec.publicKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !publicKeysEqual(key.Public(), pub) {
+		return "", time.Time{}, fmt.Errorf("%s key does not match %s", rec.Name, jwtKeysFile)
+	}
+	expiresAt = now.Add(ttl).Truncate(time.Second)
+	token, err = jwtsvid.Sign(key, jwtsvid.Header{Kid: rec.KeyID}, jwtsvid.Claims{
+		Subject:  id.String(),
+		Audience: audience,
+		Expiry:   expiresAt.Unix(),
+		IssuedAt: now.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// jwkThumbprint returns the RFC 7638 SHA-256 thumbprint of pub, used as
+// its key ID.
+func jwkThumbprint(pub crypto.PublicKey) (string, error) {
+	k, err := publicKeyJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	// The required members in lexicographic order, without whitespace.
+	var members string
+	switch k.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, k.Crv, k.Kty, k.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64url.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,128 @@
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/jwtsvid"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+func TestJWTSVID(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	td, _ := spiffeid.TrustDomainFromString("demo")
+	id, _ := spiffeid.ForService(td, "service-a")
+	now := time.Now()
+	token, exp, err := cfg.SignJWTSVID(id, []string{"service-b"}, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(DefaultJWTSVIDTTL).Truncate(time.Second); !exp.Equal(want) {
+		t.Errorf("expiry %v, want %v", exp, want)
+	}
+	b, err := cfg.TrustBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svid, err := jwtsvid.Validate(token, td, b.JWTAuthorities, "service-b", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svid.ID != id {
+		t.Errorf("sub = %s, want %s", svid.ID, id)
+	}
+
+	// jwks.json carries the same keys with alg for plain JWT libraries.
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(readFile(t, filepath.Join(dir, "jwks.json")), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0]["alg"] != "ES256" || jwks.Keys[0]["use"] != "sig" || b.JWTAuthorities[jwks.Keys[0]["kid"]] == nil {
+		t.Errorf("jwks.json = %+v", jwks.Keys)
+	}
+
+	otherTD, _ := spiffeid.TrustDomainFromString("other")
+	other, _ := spiffeid.ForService(otherTD, "service-a")
+	for name, fn := range map[string]func() error{
+		"no audience": func() error { _, _, err := cfg.SignJWTSVID(id, nil, 0, now); return err },
+		"too long":    func() error { _, _, err := cfg.SignJWTSVID(id, []string{"b"}, 2*time.Hour, now); return err },
+		"foreign id":  func() error { _, _, err := cfg.SignJWTSVID(other, []string{"b"}, 0, now); return err },
+	} {
+		if err := fn(); !errors.Is(err, ErrInvalidJWTSVIDRequest) {
+			t.Errorf("%s: err = %v, want ErrInvalidJWTSVIDRequest", name, err)
+		}
+	}
+}
+
+func TestRotateJWTKey(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, JWTKeyAlgorithm: RSA2048}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	td, _ := spiffeid.TrustDomainFromString("demo")
+	id, _ := spiffeid.ForService(td, "service-a")
+	recs, err := cfg.JWTKeys()
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("after init: %d keys, %v", len(recs), err)
+	}
+	oldToken, _, err := cfg.SignJWTSVID(id, []string{"b"}, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := cfg.RotateJWTKey(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.RotateJWTKey(time.Hour); err == nil {
+		t.Error("started a second rotation while one is pending")
+	}
+	b, _ := cfg.TrustBundle()
+	if len(b.JWTAuthorities) != 2 || b.Sequence != 2 {
+		t.Fatalf("during propagation: %d JWT authorities, sequence %d", len(b.JWTAuthorities), b.Sequence)
+	}
+
+	// The old key signs until the new one activates; afterwards the new one
+	// does, and tokens from both validate against the bundle.
+	token, _, _ := cfg.SignJWTSVID(id, []string{"b"}, 0, time.Now())
+	if tok, _ := jwtsvid.Parse(token); tok.Header.Kid != recs[0].KeyID {
+		t.Errorf("kid before activation = %s, want %s", tok.Header.Kid, recs[0].KeyID)
+	}
+	after := rec.ActivateAt.Add(time.Minute)
+	token, _, _ = cfg.SignJWTSVID(id, []string{"b"}, 0, after)
+	if tok, _ := jwtsvid.Parse(token); tok.Header.Kid != rec.KeyID || tok.Header.Alg != "RS256" {
+		t.Errorf("after activation: header %+v, want kid %s", tok.Header, rec.KeyID)
+	}
+	if _, err := jwtsvid.Validate(oldToken, td, b.JWTAuthorities, "b", time.Now()); err != nil {
+		t.Errorf("old token during overlap: %v", err)
+	}
+
+	recs, _ = cfg.JWTKeys()
+	if want := rec.ActivateAt.Add(DefaultMaxJWTSVIDValidity); !recs[0].RetireAt.Equal(want) {
+		t.Errorf("old RetireAt = %v, want %v", recs[0].RetireAt, want)
+	}
+	if retired, err := cfg.RetireJWTKeys(after); err != nil || len(retired) != 0 {
+		t.Fatalf("early retire: %v, %v", retired, err)
+	}
+	retired, err := cfg.RetireJWTKeys(recs[0].RetireAt.Add(time.Second))
+	if err != nil || len(retired) != 1 || retired[0].Name != "jwt-1" {
+		t.Fatalf("retired = %+v, %v", retired, err)
+	}
+	b, _ = cfg.TrustBundle()
+	if len(b.JWTAuthorities) != 1 || b.JWTAuthorities[rec.KeyID] == nil {
+		t.Errorf("after retirement: %d JWT authorities", len(b.JWTAuthorities))
+	}
+	if _, err := jwtsvid.Validate(oldToken, td, b.JWTAuthorities, "b", time.Now()); !errors.Is(err, jwtsvid.ErrUnknownKey) {
+		t.Errorf("token from retired key: %v", err)
+	}
+}
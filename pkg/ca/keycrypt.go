@@ -0,0 +1,221 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/zero-trust/zt-identity/pkg/pkcs12"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted keys use PKCS#8 EncryptedPrivateKeyInfo with PBES2 (RFC 8018),
+// scrypt as the key derivation function (RFC 7914) and AES-256-GCM as the
+// encryption scheme (RFC 5084). Keys encrypted by OpenSSL, with PBKDF2 and
+// AES-CBC, can be read but are never written.
+var (
+	oidPBES2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidAES256GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+)
+
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptMaxN    = 1 << 20
+	scryptMaxR    = 16
+	scryptMaxP    = 16
+	scryptMaxRP   = 32 // bounds memory at 128*N*r and work at N*r*p
+	scryptSaltLen = 16
+	aesKeyLen     = 32
+	gcmTagLen     = 16
+)
+
+// ErrPassphraseRequired is returned when an encrypted key is read without a passphrase.
+var ErrPassphraseRequired = errors.New("private key is encrypted: passphrase required")
+
+// ErrBadPassphrase is returned when an encrypted key fails to decrypt.
+var ErrBadPassphrase = errors.New("private key decryption failed: wrong passphrase or corrupted key")
+
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"default:12"`
+}
+
+// EncryptPrivateKeyPEM encodes key as an "ENCRYPTED PRIVATE KEY" PEM block
+// protected by passphrase.
+func EncryptPrivateKeyPEM(key crypto.Signer, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kdf := scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: aesKeyLen}
+	aead, err := keyEncryptionAEAD(passphrase, kdf)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	kdfDER, err := asn1.Marshal(kdf)
+	if err != nil {
+		return nil, err
+	}
+	gcmDER, err := asn1.Marshal(gcmParams{Nonce: nonce, ICVLen: gcmTagLen})
+	if err != nil {
+		return nil, err
+	}
+	paramsDER, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfDER}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256GCM, Parameters: asn1.RawValue{FullBytes: gcmDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: paramsDER}},
+		EncryptedData: aead.Seal(nil, nonce, plain, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecryptPrivateKeyPEM parses a private key that may be encrypted, by
+// EncryptPrivateKeyPEM or by OpenSSL. Plain keys are accepted as-is;
+// encrypted keys require a non-empty passphrase.
+func DecryptPrivateKeyPEM(data, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in private key")
+	}
+	if block.Type != "ENCRYPTED PRIVATE KEY" {
+		return ParsePrivateKeyPEM(data)
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, fmt.Errorf("encrypted private key: %w", err)
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption algorithm %v", info.Algo.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("PBES2 parameters: %w", err)
+	}
+	if params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		key, err := pkcs12.DecryptPrivateKey(block.Bytes, string(passphrase))
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, ErrBadPassphrase
+		}
+		return key, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidScrypt) {
+		return nil, fmt.Errorf("unsupported key derivation function %v", params.KeyDerivationFunc.Algorithm)
+	}
+	if !params.EncryptionScheme.Algorithm.Equal(oidAES256GCM) {
+		return nil, fmt.Errorf("unsupported encryption scheme %v", params.EncryptionScheme.Algorithm)
+	}
+	var kdf scryptParams
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("scrypt parameters: %w", err)
+	}
+	if err := kdf.check(); err != nil {
+		return nil, err
+	}
+	var gcm gcmParams
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &gcm); err != nil {
+		return nil, fmt.Errorf("GCM parameters: %w", err)
+	}
+	if gcm.ICVLen != gcmTagLen {
+		return nil, fmt.Errorf("unsupported GCM tag length %d", gcm.ICVLen)
+	}
+	aead, err := keyEncryptionAEAD(passphrase, kdf)
+	if err != nil {
+		return nil, err
+	}
+	if len(gcm.Nonce) != aead.NonceSize() {
+		return nil, errors.New("bad GCM nonce length")
+	}
+	plain, err := aead.Open(nil, gcm.Nonce, info.EncryptedData, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// IsEncryptedKeyPEM reports whether data holds an encrypted private key.
+func IsEncryptedKeyPEM(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == "ENCRYPTED PRIVATE KEY"
+}
+
+// check rejects scrypt parameters read from untrusted input that would
+// demand unbounded memory or CPU before the passphrase can be checked.
+func (kdf scryptParams) check() error {
+	r, p := kdf.BlockSize, kdf.ParallelizationParameter
+	if kdf.CostParameter > scryptMaxN || r < 1 || r > scryptMaxR || p < 1 || p > scryptMaxP || r*p > scryptMaxRP ||
+		(kdf.KeyLength != 0 && kdf.KeyLength != aesKeyLen) {
+		return errors.New("scrypt parameters out of range")
+	}
+	return nil
+}
+
+func keyEncryptionAEAD(passphrase []byte, kdf scryptParams) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, kdf.Salt, kdf.CostParameter, kdf.BlockSize, kdf.ParallelizationParameter, aesKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,161 @@
+package ca
+
+import (
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEncryptPrivateKeyRoundTrip(t *testing.T) {
+	key, err := GenerateKey(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncryptPrivateKeyPEM(key, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedKeyPEM(enc) {
+		t.Fatal("expected ENCRYPTED PRIVATE KEY block")
+	}
+	got, err := DecryptPrivateKeyPEM(enc, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKeysEqual(got.Public(), key.Public()) {
+		t.Error("decrypted key differs")
+	}
+	if _, err := DecryptPrivateKeyPEM(enc, []byte("wrong")); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+	if _, err := DecryptPrivateKeyPEM(enc, nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("no passphrase: got %v", err)
+	}
+}
+
+func TestDecryptRejectsCostlyScryptParams(t *testing.T) {
+	key, err := GenerateKey(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncryptPrivateKeyPEM(key, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		r, p int
+	}{
+		{"r too large", 1 << 20, 1},
+		{"p too large", 8, 1 << 20},
+		{"r*p too large", scryptMaxR, scryptMaxP},
+		{"r zero", 0, 1},
+	} {
+		tampered := withScryptParams(t, enc, tc.r, tc.p)
+		if _, err := DecryptPrivateKeyPEM(tampered, []byte("correct horse")); err == nil || errors.Is(err, ErrBadPassphrase) {
+			t.Errorf("%s: got %v, want parameter error", tc.name, err)
+		}
+	}
+}
+
+// withScryptParams re-encodes an encrypted key with scrypt r and p replaced.
+func withScryptParams(t *testing.T, data []byte, r, p int) []byte {
+	t.Helper()
+	block, _ := pem.Decode(data)
+	var info encryptedPrivateKeyInfo
+	var params pbes2Params
+	var kdf scryptParams
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatal(err)
+	}
+	kdf.BlockSize, kdf.ParallelizationParameter = r, p
+	kdfDER, err := asn1.Marshal(kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params.KeyDerivationFunc.Parameters = asn1.RawValue{FullBytes: kdfDER}
+	paramsDER, err := asn1.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Algo.Parameters = asn1.RawValue{FullBytes: paramsDER}
+	der, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+}
+
+func TestInitEncryptedKeys(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ZTCA_TEST_PASSPHRASE", "s3cret\n")
+	pass, err := PassphraseFromSpec("env:ZTCA_TEST_PASSPHRASE", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{BaseDir: dir, Passphrase: pass}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"root.key", "intermediate.key"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncryptedKeyPEM(data) {
+			t.Errorf("%s is not encrypted", name)
+		}
+	}
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.UpdateCRL(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	locked := Config{BaseDir: dir}
+	if _, _, _, _, err := locked.IssueLeaf("spiffe://demo/ns/default/sa/test", 0); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("issue without passphrase: got %v", err)
+	}
+	passFile := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(passFile, []byte("wrong\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrong, err := PassphraseFromSpec("file:"+passFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := Config{BaseDir: dir, Passphrase: wrong}
+	if err := bad.UpdateCRL(time.Now()); !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("CRL with wrong passphrase: got %v", err)
+	}
+}
+
+func TestPassphraseFromSpec(t *testing.T) {
+	if fn, err := PassphraseFromSpec("", false); err != nil || fn != nil {
+		t.Errorf("empty spec: got %v, %v", fn, err)
+	}
+	if _, err := PassphraseFromSpec("vault:x", false); err == nil {
+		t.Error("expected error for unknown source")
+	}
+	if _, err := PassphraseFromSpec("fd:x", false); err == nil {
+		t.Error("expected error for bad fd")
+	}
+	fn, err := PassphraseFromSpec("env:ZTCA_TEST_UNSET_PASSPHRASE", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn(); err == nil {
+		t.Error("expected error for unset env var")
+	}
+}
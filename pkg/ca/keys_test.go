@@ -0,0 +1,84 @@
+package ca
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInitAndIssueAllKeyAlgorithms(t *testing.T) {
+	for _, alg := range KeyAlgorithms {
+		alg := alg
+		t.Run(string(alg), func(t *testing.T) {
+			if testing.Short() && (alg == RSA3072 || alg == RSA4096) {
+				t.Skip("slow key generation")
+			}
+			dir := t.TempDir()
+			cfg := Config{
+				BaseDir:                  dir,
+				RootKeyAlgorithm:         alg,
+				IntermediateKeyAlgorithm: alg,
+				LeafKeyAlgorithm:         alg,
+			}
+			if err := cfg.Init(); err != nil {
+				t.Fatal(err)
+			}
+			keyPEM, err := os.ReadFile(filepath.Join(dir, "intermediate.key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ParsePrivateKeyPEM(keyPEM); err != nil {
+				t.Fatalf("parse intermediate key: %v", err)
+			}
+			certPEM, leafKeyPEM, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leafKey, err := ParsePrivateKeyPEM([]byte(leafKeyPEM))
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := ParseCertificatePEM([]byte(certPEM))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := leaf.PublicKeyAlgorithm, publicKeyAlgorithm(alg); got != want {
+				t.Errorf("leaf public key algorithm = %v, want %v", got, want)
+			}
+			if !publicKeysEqual(leafKey.Public(), leaf.PublicKey) {
+				t.Error("leaf key does not match certificate")
+			}
+			if alg != RSA2048 && alg != RSA3072 && alg != RSA4096 && leaf.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+				t.Error("non-RSA leaf must not assert keyEncipherment")
+			}
+			if err := cfg.UpdateCRL(time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestParseKeyAlgorithm(t *testing.T) {
+	if alg, err := ParseKeyAlgorithm(""); err != nil || alg != DefaultKeyAlgorithm {
+		t.Errorf("empty: got %q, %v", alg, err)
+	}
+	if alg, err := ParseKeyAlgorithm("ECDSA-P256"); err != nil || alg != ECDSAP256 {
+		t.Errorf("ECDSA-P256: got %q, %v", alg, err)
+	}
+	if _, err := ParseKeyAlgorithm("dsa-1024"); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+}
+
+func publicKeyAlgorithm(alg KeyAlgorithm) x509.PublicKeyAlgorithm {
+	switch alg {
+	case ECDSAP256, ECDSAP384:
+		return x509.ECDSA
+	case Ed25519:
+		return x509.Ed25519
+	default:
+		return x509.RSA
+	}
+}
@@ -0,0 +1,147 @@
+package ca
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyStore provides CA private keys by name ("root", "intermediate").
+// Implementations may keep the key material out of process; callers only
+// ever see a crypto.Signer.
+type KeyStore interface {
+	// Signer returns the signer for an existing key.
+	Signer(name string) (crypto.Signer, error)
+	// GenerateKey creates and persists a new key under name.
+	GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error)
+}
+
+// ErrKeyGenerationUnsupported is returned by key stores that can only sign.
+var ErrKeyGenerationUnsupported = errors.New("key store does not support key generation")
+
+// KeyImporter is implemented by key stores that can take custody of an
+// existing key, such as one adopted by ImportIntermediate.
+type KeyImporter interface {
+	// ImportKey persists key under name, which must not exist yet.
+	ImportKey(name string, key crypto.Signer) error
+}
+
+// ErrKeyImportUnsupported is returned for key stores that cannot import
+// keys; put the key into the backend with its own tools instead.
+var ErrKeyImportUnsupported = errors.New("key store does not support key import")
+
+// FileKeyStore keeps PKCS#8 PEM keys as <Dir>/<name>.key with mode 0600.
+// When Passphrase is set, new keys are written encrypted (see
+// EncryptPrivateKeyPEM) and encrypted keys are unlocked with it.
+type FileKeyStore struct {
+	Dir        string
+	Passphrase PassphraseFunc
+}
+
+func (s *FileKeyStore) path(name string) string {
+	return filepath.Join(s.Dir, name+".key")
+}
+
+// Signer reads and parses <Dir>/<name>.key.
+func (s *FileKeyStore) Signer(name string) (crypto.Signer, error) {
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	var pass []byte
+	if IsEncryptedKeyPEM(data) {
+		if s.Passphrase == nil {
+			return nil, fmt.Errorf("%s.key: %w", name, ErrPassphraseRequired)
+		}
+		if pass, err = s.Passphrase(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := DecryptPrivateKeyPEM(data, pass)
+	if err != nil {
+		return nil, fmt.Errorf("%s.key: %w", name, err)
+	}
+	return key, nil
+}
+
+// GenerateKey creates a key and writes it to <Dir>/<name>.key, which must
+// not exist yet.
+func (s *FileKeyStore) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeKey(name, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ImportKey writes key to <Dir>/<name>.key, encrypted like generated keys.
+func (s *FileKeyStore) ImportKey(name string, key crypto.Signer) error {
+	if _, err := os.Stat(s.path(name)); err == nil {
+		return fmt.Errorf("%s already exists", s.path(name))
+	}
+	return s.writeKey(name, key)
+}
+
+func (s *FileKeyStore) writeKey(name string, key crypto.Signer) error {
+	var keyPEM []byte
+	var err error
+	if s.Passphrase != nil {
+		pass, perr := s.Passphrase()
+		if perr != nil {
+			return perr
+		}
+		keyPEM, err = EncryptPrivateKeyPEM(key, pass)
+	} else {
+		keyPEM, err = MarshalPrivateKeyPEM(key)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	// Never replace a key: its certificate would be left without one.
+	f, err := os.OpenFile(s.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(keyPEM); err != nil {
+		f.Close()
+		os.Remove(s.path(name))
+		return err
+	}
+	return f.Close()
+}
+
+// OpenKeyStore builds a KeyStore from a configuration string:
+//
+//	"" or "file"          keys in dir, unlocked with passphrase
+//	"file:<path>"         keys in <path>, unlocked with passphrase
+//	"pkcs11:<uri>"        PKCS#11 token (RFC 7512 style, see OpenPKCS11KeyStore)
+//	"exec:<cmd> [args]"   external signer process (see ProcessKeyStore)
+func OpenKeyStore(spec, dir string, passphrase PassphraseFunc) (KeyStore, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "file":
+		if rest != "" {
+			dir = rest
+		}
+		return &FileKeyStore{Dir: dir, Passphrase: passphrase}, nil
+	case "pkcs11":
+		return OpenPKCS11KeyStore(spec)
+	case "exec":
+		args := strings.Fields(rest)
+		if len(args) == 0 {
+			return nil, errors.New("exec key store: missing command")
+		}
+		return &ProcessKeyStore{Command: args}, nil
+	default:
+		return nil, fmt.Errorf("unknown key store %q", kind)
+	}
+}
@@ -0,0 +1,114 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ProcessKeyStore delegates key operations to an external signer program.
+// The program is invoked once per operation as:
+//
+//	<Command...> public-key <name>          print the PEM "PUBLIC KEY" on stdout
+//	<Command...> generate <name> <alg>      create the key, print its PEM public key
+//	<Command...> sign <name> <hash> [pss]   read the digest on stdin, write the raw signature
+//
+// <hash> is a crypto.Hash name such as "SHA-256", or "none" for Ed25519, in
+// which case stdin carries the whole message. "pss" selects RSA-PSS with the
+// salt length equal to the hash length. A non-zero exit fails the operation;
+// a program that cannot generate keys exits from generate with status 3
+// (processExitUnsupported).
+type ProcessKeyStore struct {
+	Command []string
+}
+
+// Signer fetches the public key for name and returns a signer bound to it.
+func (s *ProcessKeyStore) Signer(name string) (crypto.Signer, error) {
+	out, err := s.run(nil, "public-key", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.newSigner(name, out)
+}
+
+// processExitUnsupported is the exit status by which the signer program
+// declines an operation it does not implement.
+const processExitUnsupported = 3
+
+// GenerateKey asks the signer program to create a key. It returns
+// ErrKeyGenerationUnsupported only when the program exits with
+// processExitUnsupported; any other failure is returned as is.
+func (s *ProcessKeyStore) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	out, err := s.run(nil, "generate", name, string(alg.orDefault()))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == processExitUnsupported {
+		return nil, fmt.Errorf("%w: %v", ErrKeyGenerationUnsupported, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.newSigner(name, out)
+}
+
+func (s *ProcessKeyStore) newSigner(name string, pubPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pubPEM)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("signer %s: expected PUBLIC KEY PEM on stdout", name)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signer %s: %w", name, err)
+	}
+	return &processSigner{store: s, name: name, pub: pub}, nil
+}
+
+func (s *ProcessKeyStore) run(stdin []byte, args ...string) ([]byte, error) {
+	if len(s.Command) == 0 {
+		return nil, errors.New("exec key store: missing command")
+	}
+	cmd := exec.Command(s.Command[0], append(append([]string{}, s.Command[1:]...), args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("signer %s %s: %w: %s", s.Command[0], args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+type processSigner struct {
+	store *ProcessKeyStore
+	name  string
+	pub   crypto.PublicKey
+}
+
+func (p *processSigner) Public() crypto.PublicKey { return p.pub }
+
+func (p *processSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := "none"
+	if h := opts.HashFunc(); h != 0 {
+		hash = h.String()
+	}
+	args := []string{"sign", p.name, hash}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		args = append(args, "pss")
+	}
+	sig, err := p.store.run(digest, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) == 0 {
+		return nil, fmt.Errorf("signer %s: empty signature", p.name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,199 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignerHelperProcess is not a real test: ProcessKeyStore tests run the
+// test binary itself as the external signer, backed by a FileKeyStore.
+func TestSignerHelperProcess(t *testing.T) {
+	dir := os.Getenv("ZTCA_TEST_SIGNER_DIR")
+	if dir == "" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if args[1] == "generate" && os.Getenv("ZTCA_TEST_SIGNER_NO_GENERATE") != "" {
+		os.Exit(processExitUnsupported)
+	}
+	if err := runTestSigner(&FileKeyStore{Dir: dir}, args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func runTestSigner(ks *FileKeyStore, args []string) error {
+	var key crypto.Signer
+	var err error
+	switch args[0] {
+	case "public-key":
+		key, err = ks.Signer(args[1])
+	case "generate":
+		key, err = ks.GenerateKey(args[1], KeyAlgorithm(args[2]))
+	case "sign":
+		key, err = ks.Signer(args[1])
+		if err != nil {
+			return err
+		}
+		digest, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		var opts crypto.SignerOpts = crypto.Hash(0)
+		for _, h := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+			if h.String() == args[2] {
+				opts = h
+			}
+		}
+		if len(args) > 3 && args[3] == "pss" {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: opts.HashFunc()}
+		}
+		sig, err := key.Sign(rand.Reader, digest, opts)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(sig)
+		return err
+	default:
+		return fmt.Errorf("unknown op %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	return pem.Encode(os.Stdout, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestProcessKeyStore(t *testing.T) {
+	for _, alg := range []KeyAlgorithm{RSA2048, ECDSAP256, Ed25519} {
+		t.Run(string(alg), func(t *testing.T) {
+			keyDir := t.TempDir()
+			t.Setenv("ZTCA_TEST_SIGNER_DIR", keyDir)
+			ks, err := OpenKeyStore("exec:"+os.Args[0]+" -test.run=^TestSignerHelperProcess$ --", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			cfg := Config{BaseDir: dir, RootKeyAlgorithm: alg, IntermediateKeyAlgorithm: alg, KeyStore: ks}
+			if err := cfg.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(dir + "/intermediate.key"); !os.IsNotExist(err) {
+				t.Error("intermediate.key written to BaseDir with an external key store")
+			}
+			certPEM, _, chainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyLeafChain(t, dir, certPEM, chainPEM)
+			if err := cfg.UpdateCRL(time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestProcessKeyStoreGenerateErrors(t *testing.T) {
+	t.Setenv("ZTCA_TEST_SIGNER_DIR", t.TempDir())
+	ks, err := OpenKeyStore("exec:"+os.Args[0]+" -test.run=^TestSignerHelperProcess$ --", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A helper failure is not "unsupported".
+	if _, err := ks.GenerateKey("root", KeyAlgorithm("dsa-1024")); err == nil || errors.Is(err, ErrKeyGenerationUnsupported) {
+		t.Errorf("failed generate: got %v", err)
+	}
+	t.Setenv("ZTCA_TEST_SIGNER_NO_GENERATE", "1")
+	if _, err := ks.GenerateKey("root", ECDSAP256); !errors.Is(err, ErrKeyGenerationUnsupported) {
+		t.Errorf("declined generate: got %v", err)
+	}
+}
+
+func TestOpenKeyStore(t *testing.T) {
+	ks, err := OpenKeyStore("", "ca", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fks, ok := ks.(*FileKeyStore); !ok || fks.Dir != "ca" {
+		t.Errorf("default key store = %#v", ks)
+	}
+	ks, err = OpenKeyStore("file:/secure/keys", "ca", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fks := ks.(*FileKeyStore); fks.Dir != "/secure/keys" {
+		t.Errorf("file dir = %q", fks.Dir)
+	}
+	if _, err := OpenKeyStore("vault:x", "ca", nil); err == nil {
+		t.Error("expected error for unknown key store")
+	}
+	if _, err := OpenKeyStore("exec:", "ca", nil); err == nil {
+		t.Error("expected error for empty exec command")
+	}
+}
+
+func TestParsePKCS11URI(t *testing.T) {
+	cfg, err := ParsePKCS11URI("pkcs11:token=zt%20ca?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Token != "zt ca" || cfg.ModulePath != "/usr/lib/softhsm/libsofthsm2.so" || cfg.PIN != "1234" {
+		t.Errorf("parsed %+v", cfg)
+	}
+	if _, err := ParsePKCS11URI("pkcs11:token=ztca"); err == nil || !strings.Contains(err.Error(), "module-path") {
+		t.Errorf("expected module-path error, got %v", err)
+	}
+}
+
+func verifyLeafChain(t *testing.T, dir, certPEM, chainPEM string) {
+	t.Helper()
+	bundle, err := os.ReadFile(dir + "/trust-bundle.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	inters := x509.NewCertPool()
+	for rest := bundle; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Subject.String() == cert.Issuer.String() {
+			roots.AddCert(cert)
+		} else {
+			inters.AddCert(cert)
+		}
+	}
+	leaf, err := ParseCertificatePEM([]byte(certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(chainPEM, certPEM) {
+		t.Error("chain does not start with leaf")
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inters, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Fatalf("verify leaf: %v", err)
+	}
+}
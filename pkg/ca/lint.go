@@ -0,0 +1,262 @@
+package ca
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// Pre-issuance linting: signLeaf runs every completed leaf template through
+// the rules below before signing it. A finding at level "error" refuses the
+// issuance; "warn" findings go to Config.Logf and issuance continues. Levels
+// come from Config.Lint, else the "lint" object of ca.json, else each
+// rule's default:
+//
+//	{"lint": {"validity-within-issuer": "warn", "ext-key-usage": "off"}}
+//
+// LintCertificate applies the same rules to issued certificates (ztca lint).
+
+// LintLevel is what a lint finding does to an issuance.
+type LintLevel string
+
+const (
+	LintError LintLevel = "error" // refuse to issue
+	LintWarn  LintLevel = "warn"  // log and issue
+	LintOff   LintLevel = "off"   // skip the rule
+)
+
+// LintPolicy sets the level of lint rules by name. Rules it does not list
+// keep their default level.
+type LintPolicy map[string]LintLevel
+
+// ErrLint is wrapped by issuance errors caused by lint findings at level
+// "error".
+var ErrLint = errors.New("certificate failed pre-issuance lint")
+
+// LintFinding is one rule a certificate breaks.
+type LintFinding struct {
+	Rule    string
+	Level   LintLevel
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Level, f.Rule, f.Message)
+}
+
+// LintRule describes one lint rule.
+type LintRule struct {
+	Name         string
+	Description  string
+	DefaultLevel LintLevel
+	// check returns a message per problem. issuer is nil when unknown.
+	check func(cert, issuer *x509.Certificate) []string
+}
+
+// LintRules returns the lint rules in the order they run.
+func LintRules() []LintRule {
+	return append([]LintRule(nil), lintRules...)
+}
+
+var lintRules = []LintRule{
+	{
+		Name:         "validity-within-issuer",
+		Description:  "the validity period is non-empty and inside the issuer's",
+		DefaultLevel: LintError,
+		check: func(cert, issuer *x509.Certificate) []string {
+			var msgs []string
+			if !cert.NotAfter.After(cert.NotBefore) {
+				msgs = append(msgs, fmt.Sprintf("NotAfter %s is not after NotBefore %s", cert.NotAfter.UTC(), cert.NotBefore.UTC()))
+			}
+			if issuer == nil {
+				return msgs
+			}
+			if cert.NotBefore.Before(issuer.NotBefore) {
+				msgs = append(msgs, fmt.Sprintf("valid from %s, before issuer %q is (%s)", cert.NotBefore.UTC(), issuer.Subject.CommonName, issuer.NotBefore.UTC()))
+			}
+			if cert.NotAfter.After(issuer.NotAfter) {
+				msgs = append(msgs, fmt.Sprintf("expires %s, after issuer %q does (%s)", cert.NotAfter.UTC(), issuer.Subject.CommonName, issuer.NotAfter.UTC()))
+			}
+			return msgs
+		},
+	},
+	{
+		Name:         "spiffe-id",
+		Description:  "exactly one URI SAN, which is a valid SPIFFE ID",
+		DefaultLevel: LintError,
+		check: func(cert, _ *x509.Certificate) []string {
+			if len(cert.URIs) != 1 {
+				return []string{fmt.Sprintf("has %d URI SANs, want exactly one SPIFFE ID", len(cert.URIs))}
+			}
+			if _, err := spiffeid.FromURI(cert.URIs[0]); err != nil {
+				return []string{fmt.Sprintf("URI SAN %q: %v", cert.URIs[0], err)}
+			}
+			return nil
+		},
+	},
+	{
+		Name:         "key-usage",
+		Description:  "digitalSignature set, no keyCertSign or cRLSign, keyEncipherment only for RSA keys",
+		DefaultLevel: LintError,
+		check: func(cert, _ *x509.Certificate) []string {
+			var msgs []string
+			if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+				msgs = append(msgs, "digitalSignature key usage is not set")
+			}
+			if cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+				msgs = append(msgs, "keyCertSign or cRLSign key usage is set on a leaf")
+			}
+			if _, isRSA := cert.PublicKey.(*rsa.PublicKey); cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 && !isRSA {
+				msgs = append(msgs, fmt.Sprintf("keyEncipherment key usage is set for a %T key", cert.PublicKey))
+			}
+			return msgs
+		},
+	},
+	{
+		Name:         "ext-key-usage",
+		Description:  "extended key usages present, without anyExtendedKeyUsage or OCSPSigning",
+		DefaultLevel: LintError,
+		check: func(cert, _ *x509.Certificate) []string {
+			if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+				return []string{"no extended key usage"}
+			}
+			var msgs []string
+			for _, eku := range cert.ExtKeyUsage {
+				switch eku {
+				case x509.ExtKeyUsageAny:
+					msgs = append(msgs, "anyExtendedKeyUsage is set")
+				case x509.ExtKeyUsageOCSPSigning:
+					msgs = append(msgs, "OCSPSigning is set, making the leaf an OCSP responder for its issuer")
+				}
+			}
+			return msgs
+		},
+	},
+	{
+		Name:         "serial-entropy",
+		Description:  "the serial is positive, at most 20 octets and at least 64 bits long",
+		DefaultLevel: LintError,
+		check: func(cert, _ *x509.Certificate) []string {
+			s := cert.SerialNumber
+			switch {
+			case s == nil || s.Sign() <= 0:
+				return []string{"serial number is not positive"}
+			case len(s.Bytes()) > 20:
+				return []string{fmt.Sprintf("serial number is %d octets, the limit is 20", len(s.Bytes()))}
+			case s.BitLen() < 64:
+				return []string{fmt.Sprintf("serial number has %d bits, want at least 64 random bits", s.BitLen())}
+			}
+			return nil
+		},
+	},
+	{
+		Name:         "key-strength",
+		Description:  "RSA of at least 2048 bits, ECDSA P-256 or P-384, or Ed25519",
+		DefaultLevel: LintError,
+		check: func(cert, _ *x509.Certificate) []string {
+			if err := checkLeafPublicKey(cert.PublicKey); err != nil {
+				return []string{err.Error()}
+			}
+			return nil
+		},
+	},
+	{
+		Name:         "no-ca",
+		Description:  "the leaf is not a CA",
+		DefaultLevel: LintError,
+		check: func(cert, _ *x509.Certificate) []string {
+			if cert.IsCA {
+				return []string{"basicConstraints marks the leaf as a CA"}
+			}
+			return nil
+		},
+	},
+}
+
+// Validate checks that p names known rules and levels.
+func (p LintPolicy) Validate() error {
+	known := map[string]bool{}
+	for _, r := range lintRules {
+		known[r.Name] = true
+	}
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("lint: unknown rule %q", name)
+		}
+		switch p[name] {
+		case LintError, LintWarn, LintOff:
+		default:
+			return fmt.Errorf("lint: rule %s: unknown level %q (want error, warn or off)", name, p[name])
+		}
+	}
+	return nil
+}
+
+// LintCertificate runs the lint rules over cert, a leaf template about to
+// be signed or an issued leaf, and returns the findings of every rule that
+// is not off. A nil issuer skips the checks against the issuer.
+func LintCertificate(cert, issuer *x509.Certificate, policy LintPolicy) []LintFinding {
+	var findings []LintFinding
+	for _, r := range lintRules {
+		level := r.DefaultLevel
+		if l, ok := policy[r.Name]; ok {
+			level = l
+		}
+		if level == LintOff {
+			continue
+		}
+		for _, msg := range r.check(cert, issuer) {
+			findings = append(findings, LintFinding{Rule: r.Name, Level: level, Message: msg})
+		}
+	}
+	return findings
+}
+
+// LintPolicy returns c.Lint when set, else the policy recorded in ca.json.
+func (c *Config) LintPolicy() (LintPolicy, error) {
+	p := c.Lint
+	if p == nil {
+		s, err := c.LoadSettings()
+		if err != nil {
+			return nil, err
+		}
+		p = s.Lint
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// lintLeaf lints a leaf template before signLeaf signs it, logging
+// warnings and failing on errors.
+func (c *Config) lintLeaf(template, issuer *x509.Certificate) error {
+	policy, err := c.LintPolicy()
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, f := range LintCertificate(template, issuer, policy) {
+		if f.Level == LintError {
+			errs = append(errs, f.Rule+": "+f.Message)
+			continue
+		}
+		if c.Logf != nil {
+			c.Logf("lint warning for %s: %s: %s", template.URIs, f.Rule, f.Message)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrLint, strings.Join(errs, "; "))
+	}
+	return nil
+}
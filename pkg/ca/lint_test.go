@@ -0,0 +1,121 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLintLeaf(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// A cap longer than the intermediate's lifetime lets a request outlive
+	// its issuer; the lint stage must catch it.
+	cfg.MaxLeafValidity = 2 * DefaultValidityInter
+	_, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/long", DefaultValidityInter+time.Hour)
+	if !errors.Is(err, ErrLint) || !strings.Contains(err.Error(), "validity-within-issuer") {
+		t.Fatalf("leaf outliving its issuer: err = %v", err)
+	}
+	if recs, _ := cfg.IssuanceDB().List(IssuedFilter{}); len(recs) != 0 {
+		t.Errorf("refused leaf was recorded: %v", recs)
+	}
+
+	// Downgraded to a warning in ca.json, the leaf is issued and logged.
+	s, err := cfg.LoadSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Lint = LintPolicy{"validity-within-issuer": LintWarn}
+	if err := cfg.SaveSettings(s); err != nil {
+		t.Fatal(err)
+	}
+	var warnings []string
+	cfg.Logf = func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/long", DefaultValidityInter+time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "validity-within-issuer") {
+		t.Errorf("warnings = %q", warnings)
+	}
+
+	// Config.Lint overrides ca.json.
+	cfg.Lint = LintPolicy{}
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/long", DefaultValidityInter+time.Hour); !errors.Is(err, ErrLint) {
+		t.Errorf("Config.Lint did not override ca.json: %v", err)
+	}
+	cfg.Lint = LintPolicy{"no-such-rule": LintOff}
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/ok", 0); err == nil {
+		t.Error("issued with an unknown lint rule configured")
+	}
+
+	// Issued leaves lint clean.
+	cfg.Lint = nil
+	s.Lint = nil
+	if err := cfg.SaveSettings(s); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/ok", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificatePEM([]byte(certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := cfg.readCert("intermediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := LintCertificate(cert, issuer, nil); len(f) != 0 {
+		t.Errorf("issued leaf has findings: %v", f)
+	}
+
+	// Every rule fires on a bad certificate.
+	bad := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		NotBefore:    issuer.NotBefore.Add(-time.Hour),
+		NotAfter:     issuer.NotAfter.Add(time.Hour),
+		URIs:         []*url.URL{{Scheme: "https", Host: "example.com"}},
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		IsCA:         true,
+		PublicKey:    "not a key",
+	}
+	got := map[string]bool{}
+	for _, f := range LintCertificate(bad, issuer, nil) {
+		got[f.Rule] = true
+	}
+	var missing []string
+	for _, r := range LintRules() {
+		if !got[r.Name] {
+			missing = append(missing, r.Name)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("rules without findings: %v", missing)
+	}
+	signer := *cert
+	signer.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageOCSPSigning}
+	if f := LintCertificate(&signer, issuer, nil); len(f) != 1 || f[0].Rule != "ext-key-usage" {
+		t.Errorf("leaf with OCSPSigning: findings %v", f)
+	}
+	if f := LintCertificate(bad, nil, LintPolicy{"key-strength": LintOff}); len(f) == 0 {
+		t.Error("no findings without issuer")
+	} else {
+		for _, finding := range f {
+			if finding.Rule == "key-strength" {
+				t.Error("rule switched off still reported")
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+)
+
+// DefaultOCSPSignerValidity is the lifetime of delegated OCSP signing
+// certificates. They carry id-pkix-ocsp-nocheck, so relying parties cannot
+// check them for revocation; a short lifetime bounds a stolen responder key.
+const DefaultOCSPSignerValidity = 7 * 24 * time.Hour
+
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// OCSPSignerName is the key store name and certificate file stem of the
+// delegated OCSP signer for the intermediate named issuer.
+func OCSPSignerName(issuer string) string {
+	return issuer + "-ocsp"
+}
+
+func (c *Config) ocspSignerValidity() time.Duration {
+	if c.OCSPSignerValidity > 0 {
+		return c.OCSPSignerValidity
+	}
+	return DefaultOCSPSignerValidity
+}
+
+// IntermediateCertificate returns the certificate of the intermediate
+// generation named name (see Intermediates).
+func (c *Config) IntermediateCertificate(name string) (*x509.Certificate, error) {
+	return c.readCert(name)
+}
+
+// IssueOCSPSigner generates a key and has the intermediate named issuer
+// sign a delegated OCSP responder certificate for it (RFC 6960 section
+// 4.2.2.2), written to BaseDir/<issuer>-ocsp.{key,crt}. It is valid for
+// OCSPSignerValidity but never outlives the intermediate. The key is written
+// unencrypted whatever the key store, so the responder can load it without
+// access to the CA keys; its short lifetime bounds its exposure.
+func (c *Config) IssueOCSPSigner(issuer string, now time.Time) (crypto.Signer, *x509.Certificate, error) {
+	interCert, err := c.readCert(issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	interKey, err := c.keyStore().Signer(issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !publicKeysEqual(interKey.Public(), interCert.PublicKey) {
+		return nil, nil, fmt.Errorf("%s key does not match %s.crt", issuer, issuer)
+	}
+	name := OCSPSignerName(issuer)
+	// Ed25519 OCSP response signatures are not widely supported (nor by
+	// golang.org/x/crypto/ocsp), so such CAs get an ECDSA responder key.
+	alg := c.LeafKeyAlgorithm
+	if alg == Ed25519 {
+		alg = ECDSAP256
+	}
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	notAfter := now.Add(c.ocspSignerValidity())
+	if notAfter.After(interCert.NotAfter) {
+		notAfter = interCert.NotAfter
+	}
+	nocheck, _ := asn1.Marshal(asn1.NullRawValue)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Zero-Trust Demo"},
+			CommonName:   "OCSP Responder (" + issuer + ")",
+		},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidOCSPNoCheck, Value: nocheck}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, interCert, key.Public(), interKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := MarshalPrivateKeyPEM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The key goes first: the responder reloads when the certificate changes.
+	if err := writeFileAtomic(filepath.Join(c.BaseDir, name+".key"), keyPEM, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := c.writeCert(name, cert); err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+// OCSPSignerDue reports whether signer, a delegated OCSP certificate issued
+// by issuer, should be renewed: less than a third of its lifetime remains
+// and a new one would outlive it.
+func OCSPSignerDue(signer, issuer *x509.Certificate, now time.Time) bool {
+	lifetime := signer.NotAfter.Sub(signer.NotBefore)
+	return signer.NotAfter.Sub(now) < lifetime/3 && signer.NotAfter.Before(issuer.NotAfter)
+}
+
+// OCSPSigner loads the delegated OCSP signer of the intermediate named
+// issuer from BaseDir/<issuer>-ocsp.{key,crt}. It never issues one and needs
+// no CA key: see RenewOCSPSigners.
+func (c *Config) OCSPSigner(issuer string) (crypto.Signer, *x509.Certificate, error) {
+	name := OCSPSignerName(issuer)
+	cert, err := c.readCert(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := (&FileKeyStore{Dir: c.BaseDir}).Signer(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return nil, nil, fmt.Errorf("%s key does not match %s.crt", name, name)
+	}
+	return key, cert, nil
+}
+
+// RenewOCSPSigners issues a delegated OCSP signer with IssueOCSPSigner for
+// every unretired intermediate that has none, whose signer cannot be
+// loaded, or whose signer is OCSPSignerDue or expired. It returns the
+// intermediates that got a new signer; the RA and `ztca ocsp` call it so
+// the responder itself never holds an intermediate key.
+func (c *Config) RenewOCSPSigners(now time.Time) ([]string, error) {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return nil, err
+	}
+	var renewed []string
+	for _, rec := range recs {
+		if rec.Retired {
+			continue
+		}
+		interCert, err := c.readCert(rec.Name)
+		if err != nil {
+			return renewed, err
+		}
+		if _, cert, err := c.OCSPSigner(rec.Name); err == nil && !OCSPSignerDue(cert, interCert, now) && !now.After(cert.NotAfter) {
+			continue
+		}
+		if _, _, err := c.IssueOCSPSigner(rec.Name, now); err != nil {
+			return renewed, fmt.Errorf("OCSP signer for %s: %w", rec.Name, err)
+		}
+		renewed = append(renewed, rec.Name)
+	}
+	return renewed, nil
+}
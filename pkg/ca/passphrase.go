@@ -0,0 +1,103 @@
+package ca
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// PassphraseFunc supplies the passphrase protecting CA keys. It is only
+// called when a key actually needs encrypting or decrypting.
+type PassphraseFunc func() ([]byte, error)
+
+// PassphraseFromSpec returns a PassphraseFunc reading from one of:
+//
+//	env:<NAME>    environment variable
+//	fd:<N>        inherited file descriptor (read once)
+//	file:<PATH>   file contents
+//	prompt        interactive prompt on the terminal
+//
+// An empty spec returns nil. Trailing newlines are stripped. With confirm,
+// an interactive prompt asks twice and fails if the entries differ. The
+// passphrase is read at most once per returned func.
+func PassphraseFromSpec(spec string, confirm bool) (PassphraseFunc, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	kind, arg, _ := strings.Cut(spec, ":")
+	var read PassphraseFunc
+	switch kind {
+	case "env":
+		read = func() ([]byte, error) {
+			v, ok := os.LookupEnv(arg)
+			if !ok || v == "" {
+				return nil, fmt.Errorf("passphrase env var %s is not set", arg)
+			}
+			return []byte(v), nil
+		}
+	case "fd":
+		fd, err := strconv.Atoi(arg)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("passphrase fd: invalid descriptor %q", arg)
+		}
+		read = func() ([]byte, error) {
+			f := os.NewFile(uintptr(fd), "passphrase-fd")
+			if f == nil {
+				return nil, fmt.Errorf("passphrase fd %d is not open", fd)
+			}
+			defer f.Close()
+			return io.ReadAll(f)
+		}
+	case "file":
+		read = func() ([]byte, error) { return os.ReadFile(arg) }
+	case "prompt":
+		read = func() ([]byte, error) { return promptPassphrase(confirm) }
+	default:
+		return nil, fmt.Errorf("unknown passphrase source %q", spec)
+	}
+	var once sync.Once
+	var pass []byte
+	var err error
+	return func() ([]byte, error) {
+		once.Do(func() {
+			pass, err = read()
+			pass = bytes.TrimRight(pass, "\r\n")
+			if err == nil && len(pass) == 0 {
+				err = errors.New("empty passphrase")
+			}
+		})
+		return pass, err
+	}, nil
+}
+
+func promptPassphrase(confirm bool) ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("passphrase prompt needs a terminal: %w", err)
+	}
+	defer tty.Close()
+	ask := func(prompt string) ([]byte, error) {
+		fmt.Fprint(tty, prompt)
+		defer fmt.Fprintln(tty)
+		return term.ReadPassword(int(tty.Fd()))
+	}
+	pass, err := ask("CA key passphrase: ")
+	if err != nil || !confirm {
+		return pass, err
+	}
+	again, err := ask("Confirm passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, again) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
@@ -0,0 +1,316 @@
+//go:build pkcs11 && cgo
+
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 v3.0 identifiers not defined by github.com/miekg/pkcs11.
+const (
+	ckkECEdwards            = 0x00000040
+	ckmECEdwardsKeyPairGen  = 0x00001055
+	ckmEDDSA                = 0x00001057
+	pkcs11MaxObjectsPerFind = 2
+)
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidEd25519        = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// PKCS11KeyStore keeps CA keys on a PKCS#11 token. Keys are located by
+// CKA_LABEL equal to the key name and never leave the token.
+type PKCS11KeyStore struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+// OpenPKCS11KeyStore loads the module, opens a session on the named token and logs in.
+func OpenPKCS11KeyStore(uri string) (KeyStore, error) {
+	cfg, err := ParsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(cfg.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: cannot load module %s", cfg.ModulePath)
+	}
+	if err := ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return nil, fmt.Errorf("pkcs11: initialize: %w", err)
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: list slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimRight(info.Label, " \x00") != cfg.Token {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11: open session: %w", err)
+		}
+		if err := ctx.Login(session, pkcs11.CKU_USER, cfg.PIN); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			ctx.CloseSession(session)
+			return nil, fmt.Errorf("pkcs11: login: %w", err)
+		}
+		return &PKCS11KeyStore{ctx: ctx, session: session}, nil
+	}
+	return nil, fmt.Errorf("pkcs11: token %q not found", cfg.Token)
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == code
+}
+
+// Signer finds the private and public key objects labelled name.
+func (s *PKCS11KeyStore) Signer(name string) (crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	priv, err := s.findObject(pkcs11.CKO_PRIVATE_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+	pubObj, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := s.publicKey(pubObj)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: key %s: %w", name, err)
+	}
+	return &pkcs11Signer{store: s, handle: priv, pub: pub}, nil
+}
+
+// GenerateKey creates a non-extractable key pair on the token.
+func (s *PKCS11KeyStore) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	common := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, name),
+	}
+	pubTmpl := append([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true)}, common...)
+	privTmpl := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}, common...)
+
+	var mech uint
+	switch alg = alg.orDefault(); alg {
+	case RSA2048, RSA3072, RSA4096:
+		bits := map[KeyAlgorithm]int{RSA2048: 2048, RSA3072: 3072, RSA4096: 4096}[alg]
+		mech = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		pubTmpl = append(pubTmpl,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+	case ECDSAP256, ECDSAP384, Ed25519:
+		oid := map[KeyAlgorithm]asn1.ObjectIdentifier{ECDSAP256: oidNamedCurveP256, ECDSAP384: oidNamedCurveP384, Ed25519: oidEd25519}[alg]
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		mech = pkcs11.CKM_EC_KEY_PAIR_GEN
+		if alg == Ed25519 {
+			mech = ckmECEdwardsKeyPairGen
+		}
+		pubTmpl = append(pubTmpl, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %q", alg)
+	}
+
+	s.mu.Lock()
+	_, _, err := s.ctx.GenerateKeyPair(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)}, pubTmpl, privTmpl)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: generate %s: %w", name, err)
+	}
+	return s.Signer(name)
+}
+
+func (s *PKCS11KeyStore) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	tmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, tmpl); err != nil {
+		return 0, err
+	}
+	objs, _, err := s.ctx.FindObjects(s.session, pkcs11MaxObjectsPerFind)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, err
+	}
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("pkcs11: no object labelled %q", label)
+	case 1:
+		return objs[0], nil
+	default:
+		return 0, fmt.Errorf("pkcs11: multiple objects labelled %q", label)
+	}
+}
+
+func (s *PKCS11KeyStore) publicKey(obj pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, obj, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return nil, err
+	}
+	switch keyType := bytesToUint(attrs[0].Value); keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := s.ctx.GetAttributeValue(s.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	case pkcs11.CKK_EC, ckkECEdwards:
+		attrs, err := s.ctx.GetAttributeValue(s.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+			return nil, fmt.Errorf("EC params: %w", err)
+		}
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil {
+			return nil, fmt.Errorf("EC point: %w", err)
+		}
+		switch {
+		case oid.Equal(oidEd25519):
+			if len(point) != ed25519.PublicKeySize {
+				return nil, errors.New("bad Ed25519 public key length")
+			}
+			return ed25519.PublicKey(point), nil
+		case oid.Equal(oidNamedCurveP256):
+			return unmarshalECPoint(elliptic.P256(), point)
+		case oid.Equal(oidNamedCurveP384):
+			return unmarshalECPoint(elliptic.P384(), point)
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", oid)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %#x", keyType)
+	}
+}
+
+func unmarshalECPoint(curve elliptic.Curve, point []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// bytesToUint decodes a CK_ULONG attribute, which tokens return in host byte order.
+func bytesToUint(b []byte) uint {
+	switch len(b) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	default:
+		return 0
+	}
+}
+
+type pkcs11Signer struct {
+	store  *PKCS11KeyStore
+	handle pkcs11.ObjectHandle
+	pub    crypto.PublicKey
+}
+
+func (p *pkcs11Signer) Public() crypto.PublicKey { return p.pub }
+
+// DER DigestInfo prefixes for PKCS#1 v1.5 signatures (RFC 8017 section 9.2).
+var pkcs1DigestInfoPrefix = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+var pssParams = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+func (p *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	input := digest
+	switch p.pub.(type) {
+	case *rsa.PublicKey:
+		h := opts.HashFunc()
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			params, ok := pssParams[h]
+			if !ok {
+				return nil, fmt.Errorf("pkcs11: unsupported PSS hash %v", h)
+			}
+			salt := pss.SaltLength
+			if salt == rsa.PSSSaltLengthEqualsHash || salt == rsa.PSSSaltLengthAuto {
+				salt = h.Size()
+			}
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(params[0], params[1], uint(salt)))
+		} else {
+			prefix, ok := pkcs1DigestInfoPrefix[h]
+			if !ok {
+				return nil, fmt.Errorf("pkcs11: unsupported hash %v", h)
+			}
+			input = append(append([]byte{}, prefix...), digest...)
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		}
+	case *ecdsa.PublicKey:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case ed25519.PublicKey:
+		mech = pkcs11.NewMechanism(ckmEDDSA, nil)
+	default:
+		return nil, fmt.Errorf("pkcs11: unsupported key type %T", p.pub)
+	}
+
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	if err := p.store.ctx.SignInit(p.store.session, []*pkcs11.Mechanism{mech}, p.handle); err != nil {
+		return nil, fmt.Errorf("pkcs11: sign init: %w", err)
+	}
+	sig, err := p.store.ctx.Sign(p.store.session, input)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: sign: %w", err)
+	}
+	if _, ok := p.pub.(*ecdsa.PublicKey); ok {
+		// PKCS#11 returns r||s; x509 expects an ASN.1 ECDSA-Sig-Value.
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:half]),
+			new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
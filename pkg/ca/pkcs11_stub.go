@@ -0,0 +1,13 @@
+//go:build !pkcs11 || !cgo
+
+package ca
+
+import "errors"
+
+// OpenPKCS11KeyStore is unavailable in this build; rebuild with -tags pkcs11 and cgo enabled.
+func OpenPKCS11KeyStore(uri string) (KeyStore, error) {
+	if _, err := ParsePKCS11URI(uri); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("pkcs11 key store not compiled in (rebuild with -tags pkcs11 and CGO_ENABLED=1)")
+}
@@ -0,0 +1,53 @@
+//go:build pkcs11 && cgo
+
+package ca
+
+import (
+	"crypto"
+	"os"
+	"testing"
+)
+
+// TestPKCS11KeyStore runs against a real token, e.g. SoftHSM:
+//
+//	softhsm2-util --init-token --free --label ztca --pin 1234 --so-pin 1234
+//	PKCS11_TEST_URI='pkcs11:token=ztca?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234' \
+//	  go test -tags pkcs11 ./pkg/ca -run PKCS11
+func TestPKCS11KeyStore(t *testing.T) {
+	uri := os.Getenv("PKCS11_TEST_URI")
+	if uri == "" {
+		t.Skip("PKCS11_TEST_URI not set")
+	}
+	ks, err := OpenKeyStore(uri, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []KeyAlgorithm{RSA2048, ECDSAP256} {
+		t.Run(string(alg), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := Config{BaseDir: dir, RootKeyAlgorithm: alg, IntermediateKeyAlgorithm: alg, KeyStore: &prefixKeyStore{ks, dir}}
+			if err := cfg.Init(); err != nil {
+				t.Fatal(err)
+			}
+			certPEM, _, chainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyLeafChain(t, dir, certPEM, chainPEM)
+		})
+	}
+}
+
+// prefixKeyStore keeps labels unique across test runs on a persistent token.
+type prefixKeyStore struct {
+	KeyStore
+	prefix string
+}
+
+func (p *prefixKeyStore) Signer(name string) (crypto.Signer, error) {
+	return p.KeyStore.Signer(p.prefix + "/" + name)
+}
+
+func (p *prefixKeyStore) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	return p.KeyStore.GenerateKey(p.prefix+"/"+name, alg)
+}
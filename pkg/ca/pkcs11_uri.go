@@ -0,0 +1,65 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// PKCS11Config identifies a token and how to log in to it.
+type PKCS11Config struct {
+	ModulePath string
+	Token      string
+	PIN        string
+}
+
+// ParsePKCS11URI parses an RFC 7512 style URI such as
+//
+//	pkcs11:token=ztca?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/run/secrets/pin
+//
+// Supported attributes are token, module-path, pin-value and pin-source
+// (a file path, optionally prefixed with file:). The PKCS11_PIN environment
+// variable is used when neither pin attribute is present.
+func ParsePKCS11URI(uri string) (*PKCS11Config, error) {
+	rest, ok := strings.CutPrefix(uri, "pkcs11:")
+	if !ok {
+		return nil, fmt.Errorf("not a pkcs11 URI: %q", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	attrs := map[string]string{}
+	for _, part := range append(strings.Split(path, ";"), strings.Split(query, "&")...) {
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, "=")
+		v, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11 URI attribute %s: %w", k, err)
+		}
+		attrs[k] = v
+	}
+	cfg := &PKCS11Config{
+		ModulePath: attrs["module-path"],
+		Token:      attrs["token"],
+		PIN:        attrs["pin-value"],
+	}
+	if src := attrs["pin-source"]; src != "" {
+		data, err := os.ReadFile(strings.TrimPrefix(src, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11 pin-source: %w", err)
+		}
+		cfg.PIN = strings.TrimRight(string(data), "\r\n")
+	}
+	if cfg.PIN == "" {
+		cfg.PIN = os.Getenv("PKCS11_PIN")
+	}
+	if cfg.ModulePath == "" {
+		return nil, errors.New("pkcs11 URI: module-path is required")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("pkcs11 URI: token is required")
+	}
+	return cfg, nil
+}
@@ -0,0 +1,362 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProfile is the profile used when a request names none.
+const DefaultProfile = "default"
+
+const profilesFile = "profiles.json"
+
+// Profile is a named leaf certificate template. Profiles are loaded from
+// Config.ProfilesFile (default BaseDir/profiles.json):
+//
+//	{
+//	  "profiles": {
+//	    "server": {"ext_key_usages": ["serverAuth"], "allowed_dns_names": ["*.lb.internal"]},
+//	    "batch":  {"ext_key_usages": ["clientAuth"], "max_ttl": "1h"}
+//	  }
+//	}
+//
+// Unset fields keep the built-in default: subject O="Zero-Trust Demo" with
+// the SPIFFE ID as CN, digitalSignature (plus keyEncipherment for RSA keys),
+// serverAuth+clientAuth, DefaultValidityLeaf, and no DNS or IP SANs. A
+// profile named "default" replaces the built-in one.
+type Profile struct {
+	Subject      *ProfileSubject `json:"subject,omitempty"`
+	KeyUsages    []string        `json:"key_usages,omitempty"`
+	ExtKeyUsages []string        `json:"ext_key_usages,omitempty"`
+	DefaultTTL   Duration        `json:"default_ttl,omitempty"`
+	MaxTTL       Duration        `json:"max_ttl,omitempty"`
+	// AllowedDNSNames lists the DNS SANs a request may carry: exact names
+	// or "*.<domain>" for any single label under domain.
+	AllowedDNSNames []string `json:"allowed_dns_names,omitempty"`
+	// AllowedIPRanges lists CIDR ranges IP SANs must fall in.
+	AllowedIPRanges []string           `json:"allowed_ip_ranges,omitempty"`
+	Extensions      []ProfileExtension `json:"extensions,omitempty"`
+}
+
+// ProfileSubject overrides the leaf subject. An empty CommonName uses the
+// SPIFFE ID.
+type ProfileSubject struct {
+	CommonName         string   `json:"common_name,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizational_unit,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+}
+
+// ProfileExtension is an extra extension copied verbatim into the leaf.
+type ProfileExtension struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical,omitempty"`
+	Value    string `json:"value"` // base64 DER
+}
+
+// Duration is a time.Duration that reads and writes JSON as "24h" strings.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LeafRequest describes one leaf certificate to issue.
+type LeafRequest struct {
+	SPIFFEID string
+	Profile  string        // "" selects DefaultProfile
+	Validity time.Duration // 0 selects the profile's default TTL
+	// Extra SANs, checked against the profile. SignCSRRequest takes these
+	// from the CSR instead.
+	DNSNames    []string
+	IPAddresses []net.IP
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+}
+
+// extKeyUsageNames leaves out OCSPSigning: a leaf holding it would be a
+// delegated OCSP responder for its intermediate, able to vouch for revoked
+// certificates. Only the CA issues such signers.
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+}
+
+// reservedExtensions are set by the CA itself and may not appear in a
+// profile's extra extensions.
+var reservedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},              // subjectKeyIdentifier
+	{2, 5, 29, 15},              // keyUsage
+	{2, 5, 29, 17},              // subjectAltName
+	{2, 5, 29, 19},              // basicConstraints
+	{2, 5, 29, 30},              // nameConstraints
+	{2, 5, 29, 31},              // cRLDistributionPoints
+	{2, 5, 29, 35},              // authorityKeyIdentifier
+	{2, 5, 29, 37},              // extKeyUsage
+	{1, 3, 6, 1, 5, 5, 7, 1, 1}, // authorityInfoAccess
+}
+
+type profileFile struct {
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+func (c *Config) profilesPath() string {
+	if c.ProfilesFile != "" {
+		return c.ProfilesFile
+	}
+	return filepath.Join(c.BaseDir, profilesFile)
+}
+
+// Profiles returns every available profile, including the built-in default.
+func (c *Config) Profiles() (map[string]Profile, error) {
+	profiles := map[string]Profile{DefaultProfile: {}}
+	data, err := os.ReadFile(c.profilesPath())
+	if errors.Is(err, os.ErrNotExist) && c.ProfilesFile == "" {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f profileFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(c.profilesPath()), err)
+	}
+	for name, p := range f.Profiles {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// Profile returns the named profile ("" for DefaultProfile).
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	profiles, err := c.Profiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown certificate profile %q", name)
+	}
+	return p, nil
+}
+
+// Validate checks that every field of p is understood.
+func (p Profile) Validate() error {
+	for _, ku := range p.KeyUsages {
+		if _, ok := keyUsageNames[ku]; !ok {
+			return fmt.Errorf("unknown key usage %q", ku)
+		}
+	}
+	for _, eku := range p.ExtKeyUsages {
+		if eku == "OCSPSigning" {
+			return errors.New("extended key usage OCSPSigning is reserved for OCSP signers")
+		}
+		if _, ok := extKeyUsageNames[eku]; !ok {
+			return fmt.Errorf("unknown extended key usage %q", eku)
+		}
+	}
+	if p.DefaultTTL < 0 || p.MaxTTL < 0 || (p.MaxTTL > 0 && p.DefaultTTL > p.MaxTTL) {
+		return errors.New("default_ttl must not exceed max_ttl")
+	}
+	for _, r := range p.AllowedIPRanges {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("allowed IP range %q: %w", r, err)
+		}
+	}
+	_, err := p.extensions()
+	return err
+}
+
+func (p Profile) extensions() ([]pkix.Extension, error) {
+	var exts []pkix.Extension
+	for _, e := range p.Extensions {
+		oid, err := parseOID(e.OID)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range reservedExtensions {
+			if oid.Equal(r) {
+				return nil, fmt.Errorf("extension %s is set by the CA and cannot be overridden", e.OID)
+			}
+		}
+		value, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("extension %s value: %w", e.OID, err)
+		}
+		exts = append(exts, pkix.Extension{Id: oid, Critical: e.Critical, Value: value})
+	}
+	return exts, nil
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || strconv.Itoa(n) != part {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+// validity resolves the requested lifetime against the profile and the CA's
+// overall cap.
+func (p Profile) validity(requested, caMax time.Duration) (time.Duration, error) {
+	max := caMax
+	if p.MaxTTL > 0 && time.Duration(p.MaxTTL) < max {
+		max = time.Duration(p.MaxTTL)
+	}
+	v := requested
+	if v == 0 {
+		v = DefaultValidityLeaf
+		if p.DefaultTTL > 0 {
+			v = time.Duration(p.DefaultTTL)
+		}
+		if v > max {
+			v = max
+		}
+	}
+	if v > max {
+		return 0, fmt.Errorf("leaf validity %v exceeds maximum %v", v, max)
+	}
+	return v, nil
+}
+
+// checkSANs rejects DNS and IP SANs outside the profile's allowances.
+func (p Profile) checkSANs(req LeafRequest) error {
+	for _, name := range req.DNSNames {
+		if !matchDNSPatterns(strings.ToLower(name), p.AllowedDNSNames) {
+			return fmt.Errorf("DNS SAN %q is not allowed by the profile", name)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !ipAllowed(ip, p.AllowedIPRanges) {
+			return fmt.Errorf("IP SAN %s is not allowed by the profile", ip)
+		}
+	}
+	return nil
+}
+
+// template builds the leaf template for req and pub, without serial or
+// validity period.
+func (p Profile) template(req LeafRequest, uri *url.URL, pub crypto.PublicKey) (*x509.Certificate, error) {
+	if err := p.checkSANs(req); err != nil {
+		return nil, err
+	}
+	subject := pkix.Name{Organization: []string{"Zero-Trust Demo"}, CommonName: uri.String()}
+	if s := p.Subject; s != nil {
+		subject = pkix.Name{
+			CommonName:         s.CommonName,
+			Organization:       s.Organization,
+			OrganizationalUnit: s.OrganizationalUnit,
+			Country:            s.Country,
+			Locality:           s.Locality,
+		}
+		if subject.CommonName == "" {
+			subject.CommonName = uri.String()
+		}
+	}
+	keyUsage := leafKeyUsage(pub)
+	if len(p.KeyUsages) > 0 {
+		keyUsage = 0
+		_, isRSA := pub.(*rsa.PublicKey)
+		for _, ku := range p.KeyUsages {
+			// Key encipherment only makes sense for RSA key transport.
+			if ku == "keyEncipherment" && !isRSA {
+				continue
+			}
+			keyUsage |= keyUsageNames[ku]
+		}
+	}
+	extKeyUsage := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	if len(p.ExtKeyUsages) > 0 {
+		extKeyUsage = nil
+		for _, eku := range p.ExtKeyUsages {
+			extKeyUsage = append(extKeyUsage, extKeyUsageNames[eku])
+		}
+	}
+	exts, err := p.extensions()
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		Subject:         subject,
+		KeyUsage:        keyUsage,
+		ExtKeyUsage:     extKeyUsage,
+		URIs:            []*url.URL{uri},
+		DNSNames:        req.DNSNames,
+		IPAddresses:     req.IPAddresses,
+		ExtraExtensions: exts,
+	}, nil
+}
+
+func matchDNSPatterns(name string, patterns []string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if suffix, ok := strings.CutPrefix(p, "*."); ok {
+			label, rest, found := strings.Cut(name, ".")
+			if found && label != "" && rest == suffix {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+func ipAllowed(ip net.IP, ranges []string) bool {
+	for _, r := range ranges {
+		if _, ipNet, err := net.ParseCIDR(r); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package ca
+
+import (
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProfiles = `{
+  "profiles": {
+    "server": {
+      "ext_key_usages": ["serverAuth"],
+      "allowed_dns_names": ["*.lb.internal", "api.example.com"],
+      "allowed_ip_ranges": ["10.0.0.0/8"]
+    },
+    "batch": {
+      "subject": {"organization": ["Batch"], "organizational_unit": ["jobs"]},
+      "key_usages": ["digitalSignature", "keyEncipherment"],
+      "ext_key_usages": ["clientAuth"],
+      "default_ttl": "30m",
+      "max_ttl": "1h",
+      "extensions": [{"oid": "1.3.6.1.4.1.99999.1", "value": "BQA="}]
+    }
+  }
+}`
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "profiles.json"), []byte(testProfiles), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const id = "spiffe://demo/ns/default/sa/test"
+
+	certPEM, _, chainPEM, _, err := cfg.IssueLeafRequest(LeafRequest{
+		SPIFFEID: id, Profile: "server",
+		DNSNames:    []string{"a.lb.internal", "api.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.1.2.3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyLeafChain(t, dir, certPEM, chainPEM)
+	leaf, _ := ParseCertificatePEM([]byte(certPEM))
+	if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("server EKU = %v", leaf.ExtKeyUsage)
+	}
+	if len(leaf.DNSNames) != 2 || len(leaf.IPAddresses) != 1 || len(leaf.URIs) != 1 {
+		t.Errorf("server SANs: dns=%v ip=%v uri=%v", leaf.DNSNames, leaf.IPAddresses, leaf.URIs)
+	}
+
+	certPEM, _, _, _, err = cfg.IssueLeafRequest(LeafRequest{SPIFFEID: id, Profile: "batch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ = ParseCertificatePEM([]byte(certPEM))
+	if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("batch EKU = %v", leaf.ExtKeyUsage)
+	}
+	if leaf.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("batch key usage on an EC key = %v", leaf.KeyUsage)
+	}
+	if leaf.Subject.Organization[0] != "Batch" || leaf.Subject.CommonName != id {
+		t.Errorf("batch subject = %v", leaf.Subject)
+	}
+	if ttl := leaf.NotAfter.Sub(leaf.NotBefore); ttl != 30*time.Minute {
+		t.Errorf("batch default TTL = %v", ttl)
+	}
+	found := false
+	for _, ext := range leaf.Extensions {
+		found = found || ext.Id.String() == "1.3.6.1.4.1.99999.1"
+	}
+	if !found {
+		t.Error("batch extra extension missing")
+	}
+
+	rejects := map[string]LeafRequest{
+		"over profile max TTL": {SPIFFEID: id, Profile: "batch", Validity: 2 * time.Hour},
+		"DNS SAN in default":   {SPIFFEID: id, DNSNames: []string{"a.lb.internal"}},
+		"DNS SAN not allowed":  {SPIFFEID: id, Profile: "server", DNSNames: []string{"a.b.lb.internal"}},
+		"IP SAN not allowed":   {SPIFFEID: id, Profile: "server", IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}},
+		"unknown profile":      {SPIFFEID: id, Profile: "nope"},
+	}
+	for name, req := range rejects {
+		if _, _, _, _, err := cfg.IssueLeafRequest(req); err == nil {
+			t.Errorf("%s: issued", name)
+		}
+	}
+
+	// CSR SANs are checked against the profile too.
+	key, _ := GenerateKey(ECDSAP256)
+	csrPEM, _ := CreateLeafCSR(key, LeafRequest{SPIFFEID: id, DNSNames: []string{"b.lb.internal"}})
+	if _, _, _, err := cfg.SignCSRRequest(csrPEM, LeafRequest{SPIFFEID: id, Profile: "server"}); err != nil {
+		t.Errorf("server CSR: %v", err)
+	}
+	if _, _, _, err := cfg.SignCSR(csrPEM, id, 0); err == nil {
+		t.Error("default profile signed a CSR with a DNS SAN")
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	bad := map[string]Profile{
+		"key usage":      {KeyUsages: []string{"certSign"}},
+		"ext key usage":  {ExtKeyUsages: []string{"anything"}},
+		"ocsp signing":   {ExtKeyUsages: []string{"clientAuth", "OCSPSigning"}},
+		"ttl order":      {DefaultTTL: Duration(2 * time.Hour), MaxTTL: Duration(time.Hour)},
+		"ip range":       {AllowedIPRanges: []string{"10.0.0.1"}},
+		"reserved ext":   {Extensions: []ProfileExtension{{OID: "2.5.29.19", Value: "MAA="}}},
+		"bad oid":        {Extensions: []ProfileExtension{{OID: "1.x", Value: ""}}},
+		"bad ext base64": {Extensions: []ProfileExtension{{OID: "1.2.3", Value: "!"}}},
+	}
+	for name, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if !matchDNSPatterns("a.lb.internal", []string{"*.LB.internal"}) || matchDNSPatterns("lb.internal", []string{"*.lb.internal"}) {
+		t.Error("wildcard DNS pattern matching")
+	}
+	if _, err := (&Config{BaseDir: t.TempDir(), ProfilesFile: "/nonexistent/profiles.json"}).Profiles(); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("missing explicit profiles file: %v", err)
+	}
+}
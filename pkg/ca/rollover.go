@@ -0,0 +1,299 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Root rollover replaces the root without a flag day. On the root host,
+// RolloverRoot creates the next root generation and two cross-certificates:
+//
+//	<new>-by-<old>.crt   new root's key and subject, issued by the old root
+//	<old>-by-<new>.crt   old root's key and subject, issued by the new root
+//
+// On the online CA, StageRootRollover installs them as root-next.crt,
+// cross-next.crt and cross-prev.crt and publishes a transitional trust
+// bundle carrying both roots. Intermediates signed by the new root then ship
+// cross-next.crt in every leaf chain, so relying parties still holding the
+// old bundle can reach the old root. FinishRootRollover promotes the new
+// root and drops the old one; intermediates still signed by the old root
+// validate through cross-prev.crt until they are retired.
+
+const rootsFile = "roots.json"
+
+// RootRecord is one root generation on the root host.
+type RootRecord struct {
+	Name     string    `json:"name"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// RootRollover describes the artifacts written by RolloverRoot.
+type RootRollover struct {
+	Old, New     RootRecord
+	NewRootPEM   []byte
+	NewByOldPEM  []byte
+	OldByNewPEM  []byte
+	Transitional string // path of the bundle with both roots
+	Final        string // path of the bundle with the new root only
+}
+
+// Roots returns the root generations in BaseDir, oldest first. The last one
+// signs intermediates.
+func (c *Config) Roots() ([]RootRecord, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, rootsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		cert, err := c.readCert("root")
+		if err != nil {
+			return nil, err
+		}
+		return []RootRecord{newRootRecord("root", cert)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []RootRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, fmt.Errorf("%s: %w", rootsFile, err)
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("%s: no roots", rootsFile)
+	}
+	return recs, nil
+}
+
+func newRootRecord(name string, cert *x509.Certificate) RootRecord {
+	return RootRecord{Name: name, Serial: fmt.Sprintf("%X", cert.SerialNumber), NotAfter: cert.NotAfter}
+}
+
+// RolloverRoot creates the next root generation in BaseDir, cross-signs it
+// with the current root in both directions and writes the transitional and
+// final trust bundles. The new root becomes the one SignIntermediate uses.
+func (c *Config) RolloverRoot() (*RootRollover, error) {
+	recs, err := c.Roots()
+	if err != nil {
+		return nil, err
+	}
+	oldRec := recs[len(recs)-1]
+	oldKey, oldCert, err := c.loadRoot()
+	if err != nil {
+		return nil, err
+	}
+	gen := len(recs) + 1
+	newName := "root-" + strconv.Itoa(gen)
+	newKey, err := c.keyStore().GenerateKey(newName, c.RootKeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	newCert, err := createRootCA(newKey, "Root CA "+strconv.Itoa(gen))
+	if err != nil {
+		return nil, err
+	}
+	newByOld, err := crossSign(newCert, oldCert, oldKey)
+	if err != nil {
+		return nil, err
+	}
+	oldByNew, err := crossSign(oldCert, newCert, newKey)
+	if err != nil {
+		return nil, err
+	}
+	rr := &RootRollover{
+		Old:          oldRec,
+		New:          newRootRecord(newName, newCert),
+		NewRootPEM:   encodeCertPEM(newCert),
+		NewByOldPEM:  encodeCertPEM(newByOld),
+		OldByNewPEM:  encodeCertPEM(oldByNew),
+		Transitional: filepath.Join(c.BaseDir, "trust-bundle-transition.pem"),
+		Final:        filepath.Join(c.BaseDir, "trust-bundle-final.pem"),
+	}
+	files := map[string][]byte{
+		newName + ".crt":                        rr.NewRootPEM,
+		newName + "-by-" + oldRec.Name + ".crt": rr.NewByOldPEM,
+		oldRec.Name + "-by-" + newName + ".crt": rr.OldByNewPEM,
+		filepath.Base(rr.Transitional):          append(encodeCertPEM(oldCert), rr.NewRootPEM...),
+		filepath.Base(rr.Final):                 append(append([]byte{}, rr.NewRootPEM...), rr.OldByNewPEM...),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(c.BaseDir, name), data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.MarshalIndent(append(recs, rr.New), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(c.BaseDir, rootsFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+// crossSign issues a CA certificate for subject's name and key, signed by issuer.
+func crossSign(subject, issuer *x509.Certificate, issuerKey crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	notAfter := subject.NotAfter
+	if issuer.NotAfter.Before(notAfter) {
+		notAfter = issuer.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject.Subject,
+		SubjectKeyId:          subject.SubjectKeyId,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, subject.PublicKey, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// StageRootRollover installs the next root and its cross-certificates in the
+// online CA and publishes the transitional trust bundle (both roots).
+// Intermediates signed by the new root may be staged afterwards.
+func (c *Config) StageRootRollover(newRootPEM, newByOldPEM, oldByNewPEM []byte) error {
+	oldRoot, err := c.readCert("root")
+	if err != nil {
+		return err
+	}
+	newRoot, err := ParseCertificatePEM(newRootPEM)
+	if err != nil {
+		return fmt.Errorf("new root: %w", err)
+	}
+	newByOld, err := ParseCertificatePEM(newByOldPEM)
+	if err != nil {
+		return fmt.Errorf("new-by-old cross certificate: %w", err)
+	}
+	oldByNew, err := ParseCertificatePEM(oldByNewPEM)
+	if err != nil {
+		return fmt.Errorf("old-by-new cross certificate: %w", err)
+	}
+	if !newRoot.IsCA || newRoot.CheckSignatureFrom(newRoot) != nil {
+		return errors.New("new root is not a self-signed CA")
+	}
+	if err := checkCrossCert(newByOld, newRoot, oldRoot); err != nil {
+		return fmt.Errorf("new-by-old cross certificate: %w", err)
+	}
+	if err := checkCrossCert(oldByNew, oldRoot, newRoot); err != nil {
+		return fmt.Errorf("old-by-new cross certificate: %w", err)
+	}
+	for name, cert := range map[string]*x509.Certificate{"root-next": newRoot, "cross-next": newByOld, "cross-prev": oldByNew} {
+		if err := c.writeCert(name, cert); err != nil {
+			return err
+		}
+	}
+	recs, err := c.Intermediates()
+	if err != nil {
+		return err
+	}
+	return c.publishTrustBundle(recs)
+}
+
+func checkCrossCert(cross, subject, issuer *x509.Certificate) error {
+	if !publicKeysEqual(cross.PublicKey, subject.PublicKey) {
+		return errors.New("public key does not match the certified root")
+	}
+	if string(cross.RawSubject) != string(subject.RawSubject) {
+		return errors.New("subject does not match the certified root")
+	}
+	if !cross.IsCA {
+		return errors.New("not a CA certificate")
+	}
+	return cross.CheckSignatureFrom(issuer)
+}
+
+// FinishRootRollover promotes root-next.crt to root.crt and publishes the
+// final trust bundle without the old root.
+func (c *Config) FinishRootRollover() error {
+	newRoot, err := c.readCert("root-next")
+	if err != nil {
+		return fmt.Errorf("no root rollover in progress: %w", err)
+	}
+	oldRoot, err := c.readCert("root")
+	if err != nil {
+		return err
+	}
+	if err := c.writeCert("root-prev", oldRoot); err != nil {
+		return err
+	}
+	if err := c.writeCert("root", newRoot); err != nil {
+		return err
+	}
+	for _, name := range []string{"root-next.crt", "cross-next.crt"} {
+		if err := os.Remove(filepath.Join(c.BaseDir, name)); err != nil {
+			return err
+		}
+	}
+	recs, err := c.Intermediates()
+	if err != nil {
+		return err
+	}
+	return c.publishTrustBundle(recs)
+}
+
+// trustAnchors returns the root certificates relying parties should trust:
+// root.crt, root-next.crt during a rollover, and cross-prev.crt after one
+// while any unretired intermediate still chains to the previous root.
+func (c *Config) trustAnchors(inters []*x509.Certificate) ([]*x509.Certificate, error) {
+	root, err := c.readCert("root")
+	if err != nil {
+		return nil, err
+	}
+	anchors := []*x509.Certificate{root}
+	if next, err := c.readCert("root-next"); err == nil {
+		return append(anchors, next), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	crossPrev, err := c.readCert("cross-prev")
+	if errors.Is(err, os.ErrNotExist) {
+		return anchors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, inter := range inters {
+		if inter.CheckSignatureFrom(root) != nil && inter.CheckSignatureFrom(crossPrev) == nil {
+			return append(anchors, crossPrev), nil
+		}
+	}
+	return anchors, nil
+}
+
+// crossChainPEM returns the cross-certificate that must follow inter in leaf
+// chains, if any: during a rollover, intermediates signed by the new root
+// need cross-next.crt to reach the old root.
+func (c *Config) crossChainPEM(inter *x509.Certificate) ([]byte, error) {
+	next, err := c.readCert("root-next")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inter.CheckSignatureFrom(next) != nil {
+		return nil, nil
+	}
+	return os.ReadFile(filepath.Join(c.BaseDir, "cross-next.crt"))
+}
+
+func encodeCertPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
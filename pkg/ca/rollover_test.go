@@ -0,0 +1,152 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRootRollover(t *testing.T) {
+	rootDir, raDir := t.TempDir(), t.TempDir()
+	root := Config{BaseDir: rootDir, RootKeyAlgorithm: ECDSAP256}
+	ra := Config{BaseDir: raDir, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := root.InitRoot(); err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := ra.CreateIntermediateCSR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := root.SignIntermediate(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRootPEM, err := os.ReadFile(filepath.Join(rootDir, "root.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.InstallIntermediate(certPEM, oldRootPEM); err != nil {
+		t.Fatal(err)
+	}
+	oldBundle := readFile(t, filepath.Join(raDir, "trust-bundle.pem"))
+	leaf1, _, chain1, _, err := ra.IssueLeaf("spiffe://demo/ns/default/sa/one", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := root.RolloverRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.New.Name != "root-2" {
+		t.Errorf("new root name = %q", rr.New.Name)
+	}
+	if err := ra.StageRootRollover(rr.NewRootPEM, rr.NewByOldPEM, rr.OldByNewPEM); err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.StageRootRollover(rr.NewRootPEM, rr.OldByNewPEM, rr.NewByOldPEM); err == nil {
+		t.Error("accepted swapped cross certificates")
+	}
+	if _, err := ra.RotateIntermediate(&root, 0); err != nil {
+		t.Fatal(err)
+	}
+	leaf2, _, chain2, _, err := ra.IssueLeaf("spiffe://demo/ns/default/sa/two", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, _ := ParseCertificatePEM(rr.NewRootPEM)
+	inter2, err := ra.readCert("intermediate-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inter2.CheckSignatureFrom(issuer); err != nil {
+		t.Fatalf("rotated intermediate not signed by the new root: %v", err)
+	}
+
+	// Relying parties that never refreshed their bundle reach the old root
+	// through the cross certificate in the leaf chain.
+	if err := verifyWithBundle(oldBundle, leaf2, chain2); err != nil {
+		t.Errorf("new leaf against old bundle: %v", err)
+	}
+	transition := readFile(t, filepath.Join(raDir, "trust-bundle.pem"))
+	for _, b := range [][]byte{transition, readFile(t, rr.Transitional)} {
+		for _, l := range [][2]string{{leaf1, chain1}, {leaf2, chain2}} {
+			if err := verifyWithBundle(b, l[0], l[1]); err != nil {
+				t.Errorf("transition bundle: %v", err)
+			}
+		}
+	}
+
+	if err := ra.FinishRootRollover(); err != nil {
+		t.Fatal(err)
+	}
+	final := readFile(t, filepath.Join(raDir, "trust-bundle.pem"))
+	for _, b := range [][]byte{final, readFile(t, rr.Final)} {
+		if err := verifyWithBundle(b, leaf1, chain1); err != nil {
+			t.Errorf("old leaf against final bundle: %v", err)
+		}
+		if err := verifyWithBundle(b, leaf2, chain2); err != nil {
+			t.Errorf("new leaf against final bundle: %v", err)
+		}
+	}
+	oldRoot, _ := ParseCertificatePEM(oldRootPEM)
+	for rest := final; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if string(block.Bytes) == string(oldRoot.Raw) {
+			t.Error("final trust bundle still contains the old self-signed root")
+		}
+	}
+
+	// Once the old intermediate retires, the cross certificate goes too.
+	if _, err := ra.RetireIntermediates(time.Now().Add(DefaultMaxValidityLeaf + time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countPEMCerts(t, filepath.Join(raDir, "trust-bundle.pem")); n != 2 {
+		t.Errorf("trust bundle after retirement has %d certs, want 2", n)
+	}
+}
+
+// verifyWithBundle verifies a leaf using only the self-signed certificates
+// in bundle as roots; everything else in bundle and chain is untrusted.
+func verifyWithBundle(bundle []byte, certPEM, chainPEM string) error {
+	roots := x509.NewCertPool()
+	inters := x509.NewCertPool()
+	for i, data := range [][]byte{bundle, []byte(chainPEM)} {
+		for rest := data; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return err
+			}
+			if i == 0 && cert.CheckSignatureFrom(cert) == nil {
+				roots.AddCert(cert)
+			} else {
+				inters.AddCert(cert)
+			}
+		}
+	}
+	leaf, err := ParseCertificatePEM([]byte(certPEM))
+	if err != nil {
+		return err
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inters, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,332 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxValidityLeaf bounds leaf lifetimes so a rotated-out intermediate
+// can be retired a known time after its successor takes over.
+const DefaultMaxValidityLeaf = 7 * 24 * time.Hour
+
+const intermediatesFile = "intermediates.json"
+
+// IntermediateRecord tracks one Intermediate CA generation. Name is both the
+// key store name and the certificate file stem (<Name>.crt in BaseDir).
+//
+// A record is pending until ActivateAt, then signs leaves until a newer
+// record activates. Once RetireAt passes, every leaf it signed has expired
+// and it is dropped from the trust bundle.
+type IntermediateRecord struct {
+	Name       string    `json:"name"`
+	Serial     string    `json:"serial"`
+	NotAfter   time.Time `json:"not_after"`
+	ActivateAt time.Time `json:"activate_at"`
+	RetireAt   time.Time `json:"retire_at,omitempty"`
+	Retired    bool      `json:"retired,omitempty"`
+}
+
+type intermediateState struct {
+	Intermediates []IntermediateRecord `json:"intermediates"`
+}
+
+func (c *Config) maxLeafValidity() time.Duration {
+	if c.MaxLeafValidity > 0 {
+		return c.MaxLeafValidity
+	}
+	return DefaultMaxValidityLeaf
+}
+
+// Intermediates returns every intermediate generation, oldest first. A CA
+// directory created before rotation support yields a single record for
+// intermediate.crt.
+func (c *Config) Intermediates() ([]IntermediateRecord, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, intermediatesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		cert, err := c.readCert("intermediate")
+		if err != nil {
+			return nil, err
+		}
+		return []IntermediateRecord{newIntermediateRecord("intermediate", cert, cert.NotBefore)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st intermediateState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", intermediatesFile, err)
+	}
+	if len(st.Intermediates) == 0 {
+		return nil, fmt.Errorf("%s: no intermediates", intermediatesFile)
+	}
+	return st.Intermediates, nil
+}
+
+func (c *Config) saveIntermediates(recs []IntermediateRecord) error {
+	data, err := json.MarshalIndent(intermediateState{Intermediates: recs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.BaseDir, intermediatesFile), data, 0644)
+}
+
+func newIntermediateRecord(name string, cert *x509.Certificate, activateAt time.Time) IntermediateRecord {
+	return IntermediateRecord{
+		Name:       name,
+		Serial:     fmt.Sprintf("%X", cert.SerialNumber),
+		NotAfter:   cert.NotAfter,
+		ActivateAt: activateAt,
+	}
+}
+
+// activeIntermediate returns the most recently activated, unretired record.
+func activeIntermediate(recs []IntermediateRecord, now time.Time) (IntermediateRecord, error) {
+	for i := len(recs) - 1; i >= 0; i-- {
+		if !recs[i].Retired && !now.Before(recs[i].ActivateAt) {
+			return recs[i], nil
+		}
+	}
+	return IntermediateRecord{}, errors.New("no active intermediate CA")
+}
+
+// checkNoPendingRotation refuses to start a rotation while a previous one
+// has not yet taken over issuance.
+func checkNoPendingRotation(recs []IntermediateRecord, now time.Time) error {
+	last := recs[len(recs)-1]
+	if now.Before(last.ActivateAt) {
+		return fmt.Errorf("%s is still pending until %s", last.Name, last.ActivateAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// PrepareIntermediateRotation generates the next intermediate key and writes
+// its CSR to BaseDir/<name>.csr for signing by the root.
+func (c *Config) PrepareIntermediateRotation() (name string, csrPEM []byte, err error) {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkNoPendingRotation(recs, time.Now()); err != nil {
+		return "", nil, err
+	}
+	name = "intermediate-" + strconv.Itoa(len(recs)+1)
+	key, err := c.keyStore().GenerateKey(name, c.IntermediateKeyAlgorithm)
+	if err != nil {
+		return "", nil, err
+	}
+	csrPEM, err = createIntermediateCSR(key)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.WriteFile(filepath.Join(c.BaseDir, name+".csr"), csrPEM, 0644); err != nil {
+		return "", nil, err
+	}
+	return name, csrPEM, nil
+}
+
+// StageIntermediate installs the signed certificate for the key created by
+// PrepareIntermediateRotation. The new intermediate joins the trust bundle
+// immediately and takes over issuance after delay, giving relying parties
+// time to fetch the new bundle. The previous intermediate is scheduled for
+// retirement once the longest-lived leaf it can still sign has expired.
+func (c *Config) StageIntermediate(certPEM []byte, delay time.Duration) (IntermediateRecord, error) {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return IntermediateRecord{}, err
+	}
+	if err := checkNoPendingRotation(recs, time.Now()); err != nil {
+		return IntermediateRecord{}, err
+	}
+	name := "intermediate-" + strconv.Itoa(len(recs)+1)
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return IntermediateRecord{}, err
+	}
+	if err := c.checkSignedByRoot(cert); err != nil {
+		return IntermediateRecord{}, err
+	}
+	key, err := c.keyStore().Signer(name)
+	if err != nil {
+		return IntermediateRecord{}, fmt.Errorf("no pending rotation key %s: %w", name, err)
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return IntermediateRecord{}, fmt.Errorf("certificate does not match pending key %s", name)
+	}
+	if err := c.writeCert(name, cert); err != nil {
+		return IntermediateRecord{}, err
+	}
+	rec := newIntermediateRecord(name, cert, time.Now().Add(delay))
+	for i := range recs {
+		if recs[i].RetireAt.IsZero() || recs[i].RetireAt.After(rec.ActivateAt.Add(c.maxLeafValidity())) {
+			recs[i].RetireAt = rec.ActivateAt.Add(c.maxLeafValidity())
+		}
+	}
+	recs = append(recs, rec)
+	if err := c.saveIntermediates(recs); err != nil {
+		return IntermediateRecord{}, err
+	}
+	os.Remove(filepath.Join(c.BaseDir, name+".csr"))
+	return rec, c.publishTrustBundle(recs)
+}
+
+// RotateIntermediate creates, signs and stages a new intermediate in one
+// step using the root held by root (see StageIntermediate).
+func (c *Config) RotateIntermediate(root *Config, delay time.Duration) (IntermediateRecord, error) {
+	_, csrPEM, err := c.PrepareIntermediateRotation()
+	if err != nil {
+		return IntermediateRecord{}, err
+	}
+	certPEM, err := root.SignIntermediate(csrPEM)
+	if err != nil {
+		return IntermediateRecord{}, err
+	}
+	return c.StageIntermediate(certPEM, delay)
+}
+
+// RetireIntermediates marks every intermediate whose RetireAt has passed as
+// retired and republishes the trust bundle without it. It returns the
+// records retired by this call.
+func (c *Config) RetireIntermediates(now time.Time) ([]IntermediateRecord, error) {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return nil, err
+	}
+	active, err := activeIntermediate(recs, now)
+	if err != nil {
+		return nil, err
+	}
+	var retired []IntermediateRecord
+	for i := range recs {
+		r := &recs[i]
+		if r.Retired || r.Name == active.Name || r.RetireAt.IsZero() || now.Before(r.RetireAt) {
+			continue
+		}
+		r.Retired = true
+		retired = append(retired, *r)
+	}
+	if len(retired) == 0 {
+		return nil, nil
+	}
+	if err := c.saveIntermediates(recs); err != nil {
+		return nil, err
+	}
+	return retired, c.publishTrustBundle(recs)
+}
+
+// checkSignedByRoot verifies cert against root.crt, during a root rollover
+// root-next.crt, or under an imported enterprise root the issuing CA in
+// issuer-chain.pem.
+func (c *Config) checkSignedByRoot(cert *x509.Certificate) error {
+	root, err := c.readCert("root")
+	if err != nil {
+		return err
+	}
+	err = cert.CheckSignatureFrom(root)
+	if err == nil {
+		return nil
+	}
+	if next, nerr := c.readCert("root-next"); nerr == nil && cert.CheckSignatureFrom(next) == nil {
+		return nil
+	}
+	if chain, cerr := c.issuerChain(); cerr == nil && len(chain) > 0 && cert.CheckSignatureFrom(chain[0]) == nil {
+		return nil
+	}
+	return fmt.Errorf("new intermediate is not signed by root.crt: %w", err)
+}
+
+// publishTrustBundle writes the trust anchors (see trustAnchors) plus every
+// unretired intermediate.
+func (c *Config) publishTrustBundle(recs []IntermediateRecord) error {
+	var inters []*x509.Certificate
+	for _, r := range recs {
+		if r.Retired {
+			continue
+		}
+		cert, err := c.readCert(r.Name)
+		if err != nil {
+			return err
+		}
+		inters = append(inters, cert)
+	}
+	anchors, err := c.trustAnchors(inters)
+	if err != nil {
+		return err
+	}
+	chain, err := c.issuerChain()
+	if err != nil {
+		return err
+	}
+	return c.writeTrustBundle(append(append(anchors, chain...), inters...)...)
+}
+
+// signingIntermediate returns the record, signer and certificate of the
+// intermediate active at now.
+func (c *Config) signingIntermediate(now time.Time) (IntermediateRecord, crypto.Signer, *x509.Certificate, []byte, error) {
+	recs, err := c.Intermediates()
+	if err != nil {
+		return IntermediateRecord{}, nil, nil, nil, err
+	}
+	rec, err := activeIntermediate(recs, now)
+	if err != nil {
+		return IntermediateRecord{}, nil, nil, nil, err
+	}
+	key, cert, certPEM, err := c.loadIntermediate(rec.Name)
+	if err != nil {
+		return IntermediateRecord{}, nil, nil, nil, err
+	}
+	return rec, key, cert, certPEM, nil
+}
+
+// loadIntermediate reads the certificate and key of intermediate name and
+// checks that they match.
+func (c *Config) loadIntermediate(name string) (crypto.Signer, *x509.Certificate, []byte, error) {
+	certPEM, err := os.ReadFile(filepath.Join(c.BaseDir, name+".crt"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s.crt: %w", name, err)
+	}
+	key, err := c.keyStore().Signer(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return nil, nil, nil, fmt.Errorf("%s key does not match %s.crt", name, name)
+	}
+	return key, cert, certPEM, nil
+}
+
+func (c *Config) readCert(name string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, name+".crt"))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseCertificatePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s.crt: %w", name, err)
+	}
+	return cert, nil
+}
+
+// writeFileAtomic writes via a temporary file and rename so readers never
+// observe a partial file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
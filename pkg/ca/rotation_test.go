@@ -0,0 +1,114 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateIntermediate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	oldInter, err := cfg.readCert("intermediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := cfg.RotateIntermediate(&cfg, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != "intermediate-2" {
+		t.Errorf("new intermediate name = %q", rec.Name)
+	}
+	if n := countPEMCerts(t, filepath.Join(dir, "trust-bundle.pem")); n != 3 {
+		t.Errorf("trust bundle during propagation has %d certs, want 3", n)
+	}
+	if _, err := cfg.RotateIntermediate(&cfg, time.Hour); err == nil {
+		t.Error("started a second rotation while one is pending")
+	}
+
+	// Before the propagation delay elapses the old intermediate keeps signing.
+	certPEM, _, chainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := ParseCertificatePEM([]byte(certPEM))
+	if !bytes.Equal(leaf.AuthorityKeyId, oldInter.SubjectKeyId) {
+		t.Error("leaf not signed by the old intermediate during propagation")
+	}
+	verifyLeafChain(t, dir, certPEM, chainPEM)
+
+	recs, err := cfg.Intermediates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := rec.ActivateAt.Add(DefaultMaxValidityLeaf); !recs[0].RetireAt.Equal(want) {
+		t.Errorf("old RetireAt = %v, want %v", recs[0].RetireAt, want)
+	}
+
+	// Nothing is retired while leaves from the old intermediate may be live.
+	if retired, err := cfg.RetireIntermediates(rec.ActivateAt.Add(time.Minute)); err != nil || len(retired) != 0 {
+		t.Fatalf("early retire: %v, %v", retired, err)
+	}
+	retired, err := cfg.RetireIntermediates(recs[0].RetireAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retired) != 1 || retired[0].Name != "intermediate" {
+		t.Fatalf("retired = %+v", retired)
+	}
+	if n := countPEMCerts(t, filepath.Join(dir, "trust-bundle.pem")); n != 2 {
+		t.Errorf("trust bundle after retirement has %d certs, want 2", n)
+	}
+}
+
+func TestRotateIntermediateSwitchesIssuance(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.RotateIntermediate(&cfg, 0); err != nil {
+		t.Fatal(err)
+	}
+	newInter, err := cfg.readCert("intermediate-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, chainPEM, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := ParseCertificatePEM([]byte(certPEM))
+	if !bytes.Equal(leaf.AuthorityKeyId, newInter.SubjectKeyId) {
+		t.Error("leaf not signed by the new intermediate after activation")
+	}
+	verifyLeafChain(t, dir, certPEM, chainPEM)
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", DefaultMaxValidityLeaf+time.Hour); err == nil {
+		t.Error("issued leaf beyond MaxLeafValidity")
+	}
+}
+
+func countPEMCerts(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return n
+		}
+		n++
+	}
+}
@@ -0,0 +1,109 @@
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+const settingsFile = "ca.json"
+
+// Settings is the CA policy chosen at init time and persisted as
+// BaseDir/ca.json, so later steps (signing rotated intermediates, issuing
+// leaves) apply it without the operator repeating flags.
+type Settings struct {
+	TrustDomain     string           `json:"trust_domain,omitempty"`
+	NameConstraints NameConstraints  `json:"name_constraints"`
+	Distribution    DistributionURLs `json:"distribution"`
+	Lint            LintPolicy       `json:"lint,omitempty"`
+}
+
+// LoadSettings reads BaseDir/ca.json. A directory created before settings
+// existed yields zero Settings.
+func (c *Config) LoadSettings() (Settings, error) {
+	var s Settings
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, settingsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%s: %w", settingsFile, err)
+	}
+	return s, nil
+}
+
+// SaveSettings writes s to BaseDir/ca.json.
+func (c *Config) SaveSettings(s Settings) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.BaseDir, settingsFile), data, 0644)
+}
+
+// nameConstraints returns c.NameConstraints when set, else the constraints
+// recorded in ca.json.
+func (c *Config) nameConstraints() (NameConstraints, error) {
+	if c.NameConstraints != nil {
+		return *c.NameConstraints, nil
+	}
+	s, err := c.LoadSettings()
+	return s.NameConstraints, err
+}
+
+// LoadTrustDomain returns c.TrustDomain when set, else the trust domain
+// recorded in ca.json. Directories initialized before it was recorded use
+// spiffeid.DefaultTrustDomain.
+func (c *Config) LoadTrustDomain() (spiffeid.TrustDomain, error) {
+	if !c.TrustDomain.IsZero() {
+		return c.TrustDomain, nil
+	}
+	s, err := c.LoadSettings()
+	if err != nil {
+		return spiffeid.TrustDomain{}, err
+	}
+	name := s.TrustDomain
+	if name == "" {
+		name = spiffeid.DefaultTrustDomain
+	}
+	td, err := spiffeid.TrustDomainFromString(name)
+	if err != nil {
+		return spiffeid.TrustDomain{}, fmt.Errorf("%s: %w", settingsFile, err)
+	}
+	return td, nil
+}
+
+// recordSettings merges the policy set on c (TrustDomain, NameConstraints,
+// Distribution) into ca.json, leaving settings c does not set untouched.
+func (c *Config) recordSettings() error {
+	if c.TrustDomain.IsZero() && c.NameConstraints == nil && c.Distribution == nil {
+		return nil
+	}
+	s, err := c.LoadSettings()
+	if err != nil {
+		return err
+	}
+	if !c.TrustDomain.IsZero() {
+		s.TrustDomain = c.TrustDomain.Name()
+	}
+	if c.NameConstraints != nil {
+		if err := c.NameConstraints.Validate(); err != nil {
+			return err
+		}
+		s.NameConstraints = *c.NameConstraints
+	}
+	if c.Distribution != nil {
+		if err := c.Distribution.Validate(); err != nil {
+			return err
+		}
+		s.Distribution = *c.Distribution
+	}
+	return c.SaveSettings(s)
+}
@@ -0,0 +1,41 @@
+package ca
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+func TestTrustDomain(t *testing.T) {
+	dir := t.TempDir()
+	td, _ := spiffeid.TrustDomainFromString("example.org")
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256, TrustDomain: td}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// Later steps read the trust domain back from ca.json.
+	online := Config{BaseDir: dir, LeafKeyAlgorithm: ECDSAP256}
+	if got, err := online.LoadTrustDomain(); err != nil || got != td {
+		t.Fatalf("LoadTrustDomain = %v, %v", got, err)
+	}
+	if _, _, _, _, err := online.IssueLeaf("spiffe://example.org/ns/default/sa/a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := online.IssueLeaf("spiffe://demo/ns/default/sa/a", time.Hour); !errors.Is(err, spiffeid.ErrNotMemberOfDomain) {
+		t.Errorf("issued for another trust domain: %v", err)
+	}
+	// IDs are never completed or rewritten.
+	for _, id := range []string{"", "a", "/ns/default/sa/a", "example.org/ns/default/sa/a", "spiffe://example.org/ns//a"} {
+		if _, _, _, _, err := online.IssueLeaf(id, time.Hour); err == nil {
+			t.Errorf("issued for %q", id)
+		}
+	}
+
+	// Directories from before the trust domain was recorded keep "demo".
+	legacy := Config{BaseDir: t.TempDir()}
+	if got, err := legacy.LoadTrustDomain(); err != nil || got.Name() != spiffeid.DefaultTrustDomain {
+		t.Errorf("legacy LoadTrustDomain = %v, %v", got, err)
+	}
+}
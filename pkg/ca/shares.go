@@ -0,0 +1,356 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/shamir"
+)
+
+// Split key custody: SplitKey cuts a file key (normally the root's, still
+// encrypted with its passphrase) into Shamir shares held by different
+// operators, and the key file is then deleted. Ceremonies that need the
+// key, such as signing an intermediate, use a ShareKeyStore that combines
+// a threshold of shares in memory; the key never returns to disk.
+
+const (
+	keySharesFile    = "key-shares.json"
+	keySharePEMBlock = "ZTCA KEY SHARE"
+)
+
+// KeyShare is one operator's share of a split key.
+type KeyShare struct {
+	Key       string // key name, e.g. "root"
+	SetID     string // identifies the split the share belongs to
+	Index     int
+	Threshold int
+	Total     int
+	Data      []byte
+}
+
+// KeySplitRecord is written to BaseDir/key-shares.json for each split key,
+// so combined shares can be checked before use.
+type KeySplitRecord struct {
+	Key       string    `json:"key"`
+	SetID     string    `json:"set_id"`
+	Threshold int       `json:"threshold"`
+	Total     int       `json:"total"`
+	SHA256    string    `json:"sha256"` // of the key file
+	SplitAt   time.Time `json:"split_at"`
+}
+
+// checksum guards a share against transcription errors from paper.
+func (s KeyShare) checksum() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00", s.Key, s.SetID, s.Index)
+	h.Write(s.Data)
+	return hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// MarshalPEM encodes the share as a printable "ZTCA KEY SHARE" PEM block
+// whose headers name the key, split and threshold.
+func (s KeyShare) MarshalPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type: keySharePEMBlock,
+		Headers: map[string]string{
+			"Key":       s.Key,
+			"Set":       s.SetID,
+			"Share":     fmt.Sprintf("%d of %d", s.Index, s.Total),
+			"Threshold": strconv.Itoa(s.Threshold),
+			"Checksum":  s.checksum(),
+		},
+		Bytes: s.Data,
+	})
+}
+
+// ParseKeySharesPEM parses every "ZTCA KEY SHARE" block in data, checking
+// each share's checksum.
+func ParseKeySharesPEM(data []byte) ([]KeyShare, error) {
+	var shares []KeyShare
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != keySharePEMBlock {
+			continue
+		}
+		s := KeyShare{Key: block.Headers["Key"], SetID: block.Headers["Set"], Data: block.Bytes}
+		if _, err := fmt.Sscanf(block.Headers["Share"], "%d of %d", &s.Index, &s.Total); err != nil {
+			return nil, fmt.Errorf("key share: bad Share header %q", block.Headers["Share"])
+		}
+		t, err := strconv.Atoi(block.Headers["Threshold"])
+		if err != nil {
+			return nil, fmt.Errorf("key share: bad Threshold header %q", block.Headers["Threshold"])
+		}
+		s.Threshold = t
+		if s.checksum() != block.Headers["Checksum"] {
+			return nil, fmt.Errorf("key share %d of %s: checksum mismatch (transcription error?)", s.Index, s.Key)
+		}
+		shares = append(shares, s)
+	}
+	if len(shares) == 0 {
+		return nil, errors.New("no ZTCA KEY SHARE PEM block found")
+	}
+	return shares, nil
+}
+
+// SplitKey splits the file key <name>.key into total shares, any
+// threshold of which reconstruct it. save writes the shares out, e.g. one
+// file per holder, and returns them as read back; SplitKey checks every
+// share survived and that they combine to the key before it records the
+// split in key-shares.json, so a failure leaves the previous split and its
+// shares in force. The key file is left in place: the caller deletes it
+// once the shares are safely with their holders. Only file keys can be
+// split; keys on a token are protected by the token instead.
+func (c *Config) SplitKey(name string, total, threshold int, save func([]KeyShare) ([]KeyShare, error)) ([]KeyShare, error) {
+	fks, ok := c.keyStore().(*FileKeyStore)
+	if !ok {
+		return nil, fmt.Errorf("split %s: only file key stores can be split", name)
+	}
+	data, err := os.ReadFile(fks.path(name))
+	if err != nil {
+		return nil, err
+	}
+	parts, err := shamir.Split(data, total, threshold)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	rec := KeySplitRecord{
+		Key:       name,
+		SetID:     hex.EncodeToString(id),
+		Threshold: threshold,
+		Total:     total,
+		SHA256:    hex.EncodeToString(sum[:]),
+		SplitAt:   time.Now().UTC(),
+	}
+	recs, err := c.KeySplits()
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]KeyShare, len(parts))
+	for i, p := range parts {
+		shares[i] = KeyShare{Key: name, SetID: rec.SetID, Index: int(p.Index), Threshold: threshold, Total: total, Data: p.Data}
+	}
+
+	saved, err := save(shares)
+	if err != nil {
+		return nil, fmt.Errorf("save shares: %w", err)
+	}
+	if err := checkSavedShares(shares, saved); err != nil {
+		return nil, err
+	}
+	if _, err := combineShares(rec, saved); err != nil {
+		return nil, fmt.Errorf("verify shares: %w", err)
+	}
+
+	if err := c.saveKeySplits(append([]KeySplitRecord{rec}, otherSplits(recs, name)...)); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// RestoreKey writes the key file <name>.key back from shares of its
+// current split and drops the split from key-shares.json, so ceremonies
+// use the file again and the shares are retired. It refuses to overwrite
+// an existing key file.
+func (c *Config) RestoreKey(name string, shares []KeyShare) error {
+	fks, ok := c.keyStore().(*FileKeyStore)
+	if !ok {
+		return fmt.Errorf("restore %s: only file key stores hold split keys", name)
+	}
+	data, err := c.CombineKey(name, shares)
+	if err != nil {
+		return err
+	}
+	path := fks.path(name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("restore %s: %s exists; refusing to overwrite it", name, path)
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+	recs, err := c.KeySplits()
+	if err != nil {
+		return err
+	}
+	return c.saveKeySplits(otherSplits(recs, name))
+}
+
+func otherSplits(recs []KeySplitRecord, name string) []KeySplitRecord {
+	out := []KeySplitRecord{}
+	for _, r := range recs {
+		if r.Key != name {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (c *Config) saveKeySplits(recs []KeySplitRecord) error {
+	buf, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.BaseDir, keySharesFile), buf, 0644)
+}
+
+// checkSavedShares reports the first share of shares missing from saved or
+// saved differently.
+func checkSavedShares(shares, saved []KeyShare) error {
+	byIndex := map[int]KeyShare{}
+	for _, s := range saved {
+		byIndex[s.Index] = s
+	}
+	for _, s := range shares {
+		got, ok := byIndex[s.Index]
+		if !ok {
+			return fmt.Errorf("verify shares: share %d of %s was not saved", s.Index, s.Key)
+		}
+		if got.Key != s.Key || got.SetID != s.SetID || got.Threshold != s.Threshold || got.Total != s.Total || !bytes.Equal(got.Data, s.Data) {
+			return fmt.Errorf("verify shares: share %d of %s reads back differently", s.Index, s.Key)
+		}
+	}
+	return nil
+}
+
+// KeySplits returns the split records of BaseDir/key-shares.json.
+func (c *Config) KeySplits() ([]KeySplitRecord, error) {
+	data, err := os.ReadFile(filepath.Join(c.BaseDir, keySharesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []KeySplitRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, fmt.Errorf("%s: %w", keySharesFile, err)
+	}
+	return recs, nil
+}
+
+func (c *Config) keySplit(name string) (KeySplitRecord, bool, error) {
+	recs, err := c.KeySplits()
+	if err != nil {
+		return KeySplitRecord{}, false, err
+	}
+	for _, r := range recs {
+		if r.Key == name {
+			return r, true, nil
+		}
+	}
+	return KeySplitRecord{}, false, nil
+}
+
+// CombineKey reconstructs the key file <name>.key from shares of its
+// current split and checks it against the recorded digest. Shares of
+// other keys or earlier splits are ignored.
+func (c *Config) CombineKey(name string, shares []KeyShare) ([]byte, error) {
+	rec, ok, err := c.keySplit(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("combine %s: key has not been split", name)
+	}
+	return combineShares(rec, shares)
+}
+
+// combineShares reconstructs the key of rec from those shares that belong
+// to its split and checks the result against the recorded digest.
+func combineShares(rec KeySplitRecord, shares []KeyShare) ([]byte, error) {
+	name := rec.Key
+	var parts []shamir.Share
+	seen := map[int]bool{}
+	for _, s := range shares {
+		if s.Key != name || s.SetID != rec.SetID || seen[s.Index] {
+			continue
+		}
+		if s.Index < 1 || s.Index > rec.Total {
+			return nil, fmt.Errorf("combine %s: share index %d out of range", name, s.Index)
+		}
+		seen[s.Index] = true
+		parts = append(parts, shamir.Share{Index: byte(s.Index), Data: s.Data})
+	}
+	if len(parts) < rec.Threshold {
+		return nil, fmt.Errorf("%s key is split: %d of %d required shares of set %s given", name, len(parts), rec.Threshold, rec.SetID)
+	}
+	data, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, fmt.Errorf("combine %s: %w", name, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != rec.SHA256 {
+		return nil, fmt.Errorf("combine %s: shares do not reconstruct the recorded key", name)
+	}
+	return data, nil
+}
+
+// ShareKeyStore serves split keys from shares combined in memory and every
+// other key from KeyStore. Dir holds key-shares.json; reconstructed keys
+// that are encrypted are unlocked with Passphrase. GenerateKey goes to
+// KeyStore, so a new root from a rollover lands on disk until it is split.
+type ShareKeyStore struct {
+	KeyStore
+	Dir        string
+	Shares     []KeyShare
+	Passphrase PassphraseFunc
+}
+
+// ImportKey imports into KeyStore.
+func (s *ShareKeyStore) ImportKey(name string, key crypto.Signer) error {
+	return importKey(s.KeyStore, name, key)
+}
+
+// Signer returns name's key, combining shares if the key has been split.
+func (s *ShareKeyStore) Signer(name string) (crypto.Signer, error) {
+	cfg := Config{BaseDir: s.Dir}
+	_, split, err := cfg.keySplit(name)
+	if err != nil {
+		return nil, err
+	}
+	if !split {
+		return s.KeyStore.Signer(name)
+	}
+	data, err := cfg.CombineKey(name, s.Shares)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range data {
+			data[i] = 0
+		}
+	}()
+	var pass []byte
+	if IsEncryptedKeyPEM(data) {
+		if s.Passphrase == nil {
+			return nil, fmt.Errorf("%s (combined): %w", name, ErrPassphraseRequired)
+		}
+		if pass, err = s.Passphrase(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := DecryptPrivateKeyPEM(data, pass)
+	if err != nil {
+		return nil, fmt.Errorf("%s (combined): %w", name, err)
+	}
+	return key, nil
+}
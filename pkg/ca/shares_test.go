@@ -0,0 +1,154 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitRootKey(t *testing.T) {
+	rootDir, raDir := t.TempDir(), t.TempDir()
+	pass := func() ([]byte, error) { return []byte("root passphrase"), nil }
+	root := Config{BaseDir: rootDir, RootKeyAlgorithm: ECDSAP256, Passphrase: pass}
+	ra := Config{BaseDir: raDir, IntermediateKeyAlgorithm: ECDSAP256}
+	if err := root.InitRoot(); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(rootDir, "root.key")
+	keyFile := readFile(t, keyPath)
+	shares, err := root.SplitKey("root", 5, 3, saveShares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shares survive a round trip through their printable form.
+	var text bytes.Buffer
+	for _, s := range shares[2:] {
+		text.Write(s.MarshalPEM())
+	}
+	parsed, err := ParseKeySharesPEM(text.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := root.CombineKey("root", parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, keyFile) {
+		t.Error("combined shares differ from root.key")
+	}
+
+	csrPEM, err := ra.CreateIntermediateCSR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.SignIntermediate(csrPEM); err == nil {
+		t.Fatal("signed an intermediate without root.key or shares")
+	}
+	ceremony := Config{BaseDir: rootDir, KeyStore: &ShareKeyStore{
+		KeyStore:   &FileKeyStore{Dir: rootDir, Passphrase: pass},
+		Dir:        rootDir,
+		Shares:     []KeyShare{shares[0], shares[3], shares[4]},
+		Passphrase: pass,
+	}}
+	certPEM, err := ceremony.SignIntermediate(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.InstallIntermediate(certPEM, readFile(t, filepath.Join(rootDir, "root.crt"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Error("combining shares wrote root.key back to disk")
+	}
+
+	ceremony.KeyStore.(*ShareKeyStore).Shares = shares[:2]
+	if _, err := ceremony.SignIntermediate(csrPEM); err == nil || !strings.Contains(err.Error(), "2 of 3") {
+		t.Errorf("two shares: %v", err)
+	}
+
+	// A share from a superseded split does not count, and a corrupted
+	// share is rejected.
+	stale := shares[1]
+	stale.SetID = "0000000000000000"
+	if _, err := root.CombineKey("root", []KeyShare{shares[0], stale, shares[2]}); err == nil {
+		t.Error("combined with a share of another split")
+	}
+	corrupt := shares[0]
+	corrupt.Data = append([]byte{}, corrupt.Data...)
+	corrupt.Data[0] ^= 1
+	if _, err := root.CombineKey("root", []KeyShare{corrupt, shares[1], shares[2]}); err == nil {
+		t.Error("combined with a corrupted share")
+	}
+	// A split whose shares cannot be saved, or read back wrong, is not
+	// recorded and the previous split stays in force.
+	if err := os.WriteFile(keyPath, keyFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	splits := readFile(t, filepath.Join(rootDir, keySharesFile))
+	for name, save := range map[string]func([]KeyShare) ([]KeyShare, error){
+		"save fails": func([]KeyShare) ([]KeyShare, error) { return nil, errors.New("disk full") },
+		"share lost": func(s []KeyShare) ([]KeyShare, error) { return s[1:], nil },
+		"share corrupted": func(s []KeyShare) ([]KeyShare, error) {
+			saved := append([]KeyShare{}, s...)
+			saved[2].Data = append([]byte{}, saved[2].Data...)
+			saved[2].Data[0] ^= 1
+			return saved, nil
+		},
+	} {
+		if _, err := root.SplitKey("root", 5, 3, save); err == nil {
+			t.Errorf("%s: split succeeded", name)
+		}
+		if !bytes.Equal(readFile(t, filepath.Join(rootDir, keySharesFile)), splits) {
+			t.Errorf("%s: key-shares.json changed", name)
+		}
+	}
+	if _, err := root.CombineKey("root", shares[:3]); err != nil {
+		t.Errorf("previous split no longer combines: %v", err)
+	}
+
+	// Restoring writes the key back and retires the split, so ceremonies
+	// use the file without shares.
+	if err := root.RestoreKey("root", shares[:3]); err == nil {
+		t.Error("restore overwrote root.key")
+	}
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.RestoreKey("root", shares[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readFile(t, keyPath), keyFile) {
+		t.Error("restored root.key differs")
+	}
+	if recs, err := root.KeySplits(); err != nil || len(recs) != 0 {
+		t.Errorf("splits after restore: %v, %v", recs, err)
+	}
+	ceremony.KeyStore.(*ShareKeyStore).Shares = nil
+	if _, err := ceremony.SignIntermediate(csrPEM); err != nil {
+		t.Errorf("sign with restored key: %v", err)
+	}
+
+	block, _ := pem.Decode(shares[0].MarshalPEM())
+	block.Bytes[0] ^= 1
+	if _, err := ParseKeySharesPEM(pem.EncodeToMemory(block)); err == nil {
+		t.Error("mistyped share passed its checksum")
+	}
+}
+
+// saveShares stands in for writing shares out: it round-trips them through
+// their printable form.
+func saveShares(shares []KeyShare) ([]KeyShare, error) {
+	var text bytes.Buffer
+	for _, s := range shares {
+		text.Write(s.MarshalPEM())
+	}
+	return ParseKeySharesPEM(text.Bytes())
+}
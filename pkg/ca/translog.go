@@ -0,0 +1,348 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/translog"
+)
+
+// Transparency log: signLeaf appends every certificate it issues to
+// translog.jsonl, an append-only Merkle log (see pkg/translog), and signs a
+// tree head covering it into translog-sth.json with the log key, whose
+// public key is published as translog.pub. The RA serves the log at
+// /v1/log; monitors check tree heads against translog.pub, check that
+// each head extends the previous one, and flag certificates for identities
+// they do not expect.
+
+const (
+	translogFile     = "translog.jsonl"
+	translogHeadFile = "translog-sth.json"
+	translogPubFile  = "translog.pub"
+	translogLockFile = "translog.lock"
+	// TransparencyLogKey is the key store name of the tree head signing key.
+	TransparencyLogKey = "translog"
+)
+
+// translogKeyAlgorithm signs tree heads, as CT logs do.
+const translogKeyAlgorithm = ECDSAP256
+
+// translogMu serializes appends within a process, and translog.lock across
+// processes sharing BaseDir, such as the RA and ztca issue. Both are held
+// from the append through the tree head update: otherwise two writers could
+// each find the stored head smaller than their tree, and the later write
+// could replace a larger head with a smaller one.
+var translogMu sync.Mutex
+
+// TransparencyLog is the CA's certificate transparency log in BaseDir.
+type TransparencyLog struct {
+	c *Config
+
+	// Appends through the same TransparencyLog extend tree, the compact
+	// tree of the first treeBytes bytes of translog.jsonl, instead of
+	// rehashing the whole log, as long as nothing else wrote to the file.
+	tree      translog.Tree
+	treeBytes int64
+	// key is the log key last loaded, reused while it matches translog.pub.
+	key crypto.Signer
+}
+
+var _ translog.Log = (*TransparencyLog)(nil)
+
+// TransparencyLog returns the transparency log in BaseDir.
+func (c *Config) TransparencyLog() *TransparencyLog {
+	return &TransparencyLog{c: c}
+}
+
+// transparencyLog returns the log signLeaf appends to, created on first
+// use. A copy of c gets its own.
+func (c *Config) transparencyLog() *TransparencyLog {
+	translogMu.Lock()
+	defer translogMu.Unlock()
+	if c.tlog == nil || c.tlog.c != c {
+		c.tlog = c.TransparencyLog()
+	}
+	return c.tlog
+}
+
+// translogLine is a stored entry; its index is its line number.
+type translogLine struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Certificate []byte    `json:"certificate"`
+}
+
+func (l *TransparencyLog) path(name string) string {
+	return filepath.Join(l.c.BaseDir, name)
+}
+
+// PublicKey returns the tree head signing key from translog.pub.
+func (l *TransparencyLog) PublicKey() (crypto.PublicKey, error) {
+	data, err := os.ReadFile(l.path(translogPubFile))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKeyPEM(data)
+}
+
+// signer returns the log key, creating it and translog.pub on first use.
+func (l *TransparencyLog) signer() (crypto.Signer, error) {
+	pub, err := l.PublicKey()
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := l.c.keyStore().GenerateKey(TransparencyLogKey, translogKeyAlgorithm)
+		if err != nil {
+			return nil, fmt.Errorf("log key: %w", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(l.path(translogPubFile), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", translogPubFile, err)
+	}
+	if l.key != nil && publicKeysEqual(l.key.Public(), pub) {
+		return l.key, nil
+	}
+	key, err := l.c.keyStore().Signer(TransparencyLogKey)
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeysEqual(key.Public(), pub) {
+		return nil, fmt.Errorf("%s key does not match %s", TransparencyLogKey, translogPubFile)
+	}
+	l.key = key
+	return key, nil
+}
+
+// lock takes translogMu and translog.lock, and returns the function that
+// releases both.
+func (l *TransparencyLog) lock() (func(), error) {
+	translogMu.Lock()
+	unlock, err := lockFile(l.path(translogLockFile))
+	if err != nil {
+		translogMu.Unlock()
+		return nil, fmt.Errorf("lock %s: %w", translogFile, err)
+	}
+	return func() {
+		unlock()
+		translogMu.Unlock()
+	}, nil
+}
+
+// init creates the log key and signs the empty tree head, unless the log
+// already has a tree head.
+func (l *TransparencyLog) init(now time.Time) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(l.path(translogHeadFile)); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	key, err := l.signer()
+	if err != nil {
+		return err
+	}
+	if err := l.reloadTree(); err != nil {
+		return err
+	}
+	return l.signHead(key, now)
+}
+
+// append logs cert and signs a tree head that includes it.
+func (l *TransparencyLog) append(cert *x509.Certificate, now time.Time) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	key, err := l.signer()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(translogLine{Timestamp: now.UTC().Truncate(time.Millisecond), Certificate: cert.Raw})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	path := l.path(translogFile)
+	if err := dropTornLine(path); err != nil {
+		return err
+	}
+	before, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+	if err := appendLines(path, line); err != nil {
+		return err
+	}
+	after, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+	if before == l.treeBytes && after == before+int64(len(line)) {
+		l.tree.Append(translog.LeafHash(cert.Raw))
+		l.treeBytes = after
+	} else if err := l.reloadTree(); err != nil {
+		return err
+	}
+	return l.signHead(key, now)
+}
+
+// reloadTree rebuilds the cached tree from every entry in the log.
+func (l *TransparencyLog) reloadTree() error {
+	entries, size, err := l.read()
+	if err != nil {
+		return err
+	}
+	l.tree = translog.Tree{}
+	for _, e := range entries {
+		l.tree.Append(e.LeafHash())
+	}
+	l.treeBytes = size
+	return nil
+}
+
+// signHead signs a tree head over the cached tree, unless the stored head
+// already covers as many entries.
+func (l *TransparencyLog) signHead(key crypto.Signer, now time.Time) error {
+	if old, err := l.TreeHead(); err == nil && old.TreeSize >= l.tree.Size {
+		return nil
+	}
+	head := translog.TreeHead{
+		TreeSize:  l.tree.Size,
+		Timestamp: now.UTC().Truncate(time.Millisecond),
+		RootHash:  l.tree.Root(),
+	}
+	if err := head.Sign(key); err != nil {
+		return fmt.Errorf("signing tree head: %w", err)
+	}
+	data, err := json.MarshalIndent(head, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path(translogHeadFile), append(data, '\n'), 0644)
+}
+
+// load returns every complete entry in the log.
+func (l *TransparencyLog) load() ([]translog.Entry, error) {
+	entries, _, err := l.read()
+	return entries, err
+}
+
+// read returns every complete entry in the log and the number of bytes
+// they take up.
+func (l *TransparencyLog) read() ([]translog.Entry, int64, error) {
+	data, err := os.ReadFile(l.path(translogFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// As in the issuance database, the segment after the final newline is
+	// empty or a torn append.
+	size := int64(bytes.LastIndexByte(data, '\n') + 1)
+	lines := bytes.Split(data[:size], []byte("\n"))
+	lines = lines[:len(lines)-1]
+	entries := make([]translog.Entry, len(lines))
+	for i, line := range lines {
+		var rec translogLine
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, 0, fmt.Errorf("%s line %d: %w", translogFile, i+1, err)
+		}
+		entries[i] = translog.Entry{Index: int64(i), Timestamp: rec.Timestamp, Certificate: rec.Certificate}
+	}
+	return entries, size, nil
+}
+
+// leaves returns the leaf hashes of the first size entries.
+func (l *TransparencyLog) leaves(size int64) ([][]byte, error) {
+	entries, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	if size > int64(len(entries)) {
+		return nil, fmt.Errorf("%w: tree size %d, log has %d entries", translog.ErrRange, size, len(entries))
+	}
+	leaves := make([][]byte, size)
+	for i, e := range entries[:size] {
+		leaves[i] = e.LeafHash()
+	}
+	return leaves, nil
+}
+
+// TreeHead returns the latest signed tree head.
+func (l *TransparencyLog) TreeHead() (translog.TreeHead, error) {
+	var head translog.TreeHead
+	data, err := os.ReadFile(l.path(translogHeadFile))
+	if err != nil {
+		return head, err
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return head, fmt.Errorf("%s: %w", translogHeadFile, err)
+	}
+	return head, nil
+}
+
+// Entries returns the entries with indices in [start, end), stopping at the
+// end of the log.
+func (l *TransparencyLog) Entries(start, end int64) ([]translog.Entry, error) {
+	entries, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || start >= int64(len(entries)) || end <= start {
+		return nil, fmt.Errorf("%w: entries [%d, %d) of %d", translog.ErrRange, start, end, len(entries))
+	}
+	if end > int64(len(entries)) {
+		end = int64(len(entries))
+	}
+	return entries[start:end], nil
+}
+
+// InclusionProof returns the index of the certificate with leafHash and
+// its audit path in the tree of size treeSize.
+func (l *TransparencyLog) InclusionProof(leafHash []byte, treeSize int64) (int64, [][]byte, error) {
+	if treeSize < 1 {
+		return 0, nil, fmt.Errorf("%w: tree size %d", translog.ErrRange, treeSize)
+	}
+	leaves, err := l.leaves(treeSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i, h := range leaves {
+		if bytes.Equal(h, leafHash) {
+			proof, err := translog.InclusionProof(leaves, int64(i))
+			return int64(i), proof, err
+		}
+	}
+	return 0, nil, fmt.Errorf("%w in the first %d entries", translog.ErrNotFound, treeSize)
+}
+
+// ConsistencyProof proves that the tree of size first is a prefix of the
+// tree of size second.
+func (l *TransparencyLog) ConsistencyProof(first, second int64) ([][]byte, error) {
+	if first < 0 || second < first {
+		return nil, fmt.Errorf("%w: sizes %d and %d", translog.ErrRange, first, second)
+	}
+	leaves, err := l.leaves(second)
+	if err != nil {
+		return nil, err
+	}
+	return translog.ConsistencyProof(leaves, first)
+}
@@ -0,0 +1,130 @@
+package ca
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zero-trust/zt-identity/pkg/translog"
+)
+
+func TestTransparencyLog(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{BaseDir: dir, RootKeyAlgorithm: ECDSAP256, IntermediateKeyAlgorithm: ECDSAP256, LeafKeyAlgorithm: ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	tlog := cfg.TransparencyLog()
+	pub, err := tlog.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := tlog.TreeHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.TreeSize != 0 {
+		t.Fatalf("fresh log has tree size %d", empty.TreeSize)
+	}
+
+	srv := httptest.NewServer(translog.Handler(tlog))
+	defer srv.Close()
+	client := &translog.Client{URL: srv.URL}
+
+	var certs []string
+	var heads []translog.TreeHead
+	var cached *TransparencyLog
+	for _, svc := range []string{"a", "b", "c", "d", "e"} {
+		certPEM, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/"+svc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, certPEM)
+		if cached == nil {
+			cached = cfg.tlog
+		}
+		head, err := client.TreeHead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := head.Verify(pub); err != nil {
+			t.Fatal(err)
+		}
+		heads = append(heads, head)
+	}
+	last := heads[len(heads)-1]
+	if last.TreeSize != int64(len(certs)) {
+		t.Fatalf("tree size %d after %d issuances", last.TreeSize, len(certs))
+	}
+	// IssueLeaf kept one log, extending its tree rather than rehashing.
+	if cfg.tlog != cached || cached.tree.Size != last.TreeSize {
+		t.Error("IssueLeaf did not reuse its transparency log")
+	}
+
+	entries, err := client.Entries(0, last.TreeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree translog.Tree
+	for _, e := range entries {
+		tree.Append(e.LeafHash())
+	}
+	if tree.Size != last.TreeSize || !bytes.Equal(tree.Root(), last.RootHash) {
+		t.Fatal("entries do not hash to the tree head")
+	}
+
+	for i, certPEM := range certs {
+		cert, err := ParseCertificatePEM([]byte(certPEM))
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := translog.LeafHash(cert.Raw)
+		index, proof, err := client.InclusionProof(leaf, last.TreeSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != int64(i) {
+			t.Errorf("certificate %d logged at index %d", i, index)
+		}
+		if err := translog.VerifyInclusion(leaf, index, last.TreeSize, proof, last.RootHash); err != nil {
+			t.Errorf("certificate %d: %v", i, err)
+		}
+	}
+	for _, old := range heads[:len(heads)-1] {
+		proof, err := client.ConsistencyProof(old.TreeSize, last.TreeSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := translog.VerifyConsistency(old.TreeSize, last.TreeSize, old.RootHash, last.RootHash, proof); err != nil {
+			t.Errorf("%d -> %d: %v", old.TreeSize, last.TreeSize, err)
+		}
+	}
+	if _, _, err := client.InclusionProof(translog.LeafHash([]byte("never issued")), last.TreeSize); !errors.Is(err, translog.ErrNotFound) {
+		t.Errorf("proof for an unlogged certificate: %v", err)
+	}
+
+	// A torn append is ignored and overwritten by the next one.
+	f, err := os.OpenFile(filepath.Join(dir, translogFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"timestamp":"2026-`)
+	f.Close()
+	if _, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/f", 0); err != nil {
+		t.Fatal(err)
+	}
+	head, err := tlog.TreeHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tlog.ConsistencyProof(last.TreeSize, head.TreeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := translog.VerifyConsistency(last.TreeSize, head.TreeSize, last.RootHash, head.RootHash, proof); err != nil || head.TreeSize != last.TreeSize+1 {
+		t.Errorf("after torn append: size %d, %v", head.TreeSize, err)
+	}
+}
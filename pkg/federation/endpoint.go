@@ -0,0 +1,81 @@
+package federation
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// Handler serves the CA's SPIFFE bundle (trust-bundle.json), the document
+// foreign trust domains fetch from this domain's bundle endpoint.
+func Handler(c *ca.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := c.TrustBundle()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data, err := b.MarshalSPIFFE()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+}
+
+// EndpointSVID supplies the X.509-SVID an https_spiffe bundle endpoint
+// presents. It issues itself a certificate for ID from the CA and renews
+// it at half its lifetime.
+type EndpointSVID struct {
+	CA *ca.Config
+	ID spiffeid.ID
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	renewAt time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (e *EndpointSVID) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if e.cert != nil && now.Before(e.renewAt) {
+		return e.cert, nil
+	}
+	_, keyPEM, chainPEM, _, err := e.CA.IssueLeafRequest(ca.LeafRequest{SPIFFEID: e.ID.String()})
+	if err != nil {
+		if e.cert != nil && now.Before(e.cert.Leaf.NotAfter) {
+			return e.cert, nil // keep serving until the old one expires
+		}
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair([]byte(chainPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, err
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		certs, err := ca.ParseCertificatesPEM([]byte(chainPEM))
+		if err != nil {
+			return nil, err
+		}
+		leaf = certs[0]
+		cert.Leaf = leaf
+	}
+	e.cert = &cert
+	e.renewAt = leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+	return e.cert, nil
+}
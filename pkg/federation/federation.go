@@ -0,0 +1,220 @@
+// Package federation implements SPIFFE bundle endpoints: serving this trust
+// domain's bundle to foreign ones, and fetching and refreshing foreign
+// bundles under the https_web and https_spiffe profiles (SPIFFE
+// Federation specification).
+package federation
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+const (
+	// maxBundleSize bounds a fetched bundle.
+	maxBundleSize = 1 << 20
+	// fetchTimeout bounds one bundle fetch.
+	fetchTimeout = 10 * time.Second
+	// RetryInterval is how soon a failed fetch is retried.
+	RetryInterval = time.Minute
+)
+
+// Fetch downloads rel's bundle. https_web endpoints are verified with
+// webRoots (nil: the system roots); https_spiffe endpoints must present an
+// X.509-SVID for rel.EndpointSPIFFEID that chains to current, the
+// domain's last known bundle.
+func Fetch(ctx context.Context, rel ca.FederationRelationship, current *ca.TrustBundle, webRoots *x509.CertPool) (*ca.TrustBundle, error) {
+	tlsConfig := &tls.Config{RootCAs: webRoots, MinVersion: tls.VersionTLS12}
+	if rel.Profile == ca.ProfileHTTPSSPIFFE {
+		if current == nil || len(current.X509Authorities) == 0 {
+			return nil, fmt.Errorf("federation %s: no bundle to authenticate the https_spiffe endpoint", rel.TrustDomain)
+		}
+		id, err := spiffeid.FromString(rel.EndpointSPIFFEID)
+		if err != nil {
+			return nil, err
+		}
+		// SVIDs carry no DNS name to check, so the usual verification is
+		// replaced with a SPIFFE ID check against the foreign bundle.
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify:    true,
+			MinVersion:            tls.VersionTLS12,
+			VerifyPeerCertificate: verifySVID(id, current.X509Authorities),
+		}
+	}
+	client := &http.Client{
+		Timeout:   fetchTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rel.BundleEndpointURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("federation %s: %w", rel.TrustDomain, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("federation %s: bundle endpoint returned %s", rel.TrustDomain, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("federation %s: %w", rel.TrustDomain, err)
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("federation %s: bundle exceeds %d bytes", rel.TrustDomain, maxBundleSize)
+	}
+	b, err := ca.ParseTrustBundle(data)
+	if err != nil {
+		return nil, fmt.Errorf("federation %s: %w", rel.TrustDomain, err)
+	}
+	if len(b.X509Authorities) == 0 && len(b.JWTAuthorities) == 0 {
+		return nil, fmt.Errorf("federation %s: bundle has no authorities", rel.TrustDomain)
+	}
+	return b, nil
+}
+
+// verifySVID checks that the peer's leaf is an X.509-SVID for id issued
+// under roots.
+func verifySVID(id spiffeid.ID, roots []*x509.Certificate) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return errors.New("bundle endpoint presented no certificate")
+		}
+		var chain []*x509.Certificate
+		for _, der := range raw {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, cert)
+		}
+		opts := x509.VerifyOptions{
+			Roots:         x509.NewCertPool(),
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, r := range roots {
+			opts.Roots.AddCert(r)
+		}
+		for _, c := range chain[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		if _, err := chain[0].Verify(opts); err != nil {
+			return fmt.Errorf("bundle endpoint certificate: %w", err)
+		}
+		if len(chain[0].URIs) != 1 {
+			return fmt.Errorf("bundle endpoint certificate has %d URI SANs, want 1", len(chain[0].URIs))
+		}
+		got, err := spiffeid.FromURI(chain[0].URIs[0])
+		if err != nil {
+			return fmt.Errorf("bundle endpoint certificate: %w", err)
+		}
+		if got != id {
+			return fmt.Errorf("bundle endpoint is %s, want %s", got, id)
+		}
+		return nil
+	}
+}
+
+// Refresher keeps the bundles of a CA's federation relationships current.
+// Each bundle is fetched again after its spiffe_refresh_hint
+// (ca.BundleRefreshHint when unset), or RetryInterval after a failure.
+// Relationships are re-read on every pass, so `ztca federation add` takes
+// effect without a restart.
+type Refresher struct {
+	CA       *ca.Config
+	WebRoots *x509.CertPool // https_web; nil uses the system roots
+	Logf     func(format string, args ...any)
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// Refresh fetches every bundle due at now and returns when the next one is.
+// It returns the first error after attempting every due relationship.
+func (r *Refresher) Refresh(ctx context.Context, now time.Time) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == nil {
+		r.next = map[string]time.Time{}
+	}
+	rels, err := r.CA.FederationRelationships()
+	if err != nil {
+		return now.Add(RetryInterval), err
+	}
+	next := now.Add(ca.BundleRefreshHint)
+	var firstErr error
+	for _, rel := range rels {
+		name := rel.TrustDomain.Name()
+		if due, ok := r.next[name]; ok && now.Before(due) {
+			if due.Before(next) {
+				next = due
+			}
+			continue
+		}
+		due := now.Add(RetryInterval)
+		if hint, err := r.refreshOne(ctx, rel); err != nil {
+			r.logf("federation: refresh %s: %v", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			due = now.Add(hint)
+		}
+		r.next[name] = due
+		if due.Before(next) {
+			next = due
+		}
+	}
+	return next, firstErr
+}
+
+func (r *Refresher) refreshOne(ctx context.Context, rel ca.FederationRelationship) (time.Duration, error) {
+	current, err := r.CA.FederatedBundle(rel.TrustDomain)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	b, err := Fetch(ctx, rel, current, r.WebRoots)
+	if err != nil {
+		return 0, err
+	}
+	if current != nil && b.Sequence != 0 && b.Sequence < current.Sequence {
+		return 0, fmt.Errorf("bundle sequence went back from %d to %d", current.Sequence, b.Sequence)
+	}
+	if err := r.CA.StoreFederatedBundle(rel.TrustDomain, b); err != nil {
+		return 0, err
+	}
+	if b.RefreshHint > 0 {
+		return b.RefreshHint, nil
+	}
+	return ca.BundleRefreshHint, nil
+}
+
+// Run calls Refresh until ctx is done.
+func (r *Refresher) Run(ctx context.Context) {
+	for {
+		next, _ := r.Refresh(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+func (r *Refresher) logf(format string, args ...any) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
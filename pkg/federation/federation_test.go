@@ -0,0 +1,129 @@
+package federation
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// newCA initializes a CA for trust domain name.
+func newCA(t *testing.T, name string) *ca.Config {
+	t.Helper()
+	td, err := spiffeid.TrustDomainFromString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &ca.Config{BaseDir: t.TempDir(), TrustDomain: td, RootKeyAlgorithm: ca.ECDSAP256, IntermediateKeyAlgorithm: ca.ECDSAP256, LeafKeyAlgorithm: ca.ECDSAP256}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHTTPSWeb(t *testing.T) {
+	foreign := newCA(t, "other.org")
+	srv := httptest.NewTLSServer(Handler(foreign))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	local := newCA(t, "demo")
+	rel := ca.FederationRelationship{TrustDomain: foreign.TrustDomain, BundleEndpointURL: srv.URL, Profile: ca.ProfileHTTPSWeb}
+	if err := local.SetFederationRelationship(rel, nil); err != nil {
+		t.Fatal(err)
+	}
+	r := &Refresher{CA: local, WebRoots: roots}
+	now := time.Now()
+	next, err := r.Refresh(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.Equal(now.Add(ca.BundleRefreshHint)) {
+		t.Errorf("next refresh %v, want refresh hint", next.Sub(now))
+	}
+	want, _ := foreign.TrustBundle()
+	got, err := local.FederatedBundle(foreign.TrustDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Sequence != want.Sequence || len(got.X509Authorities) != len(want.X509Authorities) || len(got.JWTAuthorities) != 1 {
+		t.Errorf("fetched bundle: sequence %d, %d authorities", got.Sequence, len(got.X509Authorities))
+	}
+
+	// A rotation in the foreign domain is picked up once the hint passes.
+	if _, err := foreign.RotateIntermediate(foreign, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Refresh(context.Background(), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := local.FederatedBundle(foreign.TrustDomain); got.Sequence != want.Sequence {
+		t.Error("refreshed before the refresh hint")
+	}
+	if _, err := r.Refresh(context.Background(), next); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := local.FederatedBundle(foreign.TrustDomain); got.Sequence != want.Sequence+1 || len(got.X509Authorities) != 3 {
+		t.Errorf("after rotation: sequence %d, %d authorities", got.Sequence, len(got.X509Authorities))
+	}
+
+	// Without the test server's root the endpoint is not trusted.
+	if _, err := Fetch(context.Background(), rel, nil, x509.NewCertPool()); err == nil {
+		t.Error("fetched from an untrusted https_web endpoint")
+	}
+}
+
+func TestHTTPSSPIFFE(t *testing.T) {
+	foreign := newCA(t, "other.org")
+	endpointID, _ := spiffeid.FromPath(foreign.TrustDomain, "/bundle-endpoint")
+	// StartTLS would install httptest's own certificate ahead of
+	// GetCertificate, so wrap the listener directly.
+	srv := httptest.NewUnstartedServer(Handler(foreign))
+	srv.Listener = tls.NewListener(srv.Listener, &tls.Config{GetCertificate: (&EndpointSVID{CA: foreign, ID: endpointID}).GetCertificate})
+	srv.Start()
+	defer srv.Close()
+	endpointURL := strings.Replace(srv.URL, "http://", "https://", 1)
+
+	bootstrap, err := foreign.TrustBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := ca.FederationRelationship{TrustDomain: foreign.TrustDomain, BundleEndpointURL: endpointURL, Profile: ca.ProfileHTTPSSPIFFE, EndpointSPIFFEID: endpointID.String()}
+	b, err := Fetch(context.Background(), rel, bootstrap, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Sequence != bootstrap.Sequence {
+		t.Errorf("sequence %d, want %d", b.Sequence, bootstrap.Sequence)
+	}
+
+	wrongID := rel
+	wrongID.EndpointSPIFFEID = "spiffe://other.org/impostor"
+	if _, err := Fetch(context.Background(), wrongID, bootstrap, nil); err == nil || !strings.Contains(err.Error(), "impostor") {
+		t.Errorf("wrong endpoint ID: %v", err)
+	}
+	unrelated, _ := newCA(t, "third.org").TrustBundle()
+	if _, err := Fetch(context.Background(), rel, unrelated, nil); err == nil {
+		t.Error("endpoint accepted under an unrelated bundle")
+	}
+	if _, err := Fetch(context.Background(), rel, nil, nil); err == nil {
+		t.Error("https_spiffe fetch without a bundle")
+	}
+
+	// The refresher authenticates with the stored bundle and replaces it.
+	local := newCA(t, "demo")
+	if err := local.SetFederationRelationship(rel, bootstrap); err != nil {
+		t.Fatal(err)
+	}
+	r := &Refresher{CA: local}
+	if _, err := r.Refresh(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,291 @@
+// Package jwtsvid signs and validates JWT-SVIDs, the JWT form of a SPIFFE
+// identity: a compact JWS whose sub is the workload's SPIFFE ID, with a
+// required audience and expiry, signed by one of the trust domain's JWT
+// authorities (SPIFFE JWT-SVID specification).
+package jwtsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// Leeway is the clock skew tolerated when checking exp and iat.
+const Leeway = 30 * time.Second
+
+// Validation errors, wrapped with detail; test with errors.Is.
+var (
+	ErrMalformed      = errors.New("malformed JWT")
+	ErrAlgorithm      = errors.New("unsupported or mismatched JWT algorithm")
+	ErrUnknownKey     = errors.New("JWT signed by an unknown key")
+	ErrSignature      = errors.New("JWT signature is invalid")
+	ErrExpired        = errors.New("JWT-SVID has expired")
+	ErrAudience       = errors.New("JWT-SVID audience does not match")
+	ErrInvalidSubject = errors.New("JWT-SVID subject is not a valid SPIFFE ID in the trust domain")
+)
+
+// Header is the JOSE header. X5c carries a certificate chain (standard
+// base64 DER, leaf first) when the signing key is certified by one.
+type Header struct {
+	Alg string   `json:"alg"`
+	Typ string   `json:"typ,omitempty"`
+	Kid string   `json:"kid,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+// Claims are the registered claims of a JWT-SVID.
+type Claims struct {
+	Subject  string   `json:"sub,omitempty"`
+	Audience Audience `json:"aud,omitempty"`
+	Expiry   int64    `json:"exp,omitempty"`
+	IssuedAt int64    `json:"iat,omitempty"`
+}
+
+// Audience is the aud claim, which JWTs may encode as a single string or
+// an array.
+type Audience []string
+
+// UnmarshalJSON accepts either encoding.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether aud is one of a's entries.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Algorithm returns the JWS algorithm for pub: RS256 for RSA and ES256,
+// ES384 or ES512 by ECDSA curve. Ed25519 (EdDSA) is not among the
+// algorithms the JWT-SVID specification allows.
+func Algorithm(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+	}
+	return "", fmt.Errorf("%w: no JWT-SVID algorithm for %T", ErrAlgorithm, pub)
+}
+
+func algHash(alg string) (crypto.Hash, int, error) {
+	switch alg {
+	case "RS256":
+		return crypto.SHA256, 0, nil
+	case "ES256":
+		return crypto.SHA256, 32, nil
+	case "ES384":
+		return crypto.SHA384, 48, nil
+	case "ES512":
+		return crypto.SHA512, 66, nil
+	}
+	return 0, 0, fmt.Errorf("%w: %q", ErrAlgorithm, alg)
+}
+
+var b64 = base64.RawURLEncoding
+
+// Sign returns the compact JWS of claims signed by key. The header's alg
+// is set from the key and typ defaults to "JWT".
+func Sign(key crypto.Signer, header Header, claims any) (string, error) {
+	alg, err := Algorithm(key.Public())
+	if err != nil {
+		return "", err
+	}
+	header.Alg = alg
+	if header.Typ == "" {
+		header.Typ = "JWT"
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	hash, size, _ := algHash(alg)
+	d := hash.New()
+	d.Write([]byte(input))
+	sig, err := key.Sign(rand.Reader, d.Sum(nil), hash)
+	if err != nil {
+		return "", err
+	}
+	if size > 0 {
+		// crypto.Signer returns an ASN.1 ECDSA-Sig-Value; JWS wants r||s.
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return "", fmt.Errorf("ECDSA signature: %w", err)
+		}
+		sig = append(rs.R.FillBytes(make([]byte, size)), rs.S.FillBytes(make([]byte, size))...)
+	}
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// Token is a decoded but not yet verified JWS.
+type Token struct {
+	Header Header
+	Claims Claims
+
+	input     string
+	signature []byte
+}
+
+// Parse decodes a compact JWS without checking its signature.
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want 3 parts, got %d", ErrMalformed, len(parts))
+	}
+	t := &Token{input: parts[0] + "." + parts[1]}
+	for i, dst := range []any{&t.Header, &t.Claims} {
+		data, err := b64.DecodeString(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		if err := json.Unmarshal(data, dst); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+	t.signature = sig
+	return t, nil
+}
+
+// Verify checks t's signature with pub. The header's alg must be the one
+// Algorithm assigns to pub, so a token cannot pick a weaker algorithm.
+func (t *Token) Verify(pub crypto.PublicKey) error {
+	want, err := Algorithm(pub)
+	if err != nil {
+		return err
+	}
+	if t.Header.Alg != want {
+		return fmt.Errorf("%w: header says %q, key needs %q", ErrAlgorithm, t.Header.Alg, want)
+	}
+	hash, size, err := algHash(want)
+	if err != nil {
+		return err
+	}
+	d := hash.New()
+	d.Write([]byte(t.input))
+	digest := d.Sum(nil)
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, hash, digest, t.signature) != nil {
+			return ErrSignature
+		}
+	case *ecdsa.PublicKey:
+		if len(t.signature) != 2*size {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrSignature
+		}
+	}
+	return nil
+}
+
+// SVID is a validated JWT-SVID.
+type SVID struct {
+	ID       spiffeid.ID
+	Audience []string
+	Expiry   time.Time
+	IssuedAt time.Time // zero if the token has no iat
+	Token    string
+}
+
+// Validate checks that token is a JWT-SVID for audience, signed by one of
+// keys (a trust bundle's JWT authorities, by key ID), whose subject is in
+// td and which has not expired at now.
+func Validate(token string, td spiffeid.TrustDomain, keys map[string]crypto.PublicKey, audience string, now time.Time) (*SVID, error) {
+	t, err := Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if t.Header.Typ != "" && t.Header.Typ != "JWT" && t.Header.Typ != "JOSE" {
+		return nil, fmt.Errorf("%w: typ %q", ErrMalformed, t.Header.Typ)
+	}
+	pub, ok := keys[t.Header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: kid %q", ErrUnknownKey, t.Header.Kid)
+	}
+	if err := t.Verify(pub); err != nil {
+		return nil, err
+	}
+	svid, err := t.checkClaims(td, audience, now)
+	if err != nil {
+		return nil, err
+	}
+	svid.Token = token
+	return svid, nil
+}
+
+// checkClaims validates t's registered claims: exp (required), iat,
+// audience and a subject in td.
+func (t *Token) checkClaims(td spiffeid.TrustDomain, audience string, now time.Time) (*SVID, error) {
+	c := t.Claims
+	if c.Expiry == 0 {
+		return nil, fmt.Errorf("%w: no exp claim", ErrMalformed)
+	}
+	exp := time.Unix(c.Expiry, 0)
+	if !now.Before(exp.Add(Leeway)) {
+		return nil, fmt.Errorf("%w at %s", ErrExpired, exp.UTC().Format(time.RFC3339))
+	}
+	var iat time.Time
+	if c.IssuedAt != 0 {
+		iat = time.Unix(c.IssuedAt, 0)
+		if iat.After(now.Add(Leeway)) {
+			return nil, fmt.Errorf("%w: issued in the future (%s)", ErrMalformed, iat.UTC().Format(time.RFC3339))
+		}
+	}
+	if len(c.Audience) == 0 || !c.Audience.Contains(audience) {
+		return nil, fmt.Errorf("%w: want %q, token has %q", ErrAudience, audience, []string(c.Audience))
+	}
+	id, err := spiffeid.FromString(c.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSubject, err)
+	}
+	if err := id.RequireMemberOf(td); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSubject, err)
+	}
+	return &SVID{ID: id, Audience: c.Audience, Expiry: exp, IssuedAt: iat}, nil
+}
@@ -0,0 +1,185 @@
+package jwtsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+func mustTrustDomain(t *testing.T, name string) spiffeid.TrustDomain {
+	t.Helper()
+	td, err := spiffeid.TrustDomainFromString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return td
+}
+
+func TestSignValidate(t *testing.T) {
+	td := mustTrustDomain(t, "demo")
+	now := time.Unix(1700000000, 0)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	keys := map[string]crypto.PublicKey{"rsa": rsaKey.Public(), "p256": p256.Public(), "p384": p384.Public()}
+	claims := Claims{
+		Subject:  "spiffe://demo/ns/default/sa/service-a",
+		Audience: Audience{"service-b", "gateway"},
+		Expiry:   now.Add(5 * time.Minute).Unix(),
+		IssuedAt: now.Unix(),
+	}
+	for kid, key := range map[string]crypto.Signer{"rsa": rsaKey, "p256": p256, "p384": p384} {
+		token, err := Sign(key, Header{Kid: kid}, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svid, err := Validate(token, td, keys, "gateway", now)
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		if svid.ID.String() != claims.Subject || !svid.Expiry.Equal(time.Unix(claims.Expiry, 0)) {
+			t.Errorf("%s: svid %+v", kid, svid)
+		}
+	}
+
+	token, _ := Sign(p256, Header{Kid: "p256"}, claims)
+	cases := map[string]struct {
+		token    string
+		td       spiffeid.TrustDomain
+		audience string
+		now      time.Time
+		want     error
+	}{
+		"expired":       {token, td, "service-b", now.Add(time.Hour), ErrExpired},
+		"audience":      {token, td, "service-c", now, ErrAudience},
+		"trust domain":  {token, mustTrustDomain(t, "other"), "service-b", now, ErrInvalidSubject},
+		"unknown kid":   {strings.Replace(token, token[:strings.Index(token, ".")], b64.EncodeToString([]byte(`{"alg":"ES256","kid":"x"}`)), 1), td, "service-b", now, ErrUnknownKey},
+		"key confusion": {withHeader(t, token, `{"alg":"ES256","kid":"rsa"}`), td, "service-b", now, ErrAlgorithm},
+		"signature":     {token[:len(token)-4] + "AAAA", td, "service-b", now, ErrSignature},
+		"alg none":      {withHeader(t, token, `{"alg":"none","kid":"p256"}`), td, "service-b", now, ErrAlgorithm},
+		"parts":         {"a.b", td, "service-b", now, ErrMalformed},
+	}
+	for name, c := range cases {
+		if _, err := Validate(c.token, c.td, keys, c.audience, c.now); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, c.want)
+		}
+	}
+
+	if _, err := Sign(ed25519Key(t), Header{}, claims); !errors.Is(err, ErrAlgorithm) {
+		t.Errorf("Ed25519: err = %v", err)
+	}
+}
+
+// resign swaps token's header, keeping the original signature.
+func withHeader(t *testing.T, token, header string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	return b64.EncodeToString([]byte(header)) + "." + parts[1] + "." + parts[2]
+}
+
+func ed25519Key(t *testing.T) crypto.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAudienceString(t *testing.T) {
+	var c Claims
+	if err := json.Unmarshal([]byte(`{"aud":"service-b"}`), &c); err != nil || !c.Audience.Contains("service-b") {
+		t.Errorf("single-string aud: %v, %v", c.Audience, err)
+	}
+}
+
+func TestProof(t *testing.T) {
+	td := mustTrustDomain(t, "demo")
+	now := time.Now()
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, rootKey, rootKey)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	id, _ := url.Parse("spiffe://demo/ns/default/sa/service-a")
+	leaf := newCert(t, &x509.Certificate{URIs: []*url.URL{id}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, KeyUsage: x509.KeyUsageDigitalSignature}, root, leafKey, rootKey)
+
+	proof, err := SignProof(leafKey, []*x509.Certificate{leaf}, td.IDString(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svid, cert, err := ValidateProof(proof, td, []*x509.Certificate{root}, td.IDString(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svid.ID.String() != id.String() || cert.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("proof for %s, cert %v", svid.ID, cert.SerialNumber)
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherRoot := newCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, otherKey, otherKey)
+	stolen, _ := SignProof(otherKey, []*x509.Certificate{leaf}, td.IDString(), now)
+	// A proof claiming another subject than its certificate.
+	forged, _ := Sign(leafKey, Header{X5c: []string{base64.StdEncoding.EncodeToString(leaf.Raw)}}, Claims{
+		Subject: "spiffe://demo/ns/default/sa/admin", Audience: Audience{td.IDString()},
+		Expiry: now.Add(time.Minute).Unix(), IssuedAt: now.Unix(),
+	})
+	longLived, _ := Sign(leafKey, Header{X5c: []string{base64.StdEncoding.EncodeToString(leaf.Raw)}}, Claims{
+		Subject: id.String(), Audience: Audience{td.IDString()},
+		Expiry: now.Add(time.Hour).Unix(), IssuedAt: now.Unix(),
+	})
+	cases := map[string]struct {
+		token string
+		roots []*x509.Certificate
+		aud   string
+		now   time.Time
+		want  error
+	}{
+		"untrusted root":  {proof, []*x509.Certificate{otherRoot}, td.IDString(), now, ErrCertificate},
+		"wrong key":       {stolen, []*x509.Certificate{root}, td.IDString(), now, ErrSignature},
+		"audience":        {proof, []*x509.Certificate{root}, "spiffe://other", now, ErrAudience},
+		"expired":         {proof, []*x509.Certificate{root}, td.IDString(), now.Add(2 * time.Minute), ErrExpired},
+		"subject":         {forged, []*x509.Certificate{root}, td.IDString(), now, ErrInvalidSubject},
+		"long lived":      {longLived, []*x509.Certificate{root}, td.IDString(), now, ErrMalformed},
+		"no certificates": {withHeader(t, proof, `{"alg":"ES256"}`), []*x509.Certificate{root}, td.IDString(), now, ErrCertificate},
+	}
+	for name, c := range cases {
+		if _, _, err := ValidateProof(c.token, td, c.roots, c.aud, c.now); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, c.want)
+		}
+	}
+}
+
+var serial int64
+
+func newCert(t *testing.T, template, parent *x509.Certificate, key, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	serial++
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
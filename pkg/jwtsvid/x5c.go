@@ -0,0 +1,117 @@
+package jwtsvid
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/spiffeid"
+)
+
+// ProofTTL is the lifetime of the proofs SignProof creates. Proofs are
+// bearer credentials for a single request, so they are kept short.
+const ProofTTL = time.Minute
+
+// ErrCertificate reports an x5c chain that does not verify.
+var ErrCertificate = errors.New("JWT x5c certificate chain is invalid")
+
+// SignProof returns a JWS proving possession of an X.509-SVID: its claims
+// name the certificate's SPIFFE ID as sub and audience as aud, it is
+// signed with the SVID's key and carries chain (leaf first) in x5c. Peers
+// that cannot see the TLS client certificate, such as services behind an
+// HTTP gateway, authenticate the workload with ValidateProof.
+func SignProof(key crypto.Signer, chain []*x509.Certificate, audience string, now time.Time) (string, error) {
+	if len(chain) == 0 {
+		return "", errors.New("proof needs the X.509-SVID chain")
+	}
+	id, err := certificateID(chain[0])
+	if err != nil {
+		return "", err
+	}
+	header := Header{}
+	for _, c := range chain {
+		header.X5c = append(header.X5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	return Sign(key, header, Claims{
+		Subject:  id.String(),
+		Audience: Audience{audience},
+		Expiry:   now.Add(ProofTTL).Unix(),
+		IssuedAt: now.Unix(),
+	})
+}
+
+// ValidateProof checks a SignProof token for audience: the x5c leaf must
+// chain to roots for client authentication at now, hold a SPIFFE ID in td
+// equal to sub, and have signed the token. It returns the SVID and the
+// leaf, whose revocation status the caller should check.
+func ValidateProof(token string, td spiffeid.TrustDomain, roots []*x509.Certificate, audience string, now time.Time) (*SVID, *x509.Certificate, error) {
+	t, err := Parse(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(t.Header.X5c) == 0 {
+		return nil, nil, fmt.Errorf("%w: no x5c header", ErrCertificate)
+	}
+	var chain []*x509.Certificate
+	for i, v := range t.Header.X5c {
+		der, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: x5c[%d]: %v", ErrMalformed, i, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: x5c[%d]: %v", ErrCertificate, i, err)
+		}
+		chain = append(chain, cert)
+	}
+	leaf := chain[0]
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, r := range roots {
+		opts.Roots.AddCert(r)
+	}
+	for _, c := range chain[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCertificate, err)
+	}
+	if err := t.Verify(leaf.PublicKey); err != nil {
+		return nil, nil, err
+	}
+	svid, err := t.checkClaims(td, audience, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := certificateID(leaf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if id != svid.ID {
+		return nil, nil, fmt.Errorf("%w: sub %s, certificate %s", ErrInvalidSubject, svid.ID, id)
+	}
+	if svid.Expiry.Sub(time.Unix(t.Claims.IssuedAt, 0)) > ProofTTL {
+		return nil, nil, fmt.Errorf("%w: proof lifetime exceeds %v", ErrMalformed, ProofTTL)
+	}
+	svid.Token = token
+	return svid, leaf, nil
+}
+
+// certificateID returns the single SPIFFE ID URI SAN of an X.509-SVID.
+func certificateID(cert *x509.Certificate) (spiffeid.ID, error) {
+	if len(cert.URIs) != 1 {
+		return spiffeid.ID{}, fmt.Errorf("%w: X.509-SVID has %d URI SANs, want 1", ErrCertificate, len(cert.URIs))
+	}
+	id, err := spiffeid.FromURI(cert.URIs[0])
+	if err != nil {
+		return spiffeid.ID{}, fmt.Errorf("%w: %v", ErrCertificate, err)
+	}
+	return id, nil
+}
@@ -8,6 +8,7 @@ type ServiceIdentity struct {
 	SpiffeID         string    `json:"spiffe_id"`
 	CreatedAt        time.Time `json:"created_at"`
 	BootstrapTokenHash string  `json:"-"` // never expose
+	Profiles         []string  `json:"profiles,omitempty"` // allowed certificate profiles; first is the default
 	Active           bool      `json:"active"`
 }
 
@@ -0,0 +1,363 @@
+// Package ocsp answers RFC 6960 OCSP requests for leaf certificates from the
+// CA's issuance database, signing with delegated responder certificates
+// issued by each intermediate.
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	xocsp "golang.org/x/crypto/ocsp"
+)
+
+// DefaultValidity is the gap between a response's ThisUpdate and
+// NextUpdate. Cached responses are re-signed once half of it has passed.
+const DefaultValidity = time.Hour
+
+// backdate is subtracted from ThisUpdate to tolerate relying-party clock
+// skew, as for CRLs.
+const backdate = ca.DefaultCRLBackdate
+
+// maxRequestSize bounds POST bodies; a single-certificate request is ~100
+// bytes.
+const maxRequestSize = 4 << 10
+
+// Responder answers OCSP requests for certificates issued by the CA in
+// CA.BaseDir. It loads the delegated signer of each unretired intermediate
+// with ca.Config.OCSPSigner, reloads them and issued.jsonl and
+// intermediates.json when they change, and caches signed responses until
+// they are half expired. It never reads an intermediate key: signers are
+// issued and renewed by ca.Config.RenewOCSPSigners, and intermediates
+// without one are answered as unauthorized. Responder implements
+// http.Handler for the GET and POST transports of RFC 6960 Appendix A.
+type Responder struct {
+	CA       *ca.Config
+	Validity time.Duration // default DefaultValidity
+
+	mu           sync.Mutex
+	issuers      []*issuer
+	interStamp   ca.FileStamp
+	signerStamps map[string]ca.FileStamp // <name>-ocsp.crt per unretired intermediate
+	dbStamp      ca.FileStamp
+	records      map[string]ca.IssuedRecord
+	cache        map[cacheKey]*response
+}
+
+// issuer is an unretired intermediate and its delegated signer.
+type issuer struct {
+	name    string
+	serial  string
+	cert    *x509.Certificate
+	key     crypto.Signer
+	signer  *x509.Certificate
+	keyBits []byte // subjectPublicKey, hashed for IssuerKeyHash
+}
+
+type cacheKey struct {
+	issuer string
+	serial string
+	hash   crypto.Hash
+}
+
+type response struct {
+	der        []byte
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+func (r *Responder) validity() time.Duration {
+	if r.Validity > 0 {
+		return r.Validity
+	}
+	return DefaultValidity
+}
+
+// Respond returns the DER OCSP response to the DER request reqDER. Requests
+// that cannot be parsed get a malformedRequest response and requests for
+// other issuers an unauthorized one; serials the CA never issued are
+// reported as unknown. The error is non-nil only alongside an
+// internalError response.
+func (r *Responder) Respond(reqDER []byte, now time.Time) ([]byte, error) {
+	resp, err := r.respond(reqDER, now)
+	if err != nil {
+		return xocsp.InternalErrorErrorResponse, err
+	}
+	return resp.der, nil
+}
+
+func (r *Responder) respond(reqDER []byte, now time.Time) (*response, error) {
+	req, err := xocsp.ParseRequest(reqDER)
+	if err != nil || !req.HashAlgorithm.Available() {
+		return &response{der: xocsp.MalformedRequestErrorResponse}, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.sync(now); err != nil {
+		return nil, err
+	}
+	iss := r.findIssuer(req)
+	if iss == nil {
+		return &response{der: xocsp.UnauthorizedErrorResponse}, nil
+	}
+	return r.lookup(iss, req.SerialNumber, req.HashAlgorithm, now)
+}
+
+// lookup returns the cached response for serial, signing a new one when
+// there is none or the cached one is half expired. Unknown serials are not
+// cached, so arbitrary requests cannot grow the cache.
+func (r *Responder) lookup(iss *issuer, serial *big.Int, hash crypto.Hash, now time.Time) (*response, error) {
+	key := cacheKey{iss.name, fmt.Sprintf("%X", serial), hash}
+	if resp, ok := r.cache[key]; ok && now.Before(resp.thisUpdate.Add(backdate+r.validity()/2)) {
+		return resp, nil
+	}
+	tmpl := xocsp.Response{
+		Status:       xocsp.Unknown,
+		SerialNumber: serial,
+		ThisUpdate:   now.Add(-backdate),
+		NextUpdate:   now.Add(r.validity()),
+		Certificate:  iss.signer,
+		IssuerHash:   hash,
+	}
+	rec, known := r.records[key.serial]
+	known = known && rec.IssuerSerial == iss.serial
+	if known {
+		tmpl.Status = xocsp.Good
+		if rec.Status == ca.StatusRevoked {
+			code, _, err := ca.ParseRevocationReason(rec.RevocationReason)
+			if err != nil {
+				return nil, fmt.Errorf("serial %s: %w", key.serial, err)
+			}
+			tmpl.Status = xocsp.Revoked
+			tmpl.RevokedAt = rec.RevokedAt
+			tmpl.RevocationReason = code
+		}
+	}
+	der, err := xocsp.CreateResponse(iss.cert, iss.signer, tmpl, iss.key)
+	if err != nil {
+		return nil, fmt.Errorf("signing response for %s: %w", key.serial, err)
+	}
+	resp := &response{der: der, thisUpdate: tmpl.ThisUpdate, nextUpdate: tmpl.NextUpdate}
+	if known {
+		r.cache[key] = resp
+	}
+	return resp, nil
+}
+
+// Refresh pre-signs SHA-1 responses (the hash OpenSSL and most clients
+// send) for every unexpired certificate issued by an unretired
+// intermediate, so requests are normally answered from the cache. It
+// returns the number of certificates covered.
+func (r *Responder) Refresh(now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.sync(now); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, iss := range r.issuers {
+		for _, rec := range r.records {
+			if rec.IssuerSerial != iss.serial || now.After(rec.NotAfter) {
+				continue
+			}
+			serial, ok := new(big.Int).SetString(rec.Serial, 16)
+			if !ok {
+				return n, fmt.Errorf("%s: malformed serial", rec.Serial)
+			}
+			if _, err := r.lookup(iss, serial, crypto.SHA1, now); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// sync reloads the intermediates and their signers when intermediates.json
+// or a signer certificate changes, and the issuance records when
+// issued.jsonl changes. Cached responses whose record changed are dropped.
+func (r *Responder) sync(now time.Time) error {
+	stamp, err := ca.StatFile(filepath.Join(r.CA.BaseDir, "intermediates.json"))
+	if err != nil {
+		return err
+	}
+	changed, err := r.signersChanged()
+	if err != nil {
+		return err
+	}
+	if r.issuers == nil || stamp != r.interStamp || changed {
+		if err := r.loadIssuers(); err != nil {
+			return err
+		}
+		r.interStamp = stamp
+	}
+
+	path := r.CA.IssuanceDB().Path
+	stamp, err = ca.StatFile(path)
+	if err != nil {
+		return err
+	}
+	if r.records != nil && stamp == r.dbStamp {
+		return nil
+	}
+	recs, err := r.CA.IssuanceDB().List(ca.IssuedFilter{})
+	if err != nil {
+		return err
+	}
+	records := make(map[string]ca.IssuedRecord, len(recs))
+	for _, rec := range recs {
+		records[rec.Serial] = rec
+	}
+	for key := range r.cache {
+		old, new := r.records[key.serial], records[key.serial]
+		if old.Status != new.Status || !old.RevokedAt.Equal(new.RevokedAt) || old.RevocationReason != new.RevocationReason {
+			delete(r.cache, key)
+		}
+	}
+	r.records, r.dbStamp = records, stamp
+	return nil
+}
+
+// signersChanged reports whether a signer certificate was written or
+// removed since loadIssuers.
+func (r *Responder) signersChanged() (bool, error) {
+	for name, old := range r.signerStamps {
+		stamp, err := ca.StatFile(filepath.Join(r.CA.BaseDir, ca.OCSPSignerName(name)+".crt"))
+		if err != nil {
+			return false, err
+		}
+		if stamp != old {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadIssuers loads every unretired intermediate with its delegated signer
+// and empties the cache, whose responses may carry a replaced signer.
+// Intermediates whose signer cannot be loaded are skipped until it changes.
+func (r *Responder) loadIssuers() error {
+	recs, err := r.CA.Intermediates()
+	if err != nil {
+		return err
+	}
+	issuers := []*issuer{}
+	stamps := map[string]ca.FileStamp{}
+	for _, rec := range recs {
+		if rec.Retired {
+			continue
+		}
+		cert, err := r.CA.IntermediateCertificate(rec.Name)
+		if err != nil {
+			return err
+		}
+		// Stamp before loading, so a signer written in between is
+		// picked up by the next sync.
+		if stamps[rec.Name], err = ca.StatFile(filepath.Join(r.CA.BaseDir, ca.OCSPSignerName(rec.Name)+".crt")); err != nil {
+			return err
+		}
+		key, signer, err := r.CA.OCSPSigner(rec.Name)
+		if err != nil {
+			log.Printf("ocsp: no delegated signer for %s, not answering for it (the RA or `ztca ocsp` issues one): %v", rec.Name, err)
+			continue
+		}
+		var spki struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return fmt.Errorf("%s.crt: %w", rec.Name, err)
+		}
+		issuers = append(issuers, &issuer{
+			name:    rec.Name,
+			serial:  rec.Serial,
+			cert:    cert,
+			key:     key,
+			signer:  signer,
+			keyBits: spki.PublicKey.RightAlign(),
+		})
+	}
+	r.issuers, r.signerStamps = issuers, stamps
+	r.cache = map[cacheKey]*response{}
+	return nil
+}
+
+// findIssuer matches the request's CertID issuer hashes against the
+// loaded intermediates.
+func (r *Responder) findIssuer(req *xocsp.Request) *issuer {
+	for _, iss := range r.issuers {
+		h := req.HashAlgorithm.New()
+		h.Write(iss.cert.RawSubject)
+		if !bytes.Equal(h.Sum(nil), req.IssuerNameHash) {
+			continue
+		}
+		h.Reset()
+		h.Write(iss.keyBits)
+		if bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+			return iss
+		}
+	}
+	return nil
+}
+
+// ServeHTTP answers GET /<url-encoded base64 request> and POST requests
+// with Content-Type application/ocsp-request. GET responses are cacheable
+// by HTTP proxies until the response's NextUpdate (RFC 5019 section 6).
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqDER []byte
+	switch req.Method {
+	case http.MethodGet:
+		raw, err := url.PathUnescape(strings.TrimPrefix(req.URL.EscapedPath(), "/"))
+		if err == nil {
+			reqDER, err = base64.StdEncoding.DecodeString(raw)
+		}
+		if err != nil {
+			http.Error(w, "malformed OCSP request", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if ct := req.Header.Get("Content-Type"); ct != "application/ocsp-request" {
+			http.Error(w, "Content-Type must be application/ocsp-request", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxRequestSize+1))
+		if err != nil || len(body) > maxRequestSize {
+			http.Error(w, "malformed OCSP request", http.StatusBadRequest)
+			return
+		}
+		reqDER = body
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	resp, err := r.respond(reqDER, now)
+	if err != nil {
+		log.Printf("ocsp: %v", err)
+		resp = &response{der: xocsp.InternalErrorErrorResponse}
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	if req.Method == http.MethodGet && !resp.nextUpdate.IsZero() {
+		maxAge := int(resp.nextUpdate.Sub(now) / time.Second)
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+		w.Header().Set("Last-Modified", resp.thisUpdate.UTC().Format(http.TimeFormat))
+		w.Header().Set("Expires", resp.nextUpdate.UTC().Format(http.TimeFormat))
+	}
+	w.Write(resp.der)
+}
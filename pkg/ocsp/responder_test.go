@@ -0,0 +1,184 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/zero-trust/zt-identity/pkg/ca"
+	xocsp "golang.org/x/crypto/ocsp"
+)
+
+func TestResponder(t *testing.T) {
+	pass := func() ([]byte, error) { return []byte("ocsp test passphrase"), nil }
+	cfg := &ca.Config{BaseDir: t.TempDir(), Passphrase: pass, RootKeyAlgorithm: ca.ECDSAP256, IntermediateKeyAlgorithm: ca.ECDSAP256, LeafKeyAlgorithm: ca.Ed25519}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, _, serial, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := ca.ParseCertificatePEM([]byte(certPEM))
+	inter, _ := cfg.IntermediateCertificate("intermediate")
+	if renewed, err := cfg.RenewOCSPSigners(time.Now()); err != nil || len(renewed) != 1 {
+		t.Fatalf("RenewOCSPSigners = %v, %v", renewed, err)
+	}
+	if renewed, err := cfg.RenewOCSPSigners(time.Now()); err != nil || len(renewed) != 0 {
+		t.Errorf("current signer renewed: %v, %v", renewed, err)
+	}
+	// The responder gets no passphrase: it must not need the CA keys.
+	r := &Responder{CA: &ca.Config{BaseDir: cfg.BaseDir}}
+	if n, err := r.Refresh(time.Now()); err != nil || n != 1 {
+		t.Fatalf("Refresh = %d, %v", n, err)
+	}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	check := func(resp []byte, status int) *xocsp.Response {
+		t.Helper()
+		parsed, err := xocsp.ParseResponseForCert(resp, leaf, inter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Status != status {
+			t.Fatalf("status %d, want %d", parsed.Status, status)
+		}
+		if parsed.Certificate == nil || parsed.Certificate.ExtKeyUsage[0] != x509.ExtKeyUsageOCSPSigning {
+			t.Fatal("response not signed by a delegated OCSP signer")
+		}
+		return parsed
+	}
+	post := func(req []byte) []byte {
+		t.Helper()
+		resp, err := http.Post(srv.URL, "application/ocsp-request", bytes.NewReader(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/ocsp-response" {
+			t.Fatalf("Content-Type %q", ct)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return body
+	}
+	get := func(req []byte) []byte {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/" + url.PathEscape(base64.StdEncoding.EncodeToString(req)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("Cache-Control") == "" {
+			t.Error("GET response lacks Cache-Control")
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return body
+	}
+
+	sha1Req, _ := xocsp.CreateRequest(leaf, inter, nil)
+	sha256Req, _ := xocsp.CreateRequest(leaf, inter, &xocsp.RequestOptions{Hash: crypto.SHA256})
+	check(post(sha1Req), xocsp.Good)
+	good := check(get(sha256Req), xocsp.Good)
+	if d := good.NextUpdate.Sub(good.ThisUpdate); d != DefaultValidity+ca.DefaultCRLBackdate {
+		t.Errorf("response window %s", d)
+	}
+
+	// Revocation shows up without a restart, replacing the cached response.
+	if _, err := cfg.IssuanceDB().RevokeSerial(serial, "keyCompromise", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range [][]byte{sha1Req, sha256Req} {
+		revoked := check(post(req), xocsp.Revoked)
+		if revoked.RevocationReason != xocsp.KeyCompromise {
+			t.Errorf("reason %d", revoked.RevocationReason)
+		}
+	}
+
+	// A serial the CA never issued is unknown.
+	other := *leaf
+	other.SerialNumber = new(big.Int).Lsh(leaf.SerialNumber, 1)
+	unknownReq, _ := xocsp.CreateRequest(&other, inter, nil)
+	if parsed, err := xocsp.ParseResponse(post(unknownReq), inter); err != nil || parsed.Status != xocsp.Unknown {
+		t.Errorf("unknown serial: %v, %v", parsed, err)
+	}
+
+	// Other issuers and garbage get unsigned error responses.
+	foreignReq, _ := xocsp.CreateRequest(leaf, leaf, nil)
+	if !bytes.Equal(post(foreignReq), xocsp.UnauthorizedErrorResponse) {
+		t.Error("request for another issuer was not refused")
+	}
+	if !bytes.Equal(post([]byte("not DER")), xocsp.MalformedRequestErrorResponse) {
+		t.Error("malformed request accepted")
+	}
+	if resp, err := http.Post(srv.URL, "text/plain", bytes.NewReader(sha1Req)); err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong Content-Type: %v, %v", resp, err)
+	}
+}
+
+func TestResponderRotation(t *testing.T) {
+	cfg := &ca.Config{BaseDir: t.TempDir(), RootKeyAlgorithm: ca.ECDSAP256, IntermediateKeyAlgorithm: ca.ECDSAP256, LeafKeyAlgorithm: ca.ECDSAP256}
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.RenewOCSPSigners(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r := &Responder{CA: &ca.Config{BaseDir: cfg.BaseDir}}
+	if _, err := r.Refresh(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := cfg.RotateIntermediate(cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, _, _, err := cfg.IssueLeaf("spiffe://demo/ns/default/sa/test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := ca.ParseCertificatePEM([]byte(certPEM))
+	inter, _ := cfg.IntermediateCertificate(rec.Name)
+	req, _ := xocsp.CreateRequest(leaf, inter, nil)
+
+	// Until the new intermediate has a signer, the responder cannot answer
+	// for it.
+	resp, err := r.Respond(req, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, xocsp.UnauthorizedErrorResponse) {
+		t.Error("answered for an intermediate without a delegated signer")
+	}
+	if renewed, err := cfg.RenewOCSPSigners(time.Now()); err != nil || len(renewed) != 1 || renewed[0] != rec.Name {
+		t.Fatalf("RenewOCSPSigners = %v, %v", renewed, err)
+	}
+	resp, err = r.Respond(req, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := xocsp.ParseResponseForCert(resp, leaf, inter)
+	if err != nil || parsed.Status != xocsp.Good {
+		t.Fatalf("leaf of rotated intermediate: %v, %v", parsed, err)
+	}
+
+	// A renewed signer replaces the loaded one.
+	_, signer, err := cfg.IssueOCSPSigner(rec.Name, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = r.Respond(req, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := xocsp.ParseResponseForCert(resp, leaf, inter); err != nil || !parsed.Certificate.Equal(signer) {
+		t.Errorf("response not signed by the renewed signer: %v", err)
+	}
+}
@@ -0,0 +1,672 @@
+// Package pkcs12 writes and reads PKCS#12 (RFC 7292) key and trust stores
+// for services that cannot load PEM directly, such as JVM and .NET
+// applications.
+//
+// Stores use the modern algorithms Java (8u301+), .NET and OpenSSL 3
+// default to: private keys are shrouded with PBES2 (PBKDF2-HMAC-SHA256,
+// AES-256-CBC) and the store is integrity-protected with an HMAC-SHA256
+// MAC. Certificates are stored unencrypted; they are public. Stores
+// encrypted with the legacy pbeWithSHAAnd3-KeyTripleDES-CBC can be read.
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKey    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Cert       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidJavaTrusted    = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+
+	oidPBEWithSHA3DES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+const (
+	// Iterations is the PBKDF2 and MAC iteration count of written stores,
+	// the Java default.
+	Iterations = 10000
+	// maxIterations bounds the work a store being read can demand.
+	maxIterations = 1 << 22
+	saltLen       = 16
+)
+
+// ErrIncorrectPassword is returned when a store's MAC does not verify.
+var ErrIncorrectPassword = errors.New("pkcs12: incorrect password or corrupted store")
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbeParams are the parameters of the PKCS#12 password-based encryption
+// schemes (RFC 7292, appendix C).
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// Encode returns a PKCS#12 key store holding key with its certificate
+// chain: cert, then caCerts (intermediates, optionally the root). The key
+// entry's alias is alias. Java loads it with KeyStore.getInstance("PKCS12").
+func Encode(key crypto.Signer, cert *x509.Certificate, caCerts []*x509.Certificate, alias, password string) ([]byte, error) {
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return nil, errors.New("pkcs12: private key does not match certificate")
+	}
+	keyID := sha1.Sum(cert.Raw)
+	attrs, err := bagAttributes(alias, keyID[:], false)
+	if err != nil {
+		return nil, err
+	}
+	bags := []safeBag{}
+	leafBag, err := newCertBag(cert, attrs)
+	if err != nil {
+		return nil, err
+	}
+	bags = append(bags, leafBag)
+	for _, c := range caCerts {
+		b, err := newCertBag(c, nil)
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, b)
+	}
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := encryptPBES2(plain, password)
+	if err != nil {
+		return nil, err
+	}
+	keyBag := safeBag{ID: oidShroudedKey, Value: explicitValue(shrouded), Attributes: attrs}
+	return marshalPFX([][]safeBag{bags, {keyBag}}, password)
+}
+
+// EncodeTrustStore returns a PKCS#12 trust store holding certs, each
+// marked as a trusted certificate entry for Java, aliased by position
+// ("ca-0", "ca-1", ...).
+func EncodeTrustStore(certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("pkcs12: trust store needs at least one certificate")
+	}
+	var bags []safeBag
+	for i, c := range certs {
+		attrs, err := bagAttributes(fmt.Sprintf("ca-%d", i), nil, true)
+		if err != nil {
+			return nil, err
+		}
+		b, err := newCertBag(c, attrs)
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, b)
+	}
+	return marshalPFX([][]safeBag{bags}, password)
+}
+
+// Decode parses a PKCS#12 store protected by password. It returns the
+// private key, nil for a trust store, and the certificates with the key's
+// own certificate first. PBES2 and 3DES encryption and SHA-1 and SHA-256
+// MACs are supported; stores using RC2, the default of OpenSSL before 3.0,
+// are refused with a hint to convert them.
+func Decode(data []byte, password string) (crypto.Signer, []*x509.Certificate, error) {
+	var p pfx
+	rest, err := asn1.Unmarshal(data, &p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pkcs12: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("pkcs12: trailing data")
+	}
+	if p.Version != 3 {
+		return nil, nil, fmt.Errorf("pkcs12: unsupported version %d", p.Version)
+	}
+	if !p.AuthSafe.ContentType.Equal(oidData) {
+		return nil, nil, errors.New("pkcs12: only password-integrity stores are supported")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(p.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, nil, fmt.Errorf("pkcs12: auth safe: %w", err)
+	}
+	if len(p.MacData.Mac.Digest) == 0 {
+		return nil, nil, errors.New("pkcs12: store has no MAC")
+	}
+	if err := verifyMAC(p.MacData, authSafe, password); err != nil {
+		return nil, nil, err
+	}
+	var infos []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &infos); err != nil {
+		return nil, nil, fmt.Errorf("pkcs12: auth safe: %w", err)
+	}
+	var key crypto.Signer
+	var keyID []byte
+	var certs []*x509.Certificate
+	var certIDs [][]byte
+	for _, ci := range infos {
+		var contents []byte
+		switch {
+		case ci.ContentType.Equal(oidData):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &contents); err != nil {
+				return nil, nil, fmt.Errorf("pkcs12: %w", err)
+			}
+		case ci.ContentType.Equal(oidEncryptedData):
+			var ed encryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, nil, fmt.Errorf("pkcs12: %w", err)
+			}
+			if contents, err = decrypt(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, ed.EncryptedContentInfo.EncryptedContent, password); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("pkcs12: unsupported content type %v", ci.ContentType)
+		}
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+			return nil, nil, fmt.Errorf("pkcs12: safe contents: %w", err)
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: cert bag: %w", err)
+				}
+				if !cb.ID.Equal(oidX509Cert) {
+					continue
+				}
+				c, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, fmt.Errorf("pkcs12: %w", err)
+				}
+				certs = append(certs, c)
+				certIDs = append(certIDs, localKeyID(bag))
+			case bag.ID.Equal(oidShroudedKey), bag.ID.Equal(oidKeyBag):
+				if key != nil {
+					return nil, nil, errors.New("pkcs12: store holds more than one private key")
+				}
+				var signer crypto.Signer
+				if bag.ID.Equal(oidShroudedKey) {
+					signer, err = DecryptPrivateKey(bag.Value.Bytes, password)
+				} else {
+					signer, err = parsePKCS8(bag.Value.Bytes)
+				}
+				if err != nil {
+					return nil, nil, err
+				}
+				key, keyID = signer, localKeyID(bag)
+			}
+		}
+	}
+	if key == nil {
+		return nil, certs, nil
+	}
+	// Move the key's certificate to the front: by local key ID if the
+	// store has one, otherwise by public key.
+	for i, c := range certs {
+		if (keyID != nil && bytes.Equal(certIDs[i], keyID)) || (keyID == nil && publicKeysEqual(key.Public(), c.PublicKey)) {
+			certs[0], certs[i] = certs[i], certs[0]
+			return key, certs, nil
+		}
+	}
+	return nil, nil, errors.New("pkcs12: no certificate for the private key")
+}
+
+// DecryptPrivateKey decrypts a DER PKCS#8 EncryptedPrivateKeyInfo
+// protected with PBES2 (PBKDF2, AES-CBC) or pbeWithSHAAnd3-KeyTripleDES-CBC:
+// the format of shrouded key bags, and of keys encrypted by openssl pkcs8
+// -topk8 and openssl genpkey.
+func DecryptPrivateKey(der []byte, password string) (crypto.Signer, error) {
+	var epki encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &epki); err != nil {
+		return nil, fmt.Errorf("pkcs12: encrypted private key: %w", err)
+	}
+	plain, err := decrypt(epki.Algorithm, epki.EncryptedData, password)
+	if err != nil {
+		return nil, err
+	}
+	return parsePKCS8(plain)
+}
+
+func parsePKCS8(der []byte) (crypto.Signer, error) {
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: %w", err)
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("pkcs12: unsupported private key type %T", k)
+	}
+	return signer, nil
+}
+
+func marshalPFX(safes [][]safeBag, password string) ([]byte, error) {
+	var infos []contentInfo
+	for _, bags := range safes {
+		contents, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := dataContentInfo(contents)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, ci)
+	}
+	authSafe, err := asn1.Marshal(infos)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := randomBytes(saltLen)
+	if err != nil {
+		return nil, err
+	}
+	mac := macData{
+		Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+		MacSalt:    salt,
+		Iterations: Iterations,
+	}
+	mac.Mac.Digest = computeMAC(sha256.New, authSafe, salt, Iterations, password)
+	ci, err := dataContentInfo(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfx{Version: 3, AuthSafe: ci, MacData: mac})
+}
+
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicitValue(octets)}, nil
+}
+
+func newCertBag(cert *x509.Certificate, attrs []pkcs12Attribute) (safeBag, error) {
+	der, err := asn1.Marshal(certBag{ID: oidX509Cert, Data: cert.Raw})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: oidCertBag, Value: explicitValue(der), Attributes: attrs}, nil
+}
+
+// bagAttributes returns the friendlyName (alias), localKeyId and, for a
+// trust store entry, Java's trusted-certificate attribute.
+func bagAttributes(alias string, keyID []byte, trusted bool) ([]pkcs12Attribute, error) {
+	var attrs []pkcs12Attribute
+	add := func(id asn1.ObjectIdentifier, v any, params string) error {
+		der, err := asn1.MarshalWithParams(v, params)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, pkcs12Attribute{ID: id, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}})
+		return nil
+	}
+	if alias != "" {
+		if err := add(oidFriendlyName, asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(alias, false)}, ""); err != nil {
+			return nil, err
+		}
+	}
+	if keyID != nil {
+		if err := add(oidLocalKeyID, keyID, ""); err != nil {
+			return nil, err
+		}
+	}
+	if trusted {
+		if err := add(oidJavaTrusted, oidAnyExtKeyUsage, ""); err != nil {
+			return nil, err
+		}
+	}
+	return attrs, nil
+}
+
+func localKeyID(bag safeBag) []byte {
+	for _, a := range bag.Attributes {
+		if a.ID.Equal(oidLocalKeyID) {
+			var id []byte
+			if _, err := asn1.Unmarshal(a.Value.Bytes, &id); err == nil {
+				return id
+			}
+		}
+	}
+	return nil
+}
+
+func explicitValue(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// encryptPBES2 encrypts plain with PBKDF2-HMAC-SHA256 and AES-256-CBC and
+// returns the DER EncryptedPrivateKeyInfo. PBES2 takes the password as
+// UTF-8, unlike the MAC.
+func encryptPBES2(plain []byte, password string) ([]byte, error) {
+	salt, err := randomBytes(saltLen)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := randomBytes(aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), salt, Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
+
+// decrypt decrypts data encrypted with PBES2 or, for legacy stores,
+// pbeWithSHAAnd3-KeyTripleDES-CBC.
+func decrypt(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	switch {
+	case alg.Algorithm.Equal(oidPBES2):
+		return decryptPBES2(alg, data, password)
+	case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+		return decrypt3DES(alg, data, password)
+	default:
+		return nil, fmt.Errorf("pkcs12: unsupported encryption algorithm %v (PBES2 or 3DES); "+
+			"convert the store by unpacking it with openssl pkcs12 -legacy -nodes and re-exporting it with OpenSSL 3's openssl pkcs12 -export", alg.Algorithm)
+	}
+}
+
+// decrypt3DES decrypts with pbeWithSHAAnd3-KeyTripleDES-CBC: the key and
+// IV come from the PKCS#12 KDF over SHA-1 (RFC 7292, appendix B).
+func decrypt3DES(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var params pbeParams
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("pkcs12: PBE parameters: %w", err)
+	}
+	if params.Iterations < 1 || params.Iterations > maxIterations {
+		return nil, fmt.Errorf("pkcs12: PBE iteration count %d out of range", params.Iterations)
+	}
+	if len(data) == 0 || len(data)%des.BlockSize != 0 {
+		return nil, errors.New("pkcs12: bad ciphertext length")
+	}
+	pass := bmpString(password, true)
+	block, err := des.NewTripleDESCipher(deriveKey(sha1.New, pass, params.Salt, 1, params.Iterations, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := deriveKey(sha1.New, pass, params.Salt, 2, params.Iterations, des.BlockSize)
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	return unpad(out, des.BlockSize)
+}
+
+func decryptPBES2(alg pkix.AlgorithmIdentifier, data []byte, password string) ([]byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("pkcs12: PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("pkcs12: unsupported key derivation function %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("pkcs12: PBKDF2 parameters: %w", err)
+	}
+	if kdf.IterationCount < 1 || kdf.IterationCount > maxIterations {
+		return nil, fmt.Errorf("pkcs12: PBKDF2 iteration count %d out of range", kdf.IterationCount)
+	}
+	prf := sha1.New
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACSHA1):
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("pkcs12: unsupported PBKDF2 PRF %v", kdf.PRF.Algorithm)
+	}
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("pkcs12: unsupported encryption scheme %v", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("pkcs12: bad AES-CBC IV")
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("pkcs12: bad ciphertext length")
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	return unpad(out, aes.BlockSize)
+}
+
+// unpad strips PKCS#7 padding; bad padding means a wrong password.
+func unpad(out []byte, blockSize int) ([]byte, error) {
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > blockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrIncorrectPassword
+	}
+	return out[:len(out)-pad], nil
+}
+
+func verifyMAC(m macData, authSafe []byte, password string) error {
+	var h func() hash.Hash
+	switch {
+	case m.Mac.Algorithm.Algorithm.Equal(oidSHA1):
+		h = sha1.New
+	case m.Mac.Algorithm.Algorithm.Equal(oidSHA256):
+		h = sha256.New
+	default:
+		return fmt.Errorf("pkcs12: unsupported MAC algorithm %v", m.Mac.Algorithm.Algorithm)
+	}
+	if m.Iterations < 1 || m.Iterations > maxIterations {
+		return fmt.Errorf("pkcs12: MAC iteration count %d out of range", m.Iterations)
+	}
+	if !hmac.Equal(computeMAC(h, authSafe, m.MacSalt, m.Iterations, password), m.Mac.Digest) {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+func computeMAC(h func() hash.Hash, message, salt []byte, iterations int, password string) []byte {
+	key := deriveKey(h, bmpString(password, true), salt, 3, iterations, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// deriveKey is the PKCS#12 key derivation function (RFC 7292, appendix
+// B.2): id 1 derives an encryption key, 2 an IV and 3 a MAC key.
+func deriveKey(h func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	u := h().Size()
+	v := h().BlockSize()
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+	var out []byte
+	for len(out) < size {
+		a := h()
+		a.Write(d)
+		a.Write(i)
+		sum := a.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			a = h()
+			a.Write(sum)
+			sum = a.Sum(nil)
+		}
+		out = append(out, sum...)
+		// I_j = (I_j + B + 1) mod 2^(8v) for each v-byte block of I,
+		// where B is A repeated to v bytes.
+		b := make([]byte, v)
+		for k := range b {
+			b[k] = sum[k%u]
+		}
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				carry += int(i[j+k]) + int(b[k])
+				i[j+k] = byte(carry)
+				carry >>= 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes s as big-endian UTF-16, with a terminating NUL for
+// PKCS#12 KDF passwords.
+func bmpString(s string, terminate bool) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	if terminate {
+		out = append(out, 0, 0)
+	}
+	return out
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
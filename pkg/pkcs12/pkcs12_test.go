@@ -0,0 +1,265 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// opensslStore was written by OpenSSL 3.0 (openssl pkcs12 -export with its
+// defaults: PBES2/AES-256-CBC, SHA-256 MAC, certificates in an
+// encryptedData) for a self-signed P-256 certificate, password "changeit".
+const opensslStore = `
+MIIENQIBAzCCA+sGCSqGSIb3DQEHAaCCA9wEggPYMIID1DCCAnIGCSqGSIb3DQEHBqCCAmMwggJf
+AgEAMIICWAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjy/skoBJqK
+6QICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEENPaH3ely8sWQptxizgKKF6AggHwpD6L
+xPsXf3aTgdQXh2nrBvSfQS9WrnMBPUs4Bfne0oA4nj4o0dHE0IFNHrwsIDckJ1/gVxFXStiiiJxk
+4FYP+u2XhNZ0ornkQKZLa1fTMnseFMhJI9wesHpqSsuTRWrU0caQYW8GB8oALXeZCzQcTOXNCmfI
+3ikxHVQkP2itmPH5vByUWaYUKdYqYxFghnyWiFj7whq4KBK9ubMji4Xtjp8mm940ER7ZVcT/smh+
++6a08No9l2keeiOvFapkAFqk7sqPSXvqEFP0keMmeKVwSqv15BYdj7i56P/27lLo1I03Mq18ayQP
+Gg11AKF0vsfGFFRxqaMTG6pqYt5mHx/hPyKo3RVeB4fxmivZ1EKcFPwRgd0k4c2l8BHQGI1iTolb
+s3EyNClY8Hi1qrgwpR4MyUyY3bchCSltsoFykkdxzEjcosKnFtelBXb4ThK0lqL4ZihXKiya9njL
+GyjZIVMdsyy5QGwzEf/hUAiEXLLej+SlO5zH/X74Zyx7eKTXkfDYUW61cCw8BzWqGPGKDP4zT5c+
+4HJ0geM++snqJbT7u+aOqR2csDieTFYHyxRZvxOJyXOvHKUfmloO5+3XMRkcits+IPH/K56CR09T
+u0wmvpMefw+HUI6kzZG9UakB8UC+PJuICoVCbMmYKEOtFjiMvDCCAVoGCSqGSIb3DQEHAaCCAUsE
+ggFHMIIBQzCCAT8GCyqGSIb3DQEMCgECoIHvMIHsMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEF
+DDAcBAhUEMT4tEYtcQICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEDJ5qydr4C/FmaRe
+KXmI+FAEgZAeAlOMcgGQ17030oVA/KVCUvFdIopbVTnbWGQfxVyDYdmKoPFEgNFWH7ziyq3ykWGB
+2RnlcqlUrVAxYjf+cyHybxbfQCxiDr8xYWhhEWjYGyJAQZakM0epcpSSq1aF9KU6r8MvZpk4NwK0
+KjItrcN2krm8FMW3HYh06TN4NBCRDoWAOxpNQcH5xh6CSRVFAg0xPjAXBgkqhkiG9w0BCRQxCh4I
+AHMAdgBpAGQwIwYJKoZIhvcNAQkVMRYEFPjyKqJX1s+khP08/OCUbUkLcmrMMEEwMTANBglghkgB
+ZQMEAgEFAAQgkrI/7QhJCz0mG8yED9QUmRyHhbS37QLcrwVTskJ8tz8ECHRlwh/rcfakAgIIAA==
+`
+
+// opensslKey is a P-256 key encrypted by OpenSSL 3.0 (openssl pkcs8 -topk8
+// -v2 aes-256-cbc -outform DER), password "changeit".
+const opensslKey = `
+MIHsMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjzmoMaNw0PYwICCAAwDAYIKoZIhvcN
+AgkFADAdBglghkgBZQMEASoEEIQm5WPfmbxhdZZccr9fNSUEgZAiPbUgFEKJJ1N3008u90em7Gxc
+y7Fkf9B0LShY6CxPsmbJaB17Y06q//vHlh9Qjqi48Yv+gDgwz8suL+qdPn34yYjwSeIou1dvzFIt
+KNoBJCWVArlbP/KCJMg545MXlVenewdvl4ju+onBwpAc69UunxxoJiH4f7z30UMsaQzhK0xdd0aD
+9ri0HyjNmD+fkkA=
+`
+
+// legacyStore was written by OpenSSL 3.0 with the pre-3.0 algorithms
+// except RC2 (openssl pkcs12 -export -keypbe PBE-SHA1-3DES -certpbe
+// PBE-SHA1-3DES -macalg sha1) for a self-signed P-256 certificate,
+// password "changeit".
+const legacyStore = `
+MIIDrwIBAzCCA3UGCSqGSIb3DQEHAaCCA2YEggNiMIIDXjCCAjcGCSqGSIb3DQEHBqCCAigwggIk
+AgEAMIICHQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQIobOw6qsRxgoCAggAgIIB8FKsjJx2
+nEKrlOikhHYi1BoemR76vTABV5OPFPxL9KL4wBSBdUE2oubd1KxHY/jXdImnsnxSXfK+2DgcmArM
+eaL0LmGHtrxD1wiAEjZkDjwRBiLU+E0i4qqbJtNUnFlTznVbYglHQUnfu3/8kWAdw8VTywWRezni
+cLaMuCXz2U2UngM2vz/HdxcCiRW0hkesKGzTs1k+JVbLooOjO9Y+ONjQNrFw7UvAE4eZZfjaGxAn
+RQ/zO9WLFU3nMpk1ZxapGP5Nq9d0Y5aXhNZNC55H/b/F+cTq8NZHwlnuSUVMj/gwMXpl66MW1Pvm
+Fd8vw+/b5vp5hJUcNMAY34zOVCr1a9sPV0SHmECsmxBg+t1swXXGDiytxx3w2uI4RXoYB6O16Wlc
+758k8YdX4Gg9dSxZ56RsWsRYZ7DGAQs5ujxGwi2MD+p9Arrqug5A7054V/ieHRz+5A/RFZzuffDi
+oWbrgxO00vrKIX9Kylr++oTseE7fo5OV3QB8idfIEER0swQ8Iu+uitcaCeyZZH+I+Hta/LxCnMdL
+iZCB9rc4yqNFiUQLudn8b8CRsanGq90uPg4+l5rX0oeQPNVRnTr34KNFLCv/6y8Gp6KECgvffTkc
+D0oGsQzlOb7BFFlQlzbggURprw0eFzkvLzX3dEYxaI20Zy8wggEfBgkqhkiG9w0BBwGgggEQBIIB
+DDCCAQgwggEEBgsqhkiG9w0BDAoBAqCBtDCBsTAcBgoqhkiG9w0BDAEDMA4ECFOEeANcAgF6AgII
+AASBkOa+ihLF0oO9a5YNIq0Lfu1t4RbjZtL3Zzze/BuKB6B5jv4M+VMfdlwoyHc9avUcfRojT4S4
+q5vJBJicDnugdVQIq2B5GRKFwZQNEOMlO3FXBfnrIUP5gScUUSICD440L9NAvjZEAyhm6KixIKAg
+m3zm7kYtcUQdfr2baFJY2TM+g62PqfW72h9uAKsN3gid4zE+MBcGCSqGSIb3DQEJFDEKHggAcwB2
+AGkAZDAjBgkqhkiG9w0BCRUxFgQUIb+Dd6qUgLmyYxsoiqm4wlCBxXYwMTAhMAkGBSsOAwIaBQAE
+FA78AnvBfQ0FiRZyGk3E9Fd4ZlD3BAjAIsjKZ3Oh4gICCAA=
+`
+
+// rc2Store is the same with -legacy, which encrypts the certificates with
+// RC2-40 as OpenSSL 1.1 did by default.
+const rc2Store = `
+MIIDrwIBAzCCA3UGCSqGSIb3DQEHAaCCA2YEggNiMIIDXjCCAjcGCSqGSIb3DQEHBqCCAigwggIk
+AgEAMIICHQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQYwDgQIsfrp3EvjAbECAggAgIIB8OFvuqbS
+D0oD+p15xFgN4MWzB2hbsv50+qB2gx/R09bCfV1ZCEoDP/7usNXZj1ixnmZlAyo/TWYeHWlGlWmh
+lNJ/YPitKbBPzGAB3OuV7uFuanUmEbrknW1iahYyt2GPgr3HfEZni1PtmtTeU0qOLqePMWrj0UAH
+eX11Vnl9ORZDE5XW75vQborXYhkoImocwikFRA9ysVDcL1cUM97V46tLinRBj39/8KT8TfzcQqLd
+P/LVHeW1J3AxOKwMOihcUS7TYharLBRKZQLlPQZnZh7VZu9csaavPOnI2+1vwOK1oZUSu5v4Vltx
+7FfI51eAkc7xQvX+7QZR9eYDXWnD/Ronl0hFR2sTSa10w07/YRsVTVgZrHFrpcL2dYKbm05cKPQA
+co3z6ehgxPyV4TXor8Nmr2www7nuLleCtjratrHCJanFtblb8lQpi8GOYJXFEdKXctcEsOTJrGr6
+VHEkcEPXuJneR1JcPaAhpNBYyzmF5OfZ+KXC6kYGkq54/3F3Zx3zj2vBoiPVDKyl20ueN6hYAa6H
+IhEQ70B1GyioJnrtR7v60H0w4ooyPiY4mUPw2qoWcrjMk5I06hXfW35biMv7p5PQFObE9TGzh6Yy
+6v/Vx4JN7SFaetonCvoafJ2Jm4/zsVnn9JmerhAf2nWnfbgwggEfBgkqhkiG9w0BBwGgggEQBIIB
+DDCCAQgwggEEBgsqhkiG9w0BDAoBAqCBtDCBsTAcBgoqhkiG9w0BDAEDMA4ECCj36b9W77qjAgII
+AASBkLESJXYyRED89xXeB2kIV1gwr3JDr6eAAEsWYNx6MQvRYmXpvWvG4TE+ovzEnox55ASf45I3
+NlW0dP9Fmybw1Lhn7yL0wfxfyFASE9PRAqrarTiPKWCPrNZzytBd5rZvgLM2bWCBAMKzNtVsp2sr
+RxmnvSX4CXuwb+XCG4O7QLy2dzY4x2BVzzRuoW8+vKXTpjE+MBcGCSqGSIb3DQEJFDEKHggAcwB2
+AGkAZDAjBgkqhkiG9w0BCRUxFgQUIb+Dd6qUgLmyYxsoiqm4wlCBxXYwMTAhMAkGBSsOAwIaBQAE
+FAZbaBYNojg9lkh0xB4Rek/MESsXBAiOaJ8uY+l5JAICCAA=
+`
+
+func newCert(t *testing.T, cn string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestKeyStore(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newCert(t, "root", rootKey, nil, nil)
+	interKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	inter := newCert(t, "intermediate", interKey, root, rootKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for name, key := range map[string]crypto.Signer{"ecdsa": ecKey, "rsa": rsaKey} {
+		leaf := newCert(t, "leaf", key, inter, interKey)
+		data, err := Encode(key, leaf, []*x509.Certificate{inter, root}, "svid", "pässword")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, certs, err := Decode(data, "pässword")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !publicKeysEqual(got.Public(), key.Public()) {
+			t.Errorf("%s: decoded key differs", name)
+		}
+		if len(certs) != 3 || !certs[0].Equal(leaf) || !certs[1].Equal(inter) || !certs[2].Equal(root) {
+			t.Errorf("%s: decoded %d certs, want leaf, intermediate, root", name, len(certs))
+		}
+		if _, _, err := Decode(data, "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+			t.Errorf("%s: wrong password: %v", name, err)
+		}
+		data[len(data)/2] ^= 1
+		if _, _, err := Decode(data, "pässword"); err == nil {
+			t.Errorf("%s: tampered store decoded", name)
+		}
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := Encode(other, root, nil, "svid", "pw"); err == nil {
+		t.Error("Encode accepted a key that does not match the certificate")
+	}
+}
+
+func TestTrustStore(t *testing.T) {
+	k1, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	k2, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	roots := []*x509.Certificate{newCert(t, "root-1", k1, nil, nil), newCert(t, "root-2", k2, nil, nil)}
+	data, err := EncodeTrustStore(roots, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, certs, err := Decode(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil || len(certs) != 2 || !certs[0].Equal(roots[0]) || !certs[1].Equal(roots[1]) {
+		t.Fatalf("decoded key %v, %d certs", key, len(certs))
+	}
+
+	// Java only loads certificate bags carrying its trusted-certificate
+	// attribute as trusted entries.
+	var p pfx
+	asn1.Unmarshal(data, &p)
+	var authSafe []byte
+	asn1.Unmarshal(p.AuthSafe.Content.Bytes, &authSafe)
+	var infos []contentInfo
+	asn1.Unmarshal(authSafe, &infos)
+	var contents []byte
+	asn1.Unmarshal(infos[0].Content.Bytes, &contents)
+	var bags []safeBag
+	if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+		t.Fatal(err)
+	}
+	for i, bag := range bags {
+		trusted := false
+		for _, a := range bag.Attributes {
+			trusted = trusted || a.ID.Equal(oidJavaTrusted)
+		}
+		if !trusted {
+			t.Errorf("bag %d lacks the Java trusted-certificate attribute", i)
+		}
+	}
+
+	if _, err := EncodeTrustStore(nil, "pw"); err == nil {
+		t.Error("empty trust store encoded")
+	}
+}
+
+func TestDecodeOpenSSL(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(opensslStore), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, certs, err := Decode(data, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "ossl" || !publicKeysEqual(key.Public(), certs[0].PublicKey) {
+		t.Fatalf("decoded %d certs, key %T", len(certs), key)
+	}
+	if _, _, err := Decode(data, "changeme"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(legacyStore), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, certs, err := Decode(data, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "ossl" || !publicKeysEqual(key.Public(), certs[0].PublicKey) {
+		t.Fatalf("decoded %d certs, key %T", len(certs), key)
+	}
+	if _, _, err := Decode(data, "changeme"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong password: %v", err)
+	}
+
+	data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(rc2Store), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Decode(data, "changeit"); err == nil || !strings.Contains(err.Error(), "openssl pkcs12") {
+		t.Errorf("RC2 store: %v, want a conversion hint", err)
+	}
+}
+
+func TestDecryptPrivateKeyOpenSSL(t *testing.T) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(opensslKey), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := DecryptPrivateKey(der, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || k.Curve != elliptic.P256() {
+		t.Fatalf("decrypted %T", key)
+	}
+	if _, err := DecryptPrivateKey(der, "changeme"); err == nil {
+		t.Error("decrypted with the wrong password")
+	}
+}
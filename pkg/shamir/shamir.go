@@ -0,0 +1,123 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8): a secret
+// is split into n shares such that any threshold of them reconstruct it
+// and fewer reveal nothing about it.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// MaxShares is the largest number of shares a secret can be split into;
+// share indices are the non-zero field elements.
+const MaxShares = 255
+
+// Share is one share of a secret. Index is its x coordinate (1..255) and
+// Data holds one y coordinate per secret byte.
+type Share struct {
+	Index byte
+	Data  []byte
+}
+
+// Split divides secret into n shares, any threshold of which recover it.
+// Share indices are 1..n.
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("shamir: empty secret")
+	}
+	if threshold < 2 {
+		return nil, fmt.Errorf("shamir: threshold %d must be at least 2", threshold)
+	}
+	if n < threshold || n > MaxShares {
+		return nil, fmt.Errorf("shamir: share count %d must be between the threshold %d and %d", n, threshold, MaxShares)
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{Index: byte(i + 1), Data: make([]byte, len(secret))}
+	}
+	// One random polynomial of degree threshold-1 per secret byte, with
+	// the byte as its constant term.
+	coeffs := make([]byte, threshold)
+	for j, b := range secret {
+		coeffs[0] = b
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Data[j] = evaluate(coeffs, shares[i].Index)
+		}
+	}
+	for i := range coeffs {
+		coeffs[i] = 0
+	}
+	return shares, nil
+}
+
+// Combine reconstructs a secret from shares by Lagrange interpolation at
+// zero. Given fewer than the threshold, or shares of different splits, it
+// returns a wrong secret without error: callers verify the result.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("shamir: at least two shares are required")
+	}
+	size := len(shares[0].Data)
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if s.Index == 0 {
+			return nil, errors.New("shamir: share index 0 is invalid")
+		}
+		if seen[s.Index] {
+			return nil, fmt.Errorf("shamir: share %d given twice", s.Index)
+		}
+		seen[s.Index] = true
+		if len(s.Data) != size || size == 0 {
+			return nil, errors.New("shamir: shares differ in length")
+		}
+	}
+	secret := make([]byte, size)
+	for i, si := range shares {
+		// basis_i(0) = prod_{j != i} x_j / (x_j - x_i); subtraction is XOR.
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = mul(basis, div(sj.Index, sj.Index^si.Index))
+			}
+		}
+		for k := range secret {
+			secret[k] ^= mul(si.Data[k], basis)
+		}
+	}
+	return secret, nil
+}
+
+// evaluate computes the polynomial with coefficients coeffs (constant
+// term first) at x, by Horner's rule.
+func evaluate(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(2^8) modulo the AES polynomial x^8+x^4+x^3+x+1,
+// without data-dependent branches.
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = (a << 1) ^ (-(a >> 7) & 0x1b)
+		b >>= 1
+	}
+	return p
+}
+
+// div divides a by the non-zero b, using b^-1 = b^254.
+func div(a, b byte) byte {
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = mul(inv, b)
+	}
+	return mul(a, inv)
+}
@@ -0,0 +1,284 @@
+// Package spiffeid parses and validates SPIFFE IDs and trust domain names
+// as defined by the SPIFFE ID specification. IDs are accepted only in their
+// canonical form: nothing is lowercased, defaulted or otherwise rewritten,
+// so the ID a caller asked for is the ID that ends up in a certificate.
+package spiffeid
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const scheme = "spiffe"
+
+// DefaultTrustDomain is the trust domain of the demo deployment and of CA
+// directories created before the trust domain was recorded in ca.json.
+const DefaultTrustDomain = "demo"
+
+// servicePath is the path prefix of service identities:
+// spiffe://<trust domain>/ns/default/sa/<service>.
+var servicePath = []string{"ns", "default", "sa"}
+
+// Parse errors, wrapped with the offending input; test with errors.Is.
+var (
+	ErrEmpty              = errors.New("SPIFFE ID is empty")
+	ErrScheme             = errors.New(`scheme is missing or is not "spiffe"`)
+	ErrMissingTrustDomain = errors.New("trust domain is missing")
+	ErrTrustDomainChars   = errors.New("trust domain characters are limited to lowercase letters, numbers, dots, dashes, and underscores")
+	ErrTrustDomainTooLong = errors.New("trust domain is longer than 255 characters")
+	ErrURIParts           = errors.New("SPIFFE ID may not contain a port, user info, query or fragment")
+	ErrNoLeadingSlash     = errors.New("path must have a leading slash")
+	ErrEmptySegment       = errors.New("path cannot contain empty segments")
+	ErrDotSegment         = errors.New(`path cannot contain dot segments ("." or "..")`)
+	ErrTrailingSlash      = errors.New("path cannot have a trailing slash")
+	ErrPathSegmentChars   = errors.New("path segment characters are limited to letters, numbers, dots, dashes, and underscores")
+	ErrNotMemberOfDomain  = errors.New("SPIFFE ID is not in the expected trust domain")
+)
+
+// TrustDomain is a validated trust domain name. The zero value is not a
+// valid trust domain; see IsZero.
+type TrustDomain struct {
+	name string
+}
+
+// TrustDomainFromString parses a trust domain name such as "example.org",
+// also accepting its ID form "spiffe://example.org".
+func TrustDomainFromString(s string) (TrustDomain, error) {
+	if strings.Contains(s, "://") {
+		id, err := FromString(s)
+		if err != nil {
+			return TrustDomain{}, err
+		}
+		if id.path != "" {
+			return TrustDomain{}, fmt.Errorf("trust domain %q: has a path", s)
+		}
+		return id.td, nil
+	}
+	if err := validateTrustDomain(s); err != nil {
+		return TrustDomain{}, fmt.Errorf("trust domain %q: %w", s, err)
+	}
+	return TrustDomain{name: s}, nil
+}
+
+func validateTrustDomain(s string) error {
+	if s == "" {
+		return ErrMissingTrustDomain
+	}
+	if len(s) > 255 {
+		return ErrTrustDomainTooLong
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return ErrTrustDomainChars
+		}
+	}
+	return nil
+}
+
+// Name returns the trust domain name, e.g. "example.org".
+func (td TrustDomain) Name() string { return td.name }
+
+// String returns the trust domain name.
+func (td TrustDomain) String() string { return td.name }
+
+// IDString returns the trust domain's own SPIFFE ID, "spiffe://<name>".
+func (td TrustDomain) IDString() string { return scheme + "://" + td.name }
+
+// IsZero reports whether td is the zero value.
+func (td TrustDomain) IsZero() bool { return td.name == "" }
+
+// MarshalText implements encoding.TextMarshaler.
+func (td TrustDomain) MarshalText() ([]byte, error) { return []byte(td.name), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler; the empty string
+// yields the zero TrustDomain.
+func (td *TrustDomain) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*td = TrustDomain{}
+		return nil
+	}
+	parsed, err := TrustDomainFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*td = parsed
+	return nil
+}
+
+// ID is a validated SPIFFE ID. The zero value is not a valid ID; see
+// IsZero.
+type ID struct {
+	td   TrustDomain
+	path string
+}
+
+// FromString parses a SPIFFE ID such as "spiffe://example.org/ns/a/sa/b".
+func FromString(s string) (ID, error) {
+	id, err := parse(s)
+	if err != nil {
+		if s == "" {
+			return ID{}, err
+		}
+		return ID{}, fmt.Errorf("invalid SPIFFE ID %q: %w", s, err)
+	}
+	return id, nil
+}
+
+func parse(s string) (ID, error) {
+	if s == "" {
+		return ID{}, ErrEmpty
+	}
+	rest, ok := strings.CutPrefix(s, scheme+"://")
+	if !ok {
+		return ID{}, ErrScheme
+	}
+	td, path, _ := strings.Cut(rest, "/")
+	if strings.ContainsAny(td, "@:") || strings.ContainsAny(rest, "?#") {
+		return ID{}, ErrURIParts
+	}
+	if err := validateTrustDomain(td); err != nil {
+		return ID{}, err
+	}
+	if len(rest) > len(td) {
+		path = "/" + path
+		if err := ValidatePath(path); err != nil {
+			return ID{}, err
+		}
+	}
+	return ID{td: TrustDomain{name: td}, path: path}, nil
+}
+
+// FromURI returns the SPIFFE ID in u, such as a certificate URI SAN.
+func FromURI(u *url.URL) (ID, error) {
+	if u == nil {
+		return ID{}, ErrEmpty
+	}
+	return FromString(u.String())
+}
+
+// FromPath returns the ID with path in td. path must be empty or pass
+// ValidatePath.
+func FromPath(td TrustDomain, path string) (ID, error) {
+	if td.IsZero() {
+		return ID{}, ErrMissingTrustDomain
+	}
+	if path != "" {
+		if err := ValidatePath(path); err != nil {
+			return ID{}, fmt.Errorf("invalid SPIFFE ID path %q: %w", path, err)
+		}
+	}
+	return ID{td: td, path: path}, nil
+}
+
+// FromSegments returns the ID in td whose path is segments joined by "/".
+func FromSegments(td TrustDomain, segments ...string) (ID, error) {
+	if td.IsZero() {
+		return ID{}, ErrMissingTrustDomain
+	}
+	for _, seg := range segments {
+		if err := validateSegment(seg); err != nil {
+			return ID{}, fmt.Errorf("invalid SPIFFE ID path segment %q: %w", seg, err)
+		}
+	}
+	path := ""
+	if len(segments) > 0 {
+		path = "/" + strings.Join(segments, "/")
+	}
+	return ID{td: td, path: path}, nil
+}
+
+// ForService returns the identity of a registered service,
+// spiffe://<td>/ns/default/sa/<service>.
+func ForService(td TrustDomain, service string) (ID, error) {
+	return FromSegments(td, append(append([]string{}, servicePath...), service)...)
+}
+
+// ValidatePath checks that path is a valid non-empty SPIFFE ID path:
+// "/"-separated segments of letters, digits, ".", "-" and "_", with no
+// empty, "." or ".." segments and no trailing slash.
+func ValidatePath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return ErrNoLeadingSlash
+	}
+	segments := strings.Split(path[1:], "/")
+	for i, seg := range segments {
+		if seg == "" && i == len(segments)-1 {
+			return ErrTrailingSlash
+		}
+		if err := validateSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSegment(seg string) error {
+	switch seg {
+	case "":
+		return ErrEmptySegment
+	case ".", "..":
+		return ErrDotSegment
+	}
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return ErrPathSegmentChars
+		}
+	}
+	return nil
+}
+
+// TrustDomain returns the ID's trust domain.
+func (id ID) TrustDomain() TrustDomain { return id.td }
+
+// Path returns the ID's path, "" for a trust domain ID.
+func (id ID) Path() string { return id.path }
+
+// MemberOf reports whether id is in td.
+func (id ID) MemberOf(td TrustDomain) bool { return !id.IsZero() && id.td == td }
+
+// RequireMemberOf returns an error wrapping ErrNotMemberOfDomain unless id
+// is in td.
+func (id ID) RequireMemberOf(td TrustDomain) error {
+	if !id.MemberOf(td) {
+		return fmt.Errorf("%w: %s is not in %s", ErrNotMemberOfDomain, id, td.IDString())
+	}
+	return nil
+}
+
+// String returns the canonical string form, "spiffe://<td><path>".
+func (id ID) String() string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.td.IDString() + id.path
+}
+
+// URL returns the ID as a URL, for certificate URI SANs.
+func (id ID) URL() *url.URL {
+	return &url.URL{Scheme: scheme, Host: id.td.name, Path: id.path}
+}
+
+// IsZero reports whether id is the zero value.
+func (id ID) IsZero() bool { return id.td.IsZero() }
+
+// MarshalText implements encoding.TextMarshaler.
+func (id ID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler; the empty string
+// yields the zero ID.
+func (id *ID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = ID{}
+		return nil
+	}
+	parsed, err := FromString(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
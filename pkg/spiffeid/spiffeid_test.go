@@ -0,0 +1,119 @@
+package spiffeid
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFromString(t *testing.T) {
+	valid := []string{
+		"spiffe://demo/ns/default/sa/service-a",
+		"spiffe://example.org",
+		"spiffe://prod_1.example-corp.org/Path/With.dots_and-dashes",
+	}
+	for _, s := range valid {
+		id, err := FromString(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if id.String() != s || id.URL().String() != s {
+			t.Errorf("%s round-trips as %s / %s", s, id, id.URL())
+		}
+		if back, err := FromURI(id.URL()); err != nil || back != id {
+			t.Errorf("%s: FromURI = %v, %v", s, back, err)
+		}
+	}
+
+	invalid := []struct {
+		in  string
+		err error
+	}{
+		{"", ErrEmpty},
+		{"demo/ns/default/sa/a", ErrScheme},
+		{"/ns/default/sa/a", ErrScheme},
+		{"SPIFFE://demo/a", ErrScheme},
+		{"https://demo/a", ErrScheme},
+		{"spiffe:///ns/a", ErrMissingTrustDomain},
+		{"spiffe://Demo/a", ErrTrustDomainChars},
+		{"spiffe://demo:8443/a", ErrURIParts},
+		{"spiffe://user@demo/a", ErrURIParts},
+		{"spiffe://demo/a?x=1", ErrURIParts},
+		{"spiffe://demo/a#frag", ErrURIParts},
+		{"spiffe://demo/", ErrTrailingSlash},
+		{"spiffe://demo/a/", ErrTrailingSlash},
+		{"spiffe://demo/a//b", ErrEmptySegment},
+		{"spiffe://demo/a/../b", ErrDotSegment},
+		{"spiffe://demo/a/./b", ErrDotSegment},
+		{"spiffe://demo/a%20b", ErrPathSegmentChars},
+		{"spiffe://demo/a b", ErrPathSegmentChars},
+		{"spiffe://" + strings.Repeat("a", 256), ErrTrustDomainTooLong},
+	}
+	for _, tc := range invalid {
+		if _, err := FromString(tc.in); !errors.Is(err, tc.err) {
+			t.Errorf("%q: got %v, want %v", tc.in, err, tc.err)
+		}
+	}
+}
+
+func TestTrustDomain(t *testing.T) {
+	for _, s := range []string{"example.org", "spiffe://example.org"} {
+		td, err := TrustDomainFromString(s)
+		if err != nil || td.Name() != "example.org" || td.IDString() != "spiffe://example.org" {
+			t.Errorf("%s: %v, %v", s, td, err)
+		}
+	}
+	for _, s := range []string{"", "Example.org", "example.org:443", "spiffe://example.org/path", "exa mple"} {
+		if _, err := TrustDomainFromString(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+
+	td, _ := TrustDomainFromString("example.org")
+	id, err := ForService(td, "service-a")
+	if err != nil || id.String() != "spiffe://example.org/ns/default/sa/service-a" {
+		t.Fatalf("ForService = %v, %v", id, err)
+	}
+	if !id.MemberOf(td) || id.RequireMemberOf(td) != nil {
+		t.Error("service ID not a member of its trust domain")
+	}
+	other, _ := TrustDomainFromString("other.org")
+	if err := id.RequireMemberOf(other); !errors.Is(err, ErrNotMemberOfDomain) {
+		t.Errorf("RequireMemberOf(other) = %v", err)
+	}
+	for _, bad := range []string{"", "a/b", "..", "a b"} {
+		if _, err := ForService(td, bad); err == nil {
+			t.Errorf("ForService(%q) accepted", bad)
+		}
+	}
+	if _, err := FromPath(td, "no-slash"); !errors.Is(err, ErrNoLeadingSlash) {
+		t.Errorf("FromPath without slash: %v", err)
+	}
+	if id, err := FromPath(td, ""); err != nil || id.String() != "spiffe://example.org" {
+		t.Errorf("FromPath empty: %v, %v", id, err)
+	}
+}
+
+func TestTextMarshaling(t *testing.T) {
+	var v struct {
+		TD TrustDomain `json:"td"`
+		ID ID          `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(`{"td":"example.org","id":"spiffe://example.org/a"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.Marshal(v)
+	if string(out) != `{"td":"example.org","id":"spiffe://example.org/a"}` {
+		t.Errorf("marshaled %s", out)
+	}
+	if err := json.Unmarshal([]byte(`{"id":"spiffe://example.org/a/"}`), &v); err == nil {
+		t.Error("unmarshaled an invalid ID")
+	}
+	u, _ := url.Parse("spiffe://example.org/a")
+	if id, _ := FromURI(u); id != v.ID {
+		t.Errorf("FromURI = %v, want %v", id, v.ID)
+	}
+}
@@ -0,0 +1,189 @@
+package translog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxEntries is the most entries Handler returns per request.
+const MaxEntries = 1000
+
+// maxResponse bounds the responses Client reads.
+const maxResponse = 16 << 20
+
+type entriesResponse struct {
+	Entries []Entry `json:"entries"`
+}
+
+type inclusionResponse struct {
+	Index     int64    `json:"index"`
+	AuditPath [][]byte `json:"audit_path"`
+}
+
+type consistencyResponse struct {
+	Consistency [][]byte `json:"consistency"`
+}
+
+// Handler serves l read-only over HTTP, relative to where it is mounted:
+//
+//	GET /sth                                    latest signed tree head
+//	GET /entries?start=N&end=M                  entries [N, M), at most MaxEntries
+//	GET /proof?hash=<hex leaf hash>&tree_size=N inclusion proof
+//	GET /consistency?first=N&second=M           consistency proof
+func Handler(l Log) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sth", func(w http.ResponseWriter, r *http.Request) {
+		h, err := l.TreeHead()
+		writeResponse(w, h, err)
+	})
+	mux.HandleFunc("/entries", func(w http.ResponseWriter, r *http.Request) {
+		start, err1 := queryInt(r, "start")
+		end, err2 := queryInt(r, "end")
+		if err := errors.Join(err1, err2); err != nil || start < 0 || end <= start {
+			http.Error(w, "start and end must satisfy 0 <= start < end", http.StatusBadRequest)
+			return
+		}
+		if end-start > MaxEntries {
+			end = start + MaxEntries
+		}
+		entries, err := l.Entries(start, end)
+		writeResponse(w, entriesResponse{Entries: entries}, err)
+	})
+	mux.HandleFunc("/proof", func(w http.ResponseWriter, r *http.Request) {
+		hash, err := hex.DecodeString(r.URL.Query().Get("hash"))
+		if err != nil || len(hash) != HashSize {
+			http.Error(w, "hash must be a hex SHA-256 leaf hash", http.StatusBadRequest)
+			return
+		}
+		size, err := queryInt(r, "tree_size")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		index, proof, err := l.InclusionProof(hash, size)
+		writeResponse(w, inclusionResponse{Index: index, AuditPath: proof}, err)
+	})
+	mux.HandleFunc("/consistency", func(w http.ResponseWriter, r *http.Request) {
+		first, err1 := queryInt(r, "first")
+		second, err2 := queryInt(r, "second")
+		if err := errors.Join(err1, err2); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proof, err := l.ConsistencyProof(first, second)
+		writeResponse(w, consistencyResponse{Consistency: proof}, err)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func queryInt(r *http.Request, name string) (int64, error) {
+	n, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	return n, nil
+}
+
+func writeResponse(w http.ResponseWriter, v any, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client reads a log served by Handler at URL, e.g.
+// "https://ra.example:8443/v1/log".
+type Client struct {
+	URL        string
+	HTTPClient *http.Client // http.DefaultClient when nil
+}
+
+var _ Log = (*Client)(nil)
+
+func (c *Client) get(path string, query url.Values, v any) error {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	u := strings.TrimSuffix(c.URL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := hc.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// TreeHead implements Log.
+func (c *Client) TreeHead() (TreeHead, error) {
+	var h TreeHead
+	err := c.get("/sth", nil, &h)
+	return h, err
+}
+
+// Entries implements Log.
+func (c *Client) Entries(start, end int64) ([]Entry, error) {
+	var resp entriesResponse
+	err := c.get("/entries", url.Values{
+		"start": {strconv.FormatInt(start, 10)},
+		"end":   {strconv.FormatInt(end, 10)},
+	}, &resp)
+	return resp.Entries, err
+}
+
+// InclusionProof implements Log.
+func (c *Client) InclusionProof(leafHash []byte, treeSize int64) (int64, [][]byte, error) {
+	var resp inclusionResponse
+	err := c.get("/proof", url.Values{
+		"hash":      {hex.EncodeToString(leafHash)},
+		"tree_size": {strconv.FormatInt(treeSize, 10)},
+	}, &resp)
+	return resp.Index, resp.AuditPath, err
+}
+
+// ConsistencyProof implements Log.
+func (c *Client) ConsistencyProof(first, second int64) ([][]byte, error) {
+	var resp consistencyResponse
+	err := c.get("/consistency", url.Values{
+		"first":  {strconv.FormatInt(first, 10)},
+		"second": {strconv.FormatInt(second, 10)},
+	}, &resp)
+	return resp.Consistency, err
+}
@@ -0,0 +1,319 @@
+// Package translog implements a Certificate Transparency style append-only
+// Merkle log: RFC 9162 tree hashing, inclusion and consistency proofs and
+// their verification, and signed tree heads. The CA appends every
+// certificate it issues; monitors check that the log only grows and that
+// it holds no certificate they do not expect.
+package translog
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HashSize is the size of leaf, node and root hashes (SHA-256).
+const HashSize = sha256.Size
+
+var (
+	// ErrInvalidProof is returned when a proof does not verify.
+	ErrInvalidProof = errors.New("translog: invalid proof")
+	// ErrSignature is returned when a tree head signature does not verify.
+	ErrSignature = errors.New("translog: invalid tree head signature")
+	// ErrNotFound is returned for a leaf that is not in the tree.
+	ErrNotFound = errors.New("translog: leaf not found")
+	// ErrRange is returned for indices and tree sizes beyond the tree.
+	ErrRange = errors.New("translog: out of range")
+)
+
+// Log is read access to a transparency log, local or remote.
+type Log interface {
+	// TreeHead returns the latest signed tree head.
+	TreeHead() (TreeHead, error)
+	// Entries returns the entries with indices in [start, end). It may
+	// return fewer than requested; callers continue from the last one.
+	Entries(start, end int64) ([]Entry, error)
+	// InclusionProof returns the index of the leaf with leafHash and its
+	// audit path in the tree of size treeSize.
+	InclusionProof(leafHash []byte, treeSize int64) (index int64, proof [][]byte, err error)
+	// ConsistencyProof proves that the tree of size first is a prefix of
+	// the tree of size second.
+	ConsistencyProof(first, second int64) ([][]byte, error)
+}
+
+// Entry is one logged certificate. Its leaf is the certificate's DER.
+type Entry struct {
+	Index       int64     `json:"index"`
+	Timestamp   time.Time `json:"timestamp"`
+	Certificate []byte    `json:"certificate"`
+}
+
+// LeafHash returns the entry's Merkle leaf hash.
+func (e Entry) LeafHash() []byte {
+	return LeafHash(e.Certificate)
+}
+
+// LeafHash returns the RFC 9162 hash of a leaf: SHA-256(0x00 || data).
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Tree is the compact form of a Merkle tree: the roots of its perfect
+// subtrees, largest (leftmost) first. It is enough to append leaves and
+// compute the root hash, so a monitor can follow a log without keeping
+// every leaf.
+type Tree struct {
+	Size  int64    `json:"size"`
+	Nodes [][]byte `json:"nodes"`
+}
+
+// Append adds a leaf hash to the tree.
+func (t *Tree) Append(leafHash []byte) {
+	h := leafHash
+	for s := t.Size; s&1 == 1; s >>= 1 {
+		h = nodeHash(t.Nodes[len(t.Nodes)-1], h)
+		t.Nodes = t.Nodes[:len(t.Nodes)-1]
+	}
+	t.Nodes = append(t.Nodes, h)
+	t.Size++
+}
+
+// Root returns the tree's root hash; the empty tree's is SHA-256("").
+func (t *Tree) Root() []byte {
+	if len(t.Nodes) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+	h := t.Nodes[len(t.Nodes)-1]
+	for i := len(t.Nodes) - 2; i >= 0; i-- {
+		h = nodeHash(t.Nodes[i], h)
+	}
+	return h
+}
+
+// RootHash returns the root hash of the tree with the given leaf hashes.
+func RootHash(leaves [][]byte) []byte {
+	var t Tree
+	for _, l := range leaves {
+		t.Append(l)
+	}
+	return t.Root()
+}
+
+// split returns the largest power of two smaller than n (n > 1).
+func split(n int64) int64 {
+	k := int64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// InclusionProof returns the audit path of leaves[index] in the tree of
+// all leaves (RFC 9162 section 2.1.3.1).
+func InclusionProof(leaves [][]byte, index int64) ([][]byte, error) {
+	if index < 0 || index >= int64(len(leaves)) {
+		return nil, fmt.Errorf("%w: leaf %d, tree size %d", ErrRange, index, len(leaves))
+	}
+	return path(index, leaves), nil
+}
+
+func path(m int64, leaves [][]byte) [][]byte {
+	n := int64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+	k := split(n)
+	if m < k {
+		return append(path(m, leaves[:k]), RootHash(leaves[k:]))
+	}
+	return append(path(m-k, leaves[k:]), RootHash(leaves[:k]))
+}
+
+// ConsistencyProof proves that the first leaves are a prefix of all
+// leaves (RFC 9162 section 2.1.4.1).
+func ConsistencyProof(leaves [][]byte, first int64) ([][]byte, error) {
+	if first < 0 || first > int64(len(leaves)) {
+		return nil, fmt.Errorf("%w: size %d, tree size %d", ErrRange, first, len(leaves))
+	}
+	if first == 0 || first == int64(len(leaves)) {
+		return nil, nil
+	}
+	return subproof(first, leaves, true), nil
+}
+
+func subproof(m int64, leaves [][]byte, complete bool) [][]byte {
+	n := int64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{RootHash(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subproof(m, leaves[:k], complete), RootHash(leaves[k:]))
+	}
+	return append(subproof(m-k, leaves[k:], false), RootHash(leaves[:k]))
+}
+
+// VerifyInclusion checks that leafHash is leaf index of the tree of size
+// treeSize with the given root (RFC 9162 section 2.1.3.2).
+func VerifyInclusion(leafHash []byte, index, treeSize int64, proof [][]byte, root []byte) error {
+	if index < 0 || index >= treeSize {
+		return fmt.Errorf("%w: index %d not in tree of size %d", ErrInvalidProof, index, treeSize)
+	}
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: audit path too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size first with root
+// firstRoot is a prefix of the tree of size second with root secondRoot
+// (RFC 9162 section 2.1.4.2).
+func VerifyConsistency(first, second int64, firstRoot, secondRoot []byte, proof [][]byte) error {
+	switch {
+	case first < 0 || first > second:
+		return fmt.Errorf("%w: size %d is not a prefix of size %d", ErrInvalidProof, first, second)
+	case first == second:
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrInvalidProof
+		}
+		return nil
+	case first == 0:
+		if len(proof) != 0 {
+			return ErrInvalidProof
+		}
+		return nil
+	}
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return ErrInvalidProof
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: consistency proof too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// TreeHead is a signed commitment to the log's first TreeSize entries.
+type TreeHead struct {
+	TreeSize  int64     `json:"tree_size"`
+	Timestamp time.Time `json:"timestamp"`
+	RootHash  []byte    `json:"root_hash"`
+	Signature []byte    `json:"signature"`
+}
+
+// signedData is the RFC 6962 TreeHeadSignature structure: version v1,
+// signature type tree_hash, timestamp in milliseconds, tree size and root
+// hash.
+func (h TreeHead) signedData() []byte {
+	buf := make([]byte, 2+8+8, 2+8+8+HashSize)
+	buf[0], buf[1] = 0, 1
+	binary.BigEndian.PutUint64(buf[2:], uint64(h.Timestamp.UnixMilli()))
+	binary.BigEndian.PutUint64(buf[10:], uint64(h.TreeSize))
+	return append(buf, h.RootHash...)
+}
+
+// Sign sets h.Signature: SHA-256 with ECDSA or RSA PKCS#1 v1.5, or
+// Ed25519.
+func (h *TreeHead) Sign(key crypto.Signer) error {
+	msg := h.signedData()
+	digest, opts := msg, crypto.SignerOpts(crypto.Hash(0))
+	if _, ok := key.Public().(ed25519.PublicKey); !ok {
+		sum := sha256.Sum256(msg)
+		digest, opts = sum[:], crypto.SHA256
+	}
+	sig, err := key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return err
+	}
+	h.Signature = sig
+	return nil
+}
+
+// Verify checks h's signature with the log's public key.
+func (h TreeHead) Verify(pub crypto.PublicKey) error {
+	if len(h.RootHash) != HashSize {
+		return fmt.Errorf("%w: root hash is %d bytes", ErrSignature, len(h.RootHash))
+	}
+	msg := h.signedData()
+	sum := sha256.Sum256(msg)
+	var ok bool
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, sum[:], h.Signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], h.Signature) == nil
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, msg, h.Signature)
+	default:
+		return fmt.Errorf("translog: unsupported log key %T", pub)
+	}
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package translog
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// mth is the RFC 9162 Merkle tree hash, computed recursively.
+func mth(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return RootHash(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := split(int64(len(leaves)))
+	return nodeHash(mth(leaves[:k]), mth(leaves[k:]))
+}
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LeafHash([]byte(fmt.Sprintf("certificate %d", i)))
+	}
+	return leaves
+}
+
+func TestProofs(t *testing.T) {
+	const max = 33
+	leaves := testLeaves(max)
+	for n := 1; n <= max; n++ {
+		root := RootHash(leaves[:n])
+		if !bytes.Equal(root, mth(leaves[:n])) {
+			t.Fatalf("size %d: compact root differs from the recursive hash", n)
+		}
+		for i := 0; i < n; i++ {
+			proof, err := InclusionProof(leaves[:n], int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyInclusion(leaves[i], int64(i), int64(n), proof, root); err != nil {
+				t.Fatalf("size %d leaf %d: %v", n, i, err)
+			}
+			if err := VerifyInclusion(leaves[(i+1)%max], int64(i), int64(n), proof, root); err == nil {
+				t.Fatalf("size %d leaf %d: wrong leaf verified", n, i)
+			}
+		}
+		for m := 0; m <= n; m++ {
+			proof, err := ConsistencyProof(leaves[:n], int64(m))
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := RootHash(leaves[:m])
+			if err := VerifyConsistency(int64(m), int64(n), old, root, proof); err != nil {
+				t.Fatalf("consistency %d -> %d: %v", m, n, err)
+			}
+			if m > 0 && m < n {
+				forked := append(append([][]byte(nil), leaves[:m-1]...), LeafHash([]byte("forged")))
+				if err := VerifyConsistency(int64(m), int64(n), RootHash(forked), root, proof); !errors.Is(err, ErrInvalidProof) {
+					t.Fatalf("consistency %d -> %d: rewritten history verified", m, n)
+				}
+			}
+		}
+	}
+	if _, err := InclusionProof(leaves[:4], 4); !errors.Is(err, ErrRange) {
+		t.Errorf("proof for leaf beyond the tree: %v", err)
+	}
+}
+
+func TestTreeHead(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := TreeHead{TreeSize: 3, Timestamp: time.UnixMilli(1700000000000), RootHash: RootHash(testLeaves(3))}
+	if err := h.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Verify(key.Public()); err != nil {
+		t.Fatal(err)
+	}
+	h.TreeSize = 2
+	if err := h.Verify(key.Public()); !errors.Is(err, ErrSignature) {
+		t.Errorf("altered tree head: %v", err)
+	}
+}
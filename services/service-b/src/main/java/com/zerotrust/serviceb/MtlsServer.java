@@ -18,13 +18,15 @@ import static java.nio.charset.StandardCharsets.US_ASCII;
  */
 public class MtlsServer {
     private static final int PORT = 8081;
-    private static final String CERT_PATH = "/certs/cert.pem";
-    private static final String KEY_PATH = "/certs/key.pem";
-    private static final String CA_PATH = "/certs/chain.pem";
+    // Written by the agent with CERT_FORMATS=pkcs12
+    private static final String KEYSTORE_PATH = "/certs/keystore.p12";
+    private static final String TRUSTSTORE_PATH = "/certs/truststore.p12";
+    private static final char[] STORE_PASSWORD = System.getenv().getOrDefault("KEYSTORE_PASSWORD", "").toCharArray();
     private static final long RELOAD_INTERVAL_MS = 60_000; // 1 min
 
     private final AtomicReference<SSLContext> sslContextRef = new AtomicReference<>();
-    private volatile long lastCertMtime = 0;
+    private volatile long lastKeystoreMtime = 0;
+    private volatile long lastTruststoreMtime = 0;
 
     public static void main(String[] args) throws Exception {
         MtlsServer server = new MtlsServer();
@@ -57,8 +59,10 @@ public class MtlsServer {
         while (true) {
             try {
                 Thread.sleep(RELOAD_INTERVAL_MS);
-                long mtime = getCertMtime();
-                if (mtime > 0 && mtime != lastCertMtime) {
+                // The agent rewrites the truststore alone when the bundle changes
+                long ksMtime = getMtime(KEYSTORE_PATH);
+                long tsMtime = getMtime(TRUSTSTORE_PATH);
+                if (ksMtime > 0 && tsMtime > 0 && (ksMtime != lastKeystoreMtime || tsMtime != lastTruststoreMtime)) {
                     reloadContext();
                 }
             } catch (Exception e) {
@@ -67,52 +71,35 @@ public class MtlsServer {
         }
     }
 
-    private long getCertMtime() {
+    private long getMtime(String path) {
         try {
-            return Files.getLastModifiedTime(Paths.get(CERT_PATH)).toMillis();
+            return Files.getLastModifiedTime(Paths.get(path)).toMillis();
         } catch (IOException e) {
             return 0;
         }
     }
 
     private void reloadContext() throws Exception {
-        KeyStore ks = loadKeyStore();
-        KeyStore ts = loadTrustStore();
+        KeyStore ks = loadPkcs12(KEYSTORE_PATH);
+        KeyStore ts = loadPkcs12(TRUSTSTORE_PATH);
         KeyManagerFactory kmf = KeyManagerFactory.getInstance(KeyManagerFactory.getDefaultAlgorithm());
-        kmf.init(ks, new char[0]);
+        kmf.init(ks, STORE_PASSWORD);
         TrustManagerFactory tmf = TrustManagerFactory.getInstance(TrustManagerFactory.getDefaultAlgorithm());
         tmf.init(ts);
         SSLContext ctx = SSLContext.getInstance("TLS");
         ctx.init(kmf.getKeyManagers(), tmf.getTrustManagers(), new SecureRandom());
         sslContextRef.set(ctx);
-        lastCertMtime = getCertMtime();
+        lastKeystoreMtime = getMtime(KEYSTORE_PATH);
+        lastTruststoreMtime = getMtime(TRUSTSTORE_PATH);
         System.out.println("Certs reloaded");
     }
 
-    private KeyStore loadKeyStore() throws Exception {
+    private KeyStore loadPkcs12(String path) throws Exception {
         KeyStore ks = KeyStore.getInstance("PKCS12");
-        ks.load(null, null);
-        // Load PEM cert + key into PKCS12
-        byte[] certPem = Files.readAllBytes(Paths.get(CERT_PATH));
-        byte[] keyPem = Files.readAllBytes(Paths.get(KEY_PATH));
-        Object[] pair = PemUtils.loadCertAndKey(certPem, keyPem);
-        if (pair == null) throw new RuntimeException("Failed to load cert/key");
-        X509Certificate cert = (X509Certificate) pair[0];
-        PrivateKey key = (PrivateKey) pair[1];
-        ks.setCertificateEntry("cert", cert);
-        ks.setKeyEntry("key", key, new char[0], new java.security.cert.Certificate[]{cert});
-        return ks;
-    }
-
-    private KeyStore loadTrustStore() throws Exception {
-        KeyStore ts = KeyStore.getInstance("JKS");
-        ts.load(null, null);
-        byte[] caPem = Files.readAllBytes(Paths.get(CA_PATH));
-        java.security.cert.Certificate[] chain = PemUtils.loadCertChain(caPem);
-        for (int i = 0; i < chain.length; i++) {
-            ts.setCertificateEntry("ca-" + i, chain[i]);
+        try (InputStream in = Files.newInputStream(Paths.get(path))) {
+            ks.load(in, STORE_PASSWORD);
         }
-        return ts;
+        return ks;
     }
 
     private String getPeerSpiffeId(SSLSocket socket) {